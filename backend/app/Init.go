@@ -39,6 +39,15 @@ func Init() *System {
 		log.Printf("图片存储副本回填失败: %v", err)
 	}
 	services.StartStorageSyncWorker()
+	services.StartOriginalArchiveWorker()
+	services.StartReplicaDeletionWorker()
+	services.StartBucketMigrationWorker()
+	services.StartStorageScrubWorker()
+	services.StartBucketUsageReconciler()
+	services.StartBucketHealthMonitor()
+	services.StartStorageTieringWorker()
+	services.StartImageExpiryReaper()
+	services.StartTrashPurgeWorker()
 
 	return &System{
 		Config:   cfg,
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+
+	"github.com/gin-gonic/gin"
+)
+
+type createAPITokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required"`
+	Buckets       []int    `json:"buckets"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// GetAPITokens 列出当前用户的个人访问令牌（不含令牌明文）。
+func GetAPITokens(c *gin.Context) {
+	if !requireTokenManagementSession(c) {
+		return
+	}
+	var tokens []models.APIToken
+	if err := database.GetDB().DB.Where("user_id = ?", c.GetInt("user_id")).Order("id DESC").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询访问令牌失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{"tokens": tokens}))
+}
+
+// CreateAPIToken 为当前用户创建个人访问令牌，令牌明文仅在本次响应中返回。
+func CreateAPIToken(c *gin.Context) {
+	if !requireTokenManagementSession(c) {
+		return
+	}
+	var req createAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "请求参数无效"))
+		return
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > services.MaxAPITokenLifetimeDays {
+		c.JSON(http.StatusBadRequest, result.Error(400, fmt.Sprintf("有效期必须在0-%d天之间（0 为永不过期）", services.MaxAPITokenLifetimeDays)))
+		return
+	}
+
+	token := models.APIToken{
+		UserID:  c.GetInt("user_id"),
+		Name:    strings.TrimSpace(req.Name),
+		Scopes:  slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		Buckets: slices.Compact(slices.Sorted(slices.Values(req.Buckets))),
+	}
+	if err := token.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, err.Error()))
+		return
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	db := database.GetDB().DB
+	if len(token.Buckets) > 0 {
+		var found int64
+		if err := db.Model(&models.Buckets{}).Where("id IN ?", token.Buckets).Count(&found).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, result.Error(500, "查询存储源失败"))
+			return
+		}
+		if int(found) != len(token.Buckets) {
+			c.JSON(http.StatusBadRequest, result.Error(400, "部分存储源不存在"))
+			return
+		}
+	}
+	var owned int64
+	if err := db.Model(&models.APIToken{}).Where("user_id = ?", token.UserID).Count(&owned).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询访问令牌失败"))
+		return
+	}
+	if owned >= services.MaxAPITokensPerUser {
+		c.JSON(http.StatusConflict, result.Error(409, fmt.Sprintf("每个用户最多创建 %d 个访问令牌", services.MaxAPITokensPerUser)))
+		return
+	}
+
+	raw, err := services.CreateAPIToken(db, &token)
+	if err != nil {
+		log.Printf("创建访问令牌失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "创建访问令牌失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("访问令牌已创建，请立即保存，之后将无法再次查看", gin.H{
+		"token":     raw,
+		"api_token": token,
+	}))
+}
+
+// DeleteAPIToken 吊销当前用户的一个个人访问令牌。
+func DeleteAPIToken(c *gin.Context) {
+	if !requireTokenManagementSession(c) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "令牌ID无效"))
+		return
+	}
+	deleted := database.GetDB().DB.Where("id = ? AND user_id = ?", id, c.GetInt("user_id")).Delete(&models.APIToken{})
+	if deleted.Error != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "吊销访问令牌失败"))
+		return
+	}
+	if deleted.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, result.Error(404, "访问令牌不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("访问令牌已吊销", nil))
+}
+
+// requireTokenManagementSession 令牌只能由登录的正式用户管理，API 令牌不能再签发令牌。
+func requireTokenManagementSession(c *gin.Context) bool {
+	if c.GetString("auth_method") != "" {
+		c.JSON(http.StatusForbidden, result.Error(403, "请登录后管理访问令牌"))
+		return false
+	}
+	if c.GetInt("user_role") == models.RoleGuest {
+		c.JSON(http.StatusForbidden, result.Error(403, "游客不能创建访问令牌"))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type startBucketBackfillRequest struct {
+	StartDate      string `json:"start_date"` // YYYY-MM-DD 或 RFC3339，含当天
+	EndDate        string `json:"end_date"`   // YYYY-MM-DD 或 RFC3339，含当天
+	TagIDs         []int  `json:"tag_ids"`
+	UserID         int    `json:"user_id"`
+	SourceBucketID int    `json:"source_bucket_id"`
+}
+
+// StartBucketBackfill 将已有图片按筛选条件批量加入该存储源的同步队列。
+func StartBucketBackfill(c *gin.Context) {
+	bucket, ok := loadBucketParam(c)
+	if !ok {
+		return
+	}
+	if bucket.Type == "default" {
+		c.JSON(http.StatusBadRequest, result.Error(400, "本机存储源无需补齐副本"))
+		return
+	}
+	if bucket.Disabled {
+		c.JSON(http.StatusBadRequest, result.Error(400, "存储源已停用，请先启用"))
+		return
+	}
+
+	var req startBucketBackfillRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "筛选参数无效"))
+		return
+	}
+	filter := services.BucketBackfillFilter{
+		TagIDs:         req.TagIDs,
+		UserID:         req.UserID,
+		SourceBucketID: req.SourceBucketID,
+	}
+	var err error
+	if filter.StartDate, err = parseBackfillDate(req.StartDate, false); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "开始日期格式错误"))
+		return
+	}
+	if filter.EndDate, err = parseBackfillDate(req.EndDate, true); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "结束日期格式错误"))
+		return
+	}
+	if filter.StartDate != nil && filter.EndDate != nil && !filter.StartDate.Before(*filter.EndDate) {
+		c.JSON(http.StatusBadRequest, result.Error(400, "开始日期不能晚于结束日期"))
+		return
+	}
+
+	status, err := services.StartBucketBackfill(bucket, filter)
+	if errors.Is(err, services.ErrBucketBackfillRunning) {
+		c.JSON(http.StatusConflict, result.Error(409, "该存储源的补齐任务正在运行"))
+		return
+	}
+	if err != nil {
+		log.Printf("启动存储源 %d 补齐任务失败：%v", bucket.Id, err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "启动补齐任务失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("补齐任务已启动", status))
+}
+
+// GetBucketBackfillStatus 查询该存储源最近一次补齐任务的进度。
+func GetBucketBackfillStatus(c *gin.Context) {
+	bucket, ok := loadBucketParam(c)
+	if !ok {
+		return
+	}
+	status, found := services.GetBucketBackfillStatus(bucket.Id)
+	if !found {
+		c.JSON(http.StatusOK, result.Success("ok", nil))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", status))
+}
+
+// CancelBucketBackfill 取消正在运行的补齐任务，已加入队列的副本继续同步。
+func CancelBucketBackfill(c *gin.Context) {
+	bucket, ok := loadBucketParam(c)
+	if !ok {
+		return
+	}
+	if !services.CancelBucketBackfill(bucket.Id) {
+		c.JSON(http.StatusBadRequest, result.Error(400, "该存储源没有正在运行的补齐任务"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("已取消补齐任务", nil))
+}
+
+func loadBucketParam(c *gin.Context) (models.Buckets, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "存储源ID无效"))
+		return models.Buckets{}, false
+	}
+	var bucket models.Buckets
+	if err := database.GetDB().DB.First(&bucket, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, result.Error(404, "存储源不存在"))
+			return models.Buckets{}, false
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询存储源失败"))
+		return models.Buckets{}, false
+	}
+	return bucket, true
+}
+
+// parseBackfillDate 解析日期；仅给出日期的结束日期包含当天，返回次日零点。
+func parseBackfillDate(value string, endOfDay bool) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return &parsed, nil
+	}
+	parsed, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		parsed = parsed.AddDate(0, 0, 1)
+	}
+	return &parsed, nil
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// BucketHealthSummary 存储源当前健康状态与近 24 小时检查统计。
+type BucketHealthSummary struct {
+	BucketID        int        `json:"bucket_id"`
+	BucketName      string     `json:"bucket_name"`
+	Type            string     `json:"type"`
+	Disabled        bool       `json:"disabled"`
+	Unhealthy       bool       `json:"unhealthy"`
+	HealthFailures  int        `json:"health_failures"`
+	HealthCheckedAt *time.Time `json:"health_checked_at"`
+	HealthError     string     `json:"health_error"`
+	Checks          int64      `json:"checks"`
+	FailedChecks    int64      `json:"failed_checks"`
+	AvgLatencyMs    float64    `json:"avg_latency_ms"`
+}
+
+// GetBucketHealthOverview 返回全部远程存储源的健康状态。
+func GetBucketHealthOverview(c *gin.Context) {
+	db := database.GetDB().DB
+	var bucketList []models.Buckets
+	if err := db.Where("type <> ?", "default").Order("id ASC").Find(&bucketList).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询存储源失败"))
+		return
+	}
+
+	var stats []struct {
+		BucketID     int
+		Checks       int64
+		FailedChecks int64
+		AvgLatencyMs float64
+	}
+	if err := db.Model(&models.BucketHealthCheck{}).
+		Select("bucket_id, COUNT(*) AS checks, SUM(CASE WHEN ok THEN 0 ELSE 1 END) AS failed_checks, AVG(latency_ms) AS avg_latency_ms").
+		Where("checked_at >= ?", time.Now().Add(-24*time.Hour)).
+		Group("bucket_id").
+		Scan(&stats).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询健康检查记录失败"))
+		return
+	}
+
+	summaries := make([]BucketHealthSummary, 0, len(bucketList))
+	for _, bucket := range bucketList {
+		summary := BucketHealthSummary{
+			BucketID:        bucket.Id,
+			BucketName:      bucket.Name,
+			Type:            bucket.Type,
+			Disabled:        bucket.Disabled,
+			Unhealthy:       bucket.Unhealthy,
+			HealthFailures:  bucket.HealthFailures,
+			HealthCheckedAt: bucket.HealthCheckedAt,
+			HealthError:     bucket.HealthError,
+		}
+		for _, stat := range stats {
+			if stat.BucketID == bucket.Id {
+				summary.Checks = stat.Checks
+				summary.FailedChecks = stat.FailedChecks
+				summary.AvgLatencyMs = stat.AvgLatencyMs
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{"buckets": summaries}))
+}
+
+// GetBucketHealthHistory 返回单个存储源最近的健康检查记录，可按小时数筛选。
+func GetBucketHealthHistory(c *gin.Context) {
+	bucket, ok := loadHealthCheckedBucket(c)
+	if !ok {
+		return
+	}
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	if err != nil || hours < 1 || hours > 168 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "查询时长必须在1-168小时之间"))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	var checks []models.BucketHealthCheck
+	if err := database.GetDB().DB.
+		Where("bucket_id = ? AND checked_at >= ?", bucket.Id, time.Now().Add(-time.Duration(hours)*time.Hour)).
+		Order("checked_at DESC").Limit(limit).Find(&checks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询健康检查记录失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{
+		"bucket_id":         bucket.Id,
+		"unhealthy":         bucket.Unhealthy,
+		"health_failures":   bucket.HealthFailures,
+		"health_checked_at": bucket.HealthCheckedAt,
+		"health_error":      bucket.HealthError,
+		"checks":            checks,
+	}))
+}
+
+// CheckBucketHealthNow 立即检查单个存储源并记录结果。
+func CheckBucketHealthNow(c *gin.Context) {
+	bucket, ok := loadHealthCheckedBucket(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), bucketConnectionTestTimeout+5*time.Second)
+	defer cancel()
+	check, err := services.CheckBucketHealth(ctx, bucket)
+	if err != nil {
+		log.Printf("记录存储源 %d 健康检查失败：%v", bucket.Id, err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "记录健康检查结果失败"))
+		return
+	}
+	message := "存储源连接正常"
+	if !check.OK {
+		message = "存储源连接失败：" + check.Error
+	}
+	c.JSON(http.StatusOK, result.Success(message, gin.H{"check": check}))
+}
+
+func loadHealthCheckedBucket(c *gin.Context) (models.Buckets, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "存储源ID无效"))
+		return models.Buckets{}, false
+	}
+	var bucket models.Buckets
+	if err := database.GetDB().DB.First(&bucket, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, result.Error(404, "存储源不存在"))
+			return models.Buckets{}, false
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询存储源失败"))
+		return models.Buckets{}, false
+	}
+	if bucket.Type == "default" {
+		c.JSON(http.StatusBadRequest, result.Error(400, "本机存储源不参与健康检查"))
+		return models.Buckets{}, false
+	}
+	return bucket, true
+}
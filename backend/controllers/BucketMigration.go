@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+	"oneimg/backend/utils/settings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type startBucketMigrationRequest struct {
+	SourceBucketID  int  `json:"source_bucket_id" binding:"required"`
+	TargetBucketID  int  `json:"target_bucket_id" binding:"required"`
+	RewriteBucketID bool `json:"rewrite_bucket_id"` // 同时将图片的上传存储源改写为目标存储源
+	DryRun          bool `json:"dry_run"`           // 仅返回迁移计划，不做任何修改
+}
+
+// StartBucketMigration 将源存储源的全部副本迁移到目标存储源，dry_run 时仅返回迁移计划。
+func StartBucketMigration(c *gin.Context) {
+	var req startBucketMigrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "迁移参数无效"))
+		return
+	}
+
+	db := database.GetDB().DB
+	var source, target models.Buckets
+	if err := db.First(&source, req.SourceBucketID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "源存储源不存在"))
+		return
+	}
+	if err := db.First(&target, req.TargetBucketID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "目标存储源不存在"))
+		return
+	}
+	if source.Id == target.Id {
+		c.JSON(http.StatusBadRequest, result.Error(400, "源存储源与目标存储源不能相同"))
+		return
+	}
+	if source.Type == "default" {
+		c.JSON(http.StatusBadRequest, result.Error(400, "本机存储源不能作为迁移源"))
+		return
+	}
+	if target.Disabled {
+		c.JSON(http.StatusBadRequest, result.Error(400, "目标存储源已停用，请先启用"))
+		return
+	}
+
+	plan, err := services.PlanBucketMigration(source, target)
+	if err != nil {
+		log.Printf("生成存储源迁移计划失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "生成迁移计划失败"))
+		return
+	}
+	if req.DryRun {
+		c.JSON(http.StatusOK, result.Success("ok", gin.H{"plan": plan}))
+		return
+	}
+	if !plan.CapacitySufficient {
+		c.JSON(http.StatusBadRequest, result.Error(400, "目标存储源容量不足"))
+		return
+	}
+
+	setting, err := settings.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "读取系统设置失败"))
+		return
+	}
+	if !setting.MultiStorageSync {
+		c.JSON(http.StatusBadRequest, result.Error(400, "请先开启多存储同步"))
+		return
+	}
+
+	migration, err := services.StartBucketMigration(source, target, req.RewriteBucketID)
+	if errors.Is(err, services.ErrBucketMigrationConflict) {
+		c.JSON(http.StatusConflict, result.Error(409, "相关存储源已有正在运行的迁移任务"))
+		return
+	}
+	if err != nil {
+		log.Printf("启动存储源迁移失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "启动迁移任务失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("迁移任务已启动", gin.H{
+		"migration": migration,
+		"plan":      plan,
+	}))
+}
+
+// GetBucketMigrations 分页返回迁移任务，最新的在前。
+func GetBucketMigrations(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	db := database.GetDB().DB
+	var total int64
+	if err := db.Model(&models.BucketMigration{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询迁移任务失败"))
+		return
+	}
+	var migrations []models.BucketMigration
+	if err := db.Order("id DESC").Offset((page - 1) * limit).Limit(limit).Find(&migrations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询迁移任务失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{
+		"migrations":  migrations,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"total_pages": (total + int64(limit) - 1) / int64(limit),
+	}))
+}
+
+// GetBucketMigration 查询单个迁移任务的进度。
+func GetBucketMigration(c *gin.Context) {
+	migration, ok := loadBucketMigration(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", migration))
+}
+
+// ResumeBucketMigration 从中断的阶段继续失败或已取消的迁移任务。
+func ResumeBucketMigration(c *gin.Context) {
+	migration, ok := loadBucketMigration(c)
+	if !ok {
+		return
+	}
+	migration, err := services.ResumeBucketMigration(migration.ID)
+	switch {
+	case errors.Is(err, services.ErrBucketMigrationFinished):
+		c.JSON(http.StatusBadRequest, result.Error(400, "迁移任务已完成"))
+		return
+	case errors.Is(err, services.ErrBucketMigrationConflict):
+		c.JSON(http.StatusConflict, result.Error(409, "相关存储源已有正在运行的迁移任务"))
+		return
+	case err != nil:
+		log.Printf("恢复迁移任务 %d 失败：%v", migration.ID, err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "恢复迁移任务失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("迁移任务已恢复", migration))
+}
+
+// CancelBucketMigration 取消正在运行的迁移任务，已复制的副本保留。
+func CancelBucketMigration(c *gin.Context) {
+	migration, ok := loadBucketMigration(c)
+	if !ok {
+		return
+	}
+	if err := services.CancelBucketMigration(migration.ID); err != nil {
+		if errors.Is(err, services.ErrBucketMigrationNotRunning) {
+			c.JSON(http.StatusBadRequest, result.Error(400, "迁移任务未在运行"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "取消迁移任务失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("已取消迁移任务", nil))
+}
+
+func loadBucketMigration(c *gin.Context) (models.BucketMigration, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "迁移任务ID无效"))
+		return models.BucketMigration{}, false
+	}
+	var migration models.BucketMigration
+	if err := database.GetDB().DB.First(&migration, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, result.Error(404, "迁移任务不存在"))
+			return models.BucketMigration{}, false
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询迁移任务失败"))
+		return models.BucketMigration{}, false
+	}
+	return migration, true
+}
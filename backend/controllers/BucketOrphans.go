@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxOrphanDeleteBatch = 1000
+
+type deleteBucketOrphansRequest struct {
+	Prefix     string   `json:"prefix"`
+	GraceHours int      `json:"grace_hours"`
+	Paths      []string `json:"paths"`
+	Confirm    bool     `json:"confirm"` // 必须为 true 才会删除
+}
+
+// ScanBucketOrphans 列出存储源上传目录中数据库未引用、且早于宽限期的孤儿文件，不做删除。
+func ScanBucketOrphans(c *gin.Context) {
+	bucket, ok := loadBucketParam(c)
+	if !ok {
+		return
+	}
+	prefix, grace, ok := parseOrphanScanScope(c, c.Query("prefix"), c.Query("grace_hours"))
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Minute)
+	defer cancel()
+	scan, err := services.ScanBucketOrphans(ctx, bucket, prefix, grace)
+	if errors.Is(err, services.ErrOrphanScanUnsupported) {
+		c.JSON(http.StatusBadRequest, result.Error(400, "该类型的存储源不支持列出文件"))
+		return
+	}
+	if err != nil {
+		log.Printf("扫描存储源 %d 孤儿文件失败：%v", bucket.Id, err)
+		c.JSON(http.StatusBadGateway, result.Error(502, "扫描孤儿文件失败："+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", scan))
+}
+
+// DeleteBucketOrphans 删除扫描结果中确认的孤儿文件；删除前重新扫描，已被引用或仍在宽限期内的文件会被跳过。
+func DeleteBucketOrphans(c *gin.Context) {
+	bucket, ok := loadBucketParam(c)
+	if !ok {
+		return
+	}
+	var req deleteBucketOrphansRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "请求参数无效"))
+		return
+	}
+	if !req.Confirm {
+		c.JSON(http.StatusBadRequest, result.Error(400, "请确认删除（confirm=true）"))
+		return
+	}
+	if len(req.Paths) == 0 || len(req.Paths) > maxOrphanDeleteBatch {
+		c.JSON(http.StatusBadRequest, result.Error(400, "单次可删除1-1000个文件"))
+		return
+	}
+	graceHours := ""
+	if req.GraceHours != 0 {
+		graceHours = strconv.Itoa(req.GraceHours)
+	}
+	prefix, grace, ok := parseOrphanScanScope(c, req.Prefix, graceHours)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Minute)
+	defer cancel()
+	deleted, err := services.DeleteBucketOrphans(ctx, bucket, prefix, req.Paths, grace)
+	if errors.Is(err, services.ErrOrphanScanUnsupported) {
+		c.JSON(http.StatusBadRequest, result.Error(400, "该类型的存储源不支持列出文件"))
+		return
+	}
+	if err != nil {
+		log.Printf("清理存储源 %d 孤儿文件失败：%v", bucket.Id, err)
+		c.JSON(http.StatusBadGateway, result.Error(502, "清理孤儿文件失败："+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("孤儿文件清理完成", deleted))
+}
+
+// parseOrphanScanScope 解析扫描目录与宽限期（小时，默认24，最少1）。
+func parseOrphanScanScope(c *gin.Context, prefixParam, graceParam string) (string, time.Duration, bool) {
+	prefix, err := services.OrphanScanPrefix(prefixParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "扫描目录无效，请指定上传目录前缀"))
+		return "", 0, false
+	}
+	grace := services.DefaultOrphanGracePeriod
+	if graceParam != "" {
+		hours, err := strconv.Atoi(graceParam)
+		if err != nil || time.Duration(hours)*time.Hour < services.MinOrphanGracePeriod || hours > 24*365 {
+			c.JSON(http.StatusBadRequest, result.Error(400, "宽限期必须在1-8760小时之间"))
+			return "", 0, false
+		}
+		grace = time.Duration(hours) * time.Hour
+	}
+	return prefix, grace, true
+}
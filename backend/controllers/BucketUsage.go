@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+
+	"github.com/gin-gonic/gin"
+)
+
+type reconcileBucketUsageRequest struct {
+	FromListing bool `json:"from_listing"` // 按存储源实际文件列表统计，不支持列出文件的类型仍按记录统计
+}
+
+// ReconcileAllBucketUsage 按记录（或实际文件列表）重新统计全部存储源的已用容量。
+func ReconcileAllBucketUsage(c *gin.Context) {
+	var req reconcileBucketUsageRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, result.Error(400, "请求参数无效"))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Minute)
+	defer cancel()
+	reports, err := services.ReconcileAllBucketUsage(ctx, req.FromListing)
+	if err != nil {
+		log.Printf("校准存储源用量失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "校准存储源用量失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("用量校准完成", reports))
+}
+
+// ReconcileBucketUsage 重新统计单个存储源的已用容量。
+func ReconcileBucketUsage(c *gin.Context) {
+	bucket, ok := loadBucketParam(c)
+	if !ok {
+		return
+	}
+	var req reconcileBucketUsageRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, result.Error(400, "请求参数无效"))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Minute)
+	defer cancel()
+	report, err := services.ReconcileBucketUsage(ctx, bucket, req.FromListing)
+	if err != nil {
+		log.Printf("校准存储源 %d 用量失败：%v", bucket.Id, err)
+		c.JSON(http.StatusBadGateway, result.Error(502, "校准存储源用量失败："+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("用量校准完成", report))
+}
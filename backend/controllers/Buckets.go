@@ -56,6 +56,7 @@ func GetBuckets(c *gin.Context) {
 		TotalReadable string  `json:"total_readable"` // 总容量
 		UsagePercent  float64 `json:"usage_percent"`  // 使用率（保留两位小数）
 		UsageFree     string  `json:"usage_free"`     // 可用容量
+		DiskUsed      string  `json:"disk_used"`      // 本机磁盘整体已用容量（含其他程序的文件）
 	}
 	var bucketRes []BucketResponse
 
@@ -65,18 +66,20 @@ func GetBuckets(c *gin.Context) {
 		res := BucketResponse{Buckets: bucket}
 		// 根据存储类型计算/转换容量和使用量
 		switch bucket.Type {
-		case "default": // 本地磁盘
+		case "default": // 本地磁盘：已用为 OneImg 自身文件占用，总量与可用取自磁盘
+			res.UsageReadable = formatSize(bucket.Usage)
 			diskInfo, err := getDiskUsage()
 			if err != nil {
-				res.UsageReadable = "获取失败"
+				res.TotalReadable = "获取失败"
 				bucketRes = append(bucketRes, res)
 				continue
 			}
-			db.DB.Model(&models.Buckets{}).Where("id = ?", bucket.Id).Update("usage", diskInfo.UsedBytes)
 			res.TotalReadable = diskInfo.Total
-			res.UsageReadable = diskInfo.Used
 			res.UsageFree = diskInfo.Free
-			res.UsagePercent = keepTwoDecimal(diskInfo.Percent) // 保留两位小数
+			res.DiskUsed = diskInfo.Used
+			if diskInfo.TotalBytes > 0 {
+				res.UsagePercent = keepTwoDecimal(float64(bucket.Usage) / float64(diskInfo.TotalBytes) * 100)
+			}
 		case "s3", "r2", "ftp", "webdav":
 			// 计算使用量
 			res.TotalReadable = formatSize(bucket.Capacity)
@@ -519,6 +522,145 @@ func UpdateBucketEnabled(c *gin.Context) {
 	}))
 }
 
+// UpdateBucketProcessing 设置上传到该存储桶时的图片处理规格；提交 null 清除规格并沿用系统设置。
+// 多存储同步模式下本地保留上传文件，同步到该存储桶的副本按规格生成。
+func UpdateBucketProcessing(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "存储源ID无效"))
+		return
+	}
+
+	var profile *models.BucketProcessing
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "处理规格参数无效"))
+		return
+	}
+	if profile != nil {
+		if err := profile.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, result.Error(400, err.Error()))
+			return
+		}
+	}
+
+	db := database.GetDB()
+	var bucket models.Buckets
+	if err := db.DB.First(&bucket, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, result.Error(404, "存储源不存在"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询存储源失败"))
+		return
+	}
+
+	bucket.Processing = profile
+	if err := db.DB.Model(&bucket).Select("processing").Updates(&bucket).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "更新处理规格失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("处理规格已更新", gin.H{
+		"id":         bucket.Id,
+		"processing": bucket.Processing,
+	}))
+}
+
+type updateBucketSyncConcurrencyRequest struct {
+	Concurrency *int `json:"concurrency" binding:"required"`
+}
+
+// UpdateBucketSyncConcurrency 设置该存储源的后台同步并发上限；0 为沿用系统设置。
+func UpdateBucketSyncConcurrency(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "存储源ID无效"))
+		return
+	}
+
+	var req updateBucketSyncConcurrencyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Concurrency == nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "并发参数无效"))
+		return
+	}
+	if *req.Concurrency < 0 || *req.Concurrency > services.StorageSyncMaxConcurrency {
+		c.JSON(http.StatusBadRequest, result.Error(400, fmt.Sprintf("并发上限必须在0-%d之间（0 为沿用系统设置）", services.StorageSyncMaxConcurrency)))
+		return
+	}
+
+	db := database.GetDB()
+	var bucket models.Buckets
+	if err := db.DB.First(&bucket, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, result.Error(404, "存储源不存在"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询存储源失败"))
+		return
+	}
+
+	if err := db.DB.Model(&bucket).Update("sync_concurrency", *req.Concurrency).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "更新同步并发失败"))
+		return
+	}
+	services.WakeStorageSyncWorker()
+	c.JSON(http.StatusOK, result.Success("同步并发已更新", gin.H{
+		"id":               bucket.Id,
+		"sync_concurrency": *req.Concurrency,
+	}))
+}
+
+type updateBucketDeliveryRequest struct {
+	Priority *int `json:"priority" binding:"required"`
+	Weight   *int `json:"weight" binding:"required"`
+}
+
+// UpdateBucketDelivery 设置该存储源在分发策略中的优先级与权重。
+func UpdateBucketDelivery(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "存储源ID无效"))
+		return
+	}
+
+	var req updateBucketDeliveryRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Priority == nil || req.Weight == nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "分发参数无效"))
+		return
+	}
+	if *req.Priority < -services.MaxDeliveryPriority || *req.Priority > services.MaxDeliveryPriority {
+		c.JSON(http.StatusBadRequest, result.Error(400, fmt.Sprintf("优先级必须在-%d-%d之间", services.MaxDeliveryPriority, services.MaxDeliveryPriority)))
+		return
+	}
+	if *req.Weight < 0 || *req.Weight > services.MaxDeliveryWeight {
+		c.JSON(http.StatusBadRequest, result.Error(400, fmt.Sprintf("权重必须在0-%d之间", services.MaxDeliveryWeight)))
+		return
+	}
+
+	db := database.GetDB()
+	var bucket models.Buckets
+	if err := db.DB.First(&bucket, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, result.Error(404, "存储源不存在"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询存储源失败"))
+		return
+	}
+
+	if err := db.DB.Model(&bucket).Updates(map[string]any{
+		"delivery_priority": *req.Priority,
+		"delivery_weight":   *req.Weight,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "更新分发设置失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("分发设置已更新", gin.H{
+		"id":                bucket.Id,
+		"delivery_priority": *req.Priority,
+		"delivery_weight":   *req.Weight,
+	}))
+}
+
 // DeleteBuckets 删除存储桶；仅移除该源上的副本，保留其它源与主记录。
 func DeleteBuckets(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
@@ -537,6 +679,16 @@ func DeleteBuckets(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, result.Error(400, "本机存储桶不能删除"))
 		return
 	}
+	// 分层存储后部分图片只在该存储源保留副本，删除会导致图片丢失
+	soleReplicas, err := services.CountSoleReplicas(db.DB, bucket.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "检查存储源副本失败"))
+		return
+	}
+	if soleReplicas > 0 {
+		c.JSON(http.StatusConflict, result.Error(409, fmt.Sprintf("有 %d 张图片仅在该存储源保留副本，请先迁移后再删除", soleReplicas)))
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Minute)
 	defer cancel()
@@ -545,10 +697,15 @@ func DeleteBuckets(c *gin.Context) {
 		c.JSON(http.StatusBadGateway, result.Error(502, "部分文件副本删除失败，存储源已保留"))
 		return
 	}
+	if err := services.DrainBucketDeletions(ctx, bucket); err != nil {
+		log.Printf("清理存储桶 %d 的待删除文件失败：%v", id, err)
+		c.JSON(http.StatusBadGateway, result.Error(502, "部分待删除文件清理失败，存储源已保留"))
+		return
+	}
 
 	err = db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		var primaryImages []models.Image
-		if err := tx.Where("bucket_id = ?", id).Find(&primaryImages).Error; err != nil {
+		if err := tx.Unscoped().Where("bucket_id = ?", id).Find(&primaryImages).Error; err != nil {
 			return err
 		}
 		for _, image := range primaryImages {
@@ -558,7 +715,7 @@ func DeleteBuckets(c *gin.Context) {
 				image.Id, id, models.ImageStorageStatusSuccess,
 			).Order("bucket_id ASC").First(&replacement).Error
 			if replacementErr == nil {
-				if err := tx.Model(&image).Updates(map[string]any{
+				if err := tx.Unscoped().Model(&image).Updates(map[string]any{
 					"bucket_id": replacement.BucketID,
 					"storage":   replacement.Storage,
 					"url":       replacement.URL,
@@ -574,7 +731,7 @@ func DeleteBuckets(c *gin.Context) {
 			if err := tx.Where("image_id = ?", image.Id).Delete(&models.ImageToTags{}).Error; err != nil {
 				return err
 			}
-			if err := tx.Delete(&image).Error; err != nil {
+			if err := tx.Unscoped().Delete(&image).Error; err != nil {
 				return err
 			}
 		}
@@ -582,7 +739,7 @@ func DeleteBuckets(c *gin.Context) {
 		if err := tx.Where("bucket_id = ?", id).Delete(&models.ImageStorage{}).Error; err != nil {
 			return err
 		}
-		if err := tx.Model(&models.Image{}).Where("access_bucket_id = ?", id).Update("access_bucket_id", 0).Error; err != nil {
+		if err := tx.Unscoped().Model(&models.Image{}).Where("access_bucket_id = ?", id).Update("access_bucket_id", 0).Error; err != nil {
 			return err
 		}
 		var users []models.User
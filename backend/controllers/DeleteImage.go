@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -25,10 +26,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/minio/minio-go/v7"
-	"gorm.io/gorm"
 )
 
-// DeleteImage 删除图片：先删各存储物理副本，再事务释放容量并删库记录。
+// DeleteImage 删除图片：开启回收站时仅移入回收站；否则删除库记录与本机文件，远端副本交由删除队列清理。
 func DeleteImage(c *gin.Context) {
 	idStr := c.Param("id")
 	if idStr == "" {
@@ -54,59 +54,39 @@ func DeleteImage(c *gin.Context) {
 		return
 	}
 
-	deleteCtx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Minute)
-	defer cancel()
-
-	if err := services.DeleteImageReplicas(deleteCtx, image); err != nil {
-		log.Printf("删除图片 %d 的存储副本失败：%v", image.Id, err)
-		c.JSON(http.StatusBadGateway, result.Error(502, "部分存储源删除失败，文件记录已保留，可稍后重试"))
+	setting, err := settings.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "获取系统配置失败"))
 		return
 	}
-
-	fileSize := uint64(image.FileSize)
-	err = db.Transaction(func(tx *gorm.DB) error {
-		var storageList []models.ImageStorage
-		if err := tx.Where("image_id = ?", image.Id).Find(&storageList).Error; err != nil {
-			return err
-		}
-
-		for _, storage := range storageList {
-			err := tx.Model(&models.Buckets{}).
-				Where("id = ?", storage.BucketID).
-				UpdateColumn("usage", gorm.Expr("GREATEST(usage - ?, 0)", fileSize)).Error
-			if err != nil {
-				log.Printf("Bucket %d 扣减容量失败 size=%d err=%v", storage.BucketID, fileSize, err)
-				return err
-			}
-		}
-
-		if err := tx.Where("image_id = ?", image.Id).Delete(&models.ImageStorage{}).Error; err != nil {
-			return err
+	if setting.TrashRetentionDays > 0 {
+		if err := services.TrashImage(db, image); err != nil {
+			log.Printf("图片 %d 移入回收站失败：%v", image.Id, err)
+			c.JSON(http.StatusInternalServerError, result.Error(500, "移入回收站失败"))
+			return
 		}
-		if err := tx.Where("image_id = ?", image.Id).Delete(&models.ImageToTags{}).Error; err != nil {
-			return err
-		}
-		if err := tx.Delete(&image).Error; err != nil {
-			return err
-		}
-		return nil
-	})
+		c.JSON(http.StatusOK, result.Success(fmt.Sprintf("已移入回收站，%d 天内可恢复", setting.TrashRetentionDays), nil))
+		return
+	}
 
-	if err != nil {
-		log.Printf("图片 %d 事务处理失败 err=%v", image.Id, err)
+	if err := services.DeleteImage(image); err != nil {
+		log.Printf("删除图片 %d 失败：%v", image.Id, err)
 		c.JSON(http.StatusInternalServerError, result.Error(500, "删除图片记录或更新存储容量失败"))
 		return
 	}
 
-	c.JSON(http.StatusOK, result.Success("删除成功，对应存储容量已释放", nil))
+	c.JSON(http.StatusOK, result.Success("删除成功，远端副本将在后台清理", nil))
 }
 
 // CheckImageAccessPermission 校验当前用户是否可操作目标图片。
-// 规则：超管图片仅超管可动；本人或超管放行；否则需 requiredPerm；游客用 UUID+MD5。
+// 规则：个人访问令牌须覆盖图片所在存储源；超管图片仅超管可动；本人或超管放行；否则需 requiredPerm；游客用 UUID+MD5。
 func CheckImageAccessPermission(c *gin.Context, image models.Image, requiredPerm string) bool {
 	userId := c.GetInt("user_id")
 	userRole := c.GetInt("user_role")
 
+	if !tokenAllowsImage(c, image) {
+		return false
+	}
 	if image.UserId == models.SuperAdminID && userId != models.SuperAdminID {
 		return false
 	}
@@ -59,7 +59,7 @@ func GetRandomImages(c *gin.Context) {
 			return
 		}
 	}
-	query := db.Model(&models.Image{})
+	query := db.Model(&models.Image{}).Where("images.expires_at IS NULL OR images.expires_at > ?", time.Now())
 	if len(randomGraph.UserIds) > 0 {
 		query = query.Where("images.user_id IN ?", randomGraph.UserIds)
 	}
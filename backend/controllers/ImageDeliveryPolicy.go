@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+
+	"github.com/gin-gonic/gin"
+)
+
+type imageDeliveryPolicyRequest struct {
+	Policy string `json:"policy"`
+}
+
+type batchImageDeliveryPolicyRequest struct {
+	ImageIDs []int  `json:"image_ids" binding:"required"`
+	Policy   string `json:"policy"`
+}
+
+// UpdateImageDeliveryPolicy 为单张图片指定分发策略，空字符串表示沿用全局策略。
+func UpdateImageDeliveryPolicy(c *gin.Context) {
+	imageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || imageID <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "图片ID无效"))
+		return
+	}
+
+	var req imageDeliveryPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "分发策略参数无效"))
+		return
+	}
+
+	respondImageDeliveryPolicyUpdate(c, []int{imageID}, req.Policy, "图片分发策略已更新")
+}
+
+// BatchUpdateImageDeliveryPolicy 批量设置图片分发策略。
+func BatchUpdateImageDeliveryPolicy(c *gin.Context) {
+	var req batchImageDeliveryPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "图片或分发策略参数无效"))
+		return
+	}
+
+	respondImageDeliveryPolicyUpdate(c, req.ImageIDs, req.Policy, "批量分发策略已更新")
+}
+
+func respondImageDeliveryPolicyUpdate(c *gin.Context, imageIDs []int, policy string, message string) {
+	ids, err := setImageDeliveryPolicy(c, imageIDs, policy)
+	if err != nil {
+		var sourceErr *imageAccessSourceError
+		if errors.As(err, &sourceErr) {
+			c.JSON(sourceErr.status, result.Error(sourceErr.status, sourceErr.message))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "更新图片分发策略失败"))
+		return
+	}
+
+	c.JSON(http.StatusOK, result.Success(message, gin.H{
+		"image_ids":       ids,
+		"delivery_policy": policy,
+	}))
+}
+
+// setImageDeliveryPolicy stores the policy and clears any explicitly
+// selected access bucket, which would otherwise take precedence over it.
+func setImageDeliveryPolicy(c *gin.Context, imageIDs []int, policy string) ([]int, error) {
+	if policy != "" && !services.ValidDeliveryPolicy(policy) {
+		return nil, &imageAccessSourceError{status: http.StatusBadRequest, message: "分发策略只能是 local、priority、weighted 或 fastest"}
+	}
+	ids := normalizeImageIDs(imageIDs)
+	if len(ids) == 0 {
+		return nil, &imageAccessSourceError{status: http.StatusBadRequest, message: "请选择图片"}
+	}
+	if len(ids) > maxAccessSourceBatchSize {
+		return nil, &imageAccessSourceError{
+			status:  http.StatusBadRequest,
+			message: fmt.Sprintf("单次最多设置 %d 张图片", maxAccessSourceBatchSize),
+		}
+	}
+
+	db := database.GetDB()
+	if db == nil || db.DB == nil {
+		return nil, errors.New("database is not initialized")
+	}
+
+	var images []models.Image
+	if err := db.DB.Where("id IN ?", ids).Find(&images).Error; err != nil {
+		return nil, err
+	}
+	if len(images) != len(ids) {
+		return nil, &imageAccessSourceError{status: http.StatusNotFound, message: "部分图片不存在"}
+	}
+	for _, image := range images {
+		if !canManageImageAccessSource(c, image) {
+			return nil, &imageAccessSourceError{status: http.StatusForbidden, message: "无权修改部分图片的分发策略"}
+		}
+	}
+
+	if err := db.DB.Model(&models.Image{}).Where("id IN ?", ids).Updates(map[string]any{
+		"delivery_policy":  policy,
+		"access_bucket_id": 0,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
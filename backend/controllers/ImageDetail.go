@@ -51,15 +51,23 @@ func GetImageDetail(c *gin.Context) {
 		return
 	}
 
+	thumbnails, err := loadImageThumbnails(db, image.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "获取缩略图规格失败"))
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"code": 200,
 		"msg":  "获取图片详情成功",
 		"data": struct {
 			models.Image
 			StorageStatuses []ImageStorageStatusResponse `json:"storage_statuses"`
+			Thumbnails      []ImageThumbnailResponse     `json:"thumbnails"`
 		}{
 			Image:           image,
 			StorageStatuses: statusMap[image.Id],
+			Thumbnails:      thumbnails,
 		},
 	})
 }
@@ -97,7 +97,9 @@ func GetImageList(c *gin.Context) {
 	}
 
 	db := database.GetDB().DB
-	idQuery := db.Model(&models.Image{}).Select("images.id", dbSortField)
+	// 已过期等待清理的图片不再列出
+	idQuery := db.Model(&models.Image{}).Select("images.id", dbSortField).
+		Where("images.expires_at IS NULL OR images.expires_at > ?", time.Now())
 
 	// 存储桶筛选
 	bucket := c.Query("bucket")
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/interfaces"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/images"
+	"oneimg/backend/utils/result"
+	"oneimg/backend/utils/settings"
+	"oneimg/backend/utils/uploads"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReplaceImageFile 替换图片文件：重新处理后写回原有 Url/Thumbnail 路径，访问地址保持不变，
+// 远端副本重新进入待同步状态。
+func ReplaceImageFile(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "图片ID无效"))
+		return
+	}
+
+	db := database.GetDB().DB
+	var image models.Image
+	if err := db.First(&image, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, result.Error(404, "图片不存在"))
+		return
+	}
+	if !CheckImageAccessPermission(c, image, "image:replace") {
+		c.JSON(http.StatusForbidden, result.Error(403, "无权替换此图片"))
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "请选择要替换的图片"))
+		return
+	}
+
+	setting, err := settings.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "获取系统配置失败"))
+		return
+	}
+	if err := images.ValidateImageFile(header, &setting); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "图片验证失败："+err.Error()))
+		return
+	}
+
+	// 沿用图片所在存储桶的处理规格
+	var bucket models.Buckets
+	if err := db.First(&bucket, image.BucketId).Error; err != nil {
+		bucket = models.Buckets{Type: "default"}
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "打开文件失败"))
+		return
+	}
+	defer file.Close()
+	processed, err := images.ImageSvc.ProcessImage(file, header, uploads.ProcessingSettings(&setting, &bucket), c.GetInt("user_role"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "图片处理失败："+err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Minute)
+	defer cancel()
+	updated, err := services.ReplaceImageFile(ctx, image, services.ImageReplacement{
+		Main:      processed.CompressedBytes,
+		Thumbnail: processed.ThumbnailBytes,
+		MimeType:  processed.MimeType,
+		Width:     processed.Width,
+		Height:    processed.Height,
+		Encrypted: setting.EncryptedStorage,
+	})
+	if err != nil {
+		log.Printf("替换图片 %d 文件失败：%v", image.Id, err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "替换图片文件失败"))
+		return
+	}
+
+	if err := services.RegenerateImageThumbnails(db, updated, setting.GetThumbnailProfiles(), setting.EncryptedStorage); err != nil {
+		log.Printf("重建图片 %d 的规格缩略图失败：%v", image.Id, err)
+	}
+	archiveUploadOriginal(setting, updated, &interfaces.ImageUploadResult{
+		Downscaled:     processed.Downscaled,
+		OriginalWidth:  processed.OriginalWidth,
+		OriginalHeight: processed.OriginalHeight,
+	}, header)
+
+	c.JSON(http.StatusOK, result.Success("图片已替换，访问地址保持不变", updated))
+}
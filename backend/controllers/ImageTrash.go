@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+	"oneimg/backend/utils/settings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrashedImageResponse 回收站中的图片及其预计彻底删除时间。
+type TrashedImageResponse struct {
+	models.Image
+	PurgeAt *time.Time `json:"purge_at"`
+}
+
+// GetTrashedImages 分页获取回收站中的图片，超级管理员可见全部，其余用户仅见自己删除的图片。
+func GetTrashedImages(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	setting, err := settings.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "获取系统配置失败"))
+		return
+	}
+
+	db := database.GetDB().DB
+	query := db.Unscoped().Model(&models.Image{}).Where("deleted_at IS NOT NULL")
+	if userID := c.GetInt("user_id"); userID != models.SuperAdminID {
+		if c.GetInt("user_role") == models.RoleGuest {
+			query = query.Where("uuid = ?", GetUUID(c))
+		} else {
+			query = query.Where("user_id = ?", userID)
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询回收站失败"))
+		return
+	}
+	var images []models.Image
+	if err := query.Order("deleted_at DESC").Offset((page - 1) * limit).Limit(limit).Find(&images).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询回收站失败"))
+		return
+	}
+
+	items := make([]TrashedImageResponse, 0, len(images))
+	for _, image := range images {
+		rewriteImageURLs(setting, &image)
+		item := TrashedImageResponse{Image: image}
+		if setting.TrashRetentionDays > 0 {
+			purgeAt := image.DeletedAt.Time.AddDate(0, 0, setting.TrashRetentionDays)
+			item.PurgeAt = &purgeAt
+		}
+		items = append(items, item)
+	}
+
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{
+		"images":         items,
+		"total":          total,
+		"page":           page,
+		"limit":          limit,
+		"total_pages":    (total + int64(limit) - 1) / int64(limit),
+		"retention_days": setting.TrashRetentionDays,
+	}))
+}
+
+// RestoreTrashedImage 从回收站恢复图片，原链接随即恢复访问。
+func RestoreTrashedImage(c *gin.Context) {
+	image, ok := loadTrashedImage(c)
+	if !ok {
+		return
+	}
+	if err := services.RestoreImage(database.GetDB().DB, image.Id); err != nil {
+		if errors.Is(err, services.ErrImageNotTrashed) {
+			c.JSON(http.StatusNotFound, result.Error(404, "图片不在回收站中"))
+			return
+		}
+		log.Printf("恢复图片 %d 失败：%v", image.Id, err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "恢复图片失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("图片已恢复", nil))
+}
+
+// PurgeTrashedImage 立即彻底删除回收站中的图片，远端副本交由删除队列清理。
+func PurgeTrashedImage(c *gin.Context) {
+	image, ok := loadTrashedImage(c)
+	if !ok {
+		return
+	}
+
+	if err := services.DeleteImage(image); err != nil {
+		log.Printf("彻底删除图片 %d 失败：%v", image.Id, err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "彻底删除图片失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("图片已彻底删除，远端副本将在后台清理", nil))
+}
+
+func loadTrashedImage(c *gin.Context) (models.Image, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "图片ID无效"))
+		return models.Image{}, false
+	}
+	var image models.Image
+	if err := database.GetDB().DB.Unscoped().Where("deleted_at IS NOT NULL").First(&image, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, result.Error(404, "图片不在回收站中"))
+		return models.Image{}, false
+	}
+	if !CheckImageAccessPermission(c, image, "image:delete") {
+		c.JSON(http.StatusForbidden, result.Error(403, "无权操作此图片"))
+		return models.Image{}, false
+	}
+	return image, true
+}
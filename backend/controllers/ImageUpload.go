@@ -55,8 +55,13 @@ func UploadImages(c *gin.Context) {
 		uc.Fail(500, "获取上传配置失败：%v", err)
 		return
 	}
+	expiresAt, err := resolveUploadExpiry(c, setting, c.PostForm("expires_in"))
+	if err != nil {
+		uc.Fail(400, "%v", err)
+		return
+	}
 	if !setting.MultiStorageSync {
-		uploadImagesLegacy(c, setting, existingTags)
+		uploadImagesLegacy(c, setting, existingTags, expiresAt)
 		return
 	}
 
@@ -73,6 +78,11 @@ func UploadImages(c *gin.Context) {
 		return
 	}
 
+	tagNames := make([]string, 0, len(existingTags))
+	for _, tag := range existingTags {
+		tagNames = append(tagNames, tag.Name)
+	}
+
 	// 请求内只处理一次并持久化到本机；远端副本由持久化后台任务上传。
 	uploader, err := uc.GetStorageUploader(&setting, &localBucket)
 	if err != nil {
@@ -105,6 +115,13 @@ func UploadImages(c *gin.Context) {
 			UserId:    c.GetInt("user_id"),
 			MD5:       md5.Md5(c.GetString("username") + fileResult.FileName),
 			UUID:      GetUUID(c),
+			ExpiresAt: expiresAt,
+		}
+		replicaTargets, err := resolveReplicaTargets(c, syncBuckets, tagNames, fileResult)
+		if err != nil {
+			cleanupLocalUpload(imageModel, thumbnailVariantPaths(fileResult.Thumbnails)...)
+			uc.Fail(500, "匹配复制策略失败：%v", err)
+			return
 		}
 
 		now := time.Now()
@@ -127,8 +144,11 @@ func UploadImages(c *gin.Context) {
 			if err := tx.Create(&localStatus).Error; err != nil {
 				return err
 			}
+			if err := createImageThumbnailRecords(tx, imageModel.Id, fileResult.Thumbnails); err != nil {
+				return err
+			}
 
-			for _, bucket := range syncBuckets {
+			for _, bucket := range replicaTargets {
 				storageStatus := models.ImageStorage{
 					ImageID:       imageModel.Id,
 					BucketID:      bucket.Id,
@@ -159,10 +179,11 @@ func UploadImages(c *gin.Context) {
 			return nil
 		})
 		if err != nil {
-			cleanupLocalUpload(imageModel)
+			cleanupLocalUpload(imageModel, thumbnailVariantPaths(fileResult.Thumbnails)...)
 			uc.Fail(500, "保存文件记录失败：%v", err)
 			return
 		}
+		archiveUploadOriginal(setting, imageModel, fileResult, file)
 
 		responseResult := *fileResult
 		responseResult.ID = imageModel.Id
@@ -206,6 +227,19 @@ func UploadImages(c *gin.Context) {
 	})
 }
 
+// resolveUploadExpiry 解析 expires_in（秒），缺省或超过角色默认有效期时按角色默认值。
+func resolveUploadExpiry(c *gin.Context, setting models.Settings, rawExpiresIn string) (*time.Time, error) {
+	var expiresIn int64
+	if raw := strings.TrimSpace(rawExpiresIn); raw != "" {
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("有效期必须是整数秒")
+		}
+		expiresIn = value
+	}
+	return services.ResolveImageExpiry(setting, c.GetInt("user_role"), expiresIn, time.Now())
+}
+
 // UploadImage 单文件上传
 func UploadImage(c *gin.Context) {
 	UploadImages(c)
@@ -284,10 +318,22 @@ func AddImageTag(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, result.Error(500, "添加标签失败："+err.Error()))
 		return
 	}
+	applyReplicationPoliciesAfterTagging([]int{imageId})
 
 	c.JSON(http.StatusOK, result.Success("标签添加成功", nil))
 }
 
+// applyReplicationPoliciesAfterTagging 图片新增标签后重新匹配复制策略，补建缺少的副本任务。
+func applyReplicationPoliciesAfterTagging(imageIDs []int) {
+	setting, err := settings.GetSettings()
+	if err != nil || !setting.MultiStorageSync {
+		return
+	}
+	if _, err := services.ApplyReplicationPolicies(database.GetDB().DB, imageIDs); err != nil {
+		log.Printf("按复制策略补建副本任务失败：%v", err)
+	}
+}
+
 // DeleteImageTag 单个删除图片标签
 func DeleteImageTag(c *gin.Context) {
 	type TagRequest struct {
@@ -488,6 +534,11 @@ func AddImageTags(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, result.Error(500, "批量添加标签失败："+err.Error()))
 			return
 		}
+		imageIDs := make([]int, 0, len(insertData))
+		for _, relation := range insertData {
+			imageIDs = append(imageIDs, relation.ImageId)
+		}
+		applyReplicationPoliciesAfterTagging(imageIDs)
 	} else {
 		c.JSON(http.StatusOK, result.Success("没有需要添加的标签(可能已全部存在)", nil))
 		return
@@ -523,6 +574,7 @@ func GetUploadConfig(c *gin.Context) {
 	config := map[string]any{
 		"tags":               tags,
 		"multi_storage_sync": setting.MultiStorageSync,
+		"default_expires_in": int64(services.RoleImageExpiry(setting, c.GetInt("user_role")).Seconds()), // 0 为永久
 	}
 	if !setting.MultiStorageSync {
 		buckets, err := resolveLegacyUploadBuckets(c, setting)
@@ -574,9 +626,10 @@ func UploadImagesByURL(c *gin.Context) {
 	db := database.GetDB()
 
 	type URLUploadRequest struct {
-		Urls     string `json:"url" binding:"required"`
-		Tag      string `json:"tag_id"`
-		BucketID string `json:"bucket_id"` // 兼容旧客户端，目标存储源以用户配置为准。
+		Urls      string      `json:"url" binding:"required"`
+		Tag       string      `json:"tag_id"`
+		BucketID  string      `json:"bucket_id"`  // 兼容旧客户端，目标存储源以用户配置为准。
+		ExpiresIn json.Number `json:"expires_in"` // 有效秒数，缺省按角色默认有效期
 	}
 
 	var req URLUploadRequest
@@ -589,9 +642,10 @@ func UploadImagesByURL(c *gin.Context) {
 		uc.Fail(400, "URL不能为空")
 		return
 	}
+	var tag *models.Tags
 	if req.Tag != "" && req.Tag != "0" {
-		var tags models.Tags
-		if err := db.DB.Where("id = ?", req.Tag).First(&tags).Error; err != nil {
+		tag = &models.Tags{}
+		if err := db.DB.Where("id = ?", req.Tag).First(tag).Error; err != nil {
 			uc.Fail(400, "标签不存在")
 			return
 		}
@@ -602,8 +656,13 @@ func UploadImagesByURL(c *gin.Context) {
 		uc.Fail(500, "获取上传配置失败：%v", err)
 		return
 	}
+	expiresAt, err := resolveUploadExpiry(c, setting, req.ExpiresIn.String())
+	if err != nil {
+		uc.Fail(400, "%v", err)
+		return
+	}
 	if !setting.MultiStorageSync {
-		uploadImageByURLLegacy(c, setting, req.Urls, req.Tag, req.BucketID)
+		uploadImageByURLLegacy(c, setting, req.Urls, req.Tag, req.BucketID, expiresAt)
 		return
 	}
 
@@ -700,6 +759,18 @@ func UploadImagesByURL(c *gin.Context) {
 		UserId:    c.GetInt("user_id"),
 		MD5:       md5.Md5(c.GetString("username") + fileResult.FileName),
 		UUID:      GetUUID(c),
+		ExpiresAt: expiresAt,
+	}
+
+	var tagNames []string
+	if tag != nil {
+		tagNames = []string{tag.Name}
+	}
+	replicaTargets, err := resolveReplicaTargets(c, syncBuckets, tagNames, fileResult)
+	if err != nil {
+		cleanupLocalUpload(imageModel, thumbnailVariantPaths(fileResult.Thumbnails)...)
+		uc.Fail(500, "匹配复制策略失败：%v", err)
+		return
 	}
 
 	now := time.Now()
@@ -721,7 +792,10 @@ func UploadImagesByURL(c *gin.Context) {
 		if err := tx.Create(&localStatus).Error; err != nil {
 			return err
 		}
-		for _, bucket := range syncBuckets {
+		if err := createImageThumbnailRecords(tx, imageModel.Id, fileResult.Thumbnails); err != nil {
+			return err
+		}
+		for _, bucket := range replicaTargets {
 			storageStatus := models.ImageStorage{
 				ImageID:       imageModel.Id,
 				BucketID:      bucket.Id,
@@ -748,10 +822,11 @@ func UploadImagesByURL(c *gin.Context) {
 		return nil
 	})
 	if err != nil {
-		cleanupLocalUpload(imageModel)
+		cleanupLocalUpload(imageModel, thumbnailVariantPaths(fileResult.Thumbnails)...)
 		uc.Fail(500, "保存文件记录失败：%v", err)
 		return
 	}
+	archiveUploadOriginal(setting, imageModel, fileResult, header)
 
 	// TG通知
 	if setting.TGNotice {
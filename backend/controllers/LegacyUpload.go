@@ -27,7 +27,7 @@ import (
 
 // uploadImagesLegacy keeps the original request-time, single-bucket upload
 // path used when multi-storage synchronization is disabled.
-func uploadImagesLegacy(c *gin.Context, setting models.Settings, existingTags []models.Tags) {
+func uploadImagesLegacy(c *gin.Context, setting models.Settings, existingTags []models.Tags, expiresAt *time.Time) {
 	uc := uploads.NewUploadContext(c)
 	db := database.GetDB()
 
@@ -101,6 +101,7 @@ func uploadImagesLegacy(c *gin.Context, setting models.Settings, existingTags []
 			UserId:    c.GetInt("user_id"),
 			MD5:       md5.Md5(c.GetString("username") + fileResult.FileName),
 			UUID:      GetUUID(c),
+			ExpiresAt: expiresAt,
 		}
 
 		now := time.Now()
@@ -122,6 +123,9 @@ func uploadImagesLegacy(c *gin.Context, setting models.Settings, existingTags []
 			if err := tx.Create(&storageStatus).Error; err != nil {
 				return err
 			}
+			if err := createImageThumbnailRecords(tx, imageModel.Id, fileResult.Thumbnails); err != nil {
+				return err
+			}
 			if len(existingTags) > 0 {
 				relations := make([]models.ImageToTags, 0, len(existingTags))
 				for _, tag := range existingTags {
@@ -136,6 +140,7 @@ func uploadImagesLegacy(c *gin.Context, setting models.Settings, existingTags []
 			uc.Fail(http.StatusInternalServerError, "保存文件记录失败：%v", err)
 			return
 		}
+		archiveUploadOriginal(setting, imageModel, fileResult, file)
 
 		if fileResult.Storage != "default" {
 			totalSize := uint64(fileResult.FileSize + fileResult.ThumbnailSize)
@@ -173,7 +178,7 @@ func uploadImagesLegacy(c *gin.Context, setting models.Settings, existingTags []
 	})
 }
 
-func uploadImageByURLLegacy(c *gin.Context, setting models.Settings, rawURL, tag, rawBucketID string) {
+func uploadImageByURLLegacy(c *gin.Context, setting models.Settings, rawURL, tag, rawBucketID string, expiresAt *time.Time) {
 	uc := uploads.NewUploadContext(c)
 	db := database.GetDB()
 	bucketID, err := resolveLegacyRequestedBucketID(c, setting, rawBucketID)
@@ -293,6 +298,7 @@ func uploadImageByURLLegacy(c *gin.Context, setting models.Settings, rawURL, tag
 		UserId:    c.GetInt("user_id"),
 		MD5:       md5.Md5(c.GetString("username") + fileResult.FileName),
 		UUID:      GetUUID(c),
+		ExpiresAt: expiresAt,
 	}
 	now := time.Now()
 	if err := db.DB.Transaction(func(tx *gorm.DB) error {
@@ -313,6 +319,9 @@ func uploadImageByURLLegacy(c *gin.Context, setting models.Settings, rawURL, tag
 		if err := tx.Create(&storageStatus).Error; err != nil {
 			return err
 		}
+		if err := createImageThumbnailRecords(tx, imageModel.Id, fileResult.Thumbnails); err != nil {
+			return err
+		}
 		if tag != "" && tag != "0" {
 			tagID, err := strconv.Atoi(tag)
 			if err != nil {
@@ -325,6 +334,7 @@ func uploadImageByURLLegacy(c *gin.Context, setting models.Settings, rawURL, tag
 		uc.Fail(http.StatusInternalServerError, "保存文件记录失败：%v", err)
 		return
 	}
+	archiveUploadOriginal(setting, imageModel, fileResult, header)
 
 	if fileResult.Storage != "default" {
 		totalSize := uint64(fileResult.FileSize + fileResult.ThumbnailSize)
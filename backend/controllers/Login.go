@@ -11,7 +11,9 @@ import (
 	"time"
 
 	"oneimg/backend/database"
+	"oneimg/backend/middlewares"
 	"oneimg/backend/models"
+	"oneimg/backend/services"
 	"oneimg/backend/utils/result"
 
 	"github.com/gin-contrib/sessions"
@@ -123,15 +125,42 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	totpEnabled, err := services.UserTOTPEnabled(db.DB, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询两步验证状态失败"))
+		return
+	}
+	if totpEnabled {
+		if err := savePendingTwoFactor(c, user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, result.Error(500, "session保存失败："+err.Error()))
+			return
+		}
+		c.JSON(http.StatusOK, result.Success("请输入两步验证码", map[string]any{
+			"two_factor_required": true,
+		}))
+		return
+	}
+
 	session, err := SetSession(c, &user)
 	if err != nil {
 		return
 	}
 
+	// 角色被强制启用两步验证但尚未绑定：仅开放绑定相关接口
+	setupRequired := services.TOTPRequiredForRole(settings, user.Role)
+	if setupRequired {
+		session.Set(middlewares.SessionTOTPSetupRequired, true)
+		if err := session.Save(); err != nil {
+			c.JSON(http.StatusInternalServerError, result.Error(500, "session保存失败："+err.Error()))
+			return
+		}
+	}
+
 	user.Password = ""
 	c.JSON(http.StatusOK, result.Success("登录成功", map[string]any{
-		"token": session.ID(),
-		"user":  user,
+		"token":                     session.ID(),
+		"user":                      user,
+		"two_factor_setup_required": setupRequired,
 	}))
 }
 
@@ -175,6 +204,10 @@ func saveUserSession(c *gin.Context, user *models.User) (sessions.Session, error
 	session.Set("user_role", user.Role)
 	session.Set("username", user.Username)
 	session.Set("logged_in", true)
+	session.Delete(sessionPendingTwoFactorUser)
+	session.Delete(sessionPendingTwoFactorExpires)
+	session.Delete(sessionPendingTwoFactorAttempts)
+	session.Delete(middlewares.SessionTOTPSetupRequired)
 	session.Options(sessions.Options{
 		MaxAge:   24 * 60 * 60,
 		HttpOnly: true,
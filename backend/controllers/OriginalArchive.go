@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"oneimg/backend/interfaces"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+)
+
+// archiveUploadOriginal 图片因超过最大边长被缩小且配置了原图归档存储桶时，
+// 将未处理的原始文件暂存到本机并交给后台任务归档。归档失败不影响本次上传。
+func archiveUploadOriginal(setting models.Settings, image models.Image, fileResult *interfaces.ImageUploadResult, header *multipart.FileHeader) {
+	if !fileResult.Downscaled || setting.ArchiveOriginalBucket <= 0 || header == nil {
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		log.Printf("读取原图失败（图片ID=%d）：%v", image.Id, err)
+		return
+	}
+	defer file.Close()
+	original, err := io.ReadAll(file)
+	if err != nil {
+		log.Printf("读取原图失败（图片ID=%d）：%v", image.Id, err)
+		return
+	}
+
+	mimeType := http.DetectContentType(original)
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType = header.Header.Get("Content-Type")
+	}
+	if err := services.StageOriginalArchive(
+		image, original, mimeType,
+		fileResult.OriginalWidth, fileResult.OriginalHeight,
+		setting.ArchiveOriginalBucket, setting.EncryptedStorage,
+	); err != nil {
+		log.Printf("归档原图失败（图片ID=%d）：%v", image.Id, err)
+	}
+}
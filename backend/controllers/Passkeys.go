@@ -0,0 +1,396 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"oneimg/backend/config"
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+	"oneimg/backend/utils/settings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// 通行密钥注册与登录进行中时暂存在会话中的挑战值。
+const (
+	sessionPasskeyRegisterChallenge = "webauthn_register_challenge"
+	sessionPasskeyRegisterExpires   = "webauthn_register_expires"
+	sessionPasskeyLoginChallenge    = "webauthn_login_challenge"
+	sessionPasskeyLoginExpires      = "webauthn_login_expires"
+)
+
+// passkeyCredentialJSON 浏览器 PublicKeyCredential.toJSON() 的结果，二进制字段均为 base64url。
+type passkeyCredentialJSON struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		AuthenticatorData string   `json:"authenticatorData"`
+		Signature         string   `json:"signature"`
+		UserHandle        string   `json:"userHandle"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+type finishPasskeyRegistrationRequest struct {
+	Name       string                `json:"name"`
+	Credential passkeyCredentialJSON `json:"credential" binding:"required"`
+}
+
+type beginPasskeyLoginRequest struct {
+	Username string `json:"username"`
+}
+
+// BeginPasskeyRegistration 生成注册通行密钥所需的 PublicKeyCredentialCreationOptions。
+func BeginPasskeyRegistration(c *gin.Context) {
+	user, ok := requireAccountSession(c)
+	if !ok {
+		return
+	}
+	rp, ok := passkeyRelyingParty(c)
+	if !ok {
+		return
+	}
+
+	var credentials []models.WebAuthnCredential
+	if err := database.GetDB().DB.Where("user_id = ?", user.ID).Find(&credentials).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询通行密钥失败"))
+		return
+	}
+	if len(credentials) >= services.MaxWebAuthnCredentialsPerUser {
+		c.JSON(http.StatusConflict, result.Error(409, fmt.Sprintf("每个用户最多注册 %d 个通行密钥", services.MaxWebAuthnCredentialsPerUser)))
+		return
+	}
+
+	challenge, ok := startPasskeyCeremony(c, sessionPasskeyRegisterChallenge, sessionPasskeyRegisterExpires)
+	if !ok {
+		return
+	}
+	params := make([]gin.H, 0, len(services.WebAuthnAlgorithms))
+	for _, alg := range services.WebAuthnAlgorithms {
+		params = append(params, gin.H{"type": "public-key", "alg": alg})
+	}
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{
+		"publicKey": gin.H{
+			"rp": gin.H{"id": rp.ID, "name": rp.Name},
+			"user": gin.H{
+				"id":          base64.RawURLEncoding.EncodeToString(services.WebAuthnUserHandle(user.ID)),
+				"name":        user.Username,
+				"displayName": user.Username,
+			},
+			"challenge":          challenge,
+			"pubKeyCredParams":   params,
+			"timeout":            services.WebAuthnTimeout.Milliseconds(),
+			"attestation":        "none",
+			"excludeCredentials": passkeyDescriptors(credentials),
+			"authenticatorSelection": gin.H{
+				"residentKey":      "preferred",
+				"userVerification": "required",
+			},
+		},
+	}))
+}
+
+// FinishPasskeyRegistration 校验验证器返回的注册结果并保存通行密钥。
+func FinishPasskeyRegistration(c *gin.Context) {
+	user, ok := requireAccountSession(c)
+	if !ok {
+		return
+	}
+	rp, ok := passkeyRelyingParty(c)
+	if !ok {
+		return
+	}
+	challenge := takePasskeyChallenge(c, sessionPasskeyRegisterChallenge, sessionPasskeyRegisterExpires)
+
+	var req finishPasskeyRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "请求参数无效"))
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "通行密钥"
+	}
+	if len([]rune(name)) > 64 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "名称长度不能超过64个字符"))
+		return
+	}
+	if challenge == "" {
+		c.JSON(http.StatusBadRequest, result.Error(400, "注册已过期，请重试"))
+		return
+	}
+	clientData, errData := services.DecodeWebAuthnBase64(req.Credential.Response.ClientDataJSON)
+	attestation, errAttestation := services.DecodeWebAuthnBase64(req.Credential.Response.AttestationObject)
+	if req.Credential.Type != "public-key" || errData != nil || errAttestation != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "通行密钥数据无效"))
+		return
+	}
+
+	registration, err := services.VerifyWebAuthnRegistration(rp, challenge, clientData, attestation)
+	if err != nil {
+		log.Printf("通行密钥注册校验失败：%v", err)
+		c.JSON(http.StatusBadRequest, result.Error(400, "通行密钥校验失败"))
+		return
+	}
+
+	credential := models.WebAuthnCredential{
+		UserID:       user.ID,
+		Name:         name,
+		CredentialID: base64.RawURLEncoding.EncodeToString(registration.CredentialID),
+		PublicKey:    registration.PublicKey,
+		Algorithm:    registration.Algorithm,
+		SignCount:    registration.SignCount,
+		AAGUID:       formatAAGUID(registration.AAGUID),
+		Transports:   req.Credential.Response.Transports,
+	}
+	if credential.Transports == nil {
+		credential.Transports = []string{}
+	}
+	db := database.GetDB().DB
+	var existing int64
+	if err := db.Model(&models.WebAuthnCredential{}).Where("credential_id = ?", credential.CredentialID).Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "保存通行密钥失败"))
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, result.Error(409, "该通行密钥已注册"))
+		return
+	}
+	if err := db.Create(&credential).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "保存通行密钥失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("通行密钥已添加", gin.H{"passkey": credential}))
+}
+
+// GetPasskeys 列出当前用户的通行密钥。
+func GetPasskeys(c *gin.Context) {
+	user, ok := requireAccountSession(c)
+	if !ok {
+		return
+	}
+	var credentials []models.WebAuthnCredential
+	if err := database.GetDB().DB.Where("user_id = ?", user.ID).Order("id DESC").Find(&credentials).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询通行密钥失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{"passkeys": credentials}))
+}
+
+// DeletePasskey 吊销当前用户的一个通行密钥。
+func DeletePasskey(c *gin.Context) {
+	user, ok := requireAccountSession(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "通行密钥ID无效"))
+		return
+	}
+	deleted := database.GetDB().DB.Where("id = ? AND user_id = ?", id, user.ID).Delete(&models.WebAuthnCredential{})
+	if deleted.Error != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "删除通行密钥失败"))
+		return
+	}
+	if deleted.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, result.Error(404, "通行密钥不存在"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("通行密钥已删除", nil))
+}
+
+// BeginPasskeyLogin 生成通行密钥登录所需的 PublicKeyCredentialRequestOptions。
+// 不填用户名时由验证器列出可发现凭证；用户不存在时同样返回空列表，避免暴露用户名是否存在。
+func BeginPasskeyLogin(c *gin.Context) {
+	rp, ok := passkeyRelyingParty(c)
+	if !ok {
+		return
+	}
+	var req beginPasskeyLoginRequest
+	_ = c.ShouldBindJSON(&req)
+
+	credentials := []models.WebAuthnCredential{}
+	if username := strings.TrimSpace(req.Username); username != "" {
+		db := database.GetDB().DB
+		if err := db.Joins("JOIN users ON users.id = webauthn_credentials.user_id").
+			Where("users.username = ?", username).
+			Find(&credentials).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, result.Error(500, "查询通行密钥失败"))
+			return
+		}
+	}
+
+	challenge, ok := startPasskeyCeremony(c, sessionPasskeyLoginChallenge, sessionPasskeyLoginExpires)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{
+		"publicKey": gin.H{
+			"rpId":             rp.ID,
+			"challenge":        challenge,
+			"timeout":          services.WebAuthnTimeout.Milliseconds(),
+			"userVerification": "required",
+			"allowCredentials": passkeyDescriptors(credentials),
+		},
+	}))
+}
+
+// FinishPasskeyLogin 校验通行密钥签名并复用 SetSession 建立登录会话。
+// 通行密钥本身可抵御钓鱼，且要求认证器完成用户验证（PIN 或生物识别），
+// 登录时不再要求 TOTP 两步验证。
+func FinishPasskeyLogin(c *gin.Context) {
+	rp, ok := passkeyRelyingParty(c)
+	if !ok {
+		return
+	}
+	challenge := takePasskeyChallenge(c, sessionPasskeyLoginChallenge, sessionPasskeyLoginExpires)
+
+	var credentialJSON passkeyCredentialJSON
+	if err := c.ShouldBindJSON(&credentialJSON); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "请求参数无效"))
+		return
+	}
+	if challenge == "" {
+		c.JSON(http.StatusUnauthorized, result.Error(401, "登录已过期，请重试"))
+		return
+	}
+	rawID := credentialJSON.RawID
+	if rawID == "" {
+		rawID = credentialJSON.ID
+	}
+	credentialID, errID := services.DecodeWebAuthnBase64(rawID)
+	clientData, errData := services.DecodeWebAuthnBase64(credentialJSON.Response.ClientDataJSON)
+	authData, errAuth := services.DecodeWebAuthnBase64(credentialJSON.Response.AuthenticatorData)
+	signature, errSig := services.DecodeWebAuthnBase64(credentialJSON.Response.Signature)
+	userHandle, errHandle := services.DecodeWebAuthnBase64(credentialJSON.Response.UserHandle)
+	if credentialJSON.Type != "public-key" || errors.Join(errID, errData, errAuth, errSig, errHandle) != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "通行密钥数据无效"))
+		return
+	}
+
+	db := database.GetDB().DB
+	var credential models.WebAuthnCredential
+	if err := db.Where("credential_id = ?", base64.RawURLEncoding.EncodeToString(credentialID)).First(&credential).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, result.Error(401, "通行密钥未注册"))
+		return
+	}
+	if len(userHandle) > 0 && string(userHandle) != string(services.WebAuthnUserHandle(credential.UserID)) {
+		c.JSON(http.StatusUnauthorized, result.Error(401, "通行密钥校验失败"))
+		return
+	}
+
+	signCount, err := services.VerifyWebAuthnAssertion(rp, challenge, credential, clientData, authData, signature)
+	if err != nil {
+		log.Printf("通行密钥登录校验失败：%v", err)
+		c.JSON(http.StatusUnauthorized, result.Error(401, "通行密钥校验失败"))
+		return
+	}
+	if err := services.RecordWebAuthnUse(db, credential, signCount); err != nil {
+		if errors.Is(err, services.ErrWebAuthnSignCounter) {
+			log.Printf("通行密钥 %d 签名计数未递增（已存 %d，收到 %d），可能被克隆", credential.ID, credential.SignCount, signCount)
+			c.JSON(http.StatusUnauthorized, result.Error(401, "通行密钥签名计数异常，请联系管理员"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "更新通行密钥失败"))
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, credential.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, result.Error(401, "用户不存在"))
+		return
+	}
+	session, err := SetSession(c, &user)
+	if err != nil {
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("登录成功", map[string]any{
+		"token": session.ID(),
+		"user":  user,
+	}))
+}
+
+// passkeyRelyingParty 以 APP_URL 作为通行密钥的依赖方，未配置时无法使用。
+func passkeyRelyingParty(c *gin.Context) (services.WebAuthnRelyingParty, bool) {
+	siteURL := ""
+	if config.App != nil {
+		siteURL = config.App.AppURL
+	}
+	name := services.TOTPIssuer
+	if setting, err := settings.GetSettings(); err == nil && strings.TrimSpace(setting.SEOTitle) != "" {
+		name = strings.TrimSpace(setting.SEOTitle)
+	}
+	rp, err := services.NewWebAuthnRelyingParty(siteURL, name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "APP_URL 未配置或无效，无法使用通行密钥"))
+		return rp, false
+	}
+	return rp, true
+}
+
+// startPasskeyCeremony 生成挑战值并写入会话。
+func startPasskeyCeremony(c *gin.Context, challengeKey, expiresKey string) (string, bool) {
+	challenge, err := services.NewWebAuthnChallenge()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "生成挑战值失败"))
+		return "", false
+	}
+	session := sessions.Default(c)
+	session.Set(challengeKey, challenge)
+	session.Set(expiresKey, time.Now().Add(services.WebAuthnTimeout).Unix())
+	if err := session.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "session保存失败："+err.Error()))
+		return "", false
+	}
+	return challenge, true
+}
+
+// takePasskeyChallenge 取出并作废会话中的挑战值，过期或不存在时返回空串。
+func takePasskeyChallenge(c *gin.Context, challengeKey, expiresKey string) string {
+	session := sessions.Default(c)
+	challenge, _ := session.Get(challengeKey).(string)
+	expiresAt, _ := session.Get(expiresKey).(int64)
+	session.Delete(challengeKey)
+	session.Delete(expiresKey)
+	_ = session.Save()
+	if time.Now().Unix() > expiresAt {
+		return ""
+	}
+	return challenge
+}
+
+func passkeyDescriptors(credentials []models.WebAuthnCredential) []gin.H {
+	descriptors := make([]gin.H, 0, len(credentials))
+	for _, credential := range credentials {
+		descriptor := gin.H{"type": "public-key", "id": credential.CredentialID}
+		if len(credential.Transports) > 0 {
+			descriptor["transports"] = credential.Transports
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+	return descriptors
+}
+
+// formatAAGUID 按 UUID 格式输出验证器型号标识。
+func formatAAGUID(aaguid []byte) string {
+	if len(aaguid) != 16 {
+		return ""
+	}
+	encoded := hex.EncodeToString(aaguid)
+	return encoded[:8] + "-" + encoded[8:12] + "-" + encoded[12:16] + "-" + encoded[16:20] + "-" + encoded[20:]
+}
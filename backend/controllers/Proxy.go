@@ -16,6 +16,7 @@ import (
 
 	"oneimg/backend/database"
 	"oneimg/backend/models"
+	"oneimg/backend/services"
 	"oneimg/backend/utils/buckets"
 	"oneimg/backend/utils/ftp"
 	"oneimg/backend/utils/result"
@@ -38,15 +39,25 @@ type resolvedImageAccess struct {
 	path        string
 }
 
-func resolveImageAccess(db *gorm.DB, image models.Image, thumbnail bool) (resolvedImageAccess, error) {
+func resolveImageAccess(db *gorm.DB, setting models.Settings, image models.Image, thumbnail bool) (resolvedImageAccess, error) {
 	canonicalPath := image.Url
 	if thumbnail {
 		canonicalPath = image.Thumbnail
 	}
 
-	// An explicitly selected source must have both an enabled bucket and a
-	// successful replica. If it cannot currently serve the requested object,
-	// transparently fall back to the durable local copy.
+	// Without an explicit source, a non-local delivery policy picks among
+	// the healthy replicas before the default local/canonical order applies.
+	if image.AccessBucketId == 0 {
+		if policy := services.EffectiveDeliveryPolicy(setting, image); policy != services.DeliveryPolicyLocal {
+			if resolved, ok := resolveDeliveryPolicyAccess(db, image.Id, policy, thumbnail); ok {
+				return resolved, nil
+			}
+		}
+	}
+
+	// An explicitly selected source must have both an enabled, healthy bucket
+	// and a successful replica. If it cannot currently serve the requested
+	// object, transparently fall back to the durable local copy.
 	if image.AccessBucketId > 0 {
 		if resolved, ok := resolveImageReplicaAccess(db, image.Id, image.AccessBucketId, thumbnail); ok {
 			return resolved, nil
@@ -65,7 +76,7 @@ func resolveImageAccess(db *gorm.DB, image models.Image, thumbnail bool) (resolv
 
 	var canonicalBucket models.Buckets
 	canonicalErr := db.First(&canonicalBucket, image.BucketId).Error
-	if canonicalErr == nil && !canonicalBucket.Disabled && canonicalPath != "" {
+	if canonicalErr == nil && !canonicalBucket.Disabled && !canonicalBucket.Unhealthy && canonicalPath != "" {
 		storageType := image.Storage
 		if storageType == "" {
 			storageType = canonicalBucket.Type
@@ -88,6 +99,10 @@ func resolveImageAccess(db *gorm.DB, image models.Image, thumbnail bool) (resolv
 	if resolved, ok := resolveLocalImageAccess(db, image.Id, thumbnail); ok {
 		return resolved, nil
 	}
+	// The preferred sources are down; fail over to any healthy replica.
+	if resolved, ok := resolveHealthyReplicaAccess(db, image.Id, thumbnail); ok {
+		return resolved, nil
+	}
 	if canonicalErr != nil && !errors.Is(canonicalErr, gorm.ErrRecordNotFound) {
 		return resolvedImageAccess{}, canonicalErr
 	}
@@ -104,7 +119,7 @@ func resolveImageReplicaAccess(db *gorm.DB, imageID, bucketID int, thumbnail boo
 	}
 
 	var bucket models.Buckets
-	if err := db.Where("id = ? AND disabled = ?", bucketID, false).First(&bucket).Error; err != nil {
+	if err := db.Where("id = ? AND disabled = ? AND unhealthy = ?", bucketID, false, false).First(&bucket).Error; err != nil {
 		return resolvedImageAccess{}, false
 	}
 	path := replica.URL
@@ -121,6 +136,47 @@ func resolveImageReplicaAccess(db *gorm.DB, imageID, bucketID int, thumbnail boo
 	return resolvedImageAccess{bucket: bucket, replica: &replica, storageType: storageType, path: path}, true
 }
 
+func resolveDeliveryPolicyAccess(db *gorm.DB, imageID int, policy string, thumbnail bool) (resolvedImageAccess, bool) {
+	var candidates []models.Buckets
+	if err := db.Model(&models.Buckets{}).
+		Select("buckets.*").
+		Joins("JOIN image_storages ON image_storages.bucket_id = buckets.id").
+		Where(
+			"image_storages.image_id = ? AND image_storages.status = ? AND buckets.disabled = ? AND buckets.unhealthy = ?",
+			imageID, models.ImageStorageStatusSuccess, false, false,
+		).
+		Find(&candidates).Error; err != nil {
+		return resolvedImageAccess{}, false
+	}
+	for _, bucket := range services.RankDeliveryBuckets(candidates, policy) {
+		if resolved, ok := resolveImageReplicaAccess(db, imageID, bucket.Id, thumbnail); ok {
+			return resolved, true
+		}
+	}
+	return resolvedImageAccess{}, false
+}
+
+func resolveHealthyReplicaAccess(db *gorm.DB, imageID int, thumbnail bool) (resolvedImageAccess, bool) {
+	var replicas []models.ImageStorage
+	if err := db.Model(&models.ImageStorage{}).
+		Select("image_storages.*").
+		Joins("JOIN buckets ON buckets.id = image_storages.bucket_id").
+		Where(
+			"image_storages.image_id = ? AND image_storages.status = ? AND buckets.disabled = ? AND buckets.unhealthy = ?",
+			imageID, models.ImageStorageStatusSuccess, false, false,
+		).
+		Order("buckets.id ASC").
+		Find(&replicas).Error; err != nil {
+		return resolvedImageAccess{}, false
+	}
+	for _, replica := range replicas {
+		if resolved, ok := resolveImageReplicaAccess(db, imageID, replica.BucketID, thumbnail); ok {
+			return resolved, true
+		}
+	}
+	return resolvedImageAccess{}, false
+}
+
 func resolveLocalImageAccess(db *gorm.DB, imageID int, thumbnail bool) (resolvedImageAccess, bool) {
 	var replica models.ImageStorage
 	if err := db.Model(&models.ImageStorage{}).
@@ -170,7 +226,11 @@ func ImageProxy(c *gin.Context) bool {
 	var imageModel models.Image
 	sqlResult := db.DB.Where("Url = ? OR Thumbnail = ?", cleanPath, cleanPath).First(&imageModel)
 	if sqlResult.Error != nil {
-		// 图片不存在，直接返回，交给 NoRoute 后续逻辑处理（如渲染 SPA）
+		// 图片不存在时再尝试规格缩略图，仍未命中则交给 NoRoute 后续逻辑处理（如渲染 SPA）
+		return serveImageThumbnailVariant(c, db.DB, cleanPath, watermarkCfg)
+	}
+	if services.IsImageExpired(imageModel, time.Now()) {
+		// 已过期等待清理的图片按不存在处理
 		return false
 	}
 
@@ -190,13 +250,23 @@ func ImageProxy(c *gin.Context) bool {
 		}
 	}
 
+	services.RecordImageAccess(db.DB, imageModel)
+
 	// 校验图片元信息
 	if imageModel.Width == 0 && imageModel.Height == 0 {
 		log.Printf("图片[%s]元信息不完整（宽高为0），继续代理访问", cleanPath)
 	}
 
+	// 缓存校验：图片被替换后版本号变化，未变化时直接返回 304，无需读取存储源
+	isThumbnail := imageModel.Thumbnail == cleanPath
+	setImageCacheHeaders(c, imageModel, isThumbnail)
+	if imageNotModified(c) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+
 	// 判断当前访问的是缩略图还是原图
-	access, err := resolveImageAccess(db.DB, imageModel, imageModel.Thumbnail == cleanPath)
+	access, err := resolveImageAccess(db.DB, setting, imageModel, isThumbnail)
 	if err != nil {
 		log.Printf("图片[%s]没有可用的访问存储源: %v", cleanPath, err)
 		c.JSON(http.StatusServiceUnavailable, result.Error(503, "图片存储源暂不可用"))
@@ -204,14 +274,19 @@ func ImageProxy(c *gin.Context) bool {
 	}
 	bucket := access.bucket
 	imageUrl := access.path
+	// 按存储桶处理规格转换过的副本以其实际格式返回
+	mimeType := imageModel.MimeType
+	if !isThumbnail && access.replica != nil && access.replica.MimeType != "" {
+		mimeType = access.replica.MimeType
+	}
 
 	// 传递水印配置到各个代理函数
 	switch access.storageType {
 	case "default":
-		proxyLocalFile(c, imageUrl, imageModel.MimeType, watermarkCfg)
+		proxyLocalFile(c, imageUrl, mimeType, watermarkCfg)
 
 	case "webdav":
-		proxyWebDAVFile(c, imageUrl, imageModel.MimeType, bucket, watermarkCfg)
+		proxyWebDAVFile(c, imageUrl, mimeType, bucket, watermarkCfg)
 	case "r2":
 		// 初始化S3兼容客户端
 		s3Client, err := s3.NewS3Client(setting, bucket)
@@ -221,7 +296,7 @@ func ImageProxy(c *gin.Context) bool {
 		}
 		// 提取 R2 Bucket 名称
 		storageConfig := buckets.ConvertToR2Bucket(bucket.Config)
-		proxyObjectStorageFile(c, imageUrl, imageModel.MimeType, storageConfig.R2Bucket, bucket, s3Client, watermarkCfg)
+		proxyObjectStorageFile(c, imageUrl, mimeType, storageConfig.R2Bucket, bucket, s3Client, watermarkCfg)
 
 	case "s3":
 		// 初始化S3兼容客户端
@@ -232,13 +307,13 @@ func ImageProxy(c *gin.Context) bool {
 		}
 		// 提取 S3 Bucket 名称
 		storageConfig := buckets.ConvertToS3Bucket(bucket.Config)
-		proxyObjectStorageFile(c, imageUrl, imageModel.MimeType, storageConfig.S3Bucket, bucket, s3Client, watermarkCfg)
+		proxyObjectStorageFile(c, imageUrl, mimeType, storageConfig.S3Bucket, bucket, s3Client, watermarkCfg)
 
 	case "ftp":
-		proxyFTPFile(c, imageUrl, imageModel.MimeType, bucket, watermarkCfg)
+		proxyFTPFile(c, imageUrl, mimeType, bucket, watermarkCfg)
 
 	case "telegram":
-		ProxyTelegramFile(c, imageUrl, imageModel.FileName, imageModel.MimeType, bucket, access.replica, watermarkCfg)
+		ProxyTelegramFile(c, imageUrl, imageModel.FileName, mimeType, bucket, access.replica, watermarkCfg)
 
 	default:
 		c.JSON(http.StatusUnprocessableEntity, result.Error(422, fmt.Sprintf("不支持的存储类型: %s", access.storageType)))
@@ -247,6 +322,55 @@ func ImageProxy(c *gin.Context) bool {
 	return true
 }
 
+const (
+	// imageCacheControl 图片文件默认长期缓存
+	imageCacheControl = "public, max-age=31536000"
+	// replacedImageCacheControl 替换过文件的图片使用短缓存并要求过期后按 ETag/Last-Modified 校验，
+	// 再次替换后缓存可在数分钟内更新。
+	replacedImageCacheControl = "public, max-age=300, must-revalidate"
+)
+
+// setImageCacheHeaders 按图片版本设置 Cache-Control、ETag 与 Last-Modified。
+func setImageCacheHeaders(c *gin.Context, image models.Image, thumbnail bool) {
+	if image.Version > 0 {
+		c.Header("Cache-Control", replacedImageCacheControl)
+	} else {
+		c.Header("Cache-Control", imageCacheControl)
+	}
+	variant := "o"
+	if thumbnail {
+		variant = "t"
+	}
+	c.Header("ETag", fmt.Sprintf(`"%d-%d-%s"`, image.Id, image.Version, variant))
+	modified := image.CreatedAt
+	if image.ReplacedAt != nil {
+		modified = *image.ReplacedAt
+	}
+	if !modified.IsZero() {
+		c.Header("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// imageNotModified 判断请求携带的校验值是否与当前版本一致。
+func imageNotModified(c *gin.Context) bool {
+	etag := c.Writer.Header().Get("ETag")
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(c.GetHeader("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(c.Writer.Header().Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
 // serveStoredImage is the single plaintext boundary for every storage
 // backend. Storage objects may be legacy plaintext or versioned ciphertext;
 // browsers always receive the decoded image bytes.
@@ -257,7 +381,10 @@ func serveStoredImage(c *gin.Context, stored io.Reader, mimeType, storageType st
 	}
 
 	c.Header("Content-Type", mimeType)
-	c.Header("Cache-Control", "public, max-age=31536000")
+	// 图片代理已按版本设置缓存策略，其余调用使用默认长期缓存
+	if c.Writer.Header().Get("Cache-Control") == "" {
+		c.Header("Cache-Control", imageCacheControl)
+	}
 	c.Header("X-Storage-Type", storageType)
 	c.Header("Access-Control-Allow-Origin", "*")
 
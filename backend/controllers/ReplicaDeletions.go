@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetReplicaDeletions 分页返回远端文件删除任务及各状态计数，可按状态与存储源筛选。
+func GetReplicaDeletions(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	db := database.GetDB().DB
+	query := db.Model(&models.ReplicaDeletion{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if bucketParam := c.Query("bucket_id"); bucketParam != "" {
+		bucketID, err := strconv.Atoi(bucketParam)
+		if err != nil || bucketID <= 0 {
+			c.JSON(http.StatusBadRequest, result.Error(400, "存储源ID无效"))
+			return
+		}
+		query = query.Where("bucket_id = ?", bucketID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询删除任务失败"))
+		return
+	}
+	var tasks []models.ReplicaDeletion
+	if err := query.Order("id DESC").Offset((page - 1) * limit).Limit(limit).Find(&tasks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询删除任务失败"))
+		return
+	}
+
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := db.Model(&models.ReplicaDeletion{}).Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询删除任务失败"))
+		return
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{
+		"tasks":       tasks,
+		"counts":      counts,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"total_pages": (total + int64(limit) - 1) / int64(limit),
+	}))
+}
+
+// RetryReplicaDeletion 将单个死信删除任务重新加入队列。
+func RetryReplicaDeletion(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "任务ID无效"))
+		return
+	}
+
+	var task models.ReplicaDeletion
+	if err := database.GetDB().DB.First(&task, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, result.Error(404, "删除任务不存在"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询删除任务失败"))
+		return
+	}
+	if task.Status != models.ImageStorageStatusDeadLetter {
+		c.JSON(http.StatusConflict, result.Error(409, "仅死信状态的删除任务可以重试"))
+		return
+	}
+
+	retried, err := services.RetryReplicaDeletions(id)
+	if err != nil {
+		log.Printf("重试删除任务 %d 失败：%v", id, err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "重试删除任务失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("删除任务已重新加入队列", gin.H{"retried": retried}))
+}
+
+// RetryAllReplicaDeletions 重试全部死信删除任务。
+func RetryAllReplicaDeletions(c *gin.Context) {
+	retried, err := services.RetryReplicaDeletions(0)
+	if err != nil {
+		log.Printf("重试全部删除任务失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "重试删除任务失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success(fmt.Sprintf("已重新加入 %d 个删除任务", retried), gin.H{"retried": retried}))
+}
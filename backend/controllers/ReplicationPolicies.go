@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/utils/result"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type replicationPolicyRequest struct {
+	Name      string   `json:"name"`
+	Priority  int      `json:"priority"`
+	Enabled   *bool    `json:"enabled"` // 缺省为启用
+	Tags      []string `json:"tags"`
+	UserIDs   []int    `json:"user_ids"`
+	Roles     []int    `json:"roles"`
+	MimeTypes []string `json:"mime_types"`
+	MinSize   int64    `json:"min_size"`
+	MaxSize   int64    `json:"max_size"`
+	BucketIDs []int    `json:"bucket_ids"`
+	Replicas  int      `json:"replicas"`
+}
+
+// GetReplicationPolicies 按匹配顺序返回全部复制策略。
+func GetReplicationPolicies(c *gin.Context) {
+	var policies []models.ReplicationPolicy
+	if err := database.GetDB().DB.Order("priority ASC, id ASC").Find(&policies).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询复制策略失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", policies))
+}
+
+// CreateReplicationPolicy 新增复制策略。
+func CreateReplicationPolicy(c *gin.Context) {
+	policy, ok := bindReplicationPolicy(c)
+	if !ok {
+		return
+	}
+	if err := database.GetDB().DB.Create(&policy).Error; err != nil {
+		log.Printf("新增复制策略失败：%v", err)
+		c.JSON(http.StatusBadRequest, result.Error(400, "新增复制策略失败，策略名称可能已存在"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("复制策略已创建", policy))
+}
+
+// UpdateReplicationPolicy 修改复制策略，只影响之后的上传与打标签。
+func UpdateReplicationPolicy(c *gin.Context) {
+	existing, ok := loadReplicationPolicy(c)
+	if !ok {
+		return
+	}
+	policy, ok := bindReplicationPolicy(c)
+	if !ok {
+		return
+	}
+	policy.ID = existing.ID
+	policy.CreatedAt = existing.CreatedAt
+	if err := database.GetDB().DB.Save(&policy).Error; err != nil {
+		log.Printf("修改复制策略 %d 失败：%v", existing.ID, err)
+		c.JSON(http.StatusBadRequest, result.Error(400, "修改复制策略失败，策略名称可能已存在"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("复制策略已更新", policy))
+}
+
+// DeleteReplicationPolicy 删除复制策略，已创建的副本保留。
+func DeleteReplicationPolicy(c *gin.Context) {
+	policy, ok := loadReplicationPolicy(c)
+	if !ok {
+		return
+	}
+	if err := database.GetDB().DB.Delete(&policy).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "删除复制策略失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("复制策略已删除", nil))
+}
+
+func bindReplicationPolicy(c *gin.Context) (models.ReplicationPolicy, bool) {
+	var req replicationPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "策略参数无效"))
+		return models.ReplicationPolicy{}, false
+	}
+	policy := models.ReplicationPolicy{
+		Name:      strings.TrimSpace(req.Name),
+		Priority:  req.Priority,
+		Enabled:   req.Enabled == nil || *req.Enabled,
+		Tags:      req.Tags,
+		UserIDs:   req.UserIDs,
+		Roles:     req.Roles,
+		MimeTypes: req.MimeTypes,
+		MinSize:   req.MinSize,
+		MaxSize:   req.MaxSize,
+		BucketIDs: req.BucketIDs,
+		Replicas:  req.Replicas,
+	}
+	if err := policy.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, err.Error()))
+		return models.ReplicationPolicy{}, false
+	}
+	if len(policy.BucketIDs) > 0 {
+		var buckets []models.Buckets
+		if err := database.GetDB().DB.Select("id", "type").Where("id IN ?", policy.BucketIDs).Find(&buckets).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, result.Error(500, "查询存储源失败"))
+			return models.ReplicationPolicy{}, false
+		}
+		known := make(map[int]bool, len(buckets))
+		for _, bucket := range buckets {
+			if bucket.Type == "default" {
+				c.JSON(http.StatusBadRequest, result.Error(400, "本机存储源始终保留副本，无需作为目标存储源"))
+				return models.ReplicationPolicy{}, false
+			}
+			known[bucket.Id] = true
+		}
+		for _, id := range policy.BucketIDs {
+			if !known[id] {
+				c.JSON(http.StatusBadRequest, result.Error(400, "目标存储源不存在："+strconv.Itoa(id)))
+				return models.ReplicationPolicy{}, false
+			}
+		}
+	}
+	return policy, true
+}
+
+func loadReplicationPolicy(c *gin.Context) (models.ReplicationPolicy, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "策略ID无效"))
+		return models.ReplicationPolicy{}, false
+	}
+	var policy models.ReplicationPolicy
+	if err := database.GetDB().DB.First(&policy, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, result.Error(404, "复制策略不存在"))
+			return models.ReplicationPolicy{}, false
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询复制策略失败"))
+		return models.ReplicationPolicy{}, false
+	}
+	return policy, true
+}
@@ -16,6 +16,7 @@ import (
 	"oneimg/backend/database"
 	"oneimg/backend/middlewares"
 	"oneimg/backend/models"
+	"oneimg/backend/services"
 	"oneimg/backend/utils/publicurl"
 	"oneimg/backend/utils/result"
 	"oneimg/backend/utils/secureconfig"
@@ -295,6 +296,9 @@ func buildSettingsUpdate(key string, value any, fieldName string, fieldType refl
 			return "", nil, err
 		}
 		return "public_image_domain", domain, nil
+	case "thumbnail_profiles":
+		normalized, err := normalizeThumbnailProfilesSetting(value)
+		return "thumbnail_profiles", normalized, err
 	case "oidc_issuer":
 		normalized, err := normalizeOIDCIssuer(fmt.Sprintf("%v", value))
 		return "oidc_issuer", normalized, err
@@ -494,6 +498,9 @@ func validateSettingData(key string, value any) error {
 	}
 
 	switch key {
+	case "thumbnail_profiles":
+		_, err := normalizeThumbnailProfilesSetting(value)
+		return err
 	case "oidc_issuer":
 		_, err := normalizeOIDCIssuer(fmt.Sprintf("%v", value))
 		return err
@@ -708,6 +715,141 @@ func validateSettingData(key string, value any) error {
 		if publicurl.HasDomain(setting) && !publicurl.SupportsStorage(bucketType) {
 			return fmt.Errorf("图片直链域名仅支持 S3/R2 存储，请先清空该配置")
 		}
+	case "max_image_dimension":
+		// 0 表示不限制；否则最长边需在合理范围内
+		dimension, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("最大边长必须是整数")
+		}
+		if dimension != 0 && (dimension < 100 || dimension > 20000) {
+			return fmt.Errorf("最大边长必须为0（不限制）或在100-20000之间（当前：%d）", dimension)
+		}
+	case "compress_quality":
+		quality, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("压缩质量必须是整数")
+		}
+		if quality < 1 || quality > 100 {
+			return fmt.Errorf("压缩质量必须在1-100之间（当前：%d）", quality)
+		}
+	case "compress_threshold":
+		threshold, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("压缩阈值必须是整数")
+		}
+		if threshold < 0 {
+			return fmt.Errorf("压缩阈值不能为负数")
+		}
+	case "storage_sync_workers", "storage_sync_bucket_concurrency":
+		concurrency, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("同步并发必须是整数")
+		}
+		if concurrency < 1 || concurrency > services.StorageSyncMaxConcurrency {
+			return fmt.Errorf("同步并发必须在1-%d之间（当前：%d）", services.StorageSyncMaxConcurrency, concurrency)
+		}
+	case "storage_sync_max_attempts":
+		attempts, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("重试次数必须是整数")
+		}
+		if attempts < 1 || attempts > 100 {
+			return fmt.Errorf("重试次数必须在1-100之间（当前：%d）", attempts)
+		}
+	case "storage_sync_backoff_base", "storage_sync_backoff_max":
+		seconds, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("重试等待时间必须是整数秒")
+		}
+		if seconds < 1 || seconds > 86400 {
+			return fmt.Errorf("重试等待时间必须在1-86400秒之间（当前：%d）", seconds)
+		}
+	case "storage_scrub_rate":
+		rate, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("巡检速率必须是整数")
+		}
+		if rate < 0 || rate > services.StorageScrubMaxRate {
+			return fmt.Errorf("巡检速率必须在0-%d之间（当前：%d）", services.StorageScrubMaxRate, rate)
+		}
+	case "storage_scrub_interval":
+		hours, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("巡检间隔必须是整数小时")
+		}
+		if hours < 1 || hours > 8760 {
+			return fmt.Errorf("巡检间隔必须在1-8760小时之间（当前：%d）", hours)
+		}
+	case "storage_scrub_mode":
+		mode, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("巡检模式必须是字符串类型，实际类型：%T", value)
+		}
+		if mode != services.StorageScrubModeHead && mode != services.StorageScrubModeRead {
+			return fmt.Errorf("巡检模式只能是 head 或 read")
+		}
+	case "storage_reconcile_interval":
+		hours, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("用量校准间隔必须是整数小时")
+		}
+		if hours < 0 || hours > 8760 {
+			return fmt.Errorf("用量校准间隔必须在0-8760小时之间（当前：%d）", hours)
+		}
+	case "delivery_policy":
+		policy, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("分发策略必须是字符串类型，实际类型：%T", value)
+		}
+		if !services.ValidDeliveryPolicy(policy) {
+			return fmt.Errorf("分发策略只能是 local、priority、weighted 或 fastest")
+		}
+	case "bucket_health_interval":
+		minutes, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("健康检查间隔必须是整数分钟")
+		}
+		if minutes < 0 || minutes > 1440 {
+			return fmt.Errorf("健康检查间隔必须在0-1440分钟之间（当前：%d）", minutes)
+		}
+	case "bucket_health_threshold":
+		failures, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("失败阈值必须是整数")
+		}
+		if failures < 1 || failures > 100 {
+			return fmt.Errorf("失败阈值必须在1-100之间（当前：%d）", failures)
+		}
+	case "admin_image_expiry", "user_image_expiry", "guest_image_expiry":
+		hours, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("默认有效期必须是整数小时")
+		}
+		if hours < 0 || hours > services.MaxImageExpirySeconds/3600 {
+			return fmt.Errorf("默认有效期必须在0-%d小时之间（当前：%d）", services.MaxImageExpirySeconds/3600, hours)
+		}
+	case "trash_retention_days":
+		days, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("回收站保留天数必须是整数")
+		}
+		if days < 0 || days > 3650 {
+			return fmt.Errorf("回收站保留天数必须在0-3650之间（当前：%d）", days)
+		}
+	case "archive_original_bucket":
+		// 0 表示不保留原图；否则需为已启用的存储桶
+		id, err := settingValueToInt(value)
+		if err != nil {
+			return fmt.Errorf("%s", "解析失败: "+err.Error())
+		}
+		if id < 0 {
+			return fmt.Errorf("原图归档存储桶无效")
+		}
+		if id > 0 {
+			if _, err := getBucketTypeByID(id); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -763,31 +905,55 @@ func settingValueToInt(value any) (int, error) {
 // SettingKeyPermissionMap 设置项key对应的权限码映射
 var SettingKeyPermissionMap = map[string]string{
 	// --- 上传与存储 ---
-	"default_storage":     "setting:upload",
-	"public_image_domain": "setting:upload",
-	"default_path":        "setting:upload",
-	"file_name":           "setting:upload",
-	"max_file_size":       "setting:upload",
-	"allowed_types":       "setting:upload",
-	"multi_storage_sync":  "setting:upload",
-	"encrypted_storage":   "setting:upload",
-	"save_original_name":  "setting:upload",
+	"default_storage":                 "setting:upload",
+	"public_image_domain":             "setting:upload",
+	"default_path":                    "setting:upload",
+	"file_name":                       "setting:upload",
+	"max_file_size":                   "setting:upload",
+	"allowed_types":                   "setting:upload",
+	"multi_storage_sync":              "setting:upload",
+	"encrypted_storage":               "setting:upload",
+	"storage_sync_workers":            "setting:upload",
+	"storage_sync_bucket_concurrency": "setting:upload",
+	"storage_sync_max_attempts":       "setting:upload",
+	"storage_sync_backoff_base":       "setting:upload",
+	"storage_sync_backoff_max":        "setting:upload",
+	"storage_scrub_rate":              "setting:upload",
+	"storage_scrub_interval":          "setting:upload",
+	"storage_scrub_mode":              "setting:upload",
+	"storage_reconcile_interval":      "setting:upload",
+	"delivery_policy":                 "setting:upload",
+	"bucket_health_interval":          "setting:upload",
+	"bucket_health_threshold":         "setting:upload",
+	"save_original_name":              "setting:upload",
+	"admin_image_expiry":              "setting:upload",
+	"user_image_expiry":               "setting:upload",
+	"guest_image_expiry":              "setting:upload",
+	"trash_retention_days":            "setting:upload",
 
 	// --- 图片处理 ---
-	"watermark_enable": "setting:image",
-	"watermark_text":   "setting:image",
-	"watermark_size":   "setting:image",
-	"watermark_color":  "setting:image",
-	"watermark_opac":   "setting:image",
-	"watermark_pos":    "setting:image",
-	"compress_image":   "setting:image",
-	"save_webp":        "setting:image",
-	"thumbnail":        "setting:image",
+	"watermark_enable":        "setting:image",
+	"watermark_text":          "setting:image",
+	"watermark_size":          "setting:image",
+	"watermark_color":         "setting:image",
+	"watermark_opac":          "setting:image",
+	"watermark_pos":           "setting:image",
+	"compress_image":          "setting:image",
+	"save_webp":               "setting:image",
+	"thumbnail":               "setting:image",
+	"thumbnail_profiles":      "setting:image",
+	"max_image_dimension":     "setting:image",
+	"compress_quality":        "setting:image",
+	"compress_threshold":      "setting:image",
+	"lossless_png":            "setting:image",
+	"archive_original_bucket": "setting:image",
 
 	// --- 安全与登录 ---
 	"pow_verify":                "setting:security",
 	"tourist":                   "setting:security",
 	"start_register":            "setting:security",
+	"totp_require_admin":        "setting:security",
+	"totp_require_user":         "setting:security",
 	"referer_white_enable":      "setting:security",
 	"referer_white_list":        "setting:security",
 	"oidc_enable":               "setting:security",
@@ -3,13 +3,21 @@ package controllers
 import (
 	"errors"
 	"fmt"
+	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"oneimg/backend/database"
+	"oneimg/backend/interfaces"
+	"oneimg/backend/middlewares"
 	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -33,7 +41,8 @@ type ImageStorageStatusResponse struct {
 // resolveUploadBuckets returns the durable local source and the remote targets
 // assigned to the current user. In multi-storage mode every persisted user,
 // including administrators, has an explicit list; guests retain the system
-// default because they have no user record.
+// default because they have no user record. Replication policies may replace
+// these targets per file, see resolveReplicaTargets.
 func resolveUploadBuckets(c *gin.Context, setting models.Settings) (models.Buckets, []models.Buckets, error) {
 	db := database.GetDB()
 	if db == nil || db.DB == nil {
@@ -84,7 +93,7 @@ func resolveUploadBuckets(c *gin.Context, setting models.Settings) (models.Bucke
 		if role == models.RoleGuest {
 			allowed = bucket.Id == setting.DefaultStorage
 		}
-		if allowed {
+		if allowed && tokenAllowsBucket(c, bucket.Id) {
 			targets = append(targets, bucket)
 		}
 	}
@@ -92,6 +101,53 @@ func resolveUploadBuckets(c *gin.Context, setting models.Settings) (models.Bucke
 	return localBucket, targets, nil
 }
 
+// resolveReplicaTargets applies the replication policies to one stored upload
+// and falls back to the user's targets when no policy matches.
+func resolveReplicaTargets(c *gin.Context, syncBuckets []models.Buckets, tags []string, fileResult *interfaces.ImageUploadResult) ([]models.Buckets, error) {
+	subject := services.ReplicationSubject{
+		UserID:   c.GetInt("user_id"),
+		Role:     c.GetInt("user_role"),
+		Tags:     tags,
+		MimeType: fileResult.MimeType,
+		FileSize: fileResult.FileSize,
+	}
+	targets, policy, err := services.ResolveReplicationTargets(database.GetDB().DB, subject, syncBuckets)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		targets = slices.DeleteFunc(targets, func(bucket models.Buckets) bool {
+			return !tokenAllowsBucket(c, bucket.Id)
+		})
+		log.Printf("文件 %s 命中复制策略 %d（%s），同步到 %d 个存储源", fileResult.FileName, policy.ID, policy.Name, len(targets))
+	}
+	return targets, nil
+}
+
+// tokenAllowsBucket applies the bucket restriction of the personal access
+// token used for the request, if any.
+func tokenAllowsBucket(c *gin.Context, bucketID int) bool {
+	token, ok := middlewares.APITokenFromContext(c)
+	return !ok || token.AllowsBucket(bucketID)
+}
+
+// tokenAllowsImage applies the bucket restriction of the personal access
+// token to an existing image. Images uploaded in multi-storage mode belong to
+// the local bucket, so a replica in an allowed bucket also counts.
+func tokenAllowsImage(c *gin.Context, image models.Image) bool {
+	token, ok := middlewares.APITokenFromContext(c)
+	if !ok || token.AllowsBucket(image.BucketId) {
+		return true
+	}
+	var replicas int64
+	if err := database.GetDB().DB.Model(&models.ImageStorage{}).
+		Where("image_id = ? AND bucket_id IN ?", image.Id, token.Buckets).
+		Count(&replicas).Error; err != nil {
+		return false
+	}
+	return replicas > 0
+}
+
 // resolveLegacyUploadBuckets preserves the single-storage selector semantics.
 func resolveLegacyUploadBuckets(c *gin.Context, setting models.Settings) ([]models.Buckets, error) {
 	db := database.GetDB()
@@ -133,6 +189,9 @@ func resolveLegacyUploadBuckets(c *gin.Context, setting models.Settings) ([]mode
 		if role == models.RoleGuest && bucket.Id != setting.DefaultStorage {
 			continue
 		}
+		if !tokenAllowsBucket(c, bucket.Id) {
+			continue
+		}
 		result = append(result, bucket)
 	}
 	return result, nil
@@ -211,8 +270,8 @@ func loadImageStorageStatuses(imageIDs []int, setting models.Settings) (map[int]
 	return result, nil
 }
 
-func cleanupLocalUpload(image models.Image) {
-	for _, publicPath := range []string{image.Url, image.Thumbnail} {
+func cleanupLocalUpload(image models.Image, extraPaths ...string) {
+	for _, publicPath := range append([]string{image.Url, image.Thumbnail}, extraPaths...) {
 		path := strings.TrimSpace(publicPath)
 		if path == "" || strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
 			continue
@@ -224,3 +283,100 @@ func cleanupLocalUpload(image models.Image) {
 		_ = os.Remove(cleanPath)
 	}
 }
+
+// RetryStorageReplica 将单个失败或死信副本重新加入同步队列，保留最近一次错误直到下次尝试结束。
+func RetryStorageReplica(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "副本ID无效"))
+		return
+	}
+
+	var replica models.ImageStorage
+	if err := database.GetDB().DB.First(&replica, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, result.Error(404, "副本不存在"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询副本失败"))
+		return
+	}
+	if replica.Status != models.ImageStorageStatusFailed && replica.Status != models.ImageStorageStatusDeadLetter {
+		c.JSON(http.StatusConflict, result.Error(409, "仅失败或死信状态的副本可以重试"))
+		return
+	}
+
+	retried, err := services.RetryReplica(id)
+	if err != nil {
+		log.Printf("重试副本 %d 失败：%v", id, err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "重试副本失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("副本已重新加入同步队列", gin.H{"retried": retried}))
+}
+
+// RetryBucketStorageReplicas 重试某个存储源上全部失败或死信的副本。
+func RetryBucketStorageReplicas(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "存储源ID无效"))
+		return
+	}
+
+	var bucket models.Buckets
+	if err := database.GetDB().DB.First(&bucket, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, result.Error(404, "存储源不存在"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询存储源失败"))
+		return
+	}
+
+	retried, err := services.RetryBucketReplicas(bucket.Id)
+	if err != nil {
+		log.Printf("重试存储源 %d 的失败副本失败：%v", bucket.Id, err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "重试副本失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success(fmt.Sprintf("已重新加入 %d 个副本", retried), gin.H{"retried": retried}))
+}
+
+// RetryAllStorageReplicas 重试全部失败或死信的副本。
+func RetryAllStorageReplicas(c *gin.Context) {
+	retried, err := services.RetryFailedReplicas()
+	if err != nil {
+		log.Printf("重试全部失败副本失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "重试副本失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success(fmt.Sprintf("已重新加入 %d 个副本", retried), gin.H{"retried": retried}))
+}
+
+// ScrubStorageReplica 立即校验单个已同步副本的完整性，损坏时自动修复或标记失败。
+func ScrubStorageReplica(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "副本ID无效"))
+		return
+	}
+
+	scrub, err := services.ScrubReplica(id)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, result.Error(404, "副本不存在"))
+		return
+	case errors.Is(err, services.ErrReplicaNotScrubbable):
+		c.JSON(http.StatusConflict, result.Error(409, "仅同步成功的副本可以校验"))
+		return
+	case err != nil:
+		log.Printf("校验副本 %d 失败：%v", id, err)
+		c.JSON(http.StatusBadGateway, result.Error(502, "校验副本失败："+err.Error()))
+		return
+	}
+	message := "副本完整"
+	if !scrub.Healthy {
+		message = "副本已损坏"
+	}
+	c.JSON(http.StatusOK, result.Success(message, scrub))
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// StorageSyncFailedReplica 失败副本列表项
+type StorageSyncFailedReplica struct {
+	ID         int       `json:"id"`
+	ImageID    int       `json:"image_id"`
+	ImageURL   string    `json:"image_url"`
+	BucketID   int       `json:"bucket_id"`
+	BucketName string    `json:"bucket_name"`
+	Storage    string    `json:"storage"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error"`
+	RetryCount int       `json:"retry_count"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// GetStorageSyncOverview 返回各远程存储源的队列状态计数与最久等待时长。
+func GetStorageSyncOverview(c *gin.Context) {
+	stats, err := services.StorageQueueOverview()
+	if err != nil {
+		log.Printf("获取同步队列概览失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "获取同步队列概览失败"))
+		return
+	}
+
+	totals := make(map[string]int64)
+	for _, bucket := range stats {
+		for status, count := range bucket.Counts {
+			totals[status] += count
+		}
+	}
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{
+		"buckets": stats,
+		"totals":  totals,
+	}))
+}
+
+// GetStorageSyncThroughput 按时间段统计各存储源完成的同步数量与字节数。
+// 统计基于副本最近一次同步时间，重新同步的副本只计入最新时段。
+func GetStorageSyncThroughput(c *gin.Context) {
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	if err != nil || hours < 1 || hours > 720 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "统计时长必须在1-720小时之间"))
+		return
+	}
+	interval, err := strconv.Atoi(c.DefaultQuery("interval", "60"))
+	if err != nil || interval < 5 || interval > 1440 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "统计间隔必须在5-1440分钟之间"))
+		return
+	}
+
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	series, err := services.StorageQueueThroughput(since, time.Duration(interval)*time.Minute)
+	if err != nil {
+		log.Printf("获取同步吞吐量失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "获取同步吞吐量失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{
+		"since":    since,
+		"interval": interval,
+		"points":   series,
+	}))
+}
+
+// GetStorageSyncInFlight 返回正在上传的同步任务及其开始时间。
+func GetStorageSyncInFlight(c *gin.Context) {
+	tasks, err := services.StorageQueueInFlight()
+	if err != nil {
+		log.Printf("获取进行中的同步任务失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "获取进行中的同步任务失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{"tasks": tasks}))
+}
+
+// GetStorageSyncFailedReplicas 分页返回失败与死信副本及其最近一次错误，可按存储源筛选。
+func GetStorageSyncFailedReplicas(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+	offset := (page - 1) * limit
+
+	db := database.GetDB().DB
+	query := db.Model(&models.ImageStorage{}).
+		Where("status IN ?", []string{models.ImageStorageStatusFailed, models.ImageStorageStatusDeadLetter})
+	if bucketParam := c.Query("bucket_id"); bucketParam != "" {
+		bucketID, err := strconv.Atoi(bucketParam)
+		if err != nil || bucketID <= 0 {
+			c.JSON(http.StatusBadRequest, result.Error(400, "存储源ID无效"))
+			return
+		}
+		query = query.Where("bucket_id = ?", bucketID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询失败副本失败"))
+		return
+	}
+	var replicas []models.ImageStorage
+	if err := query.Order("updated_at DESC, id DESC").Offset(offset).Limit(limit).Find(&replicas).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询失败副本失败"))
+		return
+	}
+
+	bucketNames := make(map[int]string)
+	imageURLs := make(map[int]string)
+	for _, replica := range replicas {
+		bucketNames[replica.BucketID] = ""
+		imageURLs[replica.ImageID] = ""
+	}
+	if len(replicas) > 0 {
+		var bucketList []models.Buckets
+		if err := db.Select("id", "name").Where("id IN ?", mapKeys(bucketNames)).Find(&bucketList).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, result.Error(500, "查询存储源失败"))
+			return
+		}
+		for _, bucket := range bucketList {
+			bucketNames[bucket.Id] = bucket.Name
+		}
+		var imageList []models.Image
+		if err := db.Select("id", "url").Where("id IN ?", mapKeys(imageURLs)).Find(&imageList).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, result.Error(500, "查询图片失败"))
+			return
+		}
+		for _, image := range imageList {
+			imageURLs[image.Id] = image.Url
+		}
+	}
+
+	items := make([]StorageSyncFailedReplica, 0, len(replicas))
+	for _, replica := range replicas {
+		items = append(items, StorageSyncFailedReplica{
+			ID:         replica.ID,
+			ImageID:    replica.ImageID,
+			ImageURL:   imageURLs[replica.ImageID],
+			BucketID:   replica.BucketID,
+			BucketName: bucketNames[replica.BucketID],
+			Storage:    replica.Storage,
+			Status:     replica.Status,
+			Error:      replica.Error,
+			RetryCount: replica.RetryCount,
+			UpdatedAt:  replica.UpdatedAt,
+			CreatedAt:  replica.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{
+		"replicas":    items,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"total_pages": (total + int64(limit) - 1) / int64(limit),
+	}))
+}
+
+// PauseBucketSync 暂停向该存储源的后台同步；待同步任务保留，恢复后继续。
+func PauseBucketSync(c *gin.Context) {
+	setBucketSyncPaused(c, true)
+}
+
+// ResumeBucketSync 恢复向该存储源的后台同步。
+func ResumeBucketSync(c *gin.Context) {
+	setBucketSyncPaused(c, false)
+}
+
+func setBucketSyncPaused(c *gin.Context, paused bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "存储源ID无效"))
+		return
+	}
+
+	db := database.GetDB().DB
+	var bucket models.Buckets
+	if err := db.First(&bucket, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, result.Error(404, "存储源不存在"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询存储源失败"))
+		return
+	}
+	if bucket.Type == "default" {
+		c.JSON(http.StatusBadRequest, result.Error(400, "本机存储源无需同步"))
+		return
+	}
+
+	if err := db.Model(&bucket).Update("sync_paused", paused).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "更新同步状态失败"))
+		return
+	}
+	message := "已暂停该存储源的同步"
+	if !paused {
+		message = "已恢复该存储源的同步"
+		services.WakeStorageSyncWorker()
+		services.WakeOriginalArchiveWorker()
+	}
+	c.JSON(http.StatusOK, result.Success(message, gin.H{
+		"id":          bucket.Id,
+		"sync_paused": paused,
+	}))
+}
+
+func mapKeys(values map[int]string) []int {
+	keys := make([]int, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	return keys
+}
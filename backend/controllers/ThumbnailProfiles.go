@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"oneimg/backend/interfaces"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+	"oneimg/backend/utils/settings"
+	"oneimg/backend/utils/watermark"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ImageThumbnailResponse 图片详情中的缩略图规格信息。
+type ImageThumbnailResponse struct {
+	Profile  string `json:"profile"`
+	URL      string `json:"url"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	FileSize int64  `json:"file_size"`
+	MimeType string `json:"mime_type"`
+}
+
+// StartThumbnailRegeneration 按当前缩略图规格为所有图片重建规格缩略图。
+func StartThumbnailRegeneration(c *gin.Context) {
+	status, err := services.StartThumbnailRegeneration()
+	if errors.Is(err, services.ErrThumbnailRegenerationRunning) {
+		c.JSON(http.StatusConflict, result.Error(409, "缩略图重建任务正在运行"))
+		return
+	}
+	if err != nil {
+		log.Printf("启动缩略图重建任务失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "启动缩略图重建任务失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("缩略图重建任务已启动", status))
+}
+
+// GetThumbnailRegenerationStatus 查询缩略图重建任务进度。
+func GetThumbnailRegenerationStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, result.Success("ok", services.GetThumbnailRegenerationStatus()))
+}
+
+// CancelThumbnailRegeneration 取消正在运行的缩略图重建任务。
+func CancelThumbnailRegeneration(c *gin.Context) {
+	if !services.CancelThumbnailRegeneration() {
+		c.JSON(http.StatusBadRequest, result.Error(400, "没有正在运行的缩略图重建任务"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("已取消缩略图重建任务", nil))
+}
+
+// normalizeThumbnailProfilesSetting 校验缩略图规格并返回规范化后的 JSON，
+// 兼容前端直接提交数组或 JSON 字符串。
+func normalizeThumbnailProfilesSetting(value any) (string, error) {
+	raw, ok := value.(string)
+	if !ok {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", fmt.Errorf("缩略图规格格式错误")
+		}
+		raw = string(encoded)
+	}
+	profiles, err := models.ParseThumbnailProfiles(raw)
+	if err != nil {
+		return "", err
+	}
+	if len(profiles) == 0 {
+		return "", nil
+	}
+	normalized, err := json.Marshal(profiles)
+	if err != nil {
+		return "", fmt.Errorf("缩略图规格格式错误")
+	}
+	return string(normalized), nil
+}
+
+// createImageThumbnailRecords 在图片入库事务中保存上传生成的规格缩略图记录。
+func createImageThumbnailRecords(tx *gorm.DB, imageID int, variants []interfaces.ThumbnailVariant) error {
+	if len(variants) == 0 {
+		return nil
+	}
+	records := make([]models.ImageThumbnail, 0, len(variants))
+	for _, variant := range variants {
+		records = append(records, models.ImageThumbnail{
+			ImageId:  imageID,
+			Profile:  variant.Profile,
+			Path:     variant.URL,
+			Width:    variant.Width,
+			Height:   variant.Height,
+			FileSize: variant.FileSize,
+			MimeType: variant.MimeType,
+		})
+	}
+	return tx.Create(&records).Error
+}
+
+// thumbnailVariantPaths 返回规格缩略图路径，用于上传失败时清理本机文件。
+func thumbnailVariantPaths(variants []interfaces.ThumbnailVariant) []string {
+	paths := make([]string, 0, len(variants))
+	for _, variant := range variants {
+		paths = append(paths, variant.URL)
+	}
+	return paths
+}
+
+// loadImageThumbnails 查询单张图片的规格缩略图。
+func loadImageThumbnails(db *gorm.DB, imageID int) ([]ImageThumbnailResponse, error) {
+	var records []models.ImageThumbnail
+	if err := db.Where("image_id = ?", imageID).Order("profile ASC").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	thumbnails := make([]ImageThumbnailResponse, 0, len(records))
+	for _, record := range records {
+		thumbnails = append(thumbnails, ImageThumbnailResponse{
+			Profile:  record.Profile,
+			URL:      record.Path,
+			Width:    record.Width,
+			Height:   record.Height,
+			FileSize: record.FileSize,
+			MimeType: record.MimeType,
+		})
+	}
+	return thumbnails, nil
+}
+
+// serveImageThumbnailVariant 代理访问规格缩略图（统一保存在本机）。
+// 未命中规格缩略图时返回 false，交给后续逻辑处理。
+func serveImageThumbnailVariant(c *gin.Context, db *gorm.DB, cleanPath string, watermarkCfg watermark.WatermarkConfig) bool {
+	if !strings.Contains(cleanPath, "/thumbnails/") {
+		return false
+	}
+	var record models.ImageThumbnail
+	if err := db.Where("path = ?", cleanPath).First(&record).Error; err != nil {
+		return false
+	}
+	var image models.Image
+	if err := db.Select("id", "expires_at").First(&image, record.ImageId).Error; err != nil || services.IsImageExpired(image, time.Now()) {
+		return false
+	}
+
+	setting, err := settings.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, fmt.Sprintf("获取系统配置失败: %v", err)))
+		return true
+	}
+	if setting.RefererWhiteEnable && setting.RefererWhiteList != "" {
+		if !checkReferer(c.Request.Referer(), setting.RefererWhiteList, GetSelfDomain(c)) {
+			c.JSON(http.StatusForbidden, result.Error(403, "来源非法"))
+			return true
+		}
+	}
+
+	proxyLocalFile(c, record.Path, record.MimeType, watermarkCfg)
+	return true
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type tieringRuleRequest struct {
+	Name           string `json:"name"`
+	Enabled        *bool  `json:"enabled"` // 缺省为启用
+	TargetBucketID int    `json:"target_bucket_id"`
+	MinAgeDays     int    `json:"min_age_days"`
+	IdleDays       int    `json:"idle_days"`
+}
+
+// GetTieringRules 返回全部分层存储规则。
+func GetTieringRules(c *gin.Context) {
+	var rules []models.TieringRule
+	if err := database.GetDB().DB.Order("id ASC").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询分层规则失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", rules))
+}
+
+// CreateTieringRule 新增分层存储规则。
+func CreateTieringRule(c *gin.Context) {
+	rule, ok := bindTieringRule(c)
+	if !ok {
+		return
+	}
+	if err := database.GetDB().DB.Create(&rule).Error; err != nil {
+		log.Printf("新增分层规则失败：%v", err)
+		c.JSON(http.StatusBadRequest, result.Error(400, "新增分层规则失败，规则名称可能已存在"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("分层规则已创建", rule))
+}
+
+// UpdateTieringRule 修改分层存储规则，已迁出本机的图片不会迁回。
+func UpdateTieringRule(c *gin.Context) {
+	existing, ok := loadTieringRule(c)
+	if !ok {
+		return
+	}
+	rule, ok := bindTieringRule(c)
+	if !ok {
+		return
+	}
+	rule.ID = existing.ID
+	rule.CreatedAt = existing.CreatedAt
+	if err := database.GetDB().DB.Save(&rule).Error; err != nil {
+		log.Printf("修改分层规则 %d 失败：%v", existing.ID, err)
+		c.JSON(http.StatusBadRequest, result.Error(400, "修改分层规则失败，规则名称可能已存在"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("分层规则已更新", rule))
+}
+
+// DeleteTieringRule 删除分层存储规则。
+func DeleteTieringRule(c *gin.Context) {
+	rule, ok := loadTieringRule(c)
+	if !ok {
+		return
+	}
+	if err := database.GetDB().DB.Delete(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "删除分层规则失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("分层规则已删除", nil))
+}
+
+// RunTieringRules 立即执行一次全部启用的分层规则。
+func RunTieringRules(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Minute)
+	defer cancel()
+	summary, err := services.RunStorageTiering(ctx)
+	if err != nil {
+		if errors.Is(err, services.ErrStorageTieringRunning) {
+			c.JSON(http.StatusConflict, result.Error(409, "分层任务正在执行"))
+			return
+		}
+		log.Printf("执行分层规则失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "执行分层规则失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("分层规则执行完成", summary))
+}
+
+func bindTieringRule(c *gin.Context) (models.TieringRule, bool) {
+	var req tieringRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "规则参数无效"))
+		return models.TieringRule{}, false
+	}
+	rule := models.TieringRule{
+		Name:           strings.TrimSpace(req.Name),
+		Enabled:        req.Enabled == nil || *req.Enabled,
+		TargetBucketID: req.TargetBucketID,
+		MinAgeDays:     req.MinAgeDays,
+		IdleDays:       req.IdleDays,
+	}
+	if err := rule.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, err.Error()))
+		return models.TieringRule{}, false
+	}
+	var target models.Buckets
+	if err := database.GetDB().DB.Select("id", "type").First(&target, rule.TargetBucketID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, result.Error(400, "冷数据存储源不存在："+strconv.Itoa(rule.TargetBucketID)))
+			return models.TieringRule{}, false
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询存储源失败"))
+		return models.TieringRule{}, false
+	}
+	if target.Type == "default" {
+		c.JSON(http.StatusBadRequest, result.Error(400, "本机存储源不能作为冷数据存储源"))
+		return models.TieringRule{}, false
+	}
+	return rule, true
+}
+
+func loadTieringRule(c *gin.Context) (models.TieringRule, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, result.Error(400, "规则ID无效"))
+		return models.TieringRule{}, false
+	}
+	var rule models.TieringRule
+	if err := database.GetDB().DB.First(&rule, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, result.Error(404, "分层规则不存在"))
+			return models.TieringRule{}, false
+		}
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询分层规则失败"))
+		return models.TieringRule{}, false
+	}
+	return rule, true
+}
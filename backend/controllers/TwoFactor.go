@@ -0,0 +1,314 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/middlewares"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+	"oneimg/backend/utils/result"
+	"oneimg/backend/utils/settings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 账号密码通过、等待两步验证时暂存在会话中的键。
+const (
+	sessionPendingTwoFactorUser     = "pending_2fa_user_id"
+	sessionPendingTwoFactorExpires  = "pending_2fa_expires"
+	sessionPendingTwoFactorAttempts = "pending_2fa_attempts"
+)
+
+const (
+	// pendingTwoFactorTTL 输入账号密码后完成两步验证的时限。
+	pendingTwoFactorTTL = 5 * time.Minute
+	// maxTwoFactorAttempts 单次登录允许输错验证码的次数，超过后需重新输入密码；
+	// 跨登录累计的失败次数另由 services.VerifySecondFactor 锁定。
+	maxTwoFactorAttempts = 5
+)
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type disableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// savePendingTwoFactor 记录已通过密码校验的用户，等待第二步验证，此时会话尚未登录。
+func savePendingTwoFactor(c *gin.Context, userID int) error {
+	session := sessions.Default(c)
+	session.Clear()
+	session.Set(sessionPendingTwoFactorUser, userID)
+	session.Set(sessionPendingTwoFactorExpires, time.Now().Add(pendingTwoFactorTTL).Unix())
+	session.Set(sessionPendingTwoFactorAttempts, 0)
+	return session.Save()
+}
+
+// clearPendingTwoFactor 放弃当前待验证的登录。
+func clearPendingTwoFactor(session sessions.Session) {
+	session.Delete(sessionPendingTwoFactorUser)
+	session.Delete(sessionPendingTwoFactorExpires)
+	session.Delete(sessionPendingTwoFactorAttempts)
+	_ = session.Save()
+}
+
+// LoginTwoFactor 登录第二步：校验 TOTP 验证码或恢复码后写入登录会话。
+func LoginTwoFactor(c *gin.Context) {
+	session := sessions.Default(c)
+	userID, pending := session.Get(sessionPendingTwoFactorUser).(int)
+	expiresAt, _ := session.Get(sessionPendingTwoFactorExpires).(int64)
+	if !pending || time.Now().Unix() > expiresAt {
+		clearPendingTwoFactor(session)
+		c.JSON(http.StatusUnauthorized, result.Error(401, "登录已过期，请重新输入账号密码"))
+		return
+	}
+
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "请输入两步验证码"))
+		return
+	}
+
+	db := database.GetDB().DB
+	usedRecovery, err := services.VerifySecondFactor(db, userID, req.Code)
+	switch {
+	case errors.Is(err, services.ErrTOTPInvalidCode):
+		attempts, _ := session.Get(sessionPendingTwoFactorAttempts).(int)
+		attempts++
+		if attempts >= maxTwoFactorAttempts {
+			clearPendingTwoFactor(session)
+			c.JSON(http.StatusUnauthorized, result.Error(401, "验证码错误次数过多，请重新登录"))
+			return
+		}
+		session.Set(sessionPendingTwoFactorAttempts, attempts)
+		_ = session.Save()
+		c.JSON(http.StatusBadRequest, result.Error(401, "两步验证码错误"))
+		return
+	case errors.Is(err, services.ErrTOTPLocked):
+		// 账号级锁定，重新输入密码也无法继续尝试
+		clearPendingTwoFactor(session)
+		c.JSON(http.StatusTooManyRequests, result.Error(429, "验证码错误次数过多，两步验证已暂时锁定，请稍后再试"))
+		return
+	case errors.Is(err, services.ErrTOTPNotEnrolled):
+		// 等待期间两步验证被重置，要求重新走完整登录流程
+		clearPendingTwoFactor(session)
+		c.JSON(http.StatusUnauthorized, result.Error(401, "登录已过期，请重新输入账号密码"))
+		return
+	case err != nil:
+		log.Printf("两步验证校验失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "两步验证校验失败"))
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		clearPendingTwoFactor(session)
+		c.JSON(http.StatusUnauthorized, result.Error(401, "用户不存在"))
+		return
+	}
+	newSession, err := SetSession(c, &user)
+	if err != nil {
+		return
+	}
+
+	data := map[string]any{
+		"token": newSession.ID(),
+		"user":  user,
+	}
+	if usedRecovery {
+		if record, _, err := services.LoadUserTOTP(db, userID); err == nil {
+			data["recovery_codes_remaining"] = len(record.RecoveryCodes)
+		}
+	}
+	c.JSON(http.StatusOK, result.Success("登录成功", data))
+}
+
+// GetTwoFactorStatus 查询当前用户的两步验证状态。
+func GetTwoFactorStatus(c *gin.Context) {
+	user, ok := requireAccountSession(c)
+	if !ok {
+		return
+	}
+	setting, err := settings.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "获取设置失败"))
+		return
+	}
+	record, exists, err := services.LoadUserTOTP(database.GetDB().DB, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "查询两步验证状态失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("ok", gin.H{
+		"enabled":                  exists && record.Enabled,
+		"pending":                  exists && !record.Enabled,
+		"required":                 services.TOTPRequiredForRole(setting, user.Role),
+		"enabled_at":               record.EnabledAt,
+		"recovery_codes_remaining": len(record.RecoveryCodes),
+	}))
+}
+
+// SetupTwoFactor 生成新的 TOTP 密钥与二维码链接，需再调用启用接口确认。
+func SetupTwoFactor(c *gin.Context) {
+	user, ok := requireAccountSession(c)
+	if !ok {
+		return
+	}
+	secret, uri, err := services.BeginTOTPEnrollment(database.GetDB().DB, user)
+	if errors.Is(err, services.ErrTOTPAlreadyEnabled) {
+		c.JSON(http.StatusConflict, result.Error(409, "两步验证已启用，请先关闭后再重新绑定"))
+		return
+	}
+	if err != nil {
+		log.Printf("生成两步验证密钥失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "生成两步验证密钥失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("请使用验证器扫描二维码后输入验证码", gin.H{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	}))
+}
+
+// EnableTwoFactor 校验验证器生成的验证码并启用两步验证，恢复码仅在本次返回。
+func EnableTwoFactor(c *gin.Context) {
+	user, ok := requireAccountSession(c)
+	if !ok {
+		return
+	}
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "请输入两步验证码"))
+		return
+	}
+	codes, err := services.ConfirmTOTPEnrollment(database.GetDB().DB, user.ID, req.Code)
+	switch {
+	case errors.Is(err, services.ErrTOTPNotEnrolled):
+		c.JSON(http.StatusBadRequest, result.Error(400, "请先生成两步验证密钥"))
+		return
+	case errors.Is(err, services.ErrTOTPAlreadyEnabled):
+		c.JSON(http.StatusConflict, result.Error(409, "两步验证已启用"))
+		return
+	case errors.Is(err, services.ErrTOTPInvalidCode):
+		c.JSON(http.StatusBadRequest, result.Error(400, "两步验证码错误"))
+		return
+	case err != nil:
+		log.Printf("启用两步验证失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "启用两步验证失败"))
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Delete(middlewares.SessionTOTPSetupRequired)
+	_ = session.Save()
+	c.JSON(http.StatusOK, result.Success("两步验证已启用，请妥善保存恢复码，之后将无法再次查看", gin.H{
+		"recovery_codes": codes,
+	}))
+}
+
+// DisableTwoFactor 校验密码与验证码后关闭两步验证；被强制启用的角色不能关闭。
+func DisableTwoFactor(c *gin.Context) {
+	user, ok := requireAccountSession(c)
+	if !ok {
+		return
+	}
+	var req disableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "请输入密码与两步验证码"))
+		return
+	}
+	setting, err := settings.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "获取设置失败"))
+		return
+	}
+	if services.TOTPRequiredForRole(setting, user.Role) {
+		c.JSON(http.StatusForbidden, result.Error(403, "当前角色必须启用两步验证"))
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "当前密码错误"))
+		return
+	}
+
+	db := database.GetDB().DB
+	if !verifyTwoFactorCode(c, db, user.ID, req.Code) {
+		return
+	}
+	if err := services.DisableTOTP(db, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "关闭两步验证失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("两步验证已关闭", nil))
+}
+
+// RegenerateTwoFactorRecoveryCodes 校验验证码后重新生成恢复码，旧恢复码全部失效。
+func RegenerateTwoFactorRecoveryCodes(c *gin.Context) {
+	user, ok := requireAccountSession(c)
+	if !ok {
+		return
+	}
+	var req twoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, result.Error(400, "请输入两步验证码"))
+		return
+	}
+	db := database.GetDB().DB
+	if !verifyTwoFactorCode(c, db, user.ID, req.Code) {
+		return
+	}
+	codes, err := services.RegenerateRecoveryCodes(db, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, result.Error(500, "生成恢复码失败"))
+		return
+	}
+	c.JSON(http.StatusOK, result.Success("恢复码已重新生成，请妥善保存", gin.H{
+		"recovery_codes": codes,
+	}))
+}
+
+// verifyTwoFactorCode 校验已启用用户的验证码或恢复码，失败时写入响应。
+func verifyTwoFactorCode(c *gin.Context, db *gorm.DB, userID int, code string) bool {
+	_, err := services.VerifySecondFactor(db, userID, code)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrTOTPNotEnrolled):
+		c.JSON(http.StatusBadRequest, result.Error(400, "尚未启用两步验证"))
+	case errors.Is(err, services.ErrTOTPInvalidCode):
+		c.JSON(http.StatusBadRequest, result.Error(400, "两步验证码错误"))
+	case errors.Is(err, services.ErrTOTPLocked):
+		c.JSON(http.StatusTooManyRequests, result.Error(429, "验证码错误次数过多，两步验证已暂时锁定，请稍后再试"))
+	default:
+		log.Printf("两步验证校验失败：%v", err)
+		c.JSON(http.StatusInternalServerError, result.Error(500, "两步验证校验失败"))
+	}
+	return false
+}
+
+// requireAccountSession 账号安全设置只能由登录的正式用户自行管理，API 令牌与游客不可用。
+func requireAccountSession(c *gin.Context) (models.User, bool) {
+	var user models.User
+	if c.GetString("auth_method") != "" {
+		c.JSON(http.StatusForbidden, result.Error(403, "请登录后管理账号安全设置"))
+		return user, false
+	}
+	if c.GetInt("user_role") == models.RoleGuest {
+		c.JSON(http.StatusForbidden, result.Error(403, "游客不能管理账号安全设置"))
+		return user, false
+	}
+	if err := database.GetDB().DB.First(&user, c.GetInt("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, result.Error(404, "用户不存在"))
+		return user, false
+	}
+	return user, true
+}
@@ -9,6 +9,7 @@ import (
 
 	"oneimg/backend/database"
 	"oneimg/backend/models"
+	"oneimg/backend/services"
 	"oneimg/backend/utils/result"
 	"oneimg/backend/utils/settings"
 
@@ -154,6 +155,15 @@ func DeleteUser(c *gin.Context) {
 		}).Error; err != nil {
 			return err
 		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.APIToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.UserTOTP{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.WebAuthnCredential{}).Error; err != nil {
+			return err
+		}
 		return tx.Delete(&user).Error
 	}); err != nil {
 		c.JSON(http.StatusInternalServerError, result.Fail(500, "删除用户失败："+err.Error()))
@@ -248,6 +258,42 @@ func ResetPassword(c *gin.Context) {
 	}))
 }
 
+// ResetUserTwoFactor 清除用户的两步验证绑定（用于丢失验证器且恢复码用尽的情况）。
+// 若其角色被强制启用两步验证，用户下次登录时需要重新绑定。
+func ResetUserTwoFactor(c *gin.Context) {
+	userIDStr := c.Param("id")
+	id, err := strconv.Atoi(userIDStr)
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, result.Fail(400, "用户ID参数错误"))
+		return
+	}
+
+	if id == models.SuperAdminID {
+		c.JSON(http.StatusBadRequest, result.Fail(400, "不能重置超级管理员的两步验证"))
+		return
+	}
+
+	loginUID, _ := c.Get("user_id")
+	if loginUID == id {
+		c.JSON(http.StatusBadRequest, result.Fail(400, "不能重置当前登录用户的两步验证"))
+		return
+	}
+
+	db := database.GetDB().DB
+	var user models.User
+	if err := db.Where("id = ?", id).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, result.Fail(404, "用户不存在"))
+		return
+	}
+
+	if err := services.DisableTOTP(db, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, result.Fail(500, "重置两步验证失败："+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, result.Success("两步验证已重置", nil))
+}
+
 // UpdateUserPermission 更新用户权限
 func UpdateUserPermission(c *gin.Context) {
 	userIDStr := c.Param("id")
@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"oneimg/backend/config"
+	"oneimg/backend/database"
+	"oneimg/backend/middlewares"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+)
+
+func TestPersonalAPITokenActsAsOwnerWithinScopes(t *testing.T) {
+	initExternalAuthTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{StartAPI: true}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	owner := models.User{ID: 2, Role: models.RoleUser, Username: "alice", Password: "x", Permission: models.Permission{Codes: []string{}, Buckets: []int{}}}
+	if err := db.Create(&owner).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	issue := func(scopes []string, expiresAt *time.Time) string {
+		t.Helper()
+		raw, err := services.CreateAPIToken(db, &models.APIToken{UserID: owner.ID, Name: "cli", Scopes: scopes, Buckets: []int{3}, ExpiresAt: expiresAt})
+		if err != nil {
+			t.Fatalf("create token: %v", err)
+		}
+		return raw
+	}
+	readToken := issue([]string{models.APITokenScopeRead}, nil)
+	adminToken := issue([]string{models.APITokenScopeAdmin}, nil)
+	expired := time.Now().Add(-time.Hour)
+	expiredToken := issue([]string{models.APITokenScopeAdmin}, &expired)
+
+	router := gin.New()
+	router.Use(middlewares.SessionMiddleware(config.App))
+	api := router.Group("/api", middlewares.AuthMiddleware())
+	api.GET("/images", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":       c.GetInt("user_id"),
+			"bucket_3":      tokenAllowsBucket(c, 3),
+			"bucket_4":      tokenAllowsBucket(c, 4),
+			"authenticated": c.GetString("auth_method"),
+		})
+	})
+	api.DELETE("/images/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	api.POST("/tokens", CreateAPIToken)
+
+	call := func(method, target, token string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, target, strings.NewReader(`{"name":"nested","scopes":["read"]}`))
+		request.Header.Set("Authorization", "oneimg_token="+token)
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := call(http.MethodGet, "/api/images", readToken)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("read with read scope: %d %s", recorder.Code, recorder.Body.String())
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, `"user_id":2`) || !strings.Contains(body, `"bucket_3":true`) || !strings.Contains(body, `"bucket_4":false`) {
+		t.Fatalf("request should act as the owner with the token's buckets: %s", body)
+	}
+
+	if recorder := call(http.MethodDelete, "/api/images/1", readToken); recorder.Code != http.StatusForbidden {
+		t.Fatalf("delete with read scope = %d, want 403", recorder.Code)
+	}
+	if recorder := call(http.MethodDelete, "/api/images/1", adminToken); recorder.Code != http.StatusNoContent {
+		t.Fatalf("delete with admin scope = %d, want 204", recorder.Code)
+	}
+	if recorder := call(http.MethodGet, "/api/images", expiredToken); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expired token = %d, want 401", recorder.Code)
+	}
+	if recorder := call(http.MethodGet, "/api/images", services.PersonalAPITokenPrefix+"unknown"); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown token = %d, want 401", recorder.Code)
+	}
+	if recorder := call(http.MethodPost, "/api/tokens", adminToken); recorder.Code != http.StatusForbidden {
+		t.Fatalf("tokens must not mint tokens, got %d", recorder.Code)
+	}
+
+	var used models.APIToken
+	if err := db.Where("prefix = ?", readToken[:len(services.PersonalAPITokenPrefix)+6]).First(&used).Error; err != nil {
+		t.Fatalf("reload token: %v", err)
+	}
+	if used.LastUsedAt == nil || used.LastUsedIP == "" {
+		t.Fatalf("expected last-used tracking, got %+v", used)
+	}
+	if used.TokenHash == "" || strings.Contains(used.TokenHash, readToken) {
+		t.Fatalf("token must be stored hashed")
+	}
+}
+
+func TestAuthMiddlewareAcceptsStandardTokenHeaders(t *testing.T) {
+	initExternalAuthTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{StartAPI: true, APIToken: "global-secret"}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	owner := models.User{ID: 2, Role: models.RoleUser, Username: "alice", Password: "x", Permission: models.Permission{Codes: []string{}, Buckets: []int{}}}
+	if err := db.Create(&owner).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	readToken, err := services.CreateAPIToken(db, &models.APIToken{UserID: owner.ID, Name: "cli", Scopes: []string{models.APITokenScopeRead}})
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	expired := time.Now().Add(-time.Hour)
+	expiredToken, err := services.CreateAPIToken(db, &models.APIToken{UserID: owner.ID, Name: "old", Scopes: []string{models.APITokenScopeRead}, ExpiresAt: &expired})
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	router := gin.New()
+	router.Use(middlewares.SessionMiddleware(config.App))
+	api := router.Group("/api", middlewares.AuthMiddleware())
+	api.GET("/images", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"username": c.GetString("username")})
+	})
+	api.DELETE("/images/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	call := func(method string, headers map[string]string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, "/api/images", nil)
+		if method == http.MethodDelete {
+			request = httptest.NewRequest(method, "/api/images/1", nil)
+		}
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	accepted := []struct {
+		name     string
+		headers  map[string]string
+		username string
+	}{
+		{"bearer personal", map[string]string{"Authorization": "Bearer " + readToken}, "alice"},
+		{"lowercase bearer", map[string]string{"Authorization": "bearer " + readToken}, "alice"},
+		{"x-api-key personal", map[string]string{"X-API-Key": readToken}, "alice"},
+		{"bearer global", map[string]string{"Authorization": "Bearer global-secret"}, "api_admin"},
+		{"legacy global", map[string]string{"Authorization": "oneimg_token=global-secret"}, "api_admin"},
+	}
+	for _, tc := range accepted {
+		recorder := call(http.MethodGet, tc.headers)
+		if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"username":"`+tc.username+`"`) {
+			t.Fatalf("%s: got %d %s", tc.name, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	rejected := []struct {
+		name      string
+		method    string
+		headers   map[string]string
+		status    int
+		errorCode string
+		challenge string
+	}{
+		{"no credentials", http.MethodGet, nil, http.StatusUnauthorized, middlewares.AuthErrorUnauthenticated, `Bearer realm="oneimg"`},
+		{"wrong global", http.MethodGet, map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized, middlewares.AuthErrorInvalidToken, `error="invalid_token"`},
+		{"unknown personal", http.MethodGet, map[string]string{"X-API-Key": services.PersonalAPITokenPrefix + "nope"}, http.StatusUnauthorized, middlewares.AuthErrorInvalidToken, `error="invalid_token"`},
+		{"expired personal", http.MethodGet, map[string]string{"Authorization": "Bearer " + expiredToken}, http.StatusUnauthorized, middlewares.AuthErrorTokenExpired, `error_description="token_expired"`},
+		{"missing scope", http.MethodDelete, map[string]string{"Authorization": "Bearer " + readToken}, http.StatusForbidden, middlewares.AuthErrorInsufficientScope, `scope="delete"`},
+	}
+	for _, tc := range rejected {
+		recorder := call(tc.method, tc.headers)
+		if recorder.Code != tc.status {
+			t.Fatalf("%s: status = %d, want %d", tc.name, recorder.Code, tc.status)
+		}
+		if !strings.Contains(recorder.Body.String(), `"error":"`+tc.errorCode+`"`) {
+			t.Fatalf("%s: body %s lacks error code %s", tc.name, recorder.Body.String(), tc.errorCode)
+		}
+		if challenge := recorder.Header().Get("WWW-Authenticate"); !strings.Contains(challenge, tc.challenge) {
+			t.Fatalf("%s: WWW-Authenticate = %q, want %q", tc.name, challenge, tc.challenge)
+		}
+	}
+}
+
+func TestPersonalAPITokenBucketRestrictionCoversImageEndpoints(t *testing.T) {
+	initExternalAuthTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{StartAPI: true}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	owner := models.User{ID: 2, Role: models.RoleUser, Username: "alice", Password: "x", Permission: models.Permission{Codes: []string{}, Buckets: []int{3}}}
+	if err := db.Create(&owner).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	token, err := services.CreateAPIToken(db, &models.APIToken{UserID: owner.ID, Name: "cdn", Scopes: []string{models.APITokenScopeAdmin}, Buckets: []int{3}})
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	if err := db.Create(&[]models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}},
+		{Id: 3, Name: "cdn", Type: "s3", Config: map[string]any{}},
+		{Id: 4, Name: "private", Type: "webdav", Config: map[string]any{}},
+	}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	// outside lives only in a bucket the token may not use; synced was
+	// uploaded in multi-storage mode and replicated to the token's bucket.
+	outside := models.Image{Url: "/uploads/outside.webp", FileName: "outside.webp", UserId: owner.ID, Storage: "webdav", BucketId: 4}
+	trashed := models.Image{Url: "/uploads/trashed.webp", FileName: "trashed.webp", UserId: owner.ID, Storage: "webdav", BucketId: 4}
+	synced := models.Image{Url: "/uploads/synced.webp", FileName: "synced.webp", UserId: owner.ID, Storage: "default", BucketId: 1}
+	if err := db.Create(&[]*models.Image{&outside, &trashed, &synced}).Error; err != nil {
+		t.Fatalf("create images: %v", err)
+	}
+	if err := db.Delete(&trashed).Error; err != nil {
+		t.Fatalf("trash image: %v", err)
+	}
+	if err := db.Create(&models.ImageStorage{ImageID: synced.Id, BucketID: 3, Storage: "s3", Status: models.ImageStorageStatusSuccess, URL: synced.Url}).Error; err != nil {
+		t.Fatalf("create replica: %v", err)
+	}
+
+	router := gin.New()
+	router.Use(middlewares.SessionMiddleware(config.App))
+	api := router.Group("/api", middlewares.AuthMiddleware())
+	api.GET("/images/:id", GetImageDetail)
+	api.DELETE("/images/:id", DeleteImage)
+	api.PUT("/images/:id/file", ReplaceImageFile)
+	api.POST("/trash/:id/restore", RestoreTrashedImage)
+	api.DELETE("/trash/:id", PurgeTrashedImage)
+
+	call := func(method, target string) int {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, target, nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	for _, target := range []struct{ method, path string }{
+		{http.MethodGet, fmt.Sprintf("/api/images/%d", outside.Id)},
+		{http.MethodDelete, fmt.Sprintf("/api/images/%d", outside.Id)},
+		{http.MethodPut, fmt.Sprintf("/api/images/%d/file", outside.Id)},
+		{http.MethodPost, fmt.Sprintf("/api/trash/%d/restore", trashed.Id)},
+		{http.MethodDelete, fmt.Sprintf("/api/trash/%d", trashed.Id)},
+	} {
+		if code := call(target.method, target.path); code != http.StatusForbidden {
+			t.Fatalf("%s %s outside the token's buckets = %d, want 403", target.method, target.path, code)
+		}
+	}
+	if code := call(http.MethodGet, fmt.Sprintf("/api/images/%d", synced.Id)); code != http.StatusOK {
+		t.Fatalf("image replicated to the token's bucket = %d, want 200", code)
+	}
+}
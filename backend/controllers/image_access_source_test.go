@@ -58,7 +58,7 @@ func TestSetImageAccessSourcePreservesCanonicalStorageAndFallsBackWhenDisabled(t
 		t.Fatalf("canonical storage changed: bucket=%d storage=%q url=%q", stored.BucketId, stored.Storage, stored.Url)
 	}
 
-	resolved, err := resolveImageAccess(db, stored, false)
+	resolved, err := resolveImageAccess(db, models.Settings{}, stored, false)
 	if err != nil {
 		t.Fatalf("resolve selected source: %v", err)
 	}
@@ -69,7 +69,7 @@ func TestSetImageAccessSourcePreservesCanonicalStorageAndFallsBackWhenDisabled(t
 	if err := db.Model(&models.Buckets{}).Where("id = ?", remote.Id).Update("disabled", true).Error; err != nil {
 		t.Fatalf("disable remote: %v", err)
 	}
-	resolved, err = resolveImageAccess(db, stored, false)
+	resolved, err = resolveImageAccess(db, models.Settings{}, stored, false)
 	if err != nil {
 		t.Fatalf("resolve fallback: %v", err)
 	}
@@ -209,3 +209,83 @@ func TestExplicitAccessSourceKeepsProgramProxyURL(t *testing.T) {
 		t.Fatalf("explicit access source was bypassed by direct domain: url=%q thumbnail=%q", image.Url, image.Thumbnail)
 	}
 }
+
+func TestResolveImageAccessFailsOverFromUnhealthyBucket(t *testing.T) {
+	initExternalAuthTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&[]models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}},
+		{Id: 2, Name: "primary", Type: "s3", Config: map[string]any{}, Unhealthy: true},
+		{Id: 3, Name: "backup", Type: "webdav", Config: map[string]any{}},
+	}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	image := models.Image{Url: "/remote/a.webp", FileName: "a.webp", Storage: "s3", BucketId: 2, AccessBucketId: 2}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	if err := db.Create(&[]models.ImageStorage{
+		{ImageID: image.Id, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusSuccess, URL: image.Url},
+		{ImageID: image.Id, BucketID: 3, Storage: "webdav", Status: models.ImageStorageStatusSuccess, URL: "/backup/a.webp"},
+	}).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+
+	resolved, err := resolveImageAccess(db, models.Settings{}, image, false)
+	if err != nil {
+		t.Fatalf("resolve with unhealthy primary: %v", err)
+	}
+	if resolved.bucket.Id != 3 || resolved.path != "/backup/a.webp" {
+		t.Fatalf("resolved source = bucket %d path %q, want backup", resolved.bucket.Id, resolved.path)
+	}
+
+	if err := db.Model(&models.Buckets{}).Where("id = ?", 2).Update("unhealthy", false).Error; err != nil {
+		t.Fatalf("recover primary: %v", err)
+	}
+	resolved, err = resolveImageAccess(db, models.Settings{}, image, false)
+	if err != nil {
+		t.Fatalf("resolve after recovery: %v", err)
+	}
+	if resolved.bucket.Id != 2 {
+		t.Fatalf("recovered primary should serve again, got bucket %d", resolved.bucket.Id)
+	}
+}
+
+func TestResolveImageAccessFollowsDeliveryPolicy(t *testing.T) {
+	initExternalAuthTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&[]models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}, DeliveryWeight: 1},
+		{Id: 2, Name: "cdn", Type: "s3", Config: map[string]any{}, DeliveryPriority: 5, DeliveryWeight: 1},
+	}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	image := models.Image{Url: "/uploads/a.webp", FileName: "a.webp", Storage: "default", BucketId: 1}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	if err := db.Create(&[]models.ImageStorage{
+		{ImageID: image.Id, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess, URL: image.Url},
+		{ImageID: image.Id, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusSuccess, URL: "/cdn/a.webp"},
+	}).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+
+	setting := models.Settings{DeliveryPolicy: "priority"}
+	resolved, err := resolveImageAccess(db, setting, image, false)
+	if err != nil {
+		t.Fatalf("resolve with priority policy: %v", err)
+	}
+	if resolved.bucket.Id != 2 || resolved.path != "/cdn/a.webp" {
+		t.Fatalf("priority policy served bucket %d path %q, want cdn", resolved.bucket.Id, resolved.path)
+	}
+
+	image.DeliveryPolicy = "local"
+	resolved, err = resolveImageAccess(db, setting, image, false)
+	if err != nil {
+		t.Fatalf("resolve with local override: %v", err)
+	}
+	if resolved.bucket.Id != 1 {
+		t.Fatalf("per-image local override served bucket %d, want local", resolved.bucket.Id)
+	}
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestImageListHidesExpiredImages(t *testing.T) {
+	initExternalAuthTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	imageList := []models.Image{
+		{Url: "/uploads/kept.webp", FileName: "kept.webp", FileSize: 10, UserId: 1},
+		{Url: "/uploads/expiring.webp", FileName: "expiring.webp", FileSize: 11, UserId: 1, ExpiresAt: &future},
+		{Url: "/uploads/expired.webp", FileName: "expired.webp", FileSize: 12, UserId: 1, ExpiresAt: &past},
+	}
+	if err := db.Create(&imageList).Error; err != nil {
+		t.Fatalf("create images: %v", err)
+	}
+
+	recorder, context := newExternalAuthTestContext(http.MethodGet, "/api/images?sort_by=filename&sort_order=asc")
+	context.Set("user_id", 1)
+	context.Set("user_role", models.RoleAdmin)
+	GetImageList(context)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct {
+		Data struct {
+			Images []ImageWithTags `json:"images"`
+			Total  int64           `json:"total"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	images := response.Data.Images
+	if response.Data.Total != 2 || len(images) != 2 || images[0].Filename != "expiring.webp" || images[1].Filename != "kept.webp" {
+		t.Fatalf("expected only unexpired images, got total %d: %+v", response.Data.Total, images)
+	}
+}
@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ugorji/go/codec"
+
+	"oneimg/backend/config"
+	"oneimg/backend/database"
+	"oneimg/backend/middlewares"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+)
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	initExternalAuthTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	createTwoFactorTestUser(t, "secret-password")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	rpIDHash := sha256.Sum256([]byte("oneimg.example"))
+	credentialID := []byte("controller-passkey")
+	b64 := base64.RawURLEncoding.EncodeToString
+	encodeCBOR := func(value any) []byte {
+		var out []byte
+		codec.NewEncoderBytes(&out, &codec.CborHandle{}).MustEncode(value)
+		return out
+	}
+	clientData := func(ceremony, challenge string) []byte {
+		data, _ := json.Marshal(map[string]any{"type": ceremony, "challenge": challenge, "origin": config.App.AppURL})
+		return data
+	}
+	challengeOf := func(payload map[string]any) string {
+		data, _ := payload["data"].(map[string]any)
+		options, _ := data["publicKey"].(map[string]any)
+		challenge, _ := options["challenge"].(string)
+		if challenge == "" {
+			t.Fatalf("missing challenge in %v", payload)
+		}
+		return challenge
+	}
+
+	router := gin.New()
+	router.Use(middlewares.SessionMiddleware(config.App))
+	api := router.Group("/api")
+	api.POST("/login", Login)
+	api.POST("/auth/passkey/login/begin", BeginPasskeyLogin)
+	api.POST("/auth/passkey/login/finish", FinishPasskeyLogin)
+	auth := api.Group("", middlewares.AuthMiddleware())
+	auth.GET("/images", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"user_id": c.GetInt("user_id")}) })
+	auth.GET("/account/passkeys", GetPasskeys)
+	auth.POST("/account/passkeys/register/begin", BeginPasskeyRegistration)
+	auth.POST("/account/passkeys/register/finish", FinishPasskeyRegistration)
+	auth.DELETE("/account/passkeys/:id", DeletePasskey)
+
+	owner := &twoFactorTestClient{t: t, router: router}
+	owner.do(http.MethodPost, "/api/login", `{"username":"alice","password":"secret-password"}`)
+	status, payload := owner.do(http.MethodPost, "/api/account/passkeys/register/begin", "")
+	if status != http.StatusOK {
+		t.Fatalf("begin registration: %d %v", status, payload)
+	}
+	challenge := challengeOf(payload)
+
+	authData := append(rpIDHash[:], 0x45)
+	authData = binary.BigEndian.AppendUint32(authData, 0)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(credentialID)))
+	authData = append(authData, credentialID...)
+	authData = append(authData, encodeCBOR(map[int64]any{
+		1: 2, 3: services.COSEAlgES256, -1: 1,
+		-2: key.X.FillBytes(make([]byte, 32)),
+		-3: key.Y.FillBytes(make([]byte, 32)),
+	})...)
+	attestation := encodeCBOR(map[string]any{"fmt": "none", "attStmt": map[string]any{}, "authData": authData})
+	body, _ := json.Marshal(map[string]any{
+		"name": "laptop",
+		"credential": map[string]any{
+			"id": b64(credentialID), "rawId": b64(credentialID), "type": "public-key",
+			"response": map[string]any{
+				"clientDataJSON":    b64(clientData("webauthn.create", challenge)),
+				"attestationObject": b64(attestation),
+				"transports":        []string{"internal"},
+			},
+		},
+	})
+	if status, payload := owner.do(http.MethodPost, "/api/account/passkeys/register/finish", string(body)); status != http.StatusOK {
+		t.Fatalf("finish registration: %d %v", status, payload)
+	}
+	// The challenge is single use.
+	if status, _ := owner.do(http.MethodPost, "/api/account/passkeys/register/finish", string(body)); status != http.StatusBadRequest {
+		t.Fatalf("reused registration challenge = %d, want 400", status)
+	}
+
+	var stored models.WebAuthnCredential
+	if err := db.Where("user_id = ?", 2).First(&stored).Error; err != nil || stored.Name != "laptop" {
+		t.Fatalf("stored credential: %+v %v", stored, err)
+	}
+
+	signCount := uint32(0)
+	login := func(client *twoFactorTestClient) (int, map[string]any) {
+		_, payload := client.do(http.MethodPost, "/api/auth/passkey/login/begin", `{}`)
+		challenge := challengeOf(payload)
+		assertionData := binary.BigEndian.AppendUint32(append(append([]byte{}, rpIDHash[:]...), 0x05), signCount)
+		clientDataJSON := clientData("webauthn.get", challenge)
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		digest := sha256.Sum256(append(append([]byte{}, assertionData...), clientDataHash[:]...))
+		signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		body, _ := json.Marshal(map[string]any{
+			"id": b64(credentialID), "rawId": b64(credentialID), "type": "public-key",
+			"response": map[string]any{
+				"clientDataJSON":    b64(clientDataJSON),
+				"authenticatorData": b64(assertionData),
+				"signature":         b64(signature),
+				"userHandle":        b64(services.WebAuthnUserHandle(2)),
+			},
+		})
+		return client.do(http.MethodPost, "/api/auth/passkey/login/finish", string(body))
+	}
+
+	signCount = 1
+	visitor := &twoFactorTestClient{t: t, router: router}
+	if status, payload := login(visitor); status != http.StatusOK {
+		t.Fatalf("passkey login: %d %v", status, payload)
+	}
+	status, payload = visitor.do(http.MethodGet, "/api/images", "")
+	if status != http.StatusOK || payload["user_id"] != float64(2) {
+		t.Fatalf("passkey session: %d %v", status, payload)
+	}
+
+	// A cloned authenticator replaying an old counter is refused.
+	clone := &twoFactorTestClient{t: t, router: router}
+	if status, _ := login(clone); status != http.StatusUnauthorized {
+		t.Fatalf("non-increasing counter = %d, want 401", status)
+	}
+
+	status, payload = owner.do(http.MethodGet, "/api/account/passkeys", "")
+	if status != http.StatusOK {
+		t.Fatalf("list passkeys: %d %v", status, payload)
+	}
+	if status, _ := owner.do(http.MethodDelete, "/api/account/passkeys/"+strconv.Itoa(stored.ID), ""); status != http.StatusOK {
+		t.Fatalf("delete passkey = %d", status)
+	}
+	signCount = 2
+	if status, _ := login(&twoFactorTestClient{t: t, router: router}); status != http.StatusUnauthorized {
+		t.Fatalf("revoked passkey = %d, want 401", status)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"oneimg/backend/config"
+	"oneimg/backend/models"
 	"oneimg/backend/utils/securestorage"
 	"oneimg/backend/utils/watermark"
 
@@ -58,3 +59,36 @@ func TestServeStoredImageKeepsLegacyPlaintextCompatible(t *testing.T) {
 		t.Fatalf("legacy payload = %q, want %q", recorder.Body.Bytes(), want)
 	}
 }
+
+func TestImageCacheControlDependsOnReplacement(t *testing.T) {
+	cases := []struct {
+		version int
+		want    string
+	}{
+		{version: 0, want: imageCacheControl},
+		{version: 2, want: replacedImageCacheControl},
+	}
+	for _, tc := range cases {
+		recorder := httptest.NewRecorder()
+		context, _ := gin.CreateTestContext(recorder)
+		context.Request = httptest.NewRequest(http.MethodGet, "/uploads/a.png", nil)
+
+		setImageCacheHeaders(context, models.Image{Id: 1, Version: tc.version}, false)
+		if err := serveStoredImage(context, bytes.NewReader([]byte("image")), "image/png", "default", watermark.WatermarkConfig{}); err != nil {
+			t.Fatalf("version %d: serve image: %v", tc.version, err)
+		}
+		if got := recorder.Header().Get("Cache-Control"); got != tc.want {
+			t.Fatalf("version %d: Cache-Control = %q, want %q", tc.version, got, tc.want)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	context, _ := gin.CreateTestContext(recorder)
+	context.Request = httptest.NewRequest(http.MethodGet, "/uploads/a.png", nil)
+	if err := serveStoredImage(context, bytes.NewReader([]byte("image")), "image/png", "default", watermark.WatermarkConfig{}); err != nil {
+		t.Fatalf("serve image: %v", err)
+	}
+	if got := recorder.Header().Get("Cache-Control"); got != imageCacheControl {
+		t.Fatalf("Cache-Control = %q, want default %q", got, imageCacheControl)
+	}
+}
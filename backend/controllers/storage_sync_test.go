@@ -1,7 +1,10 @@
 package controllers
 
 import (
+	"io"
+	"net/http"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"oneimg/backend/config"
@@ -121,3 +124,37 @@ func bucketIDsEqual(buckets []models.Buckets, want []int) bool {
 	}
 	return true
 }
+
+func TestBucketProcessingAllowedInMultiStorageMode(t *testing.T) {
+	initExternalAuthTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{ID: 1, DefaultStorage: 1}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	remote := models.Buckets{Id: 2, Name: "cdn", Type: "s3", Config: map[string]any{}, Processing: &models.BucketProcessing{KeepOriginal: true}}
+	if err := db.Create(&remote).Error; err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+
+	// Replicas are derived per bucket, so remote profiles do not block the mode.
+	if err := validateSettingData("multi_storage_sync", true); err != nil {
+		t.Fatalf("enable multi-storage with a remote profile: %v", err)
+	}
+	if err := db.Model(&models.Settings{}).Where("id = 1").Update("multi_storage_sync", true).Error; err != nil {
+		t.Fatalf("update settings: %v", err)
+	}
+
+	recorder, c := newExternalAuthTestContext(http.MethodPut, "/api/buckets/2/processing")
+	c.Params = gin.Params{{Key: "id", Value: "2"}}
+	c.Request.Body = io.NopCloser(strings.NewReader(`{"output_format":"webp"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	UpdateBucketProcessing(c)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("remote profile in multi-storage mode = %d, want 200: %s", recorder.Code, recorder.Body.String())
+	}
+	var stored models.Buckets
+	db.First(&stored, remote.Id)
+	if stored.Processing == nil || stored.Processing.OutputFormat != models.BucketOutputFormatWebP {
+		t.Fatalf("stored profile = %+v", stored.Processing)
+	}
+}
@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"oneimg/backend/config"
+	"oneimg/backend/database"
+	"oneimg/backend/middlewares"
+	"oneimg/backend/models"
+	"oneimg/backend/services"
+)
+
+type twoFactorTestClient struct {
+	t       *testing.T
+	router  *gin.Engine
+	cookies []*http.Cookie
+}
+
+func (client *twoFactorTestClient) do(method, target, body string) (int, map[string]any) {
+	client.t.Helper()
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	for _, cookie := range client.cookies {
+		request.AddCookie(cookie)
+	}
+	client.router.ServeHTTP(recorder, request)
+	if cookies := recorder.Result().Cookies(); len(cookies) > 0 {
+		client.cookies = cookies
+	}
+	var payload map[string]any
+	_ = json.Unmarshal(recorder.Body.Bytes(), &payload)
+	return recorder.Code, payload
+}
+
+func newTwoFactorTestRouter(t *testing.T) *twoFactorTestClient {
+	t.Helper()
+	router := gin.New()
+	router.Use(middlewares.SessionMiddleware(config.App))
+	api := router.Group("/api")
+	api.POST("/login", Login)
+	api.POST("/login/2fa", LoginTwoFactor)
+	auth := api.Group("", middlewares.AuthMiddleware())
+	auth.GET("/images", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"user_id": c.GetInt("user_id")}) })
+	auth.GET("/account/2fa", GetTwoFactorStatus)
+	auth.POST("/account/2fa/setup", SetupTwoFactor)
+	auth.POST("/account/2fa/enable", EnableTwoFactor)
+	return &twoFactorTestClient{t: t, router: router}
+}
+
+func createTwoFactorTestUser(t *testing.T, password string) models.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := models.User{ID: 2, Role: models.RoleUser, Username: "alice", Password: string(hash), Permission: models.Permission{Codes: []string{}, Buckets: []int{}}}
+	if err := database.GetDB().DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+func TestLoginRequiresSecondFactorWhenEnabled(t *testing.T) {
+	initExternalAuthTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	user := createTwoFactorTestUser(t, "secret-password")
+	secret, _, err := services.BeginTOTPEnrollment(db, user)
+	if err != nil {
+		t.Fatalf("begin enrollment: %v", err)
+	}
+	now := time.Now()
+	enrollCode, _ := services.TOTPCode(secret, now)
+	if _, err := services.ConfirmTOTPEnrollment(db, user.ID, enrollCode); err != nil {
+		t.Fatalf("confirm enrollment: %v", err)
+	}
+
+	client := newTwoFactorTestRouter(t)
+	status, payload := client.do(http.MethodPost, "/api/login", `{"username":"alice","password":"secret-password"}`)
+	data, _ := payload["data"].(map[string]any)
+	if status != http.StatusOK || data["two_factor_required"] != true || data["token"] != nil {
+		t.Fatalf("password step should only start a pending login: %d %v", status, payload)
+	}
+	if status, _ := client.do(http.MethodGet, "/api/images", ""); status != http.StatusUnauthorized {
+		t.Fatalf("pending login must not be authenticated, got %d", status)
+	}
+	if status, _ := client.do(http.MethodPost, "/api/login/2fa", `{"code":"`+enrollCode+`"}`); status != http.StatusBadRequest {
+		t.Fatalf("replayed enrollment code = %d, want 400", status)
+	}
+
+	nextCode, _ := services.TOTPCode(secret, now.Add(30*time.Second))
+	status, payload = client.do(http.MethodPost, "/api/login/2fa", `{"code":"`+nextCode+`"}`)
+	if status != http.StatusOK {
+		t.Fatalf("second factor: %d %v", status, payload)
+	}
+	if status, _ := client.do(http.MethodGet, "/api/images", ""); status != http.StatusOK {
+		t.Fatalf("completed login should be authenticated, got %d", status)
+	}
+}
+
+func TestLoginLocksPendingSecondFactorAfterRepeatedFailures(t *testing.T) {
+	initExternalAuthTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	user := createTwoFactorTestUser(t, "secret-password")
+	secret, _, _ := services.BeginTOTPEnrollment(db, user)
+	code, _ := services.TOTPCode(secret, time.Now())
+	if _, err := services.ConfirmTOTPEnrollment(db, user.ID, code); err != nil {
+		t.Fatalf("confirm enrollment: %v", err)
+	}
+
+	client := newTwoFactorTestRouter(t)
+	client.do(http.MethodPost, "/api/login", `{"username":"alice","password":"secret-password"}`)
+	for range maxTwoFactorAttempts - 1 {
+		if status, _ := client.do(http.MethodPost, "/api/login/2fa", `{"code":"abcdef"}`); status != http.StatusBadRequest {
+			t.Fatalf("wrong code = %d, want 400", status)
+		}
+	}
+	if status, _ := client.do(http.MethodPost, "/api/login/2fa", `{"code":"abcdef"}`); status != http.StatusUnauthorized {
+		t.Fatalf("last allowed attempt = %d, want 401", status)
+	}
+	nextCode, _ := services.TOTPCode(secret, time.Now().Add(30*time.Second))
+	if status, _ := client.do(http.MethodPost, "/api/login/2fa", `{"code":"`+nextCode+`"}`); status != http.StatusUnauthorized {
+		t.Fatalf("locked pending login must require the password again, got %d", status)
+	}
+}
+
+func TestEnforcedTwoFactorRestrictsSessionUntilEnrolled(t *testing.T) {
+	initExternalAuthTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{TOTPRequireUser: true}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	createTwoFactorTestUser(t, "secret-password")
+
+	client := newTwoFactorTestRouter(t)
+	status, payload := client.do(http.MethodPost, "/api/login", `{"username":"alice","password":"secret-password"}`)
+	data, _ := payload["data"].(map[string]any)
+	if status != http.StatusOK || data["two_factor_setup_required"] != true {
+		t.Fatalf("login should flag required setup: %d %v", status, payload)
+	}
+	status, payload = client.do(http.MethodGet, "/api/images", "")
+	if status != http.StatusForbidden || payload["error"] != middlewares.AuthErrorTwoFactorSetup {
+		t.Fatalf("unenrolled session should be restricted: %d %v", status, payload)
+	}
+
+	status, payload = client.do(http.MethodPost, "/api/account/2fa/setup", "")
+	data, _ = payload["data"].(map[string]any)
+	secret, _ := data["secret"].(string)
+	if status != http.StatusOK || secret == "" {
+		t.Fatalf("setup: %d %v", status, payload)
+	}
+	code, _ := services.TOTPCode(secret, time.Now())
+	status, payload = client.do(http.MethodPost, "/api/account/2fa/enable", `{"code":"`+code+`"}`)
+	data, _ = payload["data"].(map[string]any)
+	if codes, _ := data["recovery_codes"].([]any); status != http.StatusOK || len(codes) != services.RecoveryCodeCount {
+		t.Fatalf("enable: %d %v", status, payload)
+	}
+	if status, _ := client.do(http.MethodGet, "/api/images", ""); status != http.StatusOK {
+		t.Fatalf("enrolled session should be unrestricted, got %d", status)
+	}
+}
+
+func TestLoginSecondFactorFailuresPersistAcrossPasswordLogins(t *testing.T) {
+	initExternalAuthTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	user := createTwoFactorTestUser(t, "secret-password")
+	secret, _, _ := services.BeginTOTPEnrollment(db, user)
+	code, _ := services.TOTPCode(secret, time.Now())
+	if _, err := services.ConfirmTOTPEnrollment(db, user.ID, code); err != nil {
+		t.Fatalf("confirm enrollment: %v", err)
+	}
+
+	client := newTwoFactorTestRouter(t)
+	status := 0
+	for login := 0; login < 5 && status != http.StatusTooManyRequests; login++ {
+		client.do(http.MethodPost, "/api/login", `{"username":"alice","password":"secret-password"}`)
+		for range maxTwoFactorAttempts {
+			if status, _ = client.do(http.MethodPost, "/api/login/2fa", `{"code":"abcdef"}`); status == http.StatusTooManyRequests {
+				break
+			}
+		}
+	}
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("repeated failures across logins should lock the second factor, last status %d", status)
+	}
+
+	client.do(http.MethodPost, "/api/login", `{"username":"alice","password":"secret-password"}`)
+	nextCode, _ := services.TOTPCode(secret, time.Now().Add(30*time.Second))
+	if status, _ := client.do(http.MethodPost, "/api/login/2fa", `{"code":"`+nextCode+`"}`); status != http.StatusTooManyRequests {
+		t.Fatalf("correct code while locked = %d, want 429", status)
+	}
+}
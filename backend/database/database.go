@@ -93,6 +93,16 @@ func InitDB(cfg *config.Config) {
 		&models.User{},
 		&models.Image{},
 		&models.ImageStorage{},
+		&models.ImageThumbnail{},
+		&models.ImageOriginal{},
+		&models.BucketMigration{},
+		&models.ReplicationPolicy{},
+		&models.TieringRule{},
+		&models.ReplicaDeletion{},
+		&models.BucketHealthCheck{},
+		&models.APIToken{},
+		&models.UserTOTP{},
+		&models.WebAuthnCredential{},
 		&models.Settings{},
 		&models.ExternalAuthFlow{},
 		&models.ExternalIdentity{},
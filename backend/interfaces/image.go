@@ -22,6 +22,22 @@ type ImageUploadResult struct {
 	Width         int    `json:"width,omitempty"`
 	Height        int    `json:"height,omitempty"`
 	CreatedAt     string `json:"created_at,omitempty"`
+	// Thumbnails 按缩略图规格生成的衍生图（统一保存在本机）
+	Thumbnails []ThumbnailVariant `json:"thumbnails,omitempty"`
+	// Downscaled 超过最大边长被自动缩小时为 true，OriginalWidth/OriginalHeight 为缩小前尺寸
+	Downscaled     bool `json:"downscaled,omitempty"`
+	OriginalWidth  int  `json:"original_width,omitempty"`
+	OriginalHeight int  `json:"original_height,omitempty"`
+}
+
+// ThumbnailVariant 单个缩略图规格的访问信息
+type ThumbnailVariant struct {
+	Profile  string `json:"profile"`
+	URL      string `json:"url"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	FileSize int64  `json:"file_size"`
+	MimeType string `json:"mime_type"`
 }
 
 // Upload 上传处理接口
@@ -1,11 +1,13 @@
 package middlewares
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"oneimg/backend/database"
 	"oneimg/backend/models"
+	"oneimg/backend/services"
 	"oneimg/backend/utils/secureconfig"
 	"oneimg/backend/utils/settings"
 
@@ -13,24 +15,56 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// AuthResponse 认证失败时的统一响应体。
+// AuthResponse 认证失败时的统一响应体，Error 为供客户端判断的机器可读错误码。
 type AuthResponse struct {
 	Code    int    `json:"code"`
+	Error   string `json:"error,omitempty"`
 	Message string `json:"message"`
 }
 
+// 认证失败时 AuthResponse.Error 的取值。
+const (
+	AuthErrorUnauthenticated   = "unauthenticated"           // 未携带任何凭证
+	AuthErrorInvalidToken      = "invalid_token"             // API 令牌不存在或不匹配
+	AuthErrorTokenExpired      = "token_expired"             // 个人访问令牌已过期
+	AuthErrorAPIDisabled       = "api_disabled"              // 携带了令牌但未开启 API 调用
+	AuthErrorInsufficientScope = "insufficient_scope"        // 个人访问令牌缺少权限范围
+	AuthErrorSessionInvalid    = "session_invalid"           // 会话失效或用户已被删除
+	AuthErrorTwoFactorSetup    = "two_factor_setup_required" // 所属角色被强制启用两步验证但尚未绑定
+)
+
+// SessionTOTPSetupRequired 会话键：账号密码登录时所属角色被强制启用两步验证但尚未绑定。
+const SessionTOTPSetupRequired = "totp_setup_required"
+
+// twoFactorSetupRoutes 尚未绑定两步验证时仍可访问的接口。
+var twoFactorSetupRoutes = map[string]bool{
+	"/api/user/status":        true,
+	"/api/account/2fa":        true,
+	"/api/account/2fa/setup":  true,
+	"/api/account/2fa/enable": true,
+}
+
+// authRealm WWW-Authenticate 质询中的 realm。
+const authRealm = "oneimg"
+
+// 通过 API 令牌认证时写入上下文 auth_method 的取值，Session 登录不设置。
+const (
+	AuthMethodGlobalToken   = "global_token"
+	AuthMethodPersonalToken = "personal_token"
+)
+
 // AuthMiddleware 校验 Session 或 API Token，并将当前用户写入上下文。
-// 上下文键：user_id、user_role、username、current_user。
+// 上下文键：user_id、user_role、username、current_user；个人令牌另有 api_token。
+// 令牌可通过 Authorization: Bearer、Authorization: oneimg_token= 或 X-API-Key 传递。
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		setting, _ := settings.GetSettings()
-		apiToken := ""
+		apiToken := extractAPIToken(c.Request)
 
-		if setting.StartAPI {
-			authHeader := c.Request.Header.Get("Authorization")
-			parts := strings.SplitN(authHeader, "=", 2)
-			if len(parts) == 2 && strings.TrimSpace(parts[0]) == "oneimg_token" {
-				apiToken = strings.TrimSpace(parts[1])
+		if setting.StartAPI && apiToken != "" {
+			if services.IsPersonalAPIToken(apiToken) {
+				authenticatePersonalToken(c, apiToken)
+				return
 			}
 
 			if validateToken(setting, apiToken) {
@@ -48,16 +82,24 @@ func AuthMiddleware() gin.HandlerFunc {
 				c.Set("user_role", models.RoleAdmin)
 				c.Set("username", "api_admin")
 				c.Set("current_user", apiAdminUser)
+				c.Set("auth_method", AuthMethodGlobalToken)
 				c.Next()
 				return
 			}
 		}
 
+		// 令牌无效时仍允许已登录的会话通过，前端会在请求中附带无关的 Bearer 头。
 		session := sessions.Default(c)
 		loggedIn := session.Get("logged_in")
-		if (loggedIn == nil || loggedIn != true) && apiToken == "" {
-			c.JSON(http.StatusUnauthorized, AuthResponse{Code: 401, Message: "用户未登录"})
-			c.Abort()
+		if loggedIn == nil || loggedIn != true {
+			switch {
+			case apiToken == "":
+				abortUnauthorized(c, AuthErrorUnauthenticated, "用户未登录")
+			case !setting.StartAPI:
+				abortUnauthorized(c, AuthErrorAPIDisabled, "API 调用未开启")
+			default:
+				abortUnauthorized(c, AuthErrorInvalidToken, "API 令牌无效")
+			}
 			return
 		}
 
@@ -65,8 +107,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		userRole := session.Get("user_role")
 		username := session.Get("username")
 		if userID == nil || username == nil {
-			c.JSON(http.StatusUnauthorized, AuthResponse{Code: 401, Message: "会话信息无效"})
-			c.Abort()
+			abortUnauthorized(c, AuthErrorSessionInvalid, "会话信息无效")
 			return
 		}
 
@@ -74,8 +115,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		userRoleValue, userRoleOK := userRole.(int)
 		usernameValue, usernameOK := username.(string)
 		if !userIDOK || !userRoleOK || !usernameOK {
-			c.JSON(http.StatusUnauthorized, AuthResponse{Code: 401, Message: "会话信息无效"})
-			c.Abort()
+			abortUnauthorized(c, AuthErrorSessionInvalid, "会话信息无效")
 			return
 		}
 
@@ -86,8 +126,7 @@ func AuthMiddleware() gin.HandlerFunc {
 			if db == nil || db.Select("id", "role", "username", "permission").First(&currentUser, userIDValue).Error != nil {
 				session.Clear()
 				_ = session.Save()
-				c.JSON(http.StatusUnauthorized, AuthResponse{Code: 401, Message: "用户不存在或已被禁用"})
-				c.Abort()
+				abortUnauthorized(c, AuthErrorSessionInvalid, "用户不存在或已被禁用")
 				return
 			}
 			userRoleValue = currentUser.Role
@@ -102,6 +141,11 @@ func AuthMiddleware() gin.HandlerFunc {
 			}
 		}
 
+		if setupRequired, _ := session.Get(SessionTOTPSetupRequired).(bool); setupRequired && !twoFactorSetupRoutes[c.FullPath()] {
+			c.AbortWithStatusJSON(http.StatusForbidden, AuthResponse{Code: 403, Error: AuthErrorTwoFactorSetup, Message: "请先启用两步验证"})
+			return
+		}
+
 		session.Set("logged_in", true)
 		c.Set("user_id", userIDValue)
 		c.Set("user_role", userRoleValue)
@@ -111,6 +155,118 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// authenticatePersonalToken 以个人访问令牌所有者的身份处理请求，并校验令牌权限范围。
+func authenticatePersonalToken(c *gin.Context, rawToken string) {
+	token, user, err := services.AuthenticateAPIToken(database.GetDB().DB, rawToken, c.ClientIP())
+	switch {
+	case errors.Is(err, services.ErrAPITokenExpired):
+		abortUnauthorized(c, AuthErrorTokenExpired, "API 令牌已过期")
+		return
+	case errors.Is(err, services.ErrAPITokenInvalid):
+		abortUnauthorized(c, AuthErrorInvalidToken, "API 令牌无效")
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, AuthResponse{Code: 500, Message: "API 令牌校验失败"})
+		return
+	}
+	scope := requiredTokenScope(c.Request.Method, c.FullPath())
+	if !token.HasScope(scope) {
+		c.Header("WWW-Authenticate", bearerChallenge(AuthErrorInsufficientScope, "", scope))
+		c.AbortWithStatusJSON(http.StatusForbidden, AuthResponse{
+			Code:    403,
+			Error:   AuthErrorInsufficientScope,
+			Message: "API 令牌缺少权限范围: [" + scope + "]",
+		})
+		return
+	}
+
+	c.Set("user_id", user.ID)
+	c.Set("user_role", user.Role)
+	c.Set("username", user.Username)
+	c.Set("current_user", &user)
+	c.Set("auth_method", AuthMethodPersonalToken)
+	c.Set("api_token", token)
+	c.Next()
+}
+
+// extractAPIToken 依次从 Authorization（Bearer 或 oneimg_token=）与 X-API-Key 请求头读取令牌。
+func extractAPIToken(r *http.Request) string {
+	if authHeader := strings.TrimSpace(r.Header.Get("Authorization")); authHeader != "" {
+		if scheme, credentials, ok := strings.Cut(authHeader, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(credentials)
+		}
+		if name, value, ok := strings.Cut(authHeader, "="); ok && strings.TrimSpace(name) == "oneimg_token" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// abortUnauthorized 返回带 WWW-Authenticate 质询的 401 JSON 响应。
+func abortUnauthorized(c *gin.Context, errorCode, message string) {
+	switch errorCode {
+	case AuthErrorInvalidToken, AuthErrorTokenExpired, AuthErrorAPIDisabled:
+		// RFC 6750 只定义了 invalid_token，细分原因放在 error_description 中。
+		c.Header("WWW-Authenticate", bearerChallenge(AuthErrorInvalidToken, errorCode, ""))
+	default:
+		c.Header("WWW-Authenticate", bearerChallenge("", "", ""))
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, AuthResponse{Code: 401, Error: errorCode, Message: message})
+}
+
+// bearerChallenge 按 RFC 6750 构造 Bearer 质询，空参数不输出。
+func bearerChallenge(errorCode, description, scope string) string {
+	challenge := `Bearer realm="` + authRealm + `"`
+	if errorCode != "" {
+		challenge += `, error="` + errorCode + `"`
+	}
+	if description != "" {
+		challenge += `, error_description="` + description + `"`
+	}
+	if scope != "" {
+		challenge += `, scope="` + scope + `"`
+	}
+	return challenge
+}
+
+// tokenScopeRoutes 列出非 admin 范围可调用的接口，其余接口都需要 admin 范围。
+var tokenScopeRoutes = map[string]string{
+	"GET /api/uploadConfig":       models.APITokenScopeUpload,
+	"POST /api/upload":            models.APITokenScopeUpload,
+	"POST /api/upload/images":     models.APITokenScopeUpload,
+	"POST /api/images/url":        models.APITokenScopeUpload,
+	"PUT /api/images/:id/file":    models.APITokenScopeUpload,
+	"GET /api/user/status":        models.APITokenScopeRead,
+	"GET /api/stats/dashboard":    models.APITokenScopeRead,
+	"GET /api/stats/images":       models.APITokenScopeRead,
+	"GET /api/tags":               models.APITokenScopeRead,
+	"GET /api/buckets/list":       models.APITokenScopeRead,
+	"GET /api/images":             models.APITokenScopeRead,
+	"GET /api/images/:id":         models.APITokenScopeRead,
+	"GET /api/trash":              models.APITokenScopeRead,
+	"DELETE /api/images/:id":      models.APITokenScopeDelete,
+	"POST /api/trash/:id/restore": models.APITokenScopeDelete,
+	"DELETE /api/trash/:id":       models.APITokenScopeDelete,
+}
+
+// requiredTokenScope 返回调用指定接口所需的令牌权限范围。
+func requiredTokenScope(method, route string) string {
+	if scope, ok := tokenScopeRoutes[method+" "+route]; ok {
+		return scope
+	}
+	return models.APITokenScopeAdmin
+}
+
+// APITokenFromContext 返回当前请求使用的个人访问令牌。
+func APITokenFromContext(c *gin.Context) (models.APIToken, bool) {
+	value, exists := c.Get("api_token")
+	if !exists {
+		return models.APIToken{}, false
+	}
+	token, ok := value.(models.APIToken)
+	return token, ok
+}
+
 // validateToken 校验 API Token（优先哈希比对，兼容明文遗留字段）。
 func validateToken(setting models.Settings, token string) bool {
 	token = strings.TrimSpace(token)
@@ -128,8 +284,7 @@ func RequirePermission(requiredCode string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userInterface, exists := c.Get("current_user")
 		if !exists {
-			c.JSON(http.StatusUnauthorized, AuthResponse{Code: 401, Message: "用户信息获取失败"})
-			c.Abort()
+			abortUnauthorized(c, AuthErrorUnauthenticated, "用户信息获取失败")
 			return
 		}
 
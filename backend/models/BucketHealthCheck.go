@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// BucketHealthCheck is one result of the periodic bucket connectivity check.
+// The latest state is kept on the bucket itself; these rows are the history.
+type BucketHealthCheck struct {
+	ID        int       `json:"id" gorm:"type:integer;primaryKey;autoIncrement"`
+	BucketID  int       `json:"bucket_id" gorm:"column:bucket_id;not null;index:idx_bucket_health_checks_bucket,priority:1"`
+	OK        bool      `json:"ok" gorm:"column:ok;not null"`
+	LatencyMs int64     `json:"latency_ms" gorm:"column:latency_ms;not null;default:0"` // 检查耗时毫秒数
+	Error     string    `json:"error" gorm:"column:error;type:text"`                    // 已脱敏的错误信息
+	CheckedAt time.Time `json:"checked_at" gorm:"column:checked_at;not null;index:idx_bucket_health_checks_bucket,priority:2"`
+}
+
+func (BucketHealthCheck) TableName() string {
+	return "bucket_health_checks"
+}
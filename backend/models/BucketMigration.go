@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Bucket migration states.
+const (
+	BucketMigrationStatusRunning   = "running"
+	BucketMigrationStatusCompleted = "completed"
+	BucketMigrationStatusFailed    = "failed"
+	BucketMigrationStatusCanceled  = "canceled"
+)
+
+// Bucket migration phases, executed in this order.
+const (
+	BucketMigrationPhaseCopying   = "copying"
+	BucketMigrationPhaseVerifying = "verifying"
+	BucketMigrationPhaseSwitching = "switching"
+	BucketMigrationPhaseDeleting  = "deleting"
+)
+
+// BucketMigration moves every replica of a source bucket to a target bucket.
+// Progress is persisted after each batch so a running migration resumes from
+// its current phase after a restart.
+type BucketMigration struct {
+	ID              int        `json:"id" gorm:"type:integer;primaryKey;autoIncrement"`
+	SourceBucketID  int        `json:"source_bucket_id" gorm:"column:source_bucket_id;not null;index"`
+	TargetBucketID  int        `json:"target_bucket_id" gorm:"column:target_bucket_id;not null;index"`
+	RewriteBucketID bool       `json:"rewrite_bucket_id" gorm:"column:rewrite_bucket_id;not null;default:false"`
+	Status          string     `json:"status" gorm:"column:status;size:16;not null;index"`
+	Phase           string     `json:"phase" gorm:"column:phase;size:16;not null"`
+	Total           int64      `json:"total" gorm:"column:total;not null;default:0"`
+	Copied          int64      `json:"copied" gorm:"column:copied;not null;default:0"`
+	Verified        int64      `json:"verified" gorm:"column:verified;not null;default:0"`
+	Switched        int64      `json:"switched" gorm:"column:switched;not null;default:0"`
+	LastImageID     int        `json:"-" gorm:"column:last_image_id;not null;default:0"`
+	Error           string     `json:"error" gorm:"column:error;type:text"`
+	StartedAt       *time.Time `json:"started_at" gorm:"column:started_at"`
+	FinishedAt      *time.Time `json:"finished_at" gorm:"column:finished_at"`
+	CreatedAt       time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt       time.Time  `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
+}
+
+func (BucketMigration) TableName() string {
+	return "bucket_migrations"
+}
@@ -1,5 +1,10 @@
 package models
 
+import (
+	"fmt"
+	"time"
+)
+
 type Buckets struct {
 	Id       int            `json:"id" gorm:"type:integer;primaryKey;autoIncrement"`
 	Name     string         `json:"name" gorm:"not null;unique"`                      // 存储名称，唯一
@@ -8,6 +13,57 @@ type Buckets struct {
 	Capacity uint64         `json:"capacity" gorm:"not null"`                         // 容量
 	Config   map[string]any `json:"config" gorm:"type:text;not null;serializer:json"` // 配置
 	Usage    uint64         `json:"usage" gorm:"not null"`                            // 已使用容量
+	// SyncConcurrency 后台同步时该存储源同时进行的任务上限，0 为沿用系统设置
+	SyncConcurrency int `json:"sync_concurrency" gorm:"not null;default:0"`
+	// SyncPaused 暂停向该存储源的后台同步，已有任务保留在队列中
+	SyncPaused bool `json:"sync_paused" gorm:"not null;default:false"`
+	// Processing 上传到该存储桶时的图片处理规格，为空时沿用系统设置；
+	// 多存储同步时该存储桶的副本由本地文件按此规格生成
+	Processing *BucketProcessing `json:"processing" gorm:"column:processing;type:text;serializer:json"`
+	// DeliveryPriority 按优先级分发时的顺序，数值越大越优先
+	DeliveryPriority int `json:"delivery_priority" gorm:"not null;default:0"`
+	// DeliveryWeight 同级存储源间按权重分流读取，0 表示仅在其它存储源不可用时使用
+	DeliveryWeight int `json:"delivery_weight" gorm:"not null;default:1"`
+	// Unhealthy 健康检查连续失败达到阈值后自动标记，访问时跳过该存储源，检查恢复后自动清除
+	Unhealthy bool `json:"unhealthy" gorm:"not null;default:false"`
+	// HealthFailures 健康检查连续失败次数
+	HealthFailures int `json:"health_failures" gorm:"not null;default:0"`
+	// HealthCheckedAt 最近一次健康检查时间
+	HealthCheckedAt *time.Time `json:"health_checked_at"`
+	// HealthError 最近一次健康检查的错误，成功后清空
+	HealthError string `json:"health_error" gorm:"type:text"`
+}
+
+// 存储桶输出格式
+const (
+	BucketOutputFormatInherit  = ""         // 沿用系统设置
+	BucketOutputFormatOriginal = "original" // 保持原格式
+	BucketOutputFormatWebP     = "webp"     // 统一转换为 WebP
+)
+
+// BucketProcessing 存储桶图片处理规格，零值字段沿用系统设置
+type BucketProcessing struct {
+	KeepOriginal  bool   `json:"keep_original"`  // 保留原图：不缩小、不加水印、不压缩、不转换格式
+	Quality       int    `json:"quality"`        // 压缩质量 1-100
+	SizeThreshold int64  `json:"size_threshold"` // 超过该字节数才压缩
+	OutputFormat  string `json:"output_format"`  // 输出格式：""/original/webp
+	LosslessPNG   bool   `json:"lossless_png"`   // PNG 无损处理（保持 PNG 或无损 WebP）
+}
+
+// Validate 校验处理规格取值范围
+func (p BucketProcessing) Validate() error {
+	if p.Quality < 0 || p.Quality > 100 {
+		return fmt.Errorf("压缩质量必须在1-100之间（0 为沿用系统设置）")
+	}
+	if p.SizeThreshold < 0 {
+		return fmt.Errorf("压缩阈值不能为负数")
+	}
+	switch p.OutputFormat {
+	case BucketOutputFormatInherit, BucketOutputFormatOriginal, BucketOutputFormatWebP:
+	default:
+		return fmt.Errorf("输出格式仅支持 original 或 webp")
+	}
+	return nil
 }
 
 // 定义每个bucket的存储类型
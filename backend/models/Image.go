@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // 图片模型
 type Image struct {
@@ -15,11 +19,28 @@ type Image struct {
 	Storage   string `json:"storage" gorm:"default:default"`
 	BucketId  int    `json:"bucket_id" gorm:"not null;default:1"`
 	// AccessBucketId selects which successful replica serves the stable image
-	// URL. Zero keeps the canonical/original bucket, which is the local bucket
-	// for images created in multi-storage mode.
-	AccessBucketId int       `json:"access_bucket_id" gorm:"column:access_bucket_id;not null;default:0;index"`
+	// URL. Zero leaves the choice to the delivery policy, which by default
+	// keeps the local replica or the canonical/original bucket.
+	AccessBucketId int `json:"access_bucket_id" gorm:"column:access_bucket_id;not null;default:0;index"`
+	// DeliveryPolicy overrides the global delivery policy for this image when
+	// AccessBucketId is zero. Empty inherits the global policy.
+	DeliveryPolicy string    `json:"delivery_policy" gorm:"column:delivery_policy;size:16;not null;default:''"`
 	UserId         int       `json:"user_id" gorm:"not null;default:1"`
 	MD5            string    `json:"md5"`
 	UUID           string    `json:"uuid" gorm:"not null;default:'00000000-0000-0000-0000-000000000000'"`
 	CreatedAt      time.Time `json:"created_at"`
+	// Version is bumped whenever the file behind the stable URL is replaced
+	// and feeds the ETag; ReplacedAt is used as Last-Modified after a swap.
+	Version    int        `json:"version" gorm:"column:version;not null;default:0"`
+	ReplacedAt *time.Time `json:"replaced_at" gorm:"column:replaced_at"`
+	// LastAccessedAt is refreshed at most once a day when the image is served
+	// and lets tiering rules find images nobody views any more.
+	LastAccessedAt *time.Time `json:"last_accessed_at" gorm:"column:last_accessed_at;index"`
+	// ExpiresAt, when set, makes the image stop being served after that time
+	// and lets the expiry reaper delete it.
+	ExpiresAt *time.Time `json:"expires_at" gorm:"column:expires_at;index"`
+	// DeletedAt moves the image to the recycle bin: it is hidden from normal
+	// queries and no longer served, but its files stay in place until the
+	// retention window passes and the purge job deletes them.
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"column:deleted_at;index"`
 }
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// ImageOriginal records the untouched upload of an image that was downscaled
+// on upload. The original is staged on local disk first and then archived to
+// the configured bucket by a background task; Status reuses the
+// ImageStorageStatus* values.
+type ImageOriginal struct {
+	ID          int            `json:"id" gorm:"type:integer;primaryKey;autoIncrement"`
+	ImageID     int            `json:"image_id" gorm:"column:image_id;not null;uniqueIndex:idx_image_originals_image"`
+	BucketID    int            `json:"bucket_id" gorm:"column:bucket_id;not null;index:idx_image_originals_bucket"`
+	Storage     string         `json:"storage" gorm:"column:storage;not null"`
+	Status      string         `json:"status" gorm:"column:status;size:16;not null;default:pending;index:idx_image_originals_status"`
+	Path        string         `json:"path" gorm:"column:path;not null"`
+	FileName    string         `json:"filename" gorm:"column:file_name"`
+	FileSize    int64          `json:"file_size" gorm:"column:file_size;not null;default:0"`
+	MimeType    string         `json:"mime_type" gorm:"column:mime_type"`
+	Width       int            `json:"width" gorm:"column:width"`
+	Height      int            `json:"height" gorm:"column:height"`
+	Error       string         `json:"error" gorm:"column:error;type:text"`
+	RetryCount  int            `json:"retry_count" gorm:"column:retry_count;not null;default:0"`
+	Metadata    map[string]any `json:"metadata" gorm:"column:metadata;type:text;serializer:json"`
+	NextRetryAt *time.Time     `json:"next_retry_at" gorm:"column:next_retry_at"`
+	ArchivedAt  *time.Time     `json:"archived_at" gorm:"column:archived_at"`
+	CreatedAt   time.Time      `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt   time.Time      `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
+
+	// Upload lease, see ImageStorage.
+	LeaseOwner     string     `json:"lease_owner" gorm:"column:lease_owner;size:128"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at" gorm:"column:lease_expires_at;index:idx_image_originals_lease"`
+}
+
+func (ImageOriginal) TableName() string {
+	return "image_originals"
+}
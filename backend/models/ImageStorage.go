@@ -7,6 +7,9 @@ const (
 	ImageStorageStatusUploading = "uploading"
 	ImageStorageStatusSuccess   = "success"
 	ImageStorageStatusFailed    = "failed"
+	// ImageStorageStatusDeadLetter marks a task that exhausted its automatic
+	// retries. It stays parked until an administrator retries it.
+	ImageStorageStatusDeadLetter = "dead_letter"
 )
 
 // ImageStorage records one physical copy of an image in a storage bucket.
@@ -30,6 +33,26 @@ type ImageStorage struct {
 	SyncedAt      *time.Time     `json:"synced_at" gorm:"column:synced_at"`
 	CreatedAt     time.Time      `json:"created_at" gorm:"column:created_at;autoCreateTime"`
 	UpdatedAt     time.Time      `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
+
+	// Checksum and ThumbnailChecksum are hex SHA-256 digests of the bytes
+	// stored in the bucket. They stay empty where the backend re-encodes
+	// uploads (Telegram photos) and for rows created before checksums existed.
+	Checksum          string     `json:"checksum" gorm:"column:checksum;size:64"`
+	ThumbnailChecksum string     `json:"thumbnail_checksum" gorm:"column:thumbnail_checksum;size:64"`
+	ScrubbedAt        *time.Time `json:"scrubbed_at" gorm:"column:scrubbed_at;index:idx_image_storages_scrubbed"`
+
+	// Processed marks a replica whose main file was derived with the bucket's
+	// processing profile instead of copied, and MimeType is the type of those
+	// bytes. Processed replicas are never used as a source for other copies.
+	Processed bool   `json:"processed" gorm:"column:processed;not null;default:false"`
+	MimeType  string `json:"mime_type" gorm:"column:mime_type;size:64"`
+
+	// LeaseOwner identifies the worker instance uploading the replica and
+	// LeaseExpiresAt when its claim lapses unless renewed by a heartbeat.
+	// Only expired leases are returned to the queue, so several instances can
+	// share the table.
+	LeaseOwner     string     `json:"lease_owner" gorm:"column:lease_owner;size:128"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at" gorm:"column:lease_expires_at;index:idx_image_storages_lease"`
 }
 
 func (ImageStorage) TableName() string {
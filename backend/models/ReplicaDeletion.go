@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+const (
+	ReplicaDeletionKindReplica  = "replica"
+	ReplicaDeletionKindOriginal = "original"
+)
+
+// ReplicaDeletion is a durable task that removes the files of a deleted image
+// from a remote bucket. The replica or original row it came from is already
+// gone and its bucket usage released, so the task carries everything needed
+// to find the files. Status reuses the ImageStorageStatus* values: pending,
+// uploading while a worker holds the lease, and dead_letter once retries are
+// exhausted.
+type ReplicaDeletion struct {
+	ID          int            `json:"id" gorm:"type:integer;primaryKey;autoIncrement"`
+	ImageID     int            `json:"image_id" gorm:"column:image_id;not null;index:idx_replica_deletions_image"`
+	BucketID    int            `json:"bucket_id" gorm:"column:bucket_id;not null;index:idx_replica_deletions_bucket"`
+	Storage     string         `json:"storage" gorm:"column:storage;not null"`
+	Kind        string         `json:"kind" gorm:"column:kind;size:16;not null;default:replica"`
+	Status      string         `json:"status" gorm:"column:status;size:16;not null;default:pending;index:idx_replica_deletions_status"`
+	URL         string         `json:"url" gorm:"column:url"`
+	Thumbnail   string         `json:"thumbnail" gorm:"column:thumbnail"`
+	FileName    string         `json:"filename" gorm:"column:file_name"`
+	Metadata    map[string]any `json:"metadata" gorm:"column:metadata;type:text;serializer:json"`
+	Error       string         `json:"error" gorm:"column:error;type:text"`
+	RetryCount  int            `json:"retry_count" gorm:"column:retry_count;not null;default:0"`
+	NextRetryAt *time.Time     `json:"next_retry_at" gorm:"column:next_retry_at"`
+	CreatedAt   time.Time      `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt   time.Time      `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
+
+	// Deletion lease, see ImageStorage.
+	LeaseOwner     string     `json:"lease_owner" gorm:"column:lease_owner;size:128"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at" gorm:"column:lease_expires_at;index:idx_replica_deletions_lease"`
+}
+
+func (ReplicaDeletion) TableName() string {
+	return "replica_deletions"
+}
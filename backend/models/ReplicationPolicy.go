@@ -0,0 +1,63 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ReplicationPolicy decides which remote buckets receive copies of a new
+// upload in multi-storage mode. Enabled policies are evaluated by ascending
+// priority and the first one whose conditions all match wins; empty
+// conditions match everything. When no policy matches, the uploader's bucket
+// permissions apply as before.
+type ReplicationPolicy struct {
+	ID       int    `json:"id" gorm:"type:integer;primaryKey;autoIncrement"`
+	Name     string `json:"name" gorm:"column:name;size:64;not null;uniqueIndex"`
+	Priority int    `json:"priority" gorm:"column:priority;not null;default:0;index"`
+	Enabled  bool   `json:"enabled" gorm:"column:enabled;not null"`
+
+	// Conditions
+	Tags      []string `json:"tags" gorm:"column:tags;type:text;serializer:json"`             // 图片带有其中任一标签
+	UserIDs   []int    `json:"user_ids" gorm:"column:user_ids;type:text;serializer:json"`     // 上传者为其中任一用户
+	Roles     []int    `json:"roles" gorm:"column:roles;type:text;serializer:json"`           // 上传者角色为其中之一
+	MimeTypes []string `json:"mime_types" gorm:"column:mime_types;type:text;serializer:json"` // MIME 类型，支持 image/* 通配
+	MinSize   int64    `json:"min_size" gorm:"column:min_size;not null;default:0"`            // 文件大小下限（字节），0 为不限
+	MaxSize   int64    `json:"max_size" gorm:"column:max_size;not null;default:0"`            // 文件大小上限（字节），0 为不限
+
+	// Actions
+	BucketIDs []int `json:"bucket_ids" gorm:"column:bucket_ids;type:text;serializer:json"` // 目标存储源，按顺序选取；为空表示仅保留本机
+	Replicas  int   `json:"replicas" gorm:"column:replicas;not null;default:0"`            // 需要的远端副本数，0 为全部目标存储源
+
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
+}
+
+func (ReplicationPolicy) TableName() string {
+	return "replication_policies"
+}
+
+// Validate 校验策略取值范围
+func (p ReplicationPolicy) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("策略名称不能为空")
+	}
+	if p.MinSize < 0 || p.MaxSize < 0 {
+		return fmt.Errorf("文件大小条件不能为负数")
+	}
+	if p.MaxSize > 0 && p.MinSize > p.MaxSize {
+		return fmt.Errorf("文件大小下限不能大于上限")
+	}
+	for _, role := range p.Roles {
+		if role != RoleAdmin && role != RoleGuest && role != RoleUser {
+			return fmt.Errorf("角色无效：%d", role)
+		}
+	}
+	if p.Replicas < 0 {
+		return fmt.Errorf("副本数不能为负数")
+	}
+	if p.Replicas > len(p.BucketIDs) {
+		return fmt.Errorf("副本数不能超过目标存储源数量")
+	}
+	return nil
+}
@@ -7,28 +7,55 @@ import (
 // Settings 系统配置模型（全局唯一配置）
 // 注意：该表应只有一条记录（ID=1），所有配置项存储在同一条记录中
 type Settings struct {
-	ID               int    `gorm:"type:integer;primarykey;column:id;autoIncrement" json:"id"`
-	CompressImage    bool   `gorm:"column:compress_image;default:false" json:"compress_image"`         // 是否压缩图片（默认不压缩）
-	SaveWebp         bool   `gorm:"column:save_webp;default:true" json:"save_webp"`                    // 是否保存webp格式（默认保存）
-	Thumbnail        bool   `gorm:"column:thumbnail;default:true" json:"thumbnail"`                    // 是否生成缩略图（默认生成）
-	Tourist          bool   `gorm:"column:tourist;default:false" json:"tourist"`                       // 是否允许游客上传（默认允许）
-	TGNotice         bool   `gorm:"column:tg_notice;default:false" json:"tg_notice"`                   // 是否启用TG通知（默认关闭）
-	PowVerify        bool   `gorm:"column:pow_verify;default:false" json:"pow_verify"`                 // 是否启用POW验证（默认关闭）
-	TGBotToken       string `gorm:"column:tg_bot_token;default:''" json:"tg_bot_token"`                // TG机器人Token
-	TGReceivers      string `gorm:"column:tg_receivers;default:''" json:"tg_receivers"`                // TG接收者（多个用逗号分隔）
-	TGNoticeText     string `gorm:"column:tg_notice_text;default:''" json:"tg_notice_text"`            // TG通知文本
-	StartAPI         bool   `gorm:"column:start_api;default:false" json:"start_api"`                   // 是否启用API（默认关闭）
-	APIToken         string `gorm:"column:api_token;default:''" json:"api_token"`                      // 兼容旧字段
-	RandomGraph      bool   `gorm:"column:random_graph;default:false" json:"random_graph"`             // 是否启用随机图（默认关闭）
-	APITokenHash     string `gorm:"column:api_token_hash;default:''" json:"-"`                         // API Token哈希
-	SaveOriginalName bool   `gorm:"column:save_original_name;default:false" json:"save_original_name"` // 是否保存原文件名（默认不保存）
-	StartRegister    bool   `gorm:"column:start_register;default:false" json:"start_register"`         // 是否启用注册（默认关闭）
+	ID                int    `gorm:"type:integer;primarykey;column:id;autoIncrement" json:"id"`
+	CompressImage     bool   `gorm:"column:compress_image;default:false" json:"compress_image"`         // 是否压缩图片（默认不压缩）
+	SaveWebp          bool   `gorm:"column:save_webp;default:true" json:"save_webp"`                    // 是否保存webp格式（默认保存）
+	Thumbnail         bool   `gorm:"column:thumbnail;default:true" json:"thumbnail"`                    // 是否生成缩略图（默认生成）
+	ThumbnailProfiles string `gorm:"column:thumbnail_profiles;type:text" json:"thumbnail_profiles"`     // 额外缩略图规格（JSON 数组，见 ThumbnailProfile）
+	Tourist           bool   `gorm:"column:tourist;default:false" json:"tourist"`                       // 是否允许游客上传（默认允许）
+	TGNotice          bool   `gorm:"column:tg_notice;default:false" json:"tg_notice"`                   // 是否启用TG通知（默认关闭）
+	PowVerify         bool   `gorm:"column:pow_verify;default:false" json:"pow_verify"`                 // 是否启用POW验证（默认关闭）
+	TGBotToken        string `gorm:"column:tg_bot_token;default:''" json:"tg_bot_token"`                // TG机器人Token
+	TGReceivers       string `gorm:"column:tg_receivers;default:''" json:"tg_receivers"`                // TG接收者（多个用逗号分隔）
+	TGNoticeText      string `gorm:"column:tg_notice_text;default:''" json:"tg_notice_text"`            // TG通知文本
+	StartAPI          bool   `gorm:"column:start_api;default:false" json:"start_api"`                   // 是否启用API（默认关闭）
+	APIToken          string `gorm:"column:api_token;default:''" json:"api_token"`                      // 兼容旧字段
+	RandomGraph       bool   `gorm:"column:random_graph;default:false" json:"random_graph"`             // 是否启用随机图（默认关闭）
+	APITokenHash      string `gorm:"column:api_token_hash;default:''" json:"-"`                         // API Token哈希
+	SaveOriginalName  bool   `gorm:"column:save_original_name;default:false" json:"save_original_name"` // 是否保存原文件名（默认不保存）
+	StartRegister     bool   `gorm:"column:start_register;default:false" json:"start_register"`         // 是否启用注册（默认关闭）
 
 	// 默认存储
 	DefaultStorage   int  `gorm:"column:default_storage;default:1" json:"default_storage"`           // 单存储模式下的默认存储
 	MultiStorageSync bool `gorm:"column:multi_storage_sync;default:false" json:"multi_storage_sync"` // 是否启用本机落盘后的多存储后台同步
 	EncryptedStorage bool `gorm:"column:encrypted_storage;default:false" json:"encrypted_storage"`   // 是否加密新写入到各存储源的图片文件
 
+	// 存储同步并发与重试
+	StorageSyncWorkers           int `gorm:"column:storage_sync_workers;default:4" json:"storage_sync_workers"`                       // 后台同步并发数
+	StorageSyncBucketConcurrency int `gorm:"column:storage_sync_bucket_concurrency;default:2" json:"storage_sync_bucket_concurrency"` // 单个存储源的默认并发上限
+	StorageSyncMaxAttempts       int `gorm:"column:storage_sync_max_attempts;default:3" json:"storage_sync_max_attempts"`             // 自动重试次数上限，用尽后进入死信
+	StorageSyncBackoffBase       int `gorm:"column:storage_sync_backoff_base;default:5" json:"storage_sync_backoff_base"`             // 首次重试等待秒数，之后按指数增长
+	StorageSyncBackoffMax        int `gorm:"column:storage_sync_backoff_max;default:3600" json:"storage_sync_backoff_max"`            // 单次重试等待秒数上限
+
+	// 副本完整性巡检
+	StorageScrubRate     int    `gorm:"column:storage_scrub_rate;default:0" json:"storage_scrub_rate"`           // 每分钟巡检的副本数，0 表示关闭
+	StorageScrubInterval int    `gorm:"column:storage_scrub_interval;default:168" json:"storage_scrub_interval"` // 同一副本两次巡检的间隔小时数
+	StorageScrubMode     string `gorm:"column:storage_scrub_mode;default:'head'" json:"storage_scrub_mode"`      // head 仅校验存在与大小，read 下载并校验 SHA-256
+
+	// 存储用量校准
+	StorageReconcileInterval int `gorm:"column:storage_reconcile_interval;default:24" json:"storage_reconcile_interval"` // 按记录重新统计各存储源用量的间隔小时数，0 表示关闭
+
+	// 图片分发
+	DeliveryPolicy string `gorm:"column:delivery_policy;default:'local'" json:"delivery_policy"` // 未指定访问源的图片如何选择副本：local/priority/weighted/fastest
+
+	// 存储源健康检查
+	BucketHealthInterval  int `gorm:"column:bucket_health_interval;default:5" json:"bucket_health_interval"`   // 检查远程存储源连通性的间隔分钟数，0 表示关闭
+	BucketHealthThreshold int `gorm:"column:bucket_health_threshold;default:3" json:"bucket_health_threshold"` // 连续失败多少次后标记为不健康
+
+	// 两步验证
+	TOTPRequireAdmin bool `gorm:"column:totp_require_admin;default:false" json:"totp_require_admin"` // 强制管理员账号启用两步验证
+	TOTPRequireUser  bool `gorm:"column:totp_require_user;default:false" json:"totp_require_user"`   // 强制普通用户账号启用两步验证
+
 	// 外部身份认证
 	OIDCEnable             bool   `gorm:"column:oidc_enable;default:false" json:"oidc_enable"`
 	OIDCIssuer             string `gorm:"column:oidc_issuer;default:''" json:"oidc_issuer"`
@@ -53,6 +80,23 @@ type Settings struct {
 	DefaultPath  string `gorm:"column:default_path;default:'/uploads/{year}/{moon}'" json:"default_path"` // 默认上传路径，魔法变量 {year} 年 {month} 月 {day} 日 {hour} 小时 {minute} 分钟 {random} 随机 {uuid} UUID {role} 角色（1 为管理员, 2 为游客）
 	FileName     string `gorm:"column:file_name;default:'{random}'" json:"file_name"`                     // 上传文件名称，魔法变量 {random} 随机数 {year} 年 {month} 月 {day} 日 {hour} 小时 {minute} 分钟 {second} 秒
 
+	// 超尺寸自动缩小
+	MaxImageDimension     int `gorm:"column:max_image_dimension;default:0" json:"max_image_dimension"`         // 最长边超过该像素时等比缩小（0 为不限制）
+	ArchiveOriginalBucket int `gorm:"column:archive_original_bucket;default:0" json:"archive_original_bucket"` // 缩小时保留原图的归档存储源（0 为不保留）
+
+	// 压缩参数（可被存储桶处理规格覆盖）
+	CompressQuality   int   `gorm:"column:compress_quality;default:85" json:"compress_quality"`          // 压缩质量 1-100
+	CompressThreshold int64 `gorm:"column:compress_threshold;default:1048576" json:"compress_threshold"` // 超过该字节数才压缩
+	LosslessPNG       bool  `gorm:"column:lossless_png;default:false" json:"lossless_png"`               // PNG 无损处理（保持 PNG 或无损 WebP）
+
+	// 图片自动过期（按上传者角色的默认有效期，同时是 expires_in 可设置的上限）
+	AdminImageExpiry int `gorm:"column:admin_image_expiry;default:0" json:"admin_image_expiry"` // 管理员上传的有效小时数，0 为永久
+	UserImageExpiry  int `gorm:"column:user_image_expiry;default:0" json:"user_image_expiry"`   // 普通用户上传的有效小时数，0 为永久
+	GuestImageExpiry int `gorm:"column:guest_image_expiry;default:0" json:"guest_image_expiry"` // 游客上传的有效小时数，0 为永久
+
+	// 回收站
+	TrashRetentionDays int `gorm:"column:trash_retention_days;default:30" json:"trash_retention_days"` // 删除的图片可恢复的天数，0 为直接彻底删除
+
 	// 图片直链设置
 	PublicImageDomain string `gorm:"column:public_image_domain;default:''" json:"public_image_domain"` // 图片直链域名（用于非本地存储直接访问）
 
@@ -98,3 +142,15 @@ func (s *Settings) GetTGReceiversList() []string {
 	}
 	return result
 }
+
+// GetThumbnailProfiles 返回已配置的缩略图规格；关闭缩略图或配置无效时返回空。
+func (s *Settings) GetThumbnailProfiles() []ThumbnailProfile {
+	if !s.Thumbnail {
+		return nil
+	}
+	profiles, err := ParseThumbnailProfiles(s.ThumbnailProfiles)
+	if err != nil {
+		return nil
+	}
+	return profiles
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 缩略图裁剪方式
+const (
+	ThumbnailFitContain = "contain" // 等比缩放至框内，不裁剪
+	ThumbnailFitCover   = "cover"   // 居中裁剪铺满目标尺寸
+	ThumbnailFitSmart   = "smart"   // 按画面细节选取裁剪区域后铺满
+)
+
+// 缩略图输出格式
+const (
+	ThumbnailFormatWebP = "webp"
+	ThumbnailFormatJPEG = "jpeg"
+	ThumbnailFormatPNG  = "png"
+)
+
+const (
+	MaxThumbnailProfiles      = 10
+	MaxThumbnailDimension     = 4096
+	DefaultThumbnailQuality   = 80
+	thumbnailProfileNameLimit = 32
+)
+
+var thumbnailProfileNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ThumbnailProfile 缩略图规格，多个规格以 JSON 数组保存在 settings.thumbnail_profiles。
+type ThumbnailProfile struct {
+	Name    string `json:"name"`    // 规格名，同时作为存储子目录名
+	Width   int    `json:"width"`   // 目标宽度
+	Height  int    `json:"height"`  // 目标高度
+	Fit     string `json:"fit"`     // contain / cover / smart
+	Format  string `json:"format"`  // webp / jpeg / png
+	Quality int    `json:"quality"` // 1-100，png 忽略
+}
+
+// ImageThumbnail 图片按缩略图规格生成的衍生文件，统一保存在本机存储。
+type ImageThumbnail struct {
+	Id        int       `json:"id" gorm:"type:integer;primaryKey;autoIncrement"`
+	ImageId   int       `json:"image_id" gorm:"column:image_id;not null;uniqueIndex:idx_image_thumbnail_profile"`
+	Profile   string    `json:"profile" gorm:"column:profile;type:varchar(64);not null;uniqueIndex:idx_image_thumbnail_profile"`
+	Path      string    `json:"path" gorm:"column:path;type:varchar(512);not null;index"`
+	Width     int       `json:"width" gorm:"column:width"`
+	Height    int       `json:"height" gorm:"column:height"`
+	FileSize  int64     `json:"file_size" gorm:"column:file_size"`
+	MimeType  string    `json:"mime_type" gorm:"column:mime_type;type:varchar(64)"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (ImageThumbnail) TableName() string {
+	return "image_thumbnails"
+}
+
+// ParseThumbnailProfiles 解析并校验缩略图规格配置，空配置返回 nil。
+// 未填写的裁剪方式、格式和质量会补齐默认值。
+func ParseThumbnailProfiles(raw string) ([]ThumbnailProfile, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "null" {
+		return nil, nil
+	}
+
+	var profiles []ThumbnailProfile
+	if err := json.Unmarshal([]byte(raw), &profiles); err != nil {
+		return nil, fmt.Errorf("缩略图规格格式错误：%v", err)
+	}
+	if len(profiles) > MaxThumbnailProfiles {
+		return nil, fmt.Errorf("缩略图规格最多 %d 个", MaxThumbnailProfiles)
+	}
+
+	seen := make(map[string]struct{}, len(profiles))
+	for i := range profiles {
+		profile := &profiles[i]
+		profile.Name = strings.ToLower(strings.TrimSpace(profile.Name))
+		if profile.Name == "" || len(profile.Name) > thumbnailProfileNameLimit || !thumbnailProfileNameRegex.MatchString(profile.Name) {
+			return nil, fmt.Errorf("缩略图规格名只能包含小写字母、数字、下划线和短横线，且不超过 %d 个字符", thumbnailProfileNameLimit)
+		}
+		if _, exists := seen[profile.Name]; exists {
+			return nil, fmt.Errorf("缩略图规格名 %s 重复", profile.Name)
+		}
+		seen[profile.Name] = struct{}{}
+
+		if profile.Width < 1 || profile.Width > MaxThumbnailDimension || profile.Height < 1 || profile.Height > MaxThumbnailDimension {
+			return nil, fmt.Errorf("缩略图规格 %s 的宽高必须在 1-%d 之间", profile.Name, MaxThumbnailDimension)
+		}
+
+		profile.Fit = strings.ToLower(strings.TrimSpace(profile.Fit))
+		switch profile.Fit {
+		case "":
+			profile.Fit = ThumbnailFitContain
+		case ThumbnailFitContain, ThumbnailFitCover, ThumbnailFitSmart:
+		default:
+			return nil, fmt.Errorf("缩略图规格 %s 的裁剪方式不支持：%s", profile.Name, profile.Fit)
+		}
+
+		profile.Format = strings.ToLower(strings.TrimSpace(profile.Format))
+		switch profile.Format {
+		case "":
+			profile.Format = ThumbnailFormatWebP
+		case "jpg":
+			profile.Format = ThumbnailFormatJPEG
+		case ThumbnailFormatWebP, ThumbnailFormatJPEG, ThumbnailFormatPNG:
+		default:
+			return nil, fmt.Errorf("缩略图规格 %s 的格式不支持：%s", profile.Name, profile.Format)
+		}
+
+		if profile.Quality == 0 {
+			profile.Quality = DefaultThumbnailQuality
+		}
+		if profile.Quality < 1 || profile.Quality > 100 {
+			return nil, fmt.Errorf("缩略图规格 %s 的质量必须在 1-100 之间", profile.Name)
+		}
+	}
+	return profiles, nil
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TieringRule moves images off the local disk once they are old or idle. An
+// image qualifies when every non-zero threshold is exceeded: MinAgeDays
+// against its upload time and IdleDays against its last access, or its upload
+// time if it was never served. Qualifying images keep their replica in the
+// target bucket, which becomes the access source, and lose the local copy.
+type TieringRule struct {
+	ID             int       `json:"id" gorm:"type:integer;primaryKey;autoIncrement"`
+	Name           string    `json:"name" gorm:"column:name;size:64;not null;uniqueIndex"`
+	Enabled        bool      `json:"enabled" gorm:"column:enabled;not null"`
+	TargetBucketID int       `json:"target_bucket_id" gorm:"column:target_bucket_id;not null;index"` // 冷数据保留的存储源
+	MinAgeDays     int       `json:"min_age_days" gorm:"column:min_age_days;not null;default:0"`     // 上传超过该天数，0 为不限
+	IdleDays       int       `json:"idle_days" gorm:"column:idle_days;not null;default:0"`           // 超过该天数未被访问，0 为不限
+	CreatedAt      time.Time `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt      time.Time `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
+}
+
+func (TieringRule) TableName() string {
+	return "tiering_rules"
+}
+
+// Validate 校验规则取值范围
+func (r TieringRule) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("规则名称不能为空")
+	}
+	if r.TargetBucketID <= 0 {
+		return fmt.Errorf("请选择冷数据存储源")
+	}
+	if r.MinAgeDays < 0 || r.IdleDays < 0 {
+		return fmt.Errorf("天数不能为负数")
+	}
+	if r.MinAgeDays == 0 && r.IdleDays == 0 {
+		return fmt.Errorf("上传天数与未访问天数至少需要设置一项")
+	}
+	if r.MinAgeDays > 36500 || r.IdleDays > 36500 {
+		return fmt.Errorf("天数不能超过36500")
+	}
+	return nil
+}
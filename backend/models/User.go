@@ -34,6 +34,7 @@ var AllPermissionMap = map[string]string{
 	"user:role:update":       "修改角色",
 	"user:permission:update": "编辑权限",
 	"user:password:reset":    "重置密码",
+	"user:2fa:reset":         "重置两步验证",
 
 	"tag:create": "新增Tag",
 	"tag:delete": "删除Tag",
@@ -54,6 +55,7 @@ var AllPermissionMap = map[string]string{
 	"image:tag:add":       "添加图片标签",
 	"image:tag:delete":    "删除图片标签",
 	"image:access:source": "图片存储源",
+	"image:replace":       "替换图片文件",
 }
 
 // ValidatePermissionCodes 严格校验：存在非法权限码则报错。
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// UserTOTP 本地账号的 TOTP 两步验证配置。
+// Secret 以配置密钥加密存储；RecoveryCodes 为一次性恢复码的 SHA-256 摘要，用后即删。
+type UserTOTP struct {
+	UserID        int        `json:"user_id" gorm:"column:user_id;primaryKey;autoIncrement:false"`
+	Secret        string     `json:"-" gorm:"column:secret;type:text;not null"`
+	Enabled       bool       `json:"enabled" gorm:"column:enabled;default:false"` // 未启用表示仍在绑定中
+	LastUsedStep  int64      `json:"-" gorm:"column:last_used_step;default:0"`    // 最近一次通过校验的时间步，防止验证码重放
+	RecoveryCodes []string   `json:"-" gorm:"column:recovery_codes;type:text;serializer:json"`
+	FailedCount   int        `json:"-" gorm:"column:failed_count;default:0"` // 连续校验失败次数，跨登录会话累计
+	LockedUntil   *time.Time `json:"-" gorm:"column:locked_until"`           // 连续失败过多后的锁定截止时间
+	EnabledAt     *time.Time `json:"enabled_at" gorm:"column:enabled_at"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt     time.Time  `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
+}
+
+func (UserTOTP) TableName() string {
+	return "user_totps"
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// WebAuthnCredential 用户注册的通行密钥（平台验证器或安全密钥）。
+// PublicKey 为验证器返回的 COSE 公钥；SignCount 用于发现被克隆的验证器。
+type WebAuthnCredential struct {
+	ID           int        `json:"id" gorm:"type:integer;primaryKey;autoIncrement"`
+	UserID       int        `json:"user_id" gorm:"column:user_id;not null;index"`
+	Name         string     `json:"name" gorm:"column:name;size:64;not null"`
+	CredentialID string     `json:"credential_id" gorm:"column:credential_id;size:1400;not null;uniqueIndex"` // base64url 编码的凭证 ID
+	PublicKey    []byte     `json:"-" gorm:"column:public_key;not null"`
+	Algorithm    int        `json:"algorithm" gorm:"column:algorithm"` // COSE 算法标识，如 -7 (ES256)
+	SignCount    uint32     `json:"sign_count" gorm:"column:sign_count;default:0"`
+	AAGUID       string     `json:"aaguid" gorm:"column:aaguid;size:36"` // 验证器型号标识，未提供时全零
+	Transports   []string   `json:"transports" gorm:"column:transports;type:text;serializer:json"`
+	LastUsedAt   *time.Time `json:"last_used_at" gorm:"column:last_used_at"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+}
+
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
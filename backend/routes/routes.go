@@ -43,8 +43,8 @@ func SetupRoutes(frontendFS embed.FS) *gin.Engine {
 				strings.HasPrefix(origin, "http://127.0.0.1:")
 		},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-API-Key", "X-Requested-With"},
+		ExposeHeaders:    []string{"Content-Length", "WWW-Authenticate"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -61,6 +61,9 @@ func SetupRoutes(frontendFS embed.FS) *gin.Engine {
 	{
 		// 公开接口
 		api.POST("/login", controllers.Login)
+		api.POST("/login/2fa", controllers.LoginTwoFactor)
+		api.POST("/auth/passkey/login/begin", controllers.BeginPasskeyLogin)
+		api.POST("/auth/passkey/login/finish", controllers.FinishPasskeyLogin)
 		api.POST("/register", controllers.Register)
 		api.POST("/logout", controllers.Logout)
 		api.GET("/logout", controllers.Logout)
@@ -89,6 +92,9 @@ func SetupRoutes(frontendFS embed.FS) *gin.Engine {
 			auth.POST("/upload", controllers.UploadImage)
 			auth.POST("/upload/images", controllers.UploadImages)
 			auth.DELETE("/images/:id", controllers.DeleteImage)
+			auth.GET("/trash", controllers.GetTrashedImages)
+			auth.POST("/trash/:id/restore", controllers.RestoreTrashedImage)
+			auth.DELETE("/trash/:id", controllers.PurgeTrashedImage)
 			auth.GET("/images", controllers.GetImageList)
 			auth.GET("/images/:id", controllers.GetImageDetail)
 			auth.POST("/images/tag", controllers.AddImageTag)
@@ -97,6 +103,9 @@ func SetupRoutes(frontendFS embed.FS) *gin.Engine {
 			auth.POST("/images/tags", controllers.AddImageTags)
 			auth.PUT("/images/access-source", controllers.BatchUpdateImageAccessSource)
 			auth.PUT("/images/:id/access-source", controllers.UpdateImageAccessSource)
+			auth.PUT("/images/delivery-policy", controllers.BatchUpdateImageDeliveryPolicy)
+			auth.PUT("/images/:id/delivery-policy", controllers.UpdateImageDeliveryPolicy)
+			auth.PUT("/images/:id/file", controllers.ReplaceImageFile)
 			auth.POST("/images/url", controllers.UploadImagesByURL)
 
 			// 标签管理
@@ -110,10 +119,70 @@ func SetupRoutes(frontendFS embed.FS) *gin.Engine {
 			auth.POST("/buckets/test", controllers.TestBucketConnection)
 			auth.POST("/buckets/update/:id", middlewares.RequirePermission("storage:update"), controllers.UpdateBuckets)
 			auth.PUT("/buckets/:id/enabled", middlewares.RequirePermission("storage:update"), controllers.UpdateBucketEnabled)
+			auth.PUT("/buckets/:id/processing", middlewares.RequirePermission("storage:update"), controllers.UpdateBucketProcessing)
+			auth.PUT("/buckets/:id/sync-concurrency", middlewares.RequirePermission("storage:update"), controllers.UpdateBucketSyncConcurrency)
+			auth.PUT("/buckets/:id/delivery", middlewares.RequirePermission("storage:update"), controllers.UpdateBucketDelivery)
+			auth.GET("/buckets/:id/backfill", middlewares.RequirePermission("storage:update"), controllers.GetBucketBackfillStatus)
+			auth.POST("/buckets/:id/backfill", middlewares.RequirePermission("storage:update"), controllers.StartBucketBackfill)
+			auth.DELETE("/buckets/:id/backfill", middlewares.RequirePermission("storage:update"), controllers.CancelBucketBackfill)
+			auth.GET("/buckets/:id/orphans", middlewares.RequirePermission("storage:update"), controllers.ScanBucketOrphans)
+			auth.DELETE("/buckets/:id/orphans", middlewares.RequirePermission("storage:delete"), controllers.DeleteBucketOrphans)
+			auth.POST("/buckets/usage/reconcile", middlewares.RequirePermission("storage:update"), controllers.ReconcileAllBucketUsage)
+			auth.POST("/buckets/:id/usage/reconcile", middlewares.RequirePermission("storage:update"), controllers.ReconcileBucketUsage)
+			auth.GET("/buckets/health", middlewares.RequirePermission("storage:update"), controllers.GetBucketHealthOverview)
+			auth.GET("/buckets/:id/health", middlewares.RequirePermission("storage:update"), controllers.GetBucketHealthHistory)
+			auth.POST("/buckets/:id/health", middlewares.RequirePermission("storage:update"), controllers.CheckBucketHealthNow)
 			auth.DELETE("/buckets/:id", middlewares.RequirePermission("storage:delete"), controllers.DeleteBuckets)
 
+			// 存储源迁移
+			auth.GET("/storage-migrations", middlewares.RequirePermission("storage:update"), controllers.GetBucketMigrations)
+			auth.POST("/storage-migrations", middlewares.RequirePermission("storage:update"), controllers.StartBucketMigration)
+			auth.GET("/storage-migrations/:id", middlewares.RequirePermission("storage:update"), controllers.GetBucketMigration)
+			auth.POST("/storage-migrations/:id/resume", middlewares.RequirePermission("storage:update"), controllers.ResumeBucketMigration)
+			auth.POST("/storage-migrations/:id/cancel", middlewares.RequirePermission("storage:update"), controllers.CancelBucketMigration)
+
+			// 复制策略
+			auth.GET("/replication-policies", middlewares.RequirePermission("storage:update"), controllers.GetReplicationPolicies)
+			auth.POST("/replication-policies", middlewares.RequirePermission("storage:update"), controllers.CreateReplicationPolicy)
+			auth.PUT("/replication-policies/:id", middlewares.RequirePermission("storage:update"), controllers.UpdateReplicationPolicy)
+			auth.DELETE("/replication-policies/:id", middlewares.RequirePermission("storage:update"), controllers.DeleteReplicationPolicy)
+
+			// 分层存储规则
+			auth.GET("/tiering-rules", middlewares.RequirePermission("storage:update"), controllers.GetTieringRules)
+			auth.POST("/tiering-rules", middlewares.RequirePermission("storage:update"), controllers.CreateTieringRule)
+			auth.POST("/tiering-rules/run", middlewares.RequirePermission("storage:update"), controllers.RunTieringRules)
+			auth.PUT("/tiering-rules/:id", middlewares.RequirePermission("storage:update"), controllers.UpdateTieringRule)
+			auth.DELETE("/tiering-rules/:id", middlewares.RequirePermission("storage:update"), controllers.DeleteTieringRule)
+
+			// 存储同步队列
+			auth.GET("/storage-sync/overview", middlewares.AdminOnlyMiddleware(), controllers.GetStorageSyncOverview)
+			auth.GET("/storage-sync/throughput", middlewares.AdminOnlyMiddleware(), controllers.GetStorageSyncThroughput)
+			auth.GET("/storage-sync/in-flight", middlewares.AdminOnlyMiddleware(), controllers.GetStorageSyncInFlight)
+			auth.GET("/storage-sync/failed", middlewares.AdminOnlyMiddleware(), controllers.GetStorageSyncFailedReplicas)
+			auth.POST("/storage-sync/buckets/:id/pause", middlewares.RequirePermission("storage:update"), controllers.PauseBucketSync)
+			auth.POST("/storage-sync/buckets/:id/resume", middlewares.RequirePermission("storage:update"), controllers.ResumeBucketSync)
+			auth.POST("/storage-sync/retry", middlewares.RequirePermission("storage:update"), controllers.RetryAllStorageReplicas)
+			auth.POST("/storage-sync/replicas/:id/retry", middlewares.RequirePermission("storage:update"), controllers.RetryStorageReplica)
+			auth.POST("/storage-sync/replicas/:id/scrub", middlewares.RequirePermission("storage:update"), controllers.ScrubStorageReplica)
+			auth.POST("/storage-sync/buckets/:id/retry", middlewares.RequirePermission("storage:update"), controllers.RetryBucketStorageReplicas)
+			auth.GET("/storage-deletions", middlewares.AdminOnlyMiddleware(), controllers.GetReplicaDeletions)
+			auth.POST("/storage-deletions/retry", middlewares.RequirePermission("storage:update"), controllers.RetryAllReplicaDeletions)
+			auth.POST("/storage-deletions/:id/retry", middlewares.RequirePermission("storage:update"), controllers.RetryReplicaDeletion)
+
 			// 账户
 			auth.POST("/account/change", controllers.ChangeAccountInfo)
+			auth.GET("/tokens", controllers.GetAPITokens)
+			auth.POST("/tokens", controllers.CreateAPIToken)
+			auth.DELETE("/tokens/:id", controllers.DeleteAPIToken)
+			auth.GET("/account/2fa", controllers.GetTwoFactorStatus)
+			auth.POST("/account/2fa/setup", controllers.SetupTwoFactor)
+			auth.POST("/account/2fa/enable", controllers.EnableTwoFactor)
+			auth.POST("/account/2fa/disable", controllers.DisableTwoFactor)
+			auth.POST("/account/2fa/recovery-codes", controllers.RegenerateTwoFactorRecoveryCodes)
+			auth.GET("/account/passkeys", controllers.GetPasskeys)
+			auth.POST("/account/passkeys/register/begin", controllers.BeginPasskeyRegistration)
+			auth.POST("/account/passkeys/register/finish", controllers.FinishPasskeyRegistration)
+			auth.DELETE("/account/passkeys/:id", controllers.DeletePasskey)
 			auth.POST("/sessions/clear", middlewares.RequirePermission("setting:security"), controllers.ClearAllSessions)
 
 			// 用户管理
@@ -122,6 +191,7 @@ func SetupRoutes(frontendFS embed.FS) *gin.Engine {
 			auth.DELETE("/users/:id", middlewares.RequirePermission("user:delete"), controllers.DeleteUser)
 			auth.POST("/users/updateRole", middlewares.RequirePermission("user:role:update"), controllers.UpdateUserRole)
 			auth.POST("/users/resetPassword/:id", middlewares.RequirePermission("user:password:reset"), controllers.ResetPassword)
+			auth.POST("/users/reset2fa/:id", middlewares.RequirePermission("user:2fa:reset"), controllers.ResetUserTwoFactor)
 			auth.POST("/users/updatePermission/:id", middlewares.RequirePermission("user:permission:update"), controllers.UpdateUserPermission)
 
 			// 系统设置
@@ -129,6 +199,9 @@ func SetupRoutes(frontendFS embed.FS) *gin.Engine {
 			auth.POST("/settings/update", controllers.UpdateSettings)
 			auth.GET("/settings/randomGraph", middlewares.RequirePermission("setting:api"), controllers.GetRandomGraph)
 			auth.POST("/settings/randomGraph", middlewares.RequirePermission("setting:api"), controllers.SetRandomGraph)
+			auth.GET("/settings/thumbnails/regenerate", middlewares.RequirePermission("setting:image"), controllers.GetThumbnailRegenerationStatus)
+			auth.POST("/settings/thumbnails/regenerate", middlewares.RequirePermission("setting:image"), controllers.StartThumbnailRegeneration)
+			auth.DELETE("/settings/thumbnails/regenerate", middlewares.RequirePermission("setting:image"), controllers.CancelThumbnailRegeneration)
 		}
 	}
 
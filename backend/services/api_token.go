@@ -0,0 +1,100 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"oneimg/backend/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// PersonalAPITokenPrefix marks personal access tokens so they can be told
+	// apart from the global API token.
+	PersonalAPITokenPrefix = "oneimg_pat_"
+	// MaxAPITokensPerUser caps how many tokens one user may hold.
+	MaxAPITokensPerUser = 50
+	// MaxAPITokenLifetimeDays caps the requested token lifetime.
+	MaxAPITokenLifetimeDays = 3650
+
+	apiTokenVisiblePrefix = 6
+	// apiTokenTouchInterval limits how often last-used tracking writes.
+	apiTokenTouchInterval = time.Minute
+)
+
+var (
+	ErrAPITokenInvalid = errors.New("api token is invalid")
+	ErrAPITokenExpired = errors.New("api token has expired")
+)
+
+// IsPersonalAPIToken reports whether raw looks like a personal access token.
+func IsPersonalAPIToken(raw string) bool {
+	return strings.HasPrefix(raw, PersonalAPITokenPrefix)
+}
+
+// CreateAPIToken generates a new token for token.UserID and stores its hash.
+// The returned plaintext token cannot be recovered later.
+func CreateAPIToken(db *gorm.DB, token *models.APIToken) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	raw := PersonalAPITokenPrefix + hex.EncodeToString(secret)
+	token.Prefix = raw[:len(PersonalAPITokenPrefix)+apiTokenVisiblePrefix]
+	token.TokenHash = hashAPIToken(raw)
+	if token.Buckets == nil {
+		token.Buckets = []int{}
+	}
+	if err := db.Create(token).Error; err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// AuthenticateAPIToken resolves a personal access token to its record and
+// owner, and records when and from where it was last used.
+func AuthenticateAPIToken(db *gorm.DB, raw, clientIP string) (models.APIToken, models.User, error) {
+	var token models.APIToken
+	if !IsPersonalAPIToken(raw) {
+		return token, models.User{}, ErrAPITokenInvalid
+	}
+	if err := db.Where("token_hash = ?", hashAPIToken(raw)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return token, models.User{}, ErrAPITokenInvalid
+		}
+		return token, models.User{}, err
+	}
+	now := time.Now()
+	if token.IsExpired(now) {
+		return token, models.User{}, ErrAPITokenExpired
+	}
+
+	var user models.User
+	if err := db.Select("id", "role", "username", "permission").First(&user, token.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return token, models.User{}, ErrAPITokenInvalid
+		}
+		return token, models.User{}, err
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= apiTokenTouchInterval || token.LastUsedIP != clientIP {
+		if err := db.Model(&models.APIToken{}).Where("id = ?", token.ID).Updates(map[string]any{
+			"last_used_at": now,
+			"last_used_ip": clientIP,
+		}).Error; err == nil {
+			token.LastUsedAt = &now
+			token.LastUsedIP = clientIP
+		}
+	}
+	return token, user, nil
+}
+
+func hashAPIToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const bucketBackfillBatchSize = 200
+
+var (
+	ErrBucketBackfillRunning = errors.New("a backfill is already running for this bucket")
+
+	bucketBackfillMu   sync.Mutex
+	bucketBackfillJobs = make(map[int]*bucketBackfillJob)
+)
+
+// BucketBackfillFilter narrows the existing images that are replicated to a
+// bucket. Zero values do not filter.
+type BucketBackfillFilter struct {
+	StartDate      *time.Time `json:"start_date,omitempty"`
+	EndDate        *time.Time `json:"end_date,omitempty"`
+	TagIDs         []int      `json:"tag_ids,omitempty"`
+	UserID         int        `json:"user_id,omitempty"`
+	SourceBucketID int        `json:"source_bucket_id,omitempty"`
+}
+
+// BucketBackfillStatus reports progress of the job that enqueues replicas of
+// existing images for one bucket. Uploading is done by the storage sync
+// worker; Enqueued counts the pending rows this job created. Skipped counts
+// matching images without a verified replica the worker can copy from.
+type BucketBackfillStatus struct {
+	BucketID   int                  `json:"bucket_id"`
+	Filter     BucketBackfillFilter `json:"filter"`
+	Running    bool                 `json:"running"`
+	Canceled   bool                 `json:"canceled"`
+	Total      int64                `json:"total"`
+	Processed  int64                `json:"processed"`
+	Enqueued   int64                `json:"enqueued"`
+	Skipped    int64                `json:"skipped"`
+	LastError  string               `json:"last_error,omitempty"`
+	StartedAt  *time.Time           `json:"started_at,omitempty"`
+	FinishedAt *time.Time           `json:"finished_at,omitempty"`
+}
+
+type bucketBackfillJob struct {
+	status BucketBackfillStatus
+	cancel context.CancelFunc
+}
+
+// StartBucketBackfill enqueues pending replicas in bucket for every existing
+// image matching filter that does not have a replica there yet. Rows are
+// created in batches so the sync worker can start uploading immediately.
+func StartBucketBackfill(bucket models.Buckets, filter BucketBackfillFilter) (BucketBackfillStatus, error) {
+	if bucket.Type == "default" {
+		return BucketBackfillStatus{}, errors.New("the local bucket always holds the canonical files")
+	}
+	db := database.GetDB().DB
+	var matched, total int64
+	if err := bucketBackfillCandidates(db, bucket.Id, filter).Count(&matched).Error; err != nil {
+		return BucketBackfillStatus{}, err
+	}
+	if err := bucketBackfillQuery(db, bucket.Id, filter).Count(&total).Error; err != nil {
+		return BucketBackfillStatus{}, err
+	}
+
+	bucketBackfillMu.Lock()
+	defer bucketBackfillMu.Unlock()
+	if job := bucketBackfillJobs[bucket.Id]; job != nil && job.status.Running {
+		return job.status, ErrBucketBackfillRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	job := &bucketBackfillJob{
+		status: BucketBackfillStatus{
+			BucketID:  bucket.Id,
+			Filter:    filter,
+			Running:   true,
+			Total:     total,
+			Skipped:   matched - total,
+			StartedAt: &now,
+		},
+		cancel: cancel,
+	}
+	bucketBackfillJobs[bucket.Id] = job
+	go runBucketBackfill(ctx, job, bucket, filter)
+	return job.status, nil
+}
+
+// CancelBucketBackfill stops the running job of a bucket after the current
+// batch. Replicas that were already enqueued stay in the queue.
+func CancelBucketBackfill(bucketID int) bool {
+	bucketBackfillMu.Lock()
+	defer bucketBackfillMu.Unlock()
+	job := bucketBackfillJobs[bucketID]
+	if job == nil || !job.status.Running {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// GetBucketBackfillStatus returns a snapshot of the latest job of a bucket.
+func GetBucketBackfillStatus(bucketID int) (BucketBackfillStatus, bool) {
+	bucketBackfillMu.Lock()
+	defer bucketBackfillMu.Unlock()
+	job := bucketBackfillJobs[bucketID]
+	if job == nil {
+		return BucketBackfillStatus{}, false
+	}
+	return job.status, true
+}
+
+func runBucketBackfill(ctx context.Context, job *bucketBackfillJob, bucket models.Buckets, filter BucketBackfillFilter) {
+	defer func() {
+		bucketBackfillMu.Lock()
+		now := time.Now()
+		job.status.Running = false
+		job.status.Canceled = ctx.Err() != nil
+		job.status.FinishedAt = &now
+		job.cancel()
+		bucketBackfillMu.Unlock()
+	}()
+
+	db := database.GetDB().DB
+	lastID := 0
+	for ctx.Err() == nil {
+		var batch []models.Image
+		if err := bucketBackfillQuery(db, bucket.Id, filter).
+			Select("images.id", "images.url", "images.thumbnail", "images.file_size").
+			Where("images.id > ?", lastID).
+			Order("images.id ASC").
+			Limit(bucketBackfillBatchSize).
+			Find(&batch).Error; err != nil {
+			log.Printf("[storage-sync] backfill bucket %d: load images after %d: %v", bucket.Id, lastID, err)
+			recordBucketBackfillBatch(job, 0, 0, err)
+			return
+		}
+		if len(batch) == 0 {
+			return
+		}
+		lastID = batch[len(batch)-1].Id
+
+		replicas := make([]models.ImageStorage, 0, len(batch))
+		for _, image := range batch {
+			replicas = append(replicas, models.ImageStorage{
+				ImageID:   image.Id,
+				BucketID:  bucket.Id,
+				Storage:   bucket.Type,
+				Status:    models.ImageStorageStatusPending,
+				URL:       image.Url,
+				Thumbnail: image.Thumbnail,
+				FileSize:  image.FileSize,
+			})
+		}
+		// An upload may have created the replica since the batch was loaded.
+		created := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&replicas)
+		if created.Error != nil {
+			err := fmt.Errorf("enqueue images %d-%d: %w", batch[0].Id, lastID, created.Error)
+			log.Printf("[storage-sync] backfill bucket %d: %v", bucket.Id, err)
+			recordBucketBackfillBatch(job, int64(len(batch)), 0, err)
+			return
+		}
+		recordBucketBackfillBatch(job, int64(len(batch)), created.RowsAffected, nil)
+		WakeStorageSyncWorker()
+	}
+}
+
+func recordBucketBackfillBatch(job *bucketBackfillJob, processed, enqueued int64, err error) {
+	bucketBackfillMu.Lock()
+	defer bucketBackfillMu.Unlock()
+	job.status.Processed += processed
+	job.status.Enqueued += enqueued
+	if err != nil {
+		job.status.LastError = err.Error()
+	}
+}
+
+// bucketBackfillQuery selects images matching filter without a replica in
+// the target bucket that the sync worker has a source replica for.
+func bucketBackfillQuery(db *gorm.DB, bucketID int, filter BucketBackfillFilter) *gorm.DB {
+	condition, args := storageSyncSourceCondition()
+	return bucketBackfillCandidates(db, bucketID, filter).Where(condition, args...)
+}
+
+// bucketBackfillCandidates selects images matching filter without a replica
+// in the target bucket.
+func bucketBackfillCandidates(db *gorm.DB, bucketID int, filter BucketBackfillFilter) *gorm.DB {
+	query := db.Model(&models.Image{}).Where(
+		"NOT EXISTS (SELECT 1 FROM image_storages WHERE image_storages.image_id = images.id AND image_storages.bucket_id = ?)",
+		bucketID,
+	)
+	if filter.StartDate != nil {
+		query = query.Where("images.created_at >= ?", *filter.StartDate)
+	}
+	if filter.EndDate != nil {
+		query = query.Where("images.created_at < ?", *filter.EndDate)
+	}
+	if len(filter.TagIDs) > 0 {
+		query = query.Where("EXISTS (SELECT 1 FROM image_to_tags WHERE image_to_tags.image_id = images.id AND image_to_tags.tag_id IN ?)", filter.TagIDs)
+	}
+	if filter.UserID > 0 {
+		query = query.Where("images.user_id = ?", filter.UserID)
+	}
+	if filter.SourceBucketID > 0 {
+		query = query.Where(
+			"EXISTS (SELECT 1 FROM image_storages WHERE image_storages.image_id = images.id AND image_storages.bucket_id = ? AND image_storages.status = ?)",
+			filter.SourceBucketID, models.ImageStorageStatusSuccess,
+		)
+	}
+	return query
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestBucketBackfillEnqueuesMatchingImages(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+	backup := models.Buckets{Id: 2, Name: "offsite", Type: "s3", Config: map[string]any{}}
+	buckets := []models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}},
+		backup,
+		{Id: 3, Name: "cold", Type: "ftp", Config: map[string]any{}},
+	}
+	if err := db.Create(&buckets).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+
+	old := time.Now().AddDate(0, -2, 0)
+	imageList := []models.Image{
+		{Url: "/uploads/a.webp", FileName: "a.webp", FileSize: 10, UserId: 1, CreatedAt: old},
+		{Url: "/uploads/b.webp", FileName: "b.webp", FileSize: 11, UserId: 1},
+		{Url: "/uploads/c.webp", FileName: "c.webp", FileSize: 12, UserId: 2},
+		{Url: "/uploads/d.webp", FileName: "d.webp", FileSize: 13, UserId: 1},
+		{Url: "/uploads/e.webp", FileName: "e.webp", FileSize: 14, UserId: 1},
+		{Url: "/uploads/f.webp", FileName: "f.webp", FileSize: 15, UserId: 1},
+		{Url: "/uploads/g.webp", FileName: "g.webp", FileSize: 16, UserId: 1},
+	}
+	if err := db.Create(&imageList).Error; err != nil {
+		t.Fatalf("create images: %v", err)
+	}
+	for _, image := range imageList[:4] {
+		if err := db.Create(&models.ImageStorage{ImageID: image.Id, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess}).Error; err != nil {
+			t.Fatalf("create local replica: %v", err)
+		}
+	}
+	// e, f and g were tiered off the local disk. Only e has a verified cold
+	// replica of the original the worker can copy from.
+	coldReplicas := []models.ImageStorage{
+		{ImageID: imageList[4].Id, BucketID: 3, Storage: "ftp", Status: models.ImageStorageStatusSuccess, Checksum: "e-sum"},
+		{ImageID: imageList[5].Id, BucketID: 3, Storage: "ftp", Status: models.ImageStorageStatusSuccess},
+		{ImageID: imageList[6].Id, BucketID: 3, Storage: "ftp", Status: models.ImageStorageStatusSuccess, Checksum: "g-sum", Processed: true},
+	}
+	if err := db.Create(&coldReplicas).Error; err != nil {
+		t.Fatalf("create cold replicas: %v", err)
+	}
+	// d already has a replica in the target bucket.
+	if err := db.Create(&models.ImageStorage{ImageID: imageList[3].Id, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusSuccess}).Error; err != nil {
+		t.Fatalf("create existing replica: %v", err)
+	}
+
+	since := time.Now().AddDate(0, -1, 0)
+	status, err := StartBucketBackfill(backup, BucketBackfillFilter{StartDate: &since, UserID: 1})
+	if err != nil {
+		t.Fatalf("start backfill: %v", err)
+	}
+	if status.Total != 2 || status.Skipped != 2 {
+		t.Fatalf("expected two matching images, got %+v", status)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for status.Running && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		status, _ = GetBucketBackfillStatus(backup.Id)
+	}
+	if status.Running || status.Processed != 2 || status.Enqueued != 2 || status.LastError != "" {
+		t.Fatalf("unexpected final status: %+v", status)
+	}
+
+	var replicas []models.ImageStorage
+	if err := db.Where("bucket_id = ?", 2).Order("image_id ASC").Find(&replicas).Error; err != nil {
+		t.Fatalf("query replicas: %v", err)
+	}
+	if len(replicas) != 3 || replicas[0].ImageID != imageList[1].Id || replicas[0].Status != models.ImageStorageStatusPending ||
+		replicas[0].URL != "/uploads/b.webp" || replicas[0].FileSize != 11 ||
+		replicas[2].ImageID != imageList[4].Id || replicas[2].Status != models.ImageStorageStatusPending {
+		t.Fatalf("unexpected replicas: %+v", replicas)
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	storageSettings "oneimg/backend/utils/settings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	bucketHealthTick         = time.Minute
+	bucketHealthProbeTimeout = 25 * time.Second
+	bucketHealthWorkers      = 4
+	// bucketHealthHistoryRetention is how long check results are kept.
+	bucketHealthHistoryRetention = 7 * 24 * time.Hour
+	defaultBucketHealthThreshold = 3
+)
+
+var bucketHealthStartOnce sync.Once
+
+// StartBucketHealthMonitor checks every enabled remote bucket at the
+// configured interval.
+func StartBucketHealthMonitor() {
+	bucketHealthStartOnce.Do(func() {
+		go func() {
+			var lastRun time.Time
+			ticker := time.NewTicker(bucketHealthTick)
+			defer ticker.Stop()
+			for range ticker.C {
+				interval, ok := loadBucketHealthInterval()
+				if !ok || time.Since(lastRun) < interval {
+					continue
+				}
+				lastRun = time.Now()
+				if err := CheckAllBucketHealth(context.Background()); err != nil {
+					log.Printf("[bucket-health] check failed: %v", err)
+				}
+			}
+		}()
+	})
+}
+
+func loadBucketHealthInterval() (time.Duration, bool) {
+	setting, err := storageSettings.GetSettings()
+	if err != nil {
+		log.Printf("[bucket-health] failed to load settings: %v", err)
+		return 0, false
+	}
+	if setting.BucketHealthInterval <= 0 {
+		return 0, false
+	}
+	return time.Duration(setting.BucketHealthInterval) * time.Minute, true
+}
+
+func loadBucketHealthThreshold() int {
+	setting, err := storageSettings.GetSettings()
+	if err != nil || setting.BucketHealthThreshold <= 0 {
+		return defaultBucketHealthThreshold
+	}
+	return setting.BucketHealthThreshold
+}
+
+// CheckAllBucketHealth probes every enabled remote bucket and prunes old
+// history. The local bucket is the access fallback and is never checked.
+func CheckAllBucketHealth(ctx context.Context) error {
+	db := database.GetDB()
+	if db == nil || db.DB == nil {
+		return errors.New("database is not initialized")
+	}
+	var bucketList []models.Buckets
+	if err := db.DB.Where("type <> ? AND disabled = ?", "default", false).Order("id ASC").Find(&bucketList).Error; err != nil {
+		return err
+	}
+
+	slots := make(chan struct{}, bucketHealthWorkers)
+	var wg sync.WaitGroup
+	for _, bucket := range bucketList {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(bucket models.Buckets) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if _, err := CheckBucketHealth(ctx, bucket); err != nil {
+				log.Printf("[bucket-health] failed to record check of bucket %d: %v", bucket.Id, err)
+			}
+		}(bucket)
+	}
+	wg.Wait()
+
+	return db.DB.Where("checked_at < ?", time.Now().Add(-bucketHealthHistoryRetention)).
+		Delete(&models.BucketHealthCheck{}).Error
+}
+
+// CheckBucketHealth probes one bucket, records the result and updates the
+// bucket's health state. A bucket becomes unhealthy after the configured
+// number of consecutive failures and healthy again after one success.
+func CheckBucketHealth(ctx context.Context, bucket models.Buckets) (models.BucketHealthCheck, error) {
+	probeContext, cancel := context.WithTimeout(ctx, bucketHealthProbeTimeout)
+	started := time.Now()
+	_, probeErr := ProbeBucket(probeContext, bucket)
+	cancel()
+
+	check := models.BucketHealthCheck{
+		BucketID:  bucket.Id,
+		OK:        probeErr == nil,
+		LatencyMs: time.Since(started).Milliseconds(),
+		CheckedAt: time.Now(),
+	}
+	if probeErr != nil {
+		check.Error = SanitizeBucketError(probeErr, bucket.Config)
+	}
+	return check, recordBucketHealth(database.GetDB().DB, check, loadBucketHealthThreshold())
+}
+
+func recordBucketHealth(db *gorm.DB, check models.BucketHealthCheck, threshold int) error {
+	var before, after models.Buckets
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&check).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&before, check.BucketID).Error; err != nil {
+			return err
+		}
+		after = before
+		after.HealthCheckedAt = &check.CheckedAt
+		if check.OK {
+			after.HealthFailures = 0
+			after.Unhealthy = false
+			after.HealthError = ""
+		} else {
+			after.HealthFailures++
+			after.Unhealthy = before.Unhealthy || after.HealthFailures >= threshold
+			after.HealthError = check.Error
+		}
+		return tx.Model(&models.Buckets{}).Where("id = ?", check.BucketID).Updates(map[string]any{
+			"unhealthy":         after.Unhealthy,
+			"health_failures":   after.HealthFailures,
+			"health_checked_at": after.HealthCheckedAt,
+			"health_error":      after.HealthError,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case after.Unhealthy && !before.Unhealthy:
+		log.Printf("[bucket-health] bucket %d marked unhealthy after %d failed checks: %s", check.BucketID, after.HealthFailures, check.Error)
+	case !after.Unhealthy && before.Unhealthy:
+		log.Printf("[bucket-health] bucket %d recovered", check.BucketID)
+	}
+	return nil
+}
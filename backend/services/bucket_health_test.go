@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestBucketHealthMarksUnhealthyAfterThresholdAndRecovers(t *testing.T) {
+	initStorageSyncTestDB(t)
+	var failing atomic.Bool
+	failing.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{BucketHealthThreshold: 2}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	bucket := models.Buckets{Id: 2, Name: "dav", Type: "webdav", Config: map[string]any{"webdav_url": server.URL, "webdav_pass": "dav-secret"}}
+	if err := db.Create(&bucket).Error; err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+
+	reload := func() models.Buckets {
+		t.Helper()
+		var current models.Buckets
+		if err := db.First(&current, bucket.Id).Error; err != nil {
+			t.Fatalf("reload bucket: %v", err)
+		}
+		return current
+	}
+
+	check, err := CheckBucketHealth(context.Background(), bucket)
+	if err != nil {
+		t.Fatalf("first check: %v", err)
+	}
+	if check.OK || check.Error == "" {
+		t.Fatalf("expected a failed check, got %+v", check)
+	}
+	if current := reload(); current.Unhealthy || current.HealthFailures != 1 {
+		t.Fatalf("one failure must not mark the bucket unhealthy: %+v", current)
+	}
+
+	if _, err := CheckBucketHealth(context.Background(), bucket); err != nil {
+		t.Fatalf("second check: %v", err)
+	}
+	if current := reload(); !current.Unhealthy || current.HealthFailures != 2 || current.HealthCheckedAt == nil {
+		t.Fatalf("expected unhealthy bucket after two failures: %+v", current)
+	}
+
+	failing.Store(false)
+	check, err = CheckBucketHealth(context.Background(), bucket)
+	if err != nil {
+		t.Fatalf("recovery check: %v", err)
+	}
+	if !check.OK {
+		t.Fatalf("expected a successful check, got %+v", check)
+	}
+	if current := reload(); current.Unhealthy || current.HealthFailures != 0 || current.HealthError != "" {
+		t.Fatalf("expected bucket to recover: %+v", current)
+	}
+
+	var history int64
+	db.Model(&models.BucketHealthCheck{}).Where("bucket_id = ?", bucket.Id).Count(&history)
+	if history != 3 {
+		t.Fatalf("expected three history rows, got %d", history)
+	}
+}
@@ -0,0 +1,650 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	storageSettings "oneimg/backend/utils/settings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const bucketMigrationBatchSize = 100
+
+var (
+	ErrBucketMigrationConflict   = errors.New("another migration is running for one of these buckets")
+	ErrBucketMigrationNotRunning = errors.New("migration is not running")
+	ErrBucketMigrationFinished   = errors.New("migration has already completed")
+
+	bucketMigrationMu      sync.Mutex
+	bucketMigrationRunners = make(map[int]bool)
+)
+
+// BucketMigrationPlan is the dry-run result of a migration.
+type BucketMigrationPlan struct {
+	SourceBucketID     int   `json:"source_bucket_id"`
+	TargetBucketID     int   `json:"target_bucket_id"`
+	Images             int64 `json:"images"`
+	AlreadyInTarget    int64 `json:"already_in_target"`
+	ToCopy             int64 `json:"to_copy"`
+	BytesToCopy        int64 `json:"bytes_to_copy"`
+	ArchivedOriginals  int64 `json:"archived_originals"`
+	AccessSourceImages int64 `json:"access_source_images"`
+	UploadBucketImages int64 `json:"upload_bucket_images"`
+	CapacitySufficient bool  `json:"capacity_sufficient"`
+}
+
+type bucketMigrationStep int
+
+const (
+	bucketMigrationContinue bucketMigrationStep = iota
+	bucketMigrationWait
+	bucketMigrationDone
+)
+
+// ValidateBucketMigration checks that replicas can be moved from source to
+// target. The local bucket cannot be a source because it holds the canonical
+// files.
+func ValidateBucketMigration(source, target models.Buckets) error {
+	if source.Id == target.Id {
+		return errors.New("source and target bucket must differ")
+	}
+	if source.Type == "default" {
+		return errors.New("the local bucket cannot be migrated away")
+	}
+	if target.Disabled {
+		return errors.New("target bucket is disabled")
+	}
+	return nil
+}
+
+// PlanBucketMigration reports what a migration from source to target would
+// do without changing anything.
+func PlanBucketMigration(source, target models.Buckets) (BucketMigrationPlan, error) {
+	db := database.GetDB().DB
+	plan := BucketMigrationPlan{SourceBucketID: source.Id, TargetBucketID: target.Id}
+
+	if err := db.Model(&models.ImageStorage{}).Where("bucket_id = ?", source.Id).Count(&plan.Images).Error; err != nil {
+		return plan, err
+	}
+	if err := migratedTargetReplicas(db, source.Id, target.Id).
+		Where("status = ?", models.ImageStorageStatusSuccess).
+		Count(&plan.AlreadyInTarget).Error; err != nil {
+		return plan, err
+	}
+	plan.ToCopy = plan.Images - plan.AlreadyInTarget
+
+	if err := db.Model(&models.ImageStorage{}).
+		Select("COALESCE(SUM(file_size + thumbnail_size), 0)").
+		Where("bucket_id = ?", source.Id).
+		Where("NOT EXISTS (SELECT 1 FROM image_storages target WHERE target.image_id = image_storages.image_id AND target.bucket_id = ? AND target.status = ?)",
+			target.Id, models.ImageStorageStatusSuccess).
+		Scan(&plan.BytesToCopy).Error; err != nil {
+		return plan, err
+	}
+	var originalBytes int64
+	if err := db.Model(&models.ImageOriginal{}).Where("bucket_id = ?", source.Id).Count(&plan.ArchivedOriginals).Error; err != nil {
+		return plan, err
+	}
+	if err := db.Model(&models.ImageOriginal{}).Select("COALESCE(SUM(file_size), 0)").
+		Where("bucket_id = ?", source.Id).Scan(&originalBytes).Error; err != nil {
+		return plan, err
+	}
+	plan.BytesToCopy += originalBytes
+	if err := db.Unscoped().Model(&models.Image{}).Where("access_bucket_id = ?", source.Id).Count(&plan.AccessSourceImages).Error; err != nil {
+		return plan, err
+	}
+	if err := db.Unscoped().Model(&models.Image{}).Where("bucket_id = ?", source.Id).Count(&plan.UploadBucketImages).Error; err != nil {
+		return plan, err
+	}
+	plan.CapacitySufficient = checkStorageCapacity(target, plan.BytesToCopy) == nil
+	return plan, nil
+}
+
+// StartBucketMigration creates a persistent migration and starts running it.
+// Only one running migration may involve a given bucket.
+func StartBucketMigration(source, target models.Buckets, rewriteBucketID bool) (models.BucketMigration, error) {
+	if err := ValidateBucketMigration(source, target); err != nil {
+		return models.BucketMigration{}, err
+	}
+	db := database.GetDB().DB
+	var conflicts int64
+	if err := db.Model(&models.BucketMigration{}).
+		Where("status = ? AND (source_bucket_id IN ? OR target_bucket_id IN ?)",
+			models.BucketMigrationStatusRunning, []int{source.Id, target.Id}, []int{source.Id, target.Id}).
+		Count(&conflicts).Error; err != nil {
+		return models.BucketMigration{}, err
+	}
+	if conflicts > 0 {
+		return models.BucketMigration{}, ErrBucketMigrationConflict
+	}
+
+	var total int64
+	if err := db.Model(&models.ImageStorage{}).Where("bucket_id = ?", source.Id).Count(&total).Error; err != nil {
+		return models.BucketMigration{}, err
+	}
+	now := time.Now()
+	migration := models.BucketMigration{
+		SourceBucketID:  source.Id,
+		TargetBucketID:  target.Id,
+		RewriteBucketID: rewriteBucketID,
+		Status:          models.BucketMigrationStatusRunning,
+		Phase:           models.BucketMigrationPhaseCopying,
+		Total:           total,
+		StartedAt:       &now,
+	}
+	if err := db.Create(&migration).Error; err != nil {
+		return models.BucketMigration{}, err
+	}
+	launchBucketMigration(migration.ID)
+	return migration, nil
+}
+
+// ResumeBucketMigration continues a failed or canceled migration from the
+// phase it stopped in.
+func ResumeBucketMigration(id int) (models.BucketMigration, error) {
+	db := database.GetDB().DB
+	var migration models.BucketMigration
+	if err := db.First(&migration, id).Error; err != nil {
+		return migration, err
+	}
+	if migration.Status == models.BucketMigrationStatusCompleted {
+		return migration, ErrBucketMigrationFinished
+	}
+	if migration.Status != models.BucketMigrationStatusRunning {
+		var conflicts int64
+		if err := db.Model(&models.BucketMigration{}).
+			Where("id <> ? AND status = ? AND (source_bucket_id IN ? OR target_bucket_id IN ?)", migration.ID,
+				models.BucketMigrationStatusRunning,
+				[]int{migration.SourceBucketID, migration.TargetBucketID}, []int{migration.SourceBucketID, migration.TargetBucketID}).
+			Count(&conflicts).Error; err != nil {
+			return migration, err
+		}
+		if conflicts > 0 {
+			return migration, ErrBucketMigrationConflict
+		}
+		if err := db.Model(&migration).Updates(map[string]any{
+			"status":      models.BucketMigrationStatusRunning,
+			"error":       "",
+			"finished_at": nil,
+		}).Error; err != nil {
+			return migration, err
+		}
+		migration.Status = models.BucketMigrationStatusRunning
+		migration.Error = ""
+		migration.FinishedAt = nil
+	}
+	launchBucketMigration(migration.ID)
+	return migration, nil
+}
+
+// CancelBucketMigration stops a running migration after its current batch.
+// Replicas already copied to the target are kept.
+func CancelBucketMigration(id int) error {
+	now := time.Now()
+	result := database.GetDB().DB.Model(&models.BucketMigration{}).
+		Where("id = ? AND status = ?", id, models.BucketMigrationStatusRunning).
+		Updates(map[string]any{"status": models.BucketMigrationStatusCanceled, "finished_at": &now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrBucketMigrationNotRunning
+	}
+	return nil
+}
+
+// StartBucketMigrationWorker resumes migrations that were running when the
+// process stopped.
+func StartBucketMigrationWorker() {
+	db := database.GetDB()
+	if db == nil || db.DB == nil {
+		return
+	}
+	var ids []int
+	if err := db.DB.Model(&models.BucketMigration{}).
+		Where("status = ?", models.BucketMigrationStatusRunning).
+		Pluck("id", &ids).Error; err != nil {
+		log.Printf("[bucket-migration] failed to load running migrations: %v", err)
+		return
+	}
+	for _, id := range ids {
+		log.Printf("[bucket-migration] resuming migration %d", id)
+		launchBucketMigration(id)
+	}
+}
+
+func launchBucketMigration(id int) {
+	bucketMigrationMu.Lock()
+	defer bucketMigrationMu.Unlock()
+	if bucketMigrationRunners[id] {
+		return
+	}
+	bucketMigrationRunners[id] = true
+	go runBucketMigration(id)
+}
+
+func runBucketMigration(id int) {
+	defer func() {
+		bucketMigrationMu.Lock()
+		delete(bucketMigrationRunners, id)
+		bucketMigrationMu.Unlock()
+	}()
+
+	db := database.GetDB().DB
+	for {
+		var migration models.BucketMigration
+		if err := db.First(&migration, id).Error; err != nil {
+			log.Printf("[bucket-migration] failed to load migration %d: %v", id, err)
+			return
+		}
+		if migration.Status != models.BucketMigrationStatusRunning {
+			return
+		}
+
+		step, err := advanceBucketMigration(db, &migration)
+		if err != nil {
+			log.Printf("[bucket-migration] migration %d failed in phase %s: %v", id, migration.Phase, err)
+			now := time.Now()
+			_ = db.Model(&models.BucketMigration{}).
+				Where("id = ? AND status = ?", id, models.BucketMigrationStatusRunning).
+				Updates(map[string]any{
+					"status":      models.BucketMigrationStatusFailed,
+					"error":       err.Error(),
+					"finished_at": &now,
+				}).Error
+			return
+		}
+		switch step {
+		case bucketMigrationDone:
+			log.Printf("[bucket-migration] migration %d completed", id)
+			return
+		case bucketMigrationWait:
+			time.Sleep(storageSyncPollInterval)
+		}
+	}
+}
+
+// advanceBucketMigration runs one unit of work of the current phase and
+// persists the progress. Every phase is idempotent so a resumed migration
+// can safely repeat the unit it was interrupted in.
+func advanceBucketMigration(db *gorm.DB, migration *models.BucketMigration) (bucketMigrationStep, error) {
+	var source, target models.Buckets
+	if err := db.First(&source, migration.SourceBucketID).Error; err != nil {
+		return 0, fmt.Errorf("load source bucket %d: %w", migration.SourceBucketID, err)
+	}
+	if err := db.First(&target, migration.TargetBucketID).Error; err != nil {
+		return 0, fmt.Errorf("load target bucket %d: %w", migration.TargetBucketID, err)
+	}
+	if err := ValidateBucketMigration(source, target); err != nil {
+		return 0, err
+	}
+
+	switch migration.Phase {
+	case models.BucketMigrationPhaseCopying:
+		return copyBucketMigration(db, migration, source, target)
+	case models.BucketMigrationPhaseVerifying:
+		return verifyBucketMigration(db, migration, source, target)
+	case models.BucketMigrationPhaseSwitching:
+		return switchBucketMigration(db, migration, source, target)
+	case models.BucketMigrationPhaseDeleting:
+		return deleteBucketMigrationSource(db, migration, source, target)
+	default:
+		return 0, fmt.Errorf("unknown migration phase %q", migration.Phase)
+	}
+}
+
+// copyBucketMigration enqueues target replicas for every image in the source
+// bucket and waits for the storage sync worker to upload them.
+func copyBucketMigration(db *gorm.DB, migration *models.BucketMigration, source, target models.Buckets) (bucketMigrationStep, error) {
+	setting, err := storageSettings.GetSettings()
+	if err != nil {
+		return 0, fmt.Errorf("load settings: %w", err)
+	}
+	if !setting.MultiStorageSync {
+		return 0, errors.New("multi-storage synchronization is disabled; enable it to copy replicas")
+	}
+
+	enqueued := false
+	lastID := 0
+	for {
+		var batch []models.Image
+		// Trashed images move too, otherwise they could not be restored once
+		// the source bucket is gone.
+		if err := bucketBackfillCandidates(db.Unscoped(), target.Id, BucketBackfillFilter{}).
+			Select("images.id", "images.url", "images.thumbnail", "images.file_size").
+			Where("EXISTS (SELECT 1 FROM image_storages source WHERE source.image_id = images.id AND source.bucket_id = ?)", source.Id).
+			Where("images.id > ?", lastID).
+			Order("images.id ASC").
+			Limit(bucketMigrationBatchSize).
+			Find(&batch).Error; err != nil {
+			return 0, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		lastID = batch[len(batch)-1].Id
+		replicas := make([]models.ImageStorage, 0, len(batch))
+		for _, image := range batch {
+			replicas = append(replicas, models.ImageStorage{
+				ImageID:   image.Id,
+				BucketID:  target.Id,
+				Storage:   target.Type,
+				Status:    models.ImageStorageStatusPending,
+				URL:       image.Url,
+				Thumbnail: image.Thumbnail,
+				FileSize:  image.FileSize,
+			})
+		}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&replicas).Error; err != nil {
+			return 0, err
+		}
+		enqueued = true
+	}
+	if enqueued {
+		WakeStorageSyncWorker()
+	}
+	originals, err := moveBucketMigrationOriginals(db, source, target)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	if err := db.Model(&models.ImageStorage{}).Where("bucket_id = ?", source.Id).Count(&total).Error; err != nil {
+		return 0, err
+	}
+	var counts []struct {
+		Status string
+		Count  int64
+	}
+	if err := migratedTargetReplicas(db, source.Id, target.Id).
+		Select("status, COUNT(*) AS count").Group("status").Scan(&counts).Error; err != nil {
+		return 0, err
+	}
+	var copied, failed int64
+	for _, row := range counts {
+		switch row.Status {
+		case models.ImageStorageStatusSuccess:
+			copied = row.Count
+		case models.ImageStorageStatusFailed, models.ImageStorageStatusDeadLetter:
+			failed += row.Count
+		}
+	}
+	updates := map[string]any{"total": total, "copied": copied}
+	if failed == 0 && copied >= total && originals == 0 {
+		updates["phase"] = models.BucketMigrationPhaseVerifying
+		updates["verified"] = 0
+		updates["last_image_id"] = 0
+	}
+	if err := db.Model(migration).Updates(updates).Error; err != nil {
+		return 0, err
+	}
+	if failed > 0 {
+		return 0, fmt.Errorf("%d replica(s) could not be copied to bucket %d; retry them and resume the migration", failed, target.Id)
+	}
+	if copied >= total && originals == 0 {
+		return bucketMigrationContinue, nil
+	}
+	return bucketMigrationWait, nil
+}
+
+// moveBucketMigrationOriginals hands the archived originals stored in the
+// source bucket to the original archive worker for the target bucket.
+// Uploaded originals are downloaded back to their local staging path first
+// and the source object is queued for deletion. It returns how many originals
+// are still in the source, such as those the archive worker is uploading.
+func moveBucketMigrationOriginals(db *gorm.DB, source, target models.Buckets) (int64, error) {
+	var records []models.ImageOriginal
+	if err := db.Where("bucket_id = ? AND status <> ?", source.Id, models.ImageStorageStatusUploading).
+		Order("id ASC").Find(&records).Error; err != nil {
+		return 0, err
+	}
+	moved := false
+	for _, record := range records {
+		ok, err := moveBucketMigrationOriginal(db, record, source, target)
+		if err != nil {
+			return 0, fmt.Errorf("move archived original %d: %w", record.ID, err)
+		}
+		moved = moved || ok
+	}
+	if moved {
+		WakeOriginalArchiveWorker()
+		WakeReplicaDeletionWorker()
+	}
+
+	var remaining int64
+	err := db.Model(&models.ImageOriginal{}).Where("bucket_id = ?", source.Id).Count(&remaining).Error
+	return remaining, err
+}
+
+func moveBucketMigrationOriginal(db *gorm.DB, record models.ImageOriginal, source, target models.Buckets) (bool, error) {
+	unlock := lockImageOperations(record.ImageID)
+	defer unlock()
+	if err := db.First(&record, record.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if record.BucketID != source.Id || record.Status == models.ImageStorageStatusUploading {
+		return false, nil
+	}
+
+	uploaded := record.Status == models.ImageStorageStatusSuccess
+	if uploaded {
+		localPath, err := canonicalLocalPath(record.Path)
+		if err != nil {
+			return false, err
+		}
+		if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+			return false, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		tempPath, size, err := downloadReplicaObject(ctx, source, record.Path, filepath.Dir(localPath), "")
+		cancel()
+		if err != nil {
+			return false, err
+		}
+		if size != record.FileSize {
+			os.Remove(tempPath)
+			return false, fmt.Errorf("%s has %d bytes, expected %d", record.Path, size, record.FileSize)
+		}
+		if err := os.Rename(tempPath, localPath); err != nil {
+			os.Remove(tempPath)
+			return false, err
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if uploaded {
+			task := models.ReplicaDeletion{
+				ImageID:  record.ImageID,
+				BucketID: source.Id,
+				Storage:  source.Type,
+				Kind:     models.ReplicaDeletionKindOriginal,
+				Status:   models.ImageStorageStatusPending,
+				URL:      record.Path,
+				FileName: record.FileName,
+				Metadata: record.Metadata,
+			}
+			if err := tx.Create(&task).Error; err != nil {
+				return err
+			}
+			if err := ReleaseBucketUsage(tx, source.Id, record.FileSize); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.ImageOriginal{}).Where("id = ? AND bucket_id = ?", record.ID, source.Id).
+			Updates(map[string]any{
+				"bucket_id":     target.Id,
+				"storage":       target.Type,
+				"status":        models.ImageStorageStatusPending,
+				"error":         "",
+				"retry_count":   0,
+				"metadata":      nil,
+				"next_retry_at": nil,
+				"archived_at":   nil,
+			}).Error
+	})
+	return err == nil, err
+}
+
+// verifyBucketMigration checks one batch of copied replicas in the target.
+// Copies that fail verification are queued for upload again and the
+// migration returns to the copying phase.
+func verifyBucketMigration(db *gorm.DB, migration *models.BucketMigration, source, target models.Buckets) (bucketMigrationStep, error) {
+	var batch []models.ImageStorage
+	if err := migratedTargetReplicas(db, source.Id, target.Id).
+		Where("status = ? AND image_id > ?", models.ImageStorageStatusSuccess, migration.LastImageID).
+		Order("image_id ASC").
+		Limit(bucketMigrationBatchSize).
+		Find(&batch).Error; err != nil {
+		return 0, err
+	}
+	if len(batch) == 0 {
+		return bucketMigrationContinue, db.Model(migration).Updates(map[string]any{
+			"phase":         models.BucketMigrationPhaseSwitching,
+			"last_image_id": 0,
+		}).Error
+	}
+
+	policy := loadStorageRetryPolicy()
+	var verified, rejected int64
+	for _, replica := range batch {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := verifyRemoteReplica(ctx, target, replica)
+		cancel()
+		if err == nil {
+			verified++
+			continue
+		}
+		rejected++
+		log.Printf("[bucket-migration] replica %d failed verification: %v", replica.ID, err)
+		if err := requeueUnverifiedReplica(db, target, replica, policy, err); err != nil {
+			return 0, err
+		}
+	}
+
+	updates := map[string]any{
+		"verified":      gorm.Expr("verified + ?", verified),
+		"last_image_id": batch[len(batch)-1].ImageID,
+	}
+	if rejected > 0 {
+		updates["phase"] = models.BucketMigrationPhaseCopying
+		updates["verified"] = 0
+		updates["last_image_id"] = 0
+	}
+	return bucketMigrationContinue, db.Model(migration).Updates(updates).Error
+}
+
+// requeueUnverifiedReplica releases the usage of a copy that failed
+// verification and schedules it for another upload. Repeated failures use the
+// normal retry budget and end up dead-lettered.
+func requeueUnverifiedReplica(db *gorm.DB, bucket models.Buckets, replica models.ImageStorage, policy storageRetryPolicy, verifyErr error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		attempts := replica.RetryCount + 1
+		status, nextRetryAt := policy.next(attempts)
+		result := tx.Model(&models.ImageStorage{}).
+			Where("id = ? AND status = ?", replica.ID, models.ImageStorageStatusSuccess).
+			Updates(map[string]any{
+				"status":        status,
+				"error":         "verification failed: " + verifyErr.Error(),
+				"retry_count":   attempts,
+				"next_retry_at": nextRetryAt,
+				"synced_at":     nil,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if bucket.Type == "default" {
+			return nil
+		}
+		return ReleaseBucketUsage(tx, bucket.Id, replica.FileSize+replica.ThumbnailSize)
+	})
+}
+
+// switchBucketMigration points access (and optionally the upload bucket) of
+// every image with a verified target copy at the target bucket.
+func switchBucketMigration(db *gorm.DB, migration *models.BucketMigration, source, target models.Buckets) (bucketMigrationStep, error) {
+	copiedToTarget := "EXISTS (SELECT 1 FROM image_storages WHERE image_storages.image_id = images.id AND image_storages.bucket_id = ? AND image_storages.status = ?)"
+	var switched int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		access := tx.Unscoped().Model(&models.Image{}).
+			Where("access_bucket_id = ?", source.Id).
+			Where(copiedToTarget, target.Id, models.ImageStorageStatusSuccess).
+			Update("access_bucket_id", target.Id)
+		if access.Error != nil {
+			return access.Error
+		}
+		switched += access.RowsAffected
+
+		if migration.RewriteBucketID {
+			upload := tx.Unscoped().Model(&models.Image{}).
+				Where("bucket_id = ?", source.Id).
+				Where(copiedToTarget, target.Id, models.ImageStorageStatusSuccess).
+				Updates(map[string]any{"bucket_id": target.Id, "storage": target.Type})
+			if upload.Error != nil {
+				return upload.Error
+			}
+			switched += upload.RowsAffected
+		}
+		return tx.Model(migration).Updates(map[string]any{
+			"switched": gorm.Expr("switched + ?", switched),
+			"phase":    models.BucketMigrationPhaseDeleting,
+		}).Error
+	})
+	return bucketMigrationContinue, err
+}
+
+// deleteBucketMigrationSource removes the source replicas once every image in
+// the source bucket has a successful copy in the target and no archived
+// original is left there. Images uploaded or originals archived to the source
+// in the meantime send the migration back to the copying phase.
+func deleteBucketMigrationSource(db *gorm.DB, migration *models.BucketMigration, source, target models.Buckets) (bucketMigrationStep, error) {
+	var uncovered int64
+	if err := db.Model(&models.ImageStorage{}).
+		Where("bucket_id = ?", source.Id).
+		Where("NOT EXISTS (SELECT 1 FROM image_storages target WHERE target.image_id = image_storages.image_id AND target.bucket_id = ? AND target.status = ?)",
+			target.Id, models.ImageStorageStatusSuccess).
+		Count(&uncovered).Error; err != nil {
+		return 0, err
+	}
+	var originals int64
+	if err := db.Model(&models.ImageOriginal{}).Where("bucket_id = ?", source.Id).Count(&originals).Error; err != nil {
+		return 0, err
+	}
+	if uncovered > 0 || originals > 0 {
+		return bucketMigrationContinue, db.Model(migration).Updates(map[string]any{
+			"phase":         models.BucketMigrationPhaseCopying,
+			"last_image_id": 0,
+		}).Error
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	if err := DeleteBucketReplicas(ctx, source); err != nil {
+		return 0, fmt.Errorf("delete source replicas: %w", err)
+	}
+	now := time.Now()
+	return bucketMigrationDone, db.Model(migration).Updates(map[string]any{
+		"status":      models.BucketMigrationStatusCompleted,
+		"finished_at": &now,
+	}).Error
+}
+
+// migratedTargetReplicas selects target replicas of images that have a
+// replica in the source bucket.
+func migratedTargetReplicas(db *gorm.DB, sourceID, targetID int) *gorm.DB {
+	return db.Model(&models.ImageStorage{}).
+		Where("bucket_id = ?", targetID).
+		Where("EXISTS (SELECT 1 FROM image_storages source WHERE source.image_id = image_storages.image_id AND source.bucket_id = ?)", sourceID)
+}
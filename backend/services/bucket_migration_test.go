@@ -0,0 +1,334 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestBucketMigrationPhases(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{MultiStorageSync: true}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	source := models.Buckets{Id: 2, Name: "old", Type: "webdav", Config: map[string]any{}}
+	target := models.Buckets{Id: 3, Name: "new", Type: "telegram", Config: map[string]any{}}
+	if err := db.Create(&[]models.Buckets{source, target}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	imageList := []models.Image{
+		{Url: "/uploads/a.webp", FileName: "a.webp", FileSize: 10, Storage: "webdav", BucketId: 2, AccessBucketId: 2},
+		{Url: "/uploads/b.webp", FileName: "b.webp", FileSize: 20, Storage: "webdav", BucketId: 2, AccessBucketId: 2},
+	}
+	if err := db.Create(&imageList).Error; err != nil {
+		t.Fatalf("create images: %v", err)
+	}
+	for _, image := range imageList {
+		if err := db.Create(&models.ImageStorage{ImageID: image.Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: image.FileSize}).Error; err != nil {
+			t.Fatalf("create source replica: %v", err)
+		}
+	}
+
+	plan, err := PlanBucketMigration(source, target)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if plan.Images != 2 || plan.ToCopy != 2 || plan.BytesToCopy != 30 || plan.AccessSourceImages != 2 || !plan.CapacitySufficient {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+
+	migration := models.BucketMigration{
+		SourceBucketID:  2,
+		TargetBucketID:  3,
+		RewriteBucketID: true,
+		Status:          models.BucketMigrationStatusRunning,
+		Phase:           models.BucketMigrationPhaseCopying,
+	}
+	if err := db.Create(&migration).Error; err != nil {
+		t.Fatalf("create migration: %v", err)
+	}
+	advance := func(want bucketMigrationStep) {
+		t.Helper()
+		step, err := advanceBucketMigration(db, &migration)
+		if err != nil {
+			t.Fatalf("advance in phase %s: %v", migration.Phase, err)
+		}
+		if step != want {
+			t.Fatalf("expected step %d in phase %s, got %d", want, migration.Phase, step)
+		}
+		if err := db.First(&migration, migration.ID).Error; err != nil {
+			t.Fatalf("reload migration: %v", err)
+		}
+	}
+
+	// Copying enqueues the target replicas and waits for the sync worker.
+	advance(bucketMigrationWait)
+	var targets []models.ImageStorage
+	if err := db.Where("bucket_id = ?", 3).Order("image_id ASC").Find(&targets).Error; err != nil || len(targets) != 2 {
+		t.Fatalf("expected two target replicas, got %+v (%v)", targets, err)
+	}
+	if migration.Total != 2 || migration.Copied != 0 || migration.Phase != models.BucketMigrationPhaseCopying {
+		t.Fatalf("unexpected progress: %+v", migration)
+	}
+
+	// Only the first copy carries the telegram message id, so the second
+	// fails verification and is queued again.
+	db.Model(&models.ImageStorage{}).Where("id = ?", targets[0].ID).
+		Updates(map[string]any{"status": models.ImageStorageStatusSuccess, "metadata": `{"tg_message_id":5}`})
+	db.Model(&models.ImageStorage{}).Where("id = ?", targets[1].ID).Update("status", models.ImageStorageStatusSuccess)
+	advance(bucketMigrationContinue)
+	if migration.Phase != models.BucketMigrationPhaseVerifying || migration.Copied != 2 {
+		t.Fatalf("expected verifying phase, got %+v", migration)
+	}
+	advance(bucketMigrationContinue)
+	if migration.Phase != models.BucketMigrationPhaseCopying {
+		t.Fatalf("expected rejected copy to return to copying, got %+v", migration)
+	}
+	var rejected models.ImageStorage
+	db.First(&rejected, targets[1].ID)
+	if rejected.Status != models.ImageStorageStatusPending || rejected.RetryCount != 1 {
+		t.Fatalf("expected rejected copy to be requeued, got %+v", rejected)
+	}
+
+	db.Model(&models.ImageStorage{}).Where("id = ?", targets[1].ID).
+		Updates(map[string]any{"status": models.ImageStorageStatusSuccess, "metadata": `{"tg_message_id":6}`})
+	advance(bucketMigrationContinue)
+	advance(bucketMigrationContinue)
+	if migration.Verified != 2 {
+		t.Fatalf("expected both copies verified, got %+v", migration)
+	}
+	advance(bucketMigrationContinue)
+	if migration.Phase != models.BucketMigrationPhaseSwitching {
+		t.Fatalf("expected switching phase, got %+v", migration)
+	}
+
+	advance(bucketMigrationContinue)
+	var switched []models.Image
+	db.Order("id ASC").Find(&switched)
+	for _, image := range switched {
+		if image.AccessBucketId != 3 || image.BucketId != 3 || image.Storage != "telegram" {
+			t.Fatalf("expected image to point at the target bucket, got %+v", image)
+		}
+	}
+	if migration.Phase != models.BucketMigrationPhaseDeleting || migration.Switched != 4 {
+		t.Fatalf("expected deleting phase, got %+v", migration)
+	}
+
+	// An image uploaded to the source meanwhile sends the migration back to
+	// copying instead of deleting its only remote copy.
+	late := models.Image{Url: "/uploads/c.webp", FileName: "c.webp", Storage: "webdav", BucketId: 2, AccessBucketId: 2}
+	db.Create(&late)
+	db.Create(&models.ImageStorage{ImageID: late.Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusSuccess, URL: late.Url})
+	advance(bucketMigrationContinue)
+	if migration.Phase != models.BucketMigrationPhaseCopying || migration.Status != models.BucketMigrationStatusRunning {
+		t.Fatalf("expected late upload to resume copying, got %+v", migration)
+	}
+}
+
+func TestStartBucketMigrationRejectsOverlap(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+	source := models.Buckets{Id: 2, Name: "old", Type: "webdav", Config: map[string]any{}}
+	target := models.Buckets{Id: 3, Name: "new", Type: "s3", Config: map[string]any{}}
+	if err := db.Create(&models.BucketMigration{SourceBucketID: 3, TargetBucketID: 4, Status: models.BucketMigrationStatusRunning, Phase: models.BucketMigrationPhaseCopying}).Error; err != nil {
+		t.Fatalf("create migration: %v", err)
+	}
+	if _, err := StartBucketMigration(source, target, false); err != ErrBucketMigrationConflict {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	if _, err := StartBucketMigration(source, source, false); err == nil {
+		t.Fatal("expected same-bucket migration to be rejected")
+	}
+}
+
+// memoryWebDAV is a minimal WebDAV server keeping uploaded files in memory.
+type memoryWebDAV struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newMemoryWebDAV(t *testing.T, files map[string][]byte) (*memoryWebDAV, *httptest.Server) {
+	t.Helper()
+	dav := &memoryWebDAV{files: files}
+	server := httptest.NewServer(dav)
+	t.Cleanup(server.Close)
+	return dav, server
+}
+
+func (d *memoryWebDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := d.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		d.files[r.URL.Path] = data
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		delete(d.files, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	case "MKCOL":
+		w.WriteHeader(http.StatusCreated)
+	case "PROPFIND":
+		// Directories always exist.
+		if _, ok := d.files[r.URL.Path]; !ok && !strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (d *memoryWebDAV) file(path string) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.files[path]
+}
+
+func TestBucketMigrationMovesRemoteOnlyImagesAndOriginals(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{MultiStorageSync: true}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+
+	content := []byte("tiered image bytes")
+	originalContent := []byte("archived original bytes")
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	_, sourceServer := newMemoryWebDAV(t, map[string][]byte{
+		"/uploads/a.webp":          content,
+		"/uploads/originals/a.png": originalContent,
+	})
+	targetDAV, targetServer := newMemoryWebDAV(t, map[string][]byte{})
+	source := models.Buckets{Id: 2, Name: "cold", Type: "webdav", Usage: 100, Config: map[string]any{"webdav_url": sourceServer.URL}}
+	target := models.Buckets{Id: 3, Name: "new", Type: "webdav", Config: map[string]any{"webdav_url": targetServer.URL}}
+	if err := db.Create(&[]models.Buckets{{Id: 1, Name: "local", Type: "default", Config: map[string]any{}}, source, target}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+
+	// The image was tiered off the local disk: its only copy is in the source.
+	image := models.Image{Url: "/uploads/a.webp", FileName: "a.webp", FileSize: int64(len(content)), MimeType: "image/webp", Storage: "default", BucketId: 1, AccessBucketId: 2}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	if err := db.Create(&models.ImageStorage{ImageID: image.Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: image.FileSize, Checksum: checksum}).Error; err != nil {
+		t.Fatalf("create source replica: %v", err)
+	}
+	original := models.ImageOriginal{ImageID: image.Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusSuccess, Path: "/uploads/originals/a.png", FileName: "a.png", FileSize: int64(len(originalContent)), MimeType: "image/png"}
+	if err := db.Create(&original).Error; err != nil {
+		t.Fatalf("create original: %v", err)
+	}
+
+	plan, err := PlanBucketMigration(source, target)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if plan.ArchivedOriginals != 1 || plan.BytesToCopy != int64(len(content)+len(originalContent)) {
+		t.Fatalf("expected the original in the plan, got %+v", plan)
+	}
+
+	migration := models.BucketMigration{SourceBucketID: 2, TargetBucketID: 3, Status: models.BucketMigrationStatusRunning, Phase: models.BucketMigrationPhaseCopying}
+	if err := db.Create(&migration).Error; err != nil {
+		t.Fatalf("create migration: %v", err)
+	}
+	step, err := advanceBucketMigration(db, &migration)
+	if err != nil || step != bucketMigrationWait {
+		t.Fatalf("copying: step %d, %v", step, err)
+	}
+
+	// The original is staged locally again and handed to the archive worker.
+	db.First(&original, original.ID)
+	if original.BucketID != 3 || original.Status != models.ImageStorageStatusPending {
+		t.Fatalf("expected original to move to the target, got %+v", original)
+	}
+	if staged, err := os.ReadFile(filepath.Join("uploads", "originals", "a.png")); err != nil || string(staged) != string(originalContent) {
+		t.Fatalf("expected staged original, got %q (%v)", staged, err)
+	}
+	var deletion models.ReplicaDeletion
+	if err := db.Where("bucket_id = ? AND kind = ?", 2, models.ReplicaDeletionKindOriginal).First(&deletion).Error; err != nil {
+		t.Fatalf("expected the source original to be queued for deletion: %v", err)
+	}
+
+	// The sync worker copies the image from the source replica.
+	if !processNextStorageSyncTask() {
+		t.Fatal("expected the target replica to be processed")
+	}
+	var copied models.ImageStorage
+	if err := db.Where("image_id = ? AND bucket_id = ?", image.Id, 3).First(&copied).Error; err != nil {
+		t.Fatalf("load target replica: %v", err)
+	}
+	if copied.Status != models.ImageStorageStatusSuccess || copied.Checksum != checksum {
+		t.Fatalf("expected the remote-only image to be copied, got %+v", copied)
+	}
+	if string(targetDAV.file("/uploads/a.webp")) != string(content) {
+		t.Fatal("target bucket did not receive the image")
+	}
+
+	if !processNextOriginalArchiveTask() {
+		t.Fatal("expected the original to be archived")
+	}
+	db.First(&original, original.ID)
+	if original.Status != models.ImageStorageStatusSuccess || string(targetDAV.file(original.Path)) != string(originalContent) {
+		t.Fatalf("expected original archived in the target, got %+v", original)
+	}
+
+	step, err = advanceBucketMigration(db, &migration)
+	if err != nil || step != bucketMigrationContinue {
+		t.Fatalf("copying after sync: step %d, %v", step, err)
+	}
+	db.First(&migration, migration.ID)
+	if migration.Phase != models.BucketMigrationPhaseVerifying {
+		t.Fatalf("expected verifying phase, got %+v", migration)
+	}
+}
+
+func TestBucketMigrationKeepsSourceWhileOriginalsRemain(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+	source := models.Buckets{Id: 2, Name: "old", Type: "webdav", Config: map[string]any{}}
+	target := models.Buckets{Id: 3, Name: "new", Type: "s3", Config: map[string]any{}}
+	if err := db.Create(&[]models.Buckets{source, target}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	// Archived to the source after the copying phase finished.
+	if err := db.Create(&models.ImageOriginal{ImageID: 1, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusSuccess, Path: "/uploads/originals/a.png"}).Error; err != nil {
+		t.Fatalf("create original: %v", err)
+	}
+	migration := models.BucketMigration{SourceBucketID: 2, TargetBucketID: 3, Status: models.BucketMigrationStatusRunning, Phase: models.BucketMigrationPhaseDeleting}
+	if err := db.Create(&migration).Error; err != nil {
+		t.Fatalf("create migration: %v", err)
+	}
+	if _, err := advanceBucketMigration(db, &migration); err != nil {
+		t.Fatalf("advance: %v", err)
+	}
+	db.First(&migration, migration.ID)
+	if migration.Phase != models.BucketMigrationPhaseCopying || migration.Status != models.BucketMigrationStatusRunning {
+		t.Fatalf("expected the migration to return to copying, got %+v", migration)
+	}
+	var originals int64
+	db.Model(&models.ImageOriginal{}).Where("bucket_id = ?", 2).Count(&originals)
+	if originals != 1 {
+		t.Fatal("the archived original must not be deleted")
+	}
+}
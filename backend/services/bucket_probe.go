@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+
+	"oneimg/backend/models"
+	utilsBuckets "oneimg/backend/utils/buckets"
+	ftpclient "oneimg/backend/utils/ftp"
+	s3client "oneimg/backend/utils/s3"
+	"oneimg/backend/utils/secureconfig"
+	webdavclient "oneimg/backend/utils/webdav"
+)
+
+// ProbeBucket checks that a bucket is reachable with its configuration by
+// writing and removing a small test object. Telegram is only queried, since
+// a test message would be visible to the receivers. The returned detail
+// describes what was verified.
+func ProbeBucket(ctx context.Context, bucket models.Buckets) (string, error) {
+	switch bucket.Type {
+	case "default":
+		return testLocalStorage()
+	case "s3", "r2":
+		return testS3CompatibleStorage(ctx, bucket)
+	case "ftp":
+		return testFTPStorage(bucket)
+	case "webdav":
+		return testWebDAVStorage(ctx, bucket)
+	case "telegram":
+		return testTelegramStorage(ctx, bucket)
+	default:
+		return "", errors.New("不支持的存储类型")
+	}
+}
+
+func testLocalStorage() (string, error) {
+	file, err := os.CreateTemp(".", ".oneimg-storage-test-*")
+	if err != nil {
+		return "", fmt.Errorf("本地目录不可写: %w", err)
+	}
+	name := file.Name()
+	defer os.Remove(name)
+	if _, err := file.WriteString("oneimg storage connection test"); err != nil {
+		file.Close()
+		return "", fmt.Errorf("本地文件写入失败: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("本地文件关闭失败: %w", err)
+	}
+	if err := os.Remove(name); err != nil {
+		return "", fmt.Errorf("本地测试文件清理失败: %w", err)
+	}
+	return "本地目录可读写", nil
+}
+
+func testS3CompatibleStorage(ctx context.Context, bucket models.Buckets) (string, error) {
+	client, err := s3client.NewS3Client(models.Settings{}, bucket)
+	if err != nil {
+		return "", err
+	}
+	bucketName := ""
+	if bucket.Type == "s3" {
+		bucketName = utilsBuckets.ConvertToS3Bucket(bucket.Config).S3Bucket
+	} else {
+		bucketName = utilsBuckets.ConvertToR2Bucket(bucket.Config).R2Bucket
+	}
+	key := ".oneimg-connection-test/" + uuid.NewString() + ".txt"
+	content := []byte("oneimg storage connection test")
+	if _, err := client.PutObject(ctx, bucketName, key, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{}); err != nil {
+		return "", fmt.Errorf("测试对象写入失败: %s", translateS3Error(err))
+	}
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.RemoveObject(cleanupCtx, bucketName, key, minio.RemoveObjectOptions{}); err != nil {
+		return "", fmt.Errorf("写入成功，但测试对象清理失败: %w", err)
+	}
+	return "已验证对象写入与删除权限", nil
+}
+
+func testFTPStorage(bucket models.Buckets) (string, error) {
+	config := utilsBuckets.ConvertToFTPBucket(bucket.Config)
+	client := ftpclient.NewFTPUtil(ftpclient.FTPConfig{
+		Host: config.FTPHost, Port: config.FTPPort, User: config.FTPUser, Password: config.FTPPass, Timeout: 8,
+	})
+	defer client.Close()
+	remotePath := ".oneimg-connection-test-" + uuid.NewString() + ".txt"
+	if err := client.UploadImage(remotePath, []byte("oneimg storage connection test"), "text/plain"); err != nil {
+		return "", err
+	}
+	if err := client.DeleteImage(remotePath); err != nil {
+		return "", fmt.Errorf("写入成功，但测试文件清理失败: %w", err)
+	}
+	return "已验证 FTP 登录、写入与删除权限", nil
+}
+
+func testWebDAVStorage(ctx context.Context, bucket models.Buckets) (string, error) {
+	config := utilsBuckets.ConvertToWebDavBucket(bucket.Config)
+	client := webdavclient.Client(webdavclient.Config{
+		BaseURL: config.WebdavURL, Username: config.WebdavUser, Password: config.WebdavPass, Timeout: 15 * time.Second,
+	})
+	remotePath := ".oneimg-connection-test-" + uuid.NewString() + ".txt"
+	if err := client.WebDAVUpload(ctx, remotePath, strings.NewReader("oneimg storage connection test")); err != nil {
+		return "", err
+	}
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.WebDAVDelete(cleanupCtx, remotePath); err != nil {
+		return "", fmt.Errorf("写入成功，但测试文件清理失败: %w", err)
+	}
+	return "已验证 WebDAV 认证、写入与删除权限", nil
+}
+
+func testTelegramStorage(ctx context.Context, bucket models.Buckets) (string, error) {
+	config := utilsBuckets.ConvertToTelegramBucket(bucket.Config)
+	if err := callTelegramTestAPI(ctx, config.TGBotToken, "getMe", nil); err != nil {
+		return "", fmt.Errorf("Bot Token 校验失败: %w", err)
+	}
+	if err := callTelegramTestAPI(ctx, config.TGBotToken, "getChat", map[string]string{"chat_id": config.TGReceivers}); err != nil {
+		return "", fmt.Errorf("Chat ID 校验失败: %w", err)
+	}
+	return "已验证 Bot Token 与 Chat ID 访问权限（未发送消息）", nil
+}
+
+func callTelegramTestAPI(ctx context.Context, token, method string, payload any) error {
+	var body io.Reader
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.telegram.org/bot"+token+"/"+method, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := (&http.Client{Timeout: 12 * time.Second}).Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var apiResponse struct {
+		OK          bool   `json:"ok"`
+		ErrorCode   int    `json:"error_code"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&apiResponse); err != nil {
+		return fmt.Errorf("Telegram API 响应无效（HTTP %d）", response.StatusCode)
+	}
+	if response.StatusCode != http.StatusOK || !apiResponse.OK {
+		return fmt.Errorf("Telegram API 错误 [%d]: %s", apiResponse.ErrorCode, apiResponse.Description)
+	}
+	return nil
+}
+
+// SanitizeBucketError redacts the bucket's secrets from an error message and
+// truncates it for display or storage.
+func SanitizeBucketError(err error, config map[string]any) string {
+	message := err.Error()
+	for key, value := range config {
+		if !secureconfig.IsBucketSensitiveKey(key) {
+			continue
+		}
+		secret := strings.TrimSpace(fmt.Sprintf("%v", value))
+		if len(secret) >= 3 {
+			message = strings.ReplaceAll(message, secret, "***")
+		}
+	}
+	runes := []rune(message)
+	if len(runes) > 500 {
+		message = string(runes[:500]) + "..."
+	}
+	return message
+}
+
+func translateS3Error(err error) string {
+	var minioErr minio.ErrorResponse
+	if errors.As(err, &minioErr) {
+		switch minioErr.StatusCode {
+		case 401:
+			return "认证失败：Access Key 或 Secret Key 错误"
+		case 403:
+			if strings.Contains(minioErr.Code, "AccessDenied") {
+				return "权限不足：该密钥没有读写此存储桶的权限"
+			}
+			return "认证失败：密钥错误或签名不匹配"
+		case 400:
+			if strings.Contains(minioErr.Code, "InvalidAccessKeyId") {
+				return "认证失败：Access Key ID 无效"
+			}
+			return fmt.Sprintf("请求错误(400): %s", minioErr.Message)
+		case 404:
+			if minioErr.Code == "NoSuchBucket" {
+				return "存储桶不存在，请检查名称拼写或区域是否正确"
+			}
+			return "网络错误：找不到指定的存储节点"
+		case 502, 503, 504:
+			return "存储服务网络异常或超时，请检查 Endpoint 地址是否可达"
+		}
+		// 如果有具体的错误码，一并返回
+		if minioErr.Code != "" {
+			return fmt.Sprintf("%s (错误码: %s)", minioErr.Message, minioErr.Code)
+		}
+	}
+	return err.Error()
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oneimg/backend/models"
+)
+
+func TestLocalStorageConnectionTestCleansTemporaryFile(t *testing.T) {
+	before, err := filepath.Glob(".oneimg-storage-test-*")
+	if err != nil {
+		t.Fatalf("glob before test: %v", err)
+	}
+	detail, err := testLocalStorage()
+	if err != nil {
+		t.Fatalf("testLocalStorage() error: %v", err)
+	}
+	if !strings.Contains(detail, "可读写") {
+		t.Fatalf("testLocalStorage() detail = %q", detail)
+	}
+	after, err := filepath.Glob(".oneimg-storage-test-*")
+	if err != nil {
+		t.Fatalf("glob after test: %v", err)
+	}
+	if len(after) != len(before) {
+		t.Fatalf("local connection test left temporary files: before=%v after=%v", before, after)
+	}
+}
+
+func TestWebDAVStorageConnectionTestWritesAndDeletes(t *testing.T) {
+	var putCalls atomic.Int32
+	var deleteCalls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		username, password, ok := request.BasicAuth()
+		if !ok || username != "dav-user" || password != "dav-password" {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch request.Method {
+		case http.MethodPut:
+			putCalls.Add(1)
+			writer.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			deleteCalls.Add(1)
+			writer.WriteHeader(http.StatusNoContent)
+		default:
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	detail, err := testWebDAVStorage(ctx, models.Buckets{
+		Type: "webdav",
+		Config: map[string]any{
+			"webdav_url":  server.URL + "/dav",
+			"webdav_user": "dav-user",
+			"webdav_pass": "dav-password",
+		},
+	})
+	if err != nil {
+		t.Fatalf("testWebDAVStorage() error: %v", err)
+	}
+	if putCalls.Load() != 1 || deleteCalls.Load() != 1 {
+		t.Fatalf("WebDAV calls: PUT=%d DELETE=%d", putCalls.Load(), deleteCalls.Load())
+	}
+	if !strings.Contains(detail, "WebDAV") {
+		t.Fatalf("testWebDAVStorage() detail = %q", detail)
+	}
+}
+
+func TestSanitizeBucketErrorRedactsSecrets(t *testing.T) {
+	message := SanitizeBucketError(errors.New("request with token-secret and password-secret failed"), map[string]any{
+		"tg_bot_token": "token-secret",
+		"webdav_pass":  "password-secret",
+	})
+	if strings.Contains(message, "token-secret") || strings.Contains(message, "password-secret") {
+		t.Fatalf("SanitizeBucketError() leaked a secret: %q", message)
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+// Delivery policies decide which replica serves an image whose access bucket
+// was not chosen explicitly.
+const (
+	DeliveryPolicyLocal    = "local"    // 本机副本优先，其次原始存储源
+	DeliveryPolicyPriority = "priority" // 优先级最高的存储源优先，同级按权重分流
+	DeliveryPolicyWeighted = "weighted" // 在全部健康副本间按权重分流
+	DeliveryPolicyFastest  = "fastest"  // 近一小时健康检查延迟最低的存储源优先
+
+	// MaxDeliveryWeight caps the per-bucket delivery weight.
+	MaxDeliveryWeight = 1000
+	// MaxDeliveryPriority caps the per-bucket delivery priority in both
+	// directions.
+	MaxDeliveryPriority = 1000
+
+	deliveryLatencyWindow = time.Hour
+	deliveryLatencyTTL    = time.Minute
+)
+
+var deliveryLatencies struct {
+	sync.Mutex
+	loadedAt time.Time
+	values   map[int]float64
+}
+
+// ValidDeliveryPolicy reports whether policy names a known delivery policy.
+func ValidDeliveryPolicy(policy string) bool {
+	switch policy {
+	case DeliveryPolicyLocal, DeliveryPolicyPriority, DeliveryPolicyWeighted, DeliveryPolicyFastest:
+		return true
+	}
+	return false
+}
+
+// EffectiveDeliveryPolicy returns the image's own policy when it has one and
+// the global policy otherwise.
+func EffectiveDeliveryPolicy(setting models.Settings, image models.Image) string {
+	if ValidDeliveryPolicy(image.DeliveryPolicy) {
+		return image.DeliveryPolicy
+	}
+	if ValidDeliveryPolicy(setting.DeliveryPolicy) {
+		return setting.DeliveryPolicy
+	}
+	return DeliveryPolicyLocal
+}
+
+// RankDeliveryBuckets orders the buckets holding a replica of an image by
+// preference under policy. Callers serve from the first bucket that works and
+// fail over down the list. Weighted choices are randomized on every call, so
+// reads spread across replicas in proportion to their weights; a bucket with
+// zero weight only serves when no weighted bucket can.
+func RankDeliveryBuckets(buckets []models.Buckets, policy string) []models.Buckets {
+	ranked := make([]models.Buckets, len(buckets))
+	copy(ranked, buckets)
+
+	keys := make(map[int]float64, len(ranked))
+	for _, bucket := range ranked {
+		keys[bucket.Id] = weightedDeliveryKey(bucket.DeliveryWeight)
+	}
+
+	switch policy {
+	case DeliveryPolicyWeighted:
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return keys[ranked[i].Id] > keys[ranked[j].Id]
+		})
+	case DeliveryPolicyFastest:
+		latencies := loadDeliveryLatencies()
+		sort.SliceStable(ranked, func(i, j int) bool {
+			left, leftMeasured := latencies[ranked[i].Id]
+			right, rightMeasured := latencies[ranked[j].Id]
+			if leftMeasured != rightMeasured {
+				return leftMeasured
+			}
+			if leftMeasured && left != right {
+				return left < right
+			}
+			return ranked[i].DeliveryPriority > ranked[j].DeliveryPriority
+		})
+	default:
+		sort.SliceStable(ranked, func(i, j int) bool {
+			if ranked[i].DeliveryPriority != ranked[j].DeliveryPriority {
+				return ranked[i].DeliveryPriority > ranked[j].DeliveryPriority
+			}
+			return keys[ranked[i].Id] > keys[ranked[j].Id]
+		})
+	}
+	return ranked
+}
+
+// weightedDeliveryKey draws a random sort key so that sorting by it in
+// descending order is a weighted shuffle (Efraimidis–Spirakis).
+func weightedDeliveryKey(weight int) float64 {
+	if weight <= 0 {
+		return -1
+	}
+	return math.Pow(rand.Float64(), 1/float64(weight))
+}
+
+// loadDeliveryLatencies returns the average latency of recent successful
+// health checks per bucket, cached briefly since it is read on every request.
+// Buckets without checks, including the local bucket, are absent.
+func loadDeliveryLatencies() map[int]float64 {
+	deliveryLatencies.Lock()
+	defer deliveryLatencies.Unlock()
+	if deliveryLatencies.values != nil && time.Since(deliveryLatencies.loadedAt) < deliveryLatencyTTL {
+		return deliveryLatencies.values
+	}
+
+	values := make(map[int]float64)
+	db := database.GetDB()
+	if db != nil && db.DB != nil {
+		var rows []struct {
+			BucketID     int
+			AvgLatencyMs float64
+		}
+		if err := db.DB.Model(&models.BucketHealthCheck{}).
+			Select("bucket_id, AVG(latency_ms) AS avg_latency_ms").
+			Where("ok = ? AND checked_at >= ?", true, time.Now().Add(-deliveryLatencyWindow)).
+			Group("bucket_id").
+			Scan(&rows).Error; err == nil {
+			for _, row := range rows {
+				values[row.BucketID] = row.AvgLatencyMs
+			}
+		}
+	}
+	deliveryLatencies.values = values
+	deliveryLatencies.loadedAt = time.Now()
+	return values
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestRankDeliveryBucketsByPriorityAndWeight(t *testing.T) {
+	buckets := []models.Buckets{
+		{Id: 1, Name: "local", Type: "default", DeliveryPriority: 0, DeliveryWeight: 1},
+		{Id: 2, Name: "heavy", Type: "s3", DeliveryPriority: 10, DeliveryWeight: 3},
+		{Id: 3, Name: "light", Type: "webdav", DeliveryPriority: 10, DeliveryWeight: 1},
+		{Id: 4, Name: "standby", Type: "ftp", DeliveryPriority: 10, DeliveryWeight: 0},
+	}
+
+	first := make(map[int]int)
+	const rounds = 4000
+	for range rounds {
+		ranked := RankDeliveryBuckets(buckets, DeliveryPolicyPriority)
+		first[ranked[0].Id]++
+		if ranked[2].Id != 4 || ranked[3].Id != 1 {
+			t.Fatalf("zero-weight and lower-priority buckets must rank last: %v", bucketIDs(ranked))
+		}
+	}
+	// Weights 3:1 put the heavy bucket first about 75% of the time.
+	if share := float64(first[2]) / rounds; share < 0.68 || share > 0.82 {
+		t.Fatalf("heavy bucket ranked first %.2f of the time, want about 0.75", share)
+	}
+
+	first = make(map[int]int)
+	for range rounds {
+		first[RankDeliveryBuckets(buckets, DeliveryPolicyWeighted)[0].Id]++
+	}
+	if first[4] != 0 || first[1] == 0 {
+		t.Fatalf("weighted policy should ignore priority and skip zero weight: %v", first)
+	}
+}
+
+func TestRankDeliveryBucketsFastestPrefersMeasuredLowLatency(t *testing.T) {
+	initStorageSyncTestDB(t)
+	deliveryLatencies.Lock()
+	deliveryLatencies.values = nil
+	deliveryLatencies.Unlock()
+
+	db := database.GetDB().DB
+	now := time.Now()
+	if err := db.Create(&[]models.BucketHealthCheck{
+		{BucketID: 2, OK: true, LatencyMs: 300, CheckedAt: now},
+		{BucketID: 3, OK: true, LatencyMs: 40, CheckedAt: now},
+		{BucketID: 3, OK: false, LatencyMs: 9000, CheckedAt: now},
+	}).Error; err != nil {
+		t.Fatalf("create checks: %v", err)
+	}
+
+	ranked := RankDeliveryBuckets([]models.Buckets{
+		{Id: 1, Type: "default", DeliveryWeight: 1},
+		{Id: 2, Type: "s3", DeliveryWeight: 1},
+		{Id: 3, Type: "webdav", DeliveryWeight: 1},
+	}, DeliveryPolicyFastest)
+	if ids := bucketIDs(ranked); ids[0] != 3 || ids[1] != 2 || ids[2] != 1 {
+		t.Fatalf("fastest ranking = %v, want [3 2 1]", ids)
+	}
+}
+
+func TestEffectiveDeliveryPolicyPrefersImageOverride(t *testing.T) {
+	setting := models.Settings{DeliveryPolicy: DeliveryPolicyWeighted}
+	if policy := EffectiveDeliveryPolicy(setting, models.Image{}); policy != DeliveryPolicyWeighted {
+		t.Fatalf("inherited policy = %q", policy)
+	}
+	if policy := EffectiveDeliveryPolicy(setting, models.Image{DeliveryPolicy: DeliveryPolicyLocal}); policy != DeliveryPolicyLocal {
+		t.Fatalf("override policy = %q", policy)
+	}
+	if policy := EffectiveDeliveryPolicy(models.Settings{}, models.Image{}); policy != DeliveryPolicyLocal {
+		t.Fatalf("default policy = %q", policy)
+	}
+}
+
+func bucketIDs(buckets []models.Buckets) []int {
+	ids := make([]int, 0, len(buckets))
+	for _, bucket := range buckets {
+		ids = append(ids, bucket.Id)
+	}
+	return ids
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+
+	"gorm.io/gorm"
+)
+
+// DeleteImage removes an image completely: its replicas in every bucket, the
+// archived original, profile thumbnails and all database rows. Local files
+// are deleted right away; remote files are handed to the replica deletion
+// queue, so no storage backend is contacted here. Bucket usage is released
+// as soon as the rows are gone.
+func DeleteImage(image models.Image) error {
+	if err := DeleteImageReplicas(image); err != nil {
+		return fmt.Errorf("delete replicas: %w", err)
+	}
+	if err := QueueImageOriginalDeletion(image.Id); err != nil {
+		return fmt.Errorf("delete archived original: %w", err)
+	}
+
+	db := database.GetDB()
+	if db == nil || db.DB == nil {
+		return errors.New("database is not initialized")
+	}
+	var thumbnailRecords []models.ImageThumbnail
+	if err := db.DB.Where("image_id = ?", image.Id).Find(&thumbnailRecords).Error; err != nil {
+		return fmt.Errorf("load profile thumbnails: %w", err)
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("image_id = ?", image.Id).Delete(&models.ImageStorage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("image_id = ?", image.Id).Delete(&models.ImageToTags{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("image_id = ?", image.Id).Delete(&models.ImageThumbnail{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&models.Image{}, image.Id).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := DeleteImageThumbnailFiles(thumbnailRecords); err != nil {
+		log.Printf("[image-delete] failed to delete profile thumbnails of image %d: %v", image.Id, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+const (
+	imageExpiryTick      = time.Minute
+	imageExpiryBatchSize = 100
+	// MaxImageExpirySeconds caps expires_in at ten years.
+	MaxImageExpirySeconds = 10 * 365 * 24 * 3600
+)
+
+var imageExpiryStartOnce sync.Once
+
+// RoleImageExpiry returns the default lifetime of uploads by the given role,
+// or zero when they are kept forever.
+func RoleImageExpiry(setting models.Settings, role int) time.Duration {
+	var hours int
+	switch role {
+	case models.RoleAdmin:
+		hours = setting.AdminImageExpiry
+	case models.RoleUser:
+		hours = setting.UserImageExpiry
+	default:
+		hours = setting.GuestImageExpiry
+	}
+	if hours <= 0 {
+		return 0
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// ResolveImageExpiry returns when an upload expires. A positive expiresIn
+// (seconds) is used as requested; zero falls back to the role default. When
+// the role has a default, it is also the longest lifetime that role may ask
+// for, so guests cannot opt out of expiry.
+func ResolveImageExpiry(setting models.Settings, role int, expiresIn int64, now time.Time) (*time.Time, error) {
+	if expiresIn < 0 || expiresIn > MaxImageExpirySeconds {
+		return nil, fmt.Errorf("有效期必须在0-%d秒之间", MaxImageExpirySeconds)
+	}
+	limit := RoleImageExpiry(setting, role)
+	lifetime := time.Duration(expiresIn) * time.Second
+	if lifetime == 0 || (limit > 0 && lifetime > limit) {
+		lifetime = limit
+	}
+	if lifetime == 0 {
+		return nil, nil
+	}
+	expiresAt := now.Add(lifetime)
+	return &expiresAt, nil
+}
+
+// IsImageExpired reports whether the image has passed its expiry time. Such
+// images are no longer served even before the reaper has deleted them.
+func IsImageExpired(image models.Image, now time.Time) bool {
+	return image.ExpiresAt != nil && !image.ExpiresAt.After(now)
+}
+
+// StartImageExpiryReaper deletes expired images every minute.
+func StartImageExpiryReaper() {
+	imageExpiryStartOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(imageExpiryTick)
+			defer ticker.Stop()
+			for range ticker.C {
+				if _, err := ReapExpiredImages(context.Background()); err != nil {
+					log.Printf("[image-expiry] reap failed: %v", err)
+				}
+			}
+		}()
+	})
+}
+
+// ReapExpiredImages deletes every image whose expiry time has passed and
+// returns how many were removed. Images that fail to delete keep their
+// record and are retried on the next run.
+func ReapExpiredImages(ctx context.Context) (int, error) {
+	db := database.GetDB()
+	if db == nil || db.DB == nil {
+		return 0, errors.New("database is not initialized")
+	}
+	now := time.Now()
+	deleted := 0
+	lastID := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		var images []models.Image
+		if err := db.DB.Where("expires_at IS NOT NULL AND expires_at <= ? AND id > ?", now, lastID).
+			Order("id ASC").Limit(imageExpiryBatchSize).Find(&images).Error; err != nil {
+			return deleted, err
+		}
+		if len(images) == 0 {
+			break
+		}
+		for _, image := range images {
+			lastID = image.Id
+			if err := DeleteImage(image); err != nil {
+				log.Printf("[image-expiry] failed to delete expired image %d: %v", image.Id, err)
+				continue
+			}
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		log.Printf("[image-expiry] deleted %d expired image(s)", deleted)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestResolveImageExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	setting := models.Settings{GuestImageExpiry: 24 * 7}
+	cases := []struct {
+		role      int
+		expiresIn int64
+		want      time.Duration
+	}{
+		{models.RoleGuest, 0, 7 * 24 * time.Hour},
+		{models.RoleGuest, 3600, time.Hour},
+		{models.RoleGuest, 30 * 24 * 3600, 7 * 24 * time.Hour},
+		{models.RoleUser, 0, 0},
+		{models.RoleUser, 60, time.Minute},
+		{models.RoleAdmin, 30 * 24 * 3600, 30 * 24 * time.Hour},
+	}
+	for _, tc := range cases {
+		got, err := ResolveImageExpiry(setting, tc.role, tc.expiresIn, now)
+		if err != nil {
+			t.Fatalf("role %d expires_in %d: %v", tc.role, tc.expiresIn, err)
+		}
+		if tc.want == 0 {
+			if got != nil {
+				t.Fatalf("role %d expires_in %d: expected no expiry, got %v", tc.role, tc.expiresIn, got)
+			}
+			continue
+		}
+		if got == nil || !got.Equal(now.Add(tc.want)) {
+			t.Fatalf("role %d expires_in %d: expected %v, got %v", tc.role, tc.expiresIn, now.Add(tc.want), got)
+		}
+	}
+	if _, err := ResolveImageExpiry(setting, models.RoleUser, -1, now); err == nil {
+		t.Fatal("negative expires_in should be rejected")
+	}
+}
+
+func TestReapExpiredImages(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+
+	buckets := []models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}},
+		{Id: 2, Name: "remote", Type: "webdav", Config: map[string]any{}, Usage: 500},
+	}
+	if err := db.Create(&buckets).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	imageList := []models.Image{
+		{Url: "/uploads/expired.webp", FileName: "expired.webp", BucketId: 1, ExpiresAt: &past},
+		{Url: "/uploads/later.webp", FileName: "later.webp", BucketId: 1, ExpiresAt: &future},
+		{Url: "/uploads/forever.webp", FileName: "forever.webp", BucketId: 1},
+	}
+	if err := db.Create(&imageList).Error; err != nil {
+		t.Fatalf("create images: %v", err)
+	}
+	if err := os.MkdirAll("uploads", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range imageList {
+		if err := os.WriteFile(filepath.Join("uploads", image.FileName), []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&models.ImageStorage{ImageID: image.Id, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess, URL: image.Url}).Error; err != nil {
+			t.Fatalf("create local replica: %v", err)
+		}
+	}
+	// A queued remote copy is dropped without contacting the backend.
+	if err := db.Create(&models.ImageStorage{ImageID: imageList[0].Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusPending, URL: imageList[0].Url}).Error; err != nil {
+		t.Fatalf("create pending replica: %v", err)
+	}
+
+	deleted, err := ReapExpiredImages(context.Background())
+	if err != nil {
+		t.Fatalf("reap: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected one deleted image, got %d", deleted)
+	}
+	var remaining []models.Image
+	if err := db.Order("id ASC").Find(&remaining).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 2 || remaining[0].Id != imageList[1].Id {
+		t.Fatalf("unexpected remaining images: %+v", remaining)
+	}
+	var replicas int64
+	db.Model(&models.ImageStorage{}).Where("image_id = ?", imageList[0].Id).Count(&replicas)
+	if replicas != 0 {
+		t.Fatalf("expected replicas of expired image to be removed, got %d", replicas)
+	}
+	if _, err := os.Stat(filepath.Join("uploads", "expired.webp")); !os.IsNotExist(err) {
+		t.Fatalf("expired file should be deleted, stat err: %v", err)
+	}
+	if !IsImageExpired(imageList[0], time.Now()) || IsImageExpired(imageList[1], time.Now()) || IsImageExpired(imageList[2], time.Now()) {
+		t.Fatal("IsImageExpired disagrees with expiry times")
+	}
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/utils/securestorage"
+
+	"gorm.io/gorm"
+)
+
+// ImageReplacement is the processed content that replaces the file behind an
+// existing image. Main and Thumbnail are written to the image's current
+// Url/Thumbnail paths so published links keep working.
+type ImageReplacement struct {
+	Main      []byte
+	Thumbnail []byte
+	MimeType  string
+	Width     int
+	Height    int
+	Encrypted bool
+}
+
+// ReplaceImageFile swaps the local canonical file of an image, bumps its
+// version and returns every remote replica to pending so the storage sync
+// worker uploads the new bytes to the same keys. Images that only lived on a
+// remote bucket gain a local replica, which the proxy prefers immediately.
+func ReplaceImageFile(ctx context.Context, image models.Image, replacement ImageReplacement) (models.Image, error) {
+	unlock := lockImageOperations(image.Id)
+	defer unlock()
+
+	db := database.GetDB()
+	if db == nil || db.DB == nil {
+		return image, errors.New("database is not initialized")
+	}
+	if len(replacement.Main) == 0 {
+		return image, errors.New("replacement image is empty")
+	}
+
+	targets := []replacementTarget{{publicPath: image.Url, data: replacement.Main}}
+	thumbnailSize := int64(0)
+	if image.Thumbnail != "" && len(replacement.Thumbnail) > 0 {
+		targets = append(targets, replacementTarget{publicPath: image.Thumbnail, data: replacement.Thumbnail})
+		thumbnailSize = int64(len(replacement.Thumbnail))
+	}
+	for i := range targets {
+		if err := targets[i].stage(replacement.Encrypted); err != nil {
+			discardReplacementTargets(targets)
+			return image, err
+		}
+	}
+
+	var replicas []models.ImageStorage
+	if err := db.DB.Where("image_id = ?", image.Id).Order("id ASC").Find(&replicas).Error; err != nil {
+		discardReplacementTargets(targets)
+		return image, err
+	}
+	buckets := make(map[int]models.Buckets)
+	for _, replica := range replicas {
+		var bucket models.Buckets
+		if err := db.DB.First(&bucket, replica.BucketID).Error; err != nil {
+			discardReplacementTargets(targets)
+			return image, fmt.Errorf("load bucket %d: %w", replica.BucketID, err)
+		}
+		buckets[bucket.Id] = bucket
+	}
+
+	now := time.Now()
+	fileSize := int64(len(replacement.Main))
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		hasLocal := false
+		for _, replica := range replicas {
+			if buckets[replica.BucketID].Type == "default" {
+				hasLocal = true
+				if err := tx.Model(&models.ImageStorage{}).Where("id = ?", replica.ID).Updates(map[string]any{
+					"status":         models.ImageStorageStatusSuccess,
+					"url":            image.Url,
+					"thumbnail":      image.Thumbnail,
+					"file_size":      fileSize,
+					"thumbnail_size": thumbnailSize,
+					"error":          "",
+					"synced_at":      &now,
+					// Recorded again by the next scrub of the new file.
+					"checksum":           "",
+					"thumbnail_checksum": "",
+				}).Error; err != nil {
+					return err
+				}
+				continue
+			}
+
+			if replica.Status == models.ImageStorageStatusSuccess {
+				if err := ReleaseBucketUsage(tx, replica.BucketID, replica.FileSize+replica.ThumbnailSize); err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(&models.ImageStorage{}).Where("id = ?", replica.ID).Updates(map[string]any{
+				"status":           models.ImageStorageStatusPending,
+				"retry_count":      0,
+				"error":            "",
+				"started_at":       nil,
+				"next_retry_at":    nil,
+				"lease_owner":      "",
+				"lease_expires_at": nil,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		if !hasLocal {
+			var localBucket models.Buckets
+			if err := tx.Where("type = ?", "default").Order("id ASC").First(&localBucket).Error; err != nil {
+				return fmt.Errorf("load local bucket: %w", err)
+			}
+			if err := tx.Create(&models.ImageStorage{
+				ImageID:       image.Id,
+				BucketID:      localBucket.Id,
+				Storage:       "default",
+				Status:        models.ImageStorageStatusSuccess,
+				URL:           image.Url,
+				Thumbnail:     image.Thumbnail,
+				FileSize:      fileSize,
+				ThumbnailSize: thumbnailSize,
+				SyncedAt:      &now,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&models.Image{}).Where("id = ?", image.Id).Updates(map[string]any{
+			"file_size":   fileSize,
+			"mime_type":   replacement.MimeType,
+			"width":       replacement.Width,
+			"height":      replacement.Height,
+			"version":     gorm.Expr("version + 1"),
+			"replaced_at": &now,
+		}).Error
+	})
+	if err != nil {
+		discardReplacementTargets(targets)
+		return image, err
+	}
+
+	var commitErrors []error
+	for _, replica := range replicas {
+		// Telegram cannot overwrite a message in place; drop the old message
+		// before the worker posts the new file.
+		bucket := buckets[replica.BucketID]
+		if bucket.Type == "telegram" && replica.Status == models.ImageStorageStatusSuccess {
+			if err := deleteRemoteReplica(ctx, image, bucket, replica); err != nil {
+				log.Printf("[storage-sync] failed to delete replaced telegram replica %d: %v", replica.ID, err)
+			}
+		}
+	}
+	// The archived original belonged to the previous file.
+	if err := deleteImageOriginalLocked(ctx, db.DB, image.Id); err != nil {
+		log.Printf("[original-archive] failed to delete original of replaced image %d: %v", image.Id, err)
+	}
+	for _, target := range targets {
+		if err := os.Rename(target.stagedPath, target.localPath); err != nil {
+			commitErrors = append(commitErrors, fmt.Errorf("replace %s: %w", target.publicPath, err))
+		}
+	}
+	if err := db.DB.First(&image, image.Id).Error; err != nil {
+		commitErrors = append(commitErrors, err)
+	}
+	WakeStorageSyncWorker()
+	return image, errors.Join(commitErrors...)
+}
+
+type replacementTarget struct {
+	publicPath string
+	localPath  string
+	stagedPath string
+	data       []byte
+}
+
+func (t *replacementTarget) stage(encrypted bool) error {
+	localPath, err := canonicalLocalPath(t.publicPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("create image directory: %w", err)
+	}
+	t.localPath = localPath
+	t.stagedPath = localPath + ".replace"
+	if err := securestorage.WriteFile(t.stagedPath, t.data, encrypted); err != nil {
+		return fmt.Errorf("write replacement %s: %w", t.publicPath, err)
+	}
+	return nil
+}
+
+func discardReplacementTargets(targets []replacementTarget) {
+	for _, target := range targets {
+		if target.stagedPath != "" {
+			_ = os.Remove(target.stagedPath)
+		}
+	}
+}
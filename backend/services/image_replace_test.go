@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestReplaceImageFileKeepsPathsAndResetsReplicas(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+
+	buckets := []models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{"storagePath": "/uploads"}},
+		{Id: 2, Name: "remote", Type: "s3", Capacity: 1024, Usage: 30, Config: map[string]any{}},
+	}
+	if err := db.Create(&buckets).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join("uploads", "thumbnails"), 0755); err != nil {
+		t.Fatalf("create upload dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join("uploads", "diagram.png"), []byte("old main"), 0644); err != nil {
+		t.Fatalf("write main: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join("uploads", "thumbnails", "diagram.png"), []byte("old thumb"), 0644); err != nil {
+		t.Fatalf("write thumbnail: %v", err)
+	}
+
+	image := models.Image{
+		Url:       "/uploads/diagram.png",
+		Thumbnail: "/uploads/thumbnails/diagram.png",
+		FileName:  "diagram.png",
+		FileSize:  8,
+		MimeType:  "image/png",
+		Storage:   "default",
+		BucketId:  1,
+		UserId:    1,
+	}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	replicas := []models.ImageStorage{
+		{ImageID: image.Id, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess, URL: image.Url, Thumbnail: image.Thumbnail, FileSize: 8, ThumbnailSize: 9},
+		{ImageID: image.Id, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusSuccess, URL: image.Url, Thumbnail: image.Thumbnail, FileSize: 8, ThumbnailSize: 9, RetryCount: 2},
+	}
+	if err := db.Create(&replicas).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+
+	updated, err := ReplaceImageFile(context.Background(), image, ImageReplacement{
+		Main:      []byte("new main bytes"),
+		Thumbnail: []byte("new thumb"),
+		MimeType:  "image/webp",
+		Width:     640,
+		Height:    480,
+	})
+	if err != nil {
+		t.Fatalf("replace image: %v", err)
+	}
+	if updated.Url != image.Url || updated.Thumbnail != image.Thumbnail || updated.Version != 1 || updated.ReplacedAt == nil ||
+		updated.FileSize != int64(len("new main bytes")) || updated.MimeType != "image/webp" || updated.Width != 640 {
+		t.Fatalf("unexpected updated image: %+v", updated)
+	}
+	main, err := os.ReadFile(filepath.Join("uploads", "diagram.png"))
+	if err != nil || string(main) != "new main bytes" {
+		t.Fatalf("main file not replaced: %q, %v", main, err)
+	}
+	thumbnail, err := os.ReadFile(filepath.Join("uploads", "thumbnails", "diagram.png"))
+	if err != nil || string(thumbnail) != "new thumb" {
+		t.Fatalf("thumbnail not replaced: %q, %v", thumbnail, err)
+	}
+	if _, err := os.Stat(filepath.Join("uploads", "diagram.png.replace")); !os.IsNotExist(err) {
+		t.Fatalf("staged file should be renamed, stat err=%v", err)
+	}
+
+	var remote models.ImageStorage
+	if err := db.Where("image_id = ? AND bucket_id = ?", image.Id, 2).First(&remote).Error; err != nil {
+		t.Fatalf("query remote replica: %v", err)
+	}
+	if remote.Status != models.ImageStorageStatusPending || remote.RetryCount != 0 || remote.URL != image.Url {
+		t.Fatalf("remote replica should be pending for re-upload: %+v", remote)
+	}
+	var local models.ImageStorage
+	if err := db.Where("image_id = ? AND bucket_id = ?", image.Id, 1).First(&local).Error; err != nil {
+		t.Fatalf("query local replica: %v", err)
+	}
+	if local.Status != models.ImageStorageStatusSuccess || local.FileSize != int64(len("new main bytes")) || local.ThumbnailSize != int64(len("new thumb")) {
+		t.Fatalf("unexpected local replica: %+v", local)
+	}
+	var remoteBucket models.Buckets
+	if err := db.First(&remoteBucket, 2).Error; err != nil {
+		t.Fatalf("query remote bucket: %v", err)
+	}
+	if remoteBucket.Usage != 13 {
+		t.Fatalf("old remote replica usage should be released, got %d", remoteBucket.Usage)
+	}
+}
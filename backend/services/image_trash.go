@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	storageSettings "oneimg/backend/utils/settings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	trashPurgeTick      = time.Hour
+	trashPurgeBatchSize = 100
+)
+
+var (
+	ErrImageNotTrashed = errors.New("image is not in the recycle bin")
+
+	trashPurgeStartOnce sync.Once
+)
+
+// TrashImage moves an image to the recycle bin. Its replicas are left alone
+// so that RestoreImage can bring it back unchanged.
+func TrashImage(db *gorm.DB, image models.Image) error {
+	return db.Delete(&models.Image{}, image.Id).Error
+}
+
+// RestoreImage takes an image out of the recycle bin.
+func RestoreImage(db *gorm.DB, imageID int) error {
+	restored := db.Unscoped().Model(&models.Image{}).
+		Where("id = ? AND deleted_at IS NOT NULL", imageID).
+		Update("deleted_at", nil)
+	if restored.Error != nil {
+		return restored.Error
+	}
+	if restored.RowsAffected == 0 {
+		return ErrImageNotTrashed
+	}
+	return nil
+}
+
+// TrashPurgeDeadline returns the deletion time before which trashed images
+// are purged, or false when the recycle bin is disabled.
+func TrashPurgeDeadline(setting models.Settings, now time.Time) (time.Time, bool) {
+	if setting.TrashRetentionDays <= 0 {
+		return time.Time{}, false
+	}
+	return now.AddDate(0, 0, -setting.TrashRetentionDays), true
+}
+
+// StartTrashPurgeWorker purges images whose retention window passed every
+// hour.
+func StartTrashPurgeWorker() {
+	trashPurgeStartOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(trashPurgeTick)
+			defer ticker.Stop()
+			for range ticker.C {
+				if _, err := PurgeTrashedImages(context.Background()); err != nil {
+					log.Printf("[trash] purge failed: %v", err)
+				}
+			}
+		}()
+	})
+}
+
+// PurgeTrashedImages permanently deletes trashed images older than the
+// retention window. With the recycle bin disabled every trashed image is
+// purged. Images that fail to delete stay in the bin for the next run.
+func PurgeTrashedImages(ctx context.Context) (int, error) {
+	db := database.GetDB()
+	if db == nil || db.DB == nil {
+		return 0, errors.New("database is not initialized")
+	}
+	setting, err := storageSettings.GetSettings()
+	if err != nil {
+		return 0, err
+	}
+	deadline, ok := TrashPurgeDeadline(setting, time.Now())
+	if !ok {
+		deadline = time.Now()
+	}
+
+	purged := 0
+	lastID := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+		var images []models.Image
+		if err := db.DB.Unscoped().
+			Where("deleted_at IS NOT NULL AND deleted_at <= ? AND id > ?", deadline, lastID).
+			Order("id ASC").Limit(trashPurgeBatchSize).Find(&images).Error; err != nil {
+			return purged, err
+		}
+		if len(images) == 0 {
+			break
+		}
+		for _, image := range images {
+			lastID = image.Id
+			if err := DeleteImage(image); err != nil {
+				log.Printf("[trash] failed to purge image %d: %v", image.Id, err)
+				continue
+			}
+			purged++
+		}
+	}
+	if purged > 0 {
+		log.Printf("[trash] purged %d image(s)", purged)
+	}
+	return purged, nil
+}
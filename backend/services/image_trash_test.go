@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestTrashRestoreAndPurge(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{TrashRetentionDays: 7}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	if err := db.Create(&models.Buckets{Id: 1, Name: "local", Type: "default", Config: map[string]any{}}).Error; err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+	imageList := []models.Image{
+		{Url: "/uploads/old.webp", FileName: "old.webp", BucketId: 1},
+		{Url: "/uploads/recent.webp", FileName: "recent.webp", BucketId: 1},
+	}
+	if err := db.Create(&imageList).Error; err != nil {
+		t.Fatalf("create images: %v", err)
+	}
+	for _, image := range imageList {
+		if err := db.Create(&models.ImageStorage{ImageID: image.Id, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess, URL: image.Url}).Error; err != nil {
+			t.Fatalf("create replica: %v", err)
+		}
+		if err := TrashImage(db, image); err != nil {
+			t.Fatalf("trash image %d: %v", image.Id, err)
+		}
+	}
+	if err := db.First(&models.Image{}, imageList[0].Id).Error; err == nil {
+		t.Fatal("trashed image should be hidden from normal queries")
+	}
+
+	if err := RestoreImage(db, imageList[1].Id); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if err := db.First(&models.Image{}, imageList[1].Id).Error; err != nil {
+		t.Fatalf("restored image should be visible: %v", err)
+	}
+	if err := RestoreImage(db, imageList[1].Id); !errors.Is(err, ErrImageNotTrashed) {
+		t.Fatalf("expected ErrImageNotTrashed, got %v", err)
+	}
+
+	// Only images trashed before the retention window are purged.
+	if err := TrashImage(db, imageList[1]); err != nil {
+		t.Fatalf("trash again: %v", err)
+	}
+	if err := db.Unscoped().Model(&models.Image{}).Where("id = ?", imageList[0].Id).
+		Update("deleted_at", time.Now().AddDate(0, 0, -8)).Error; err != nil {
+		t.Fatal(err)
+	}
+	purged, err := PurgeTrashedImages(context.Background())
+	if err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if purged != 1 {
+		t.Fatalf("expected one purged image, got %d", purged)
+	}
+	var remaining []models.Image
+	if err := db.Unscoped().Find(&remaining).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0].Id != imageList[1].Id || !remaining[0].DeletedAt.Valid {
+		t.Fatalf("unexpected images after purge: %+v", remaining)
+	}
+	var replicas int64
+	db.Model(&models.ImageStorage{}).Where("image_id = ?", imageList[0].Id).Count(&replicas)
+	if replicas != 0 {
+		t.Fatalf("expected purged replicas to be removed, got %d", replicas)
+	}
+}
@@ -0,0 +1,459 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/utils/securestorage"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	originalArchiveStartOnce sync.Once
+	originalArchiveWake      = make(chan struct{}, 1)
+)
+
+var originalArchiveExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+	"image/tiff": ".tiff",
+	"image/heic": ".heic",
+	"image/heif": ".heif",
+}
+
+// StageOriginalArchive keeps the untouched upload of a downscaled image. The
+// bytes are written to <image dir>/originals/ on local disk and a durable
+// archive task is queued for bucketID. A local archive bucket completes
+// immediately; remote buckets are handled by the archive worker, which removes
+// the local staging copy once the upload succeeds.
+func StageOriginalArchive(image models.Image, original []byte, mimeType string, width, height, bucketID int, encrypted bool) error {
+	db := database.GetDB().DB
+	var bucket models.Buckets
+	if err := db.First(&bucket, bucketID).Error; err != nil {
+		return fmt.Errorf("load archive bucket %d: %w", bucketID, err)
+	}
+
+	publicPath := originalArchivePath(image.Url, mimeType)
+	localPath, err := canonicalLocalPath(publicPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("create original archive directory: %w", err)
+	}
+	if err := securestorage.WriteFile(localPath, original, encrypted); err != nil {
+		return fmt.Errorf("write original archive: %w", err)
+	}
+
+	record := models.ImageOriginal{
+		ImageID:  image.Id,
+		BucketID: bucket.Id,
+		Storage:  bucket.Type,
+		Status:   models.ImageStorageStatusPending,
+		Path:     publicPath,
+		FileName: path.Base(publicPath),
+		FileSize: int64(len(original)),
+		MimeType: mimeType,
+		Width:    width,
+		Height:   height,
+	}
+	if bucket.Type == "default" {
+		now := time.Now()
+		record.Status = models.ImageStorageStatusSuccess
+		record.ArchivedAt = &now
+	}
+	if err := db.Create(&record).Error; err != nil {
+		_ = os.Remove(localPath)
+		return err
+	}
+
+	if record.Status == models.ImageStorageStatusPending {
+		WakeOriginalArchiveWorker()
+	}
+	return nil
+}
+
+// StartOriginalArchiveWorker starts the background uploader for staged
+// originals. Uploads whose lease has expired are returned to pending first.
+func StartOriginalArchiveWorker() {
+	originalArchiveStartOnce.Do(func() {
+		db := database.GetDB()
+		if db == nil || db.DB == nil {
+			log.Printf("[original-archive] database is not initialized; worker not started")
+			return
+		}
+		reclaimExpiredOriginalArchiveLeases(db.DB)
+		go runOriginalArchiveWorker()
+	})
+	WakeOriginalArchiveWorker()
+}
+
+func reclaimExpiredOriginalArchiveLeases(db *gorm.DB) {
+	reclaimed, err := reclaimExpiredStorageLeases(db, &models.ImageOriginal{}, nil)
+	if err != nil {
+		log.Printf("[original-archive] failed to recover interrupted tasks: %v", err)
+	} else if reclaimed > 0 {
+		log.Printf("[original-archive] recovered %d interrupted task(s)", reclaimed)
+		WakeOriginalArchiveWorker()
+	}
+}
+
+// WakeOriginalArchiveWorker asks the archive worker to poll immediately.
+func WakeOriginalArchiveWorker() {
+	select {
+	case originalArchiveWake <- struct{}{}:
+	default:
+	}
+}
+
+func runOriginalArchiveWorker() {
+	ticker := time.NewTicker(storageSyncPollInterval)
+	defer ticker.Stop()
+	lastReclaim := time.Now()
+
+	for {
+		if time.Since(lastReclaim) >= storageLeaseHeartbeat {
+			lastReclaim = time.Now()
+			if db := database.GetDB(); db != nil && db.DB != nil {
+				reclaimExpiredOriginalArchiveLeases(db.DB)
+			}
+		}
+		for processNextOriginalArchiveTask() {
+		}
+
+		select {
+		case <-originalArchiveWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func processNextOriginalArchiveTask() bool {
+	db := database.GetDB()
+	if db == nil || db.DB == nil {
+		return false
+	}
+
+	var record models.ImageOriginal
+	lookup := db.DB.Where(
+		"status = ? AND (next_retry_at IS NULL OR next_retry_at <= ?) AND "+
+			"EXISTS (SELECT 1 FROM buckets WHERE buckets.id = image_originals.bucket_id AND buckets.disabled = ? AND buckets.sync_paused = ?)",
+		models.ImageStorageStatusPending, time.Now(), false, false,
+	).
+		Order("id ASC").
+		Limit(1).
+		Find(&record)
+	if lookup.Error != nil {
+		log.Printf("[original-archive] failed to find pending task: %v", lookup.Error)
+		return false
+	}
+	if lookup.RowsAffected == 0 {
+		return false
+	}
+
+	updates := storageLeaseClaim(time.Now())
+	updates["error"] = ""
+	claim := db.DB.Model(&models.ImageOriginal{}).
+		Where("id = ? AND status = ?", record.ID, models.ImageStorageStatusPending).
+		Updates(updates)
+	if claim.Error != nil {
+		log.Printf("[original-archive] failed to claim task %d: %v", record.ID, claim.Error)
+		return false
+	}
+	if claim.RowsAffected == 0 {
+		return true
+	}
+
+	leaseContext, releaseLease := holdStorageLease(db.DB, &models.ImageOriginal{}, record.ID, "original-archive")
+	defer releaseLease()
+
+	unlock := lockImageOperations(record.ImageID)
+	defer unlock()
+	var current models.ImageOriginal
+	if err := db.DB.Select("id", "status", "lease_owner").First(&current, record.ID).Error; err != nil ||
+		current.Status != models.ImageStorageStatusUploading || current.LeaseOwner != StorageWorkerID() {
+		return true
+	}
+
+	taskContext, cancelTask := context.WithTimeout(leaseContext, 5*time.Minute)
+	archiveErr := archiveOriginal(taskContext, record)
+	cancelTask()
+	if archiveErr != nil {
+		if err := markOriginalArchiveFailed(record.ID, archiveErr); err != nil {
+			log.Printf("[original-archive] task %d failed and status update failed: %v (upload error: %v)", record.ID, err, archiveErr)
+		} else {
+			log.Printf("[original-archive] task %d failed: %v", record.ID, archiveErr)
+		}
+		return true
+	}
+
+	localPath, err := canonicalLocalPath(record.Path)
+	if err == nil {
+		err = os.Remove(localPath)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[original-archive] task %d archived but local staging copy was kept: %v", record.ID, err)
+	}
+	log.Printf("[original-archive] image %d original archived to bucket %d (%s)", record.ImageID, record.BucketID, record.Storage)
+	return true
+}
+
+func archiveOriginal(ctx context.Context, record models.ImageOriginal) error {
+	db := database.GetDB().DB
+
+	var bucket models.Buckets
+	if err := db.First(&bucket, record.BucketID).Error; err != nil {
+		return fmt.Errorf("load bucket %d: %w", record.BucketID, err)
+	}
+	localPath, err := canonicalLocalPath(record.Path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("stat staged original %q: %w", localPath, err)
+	}
+	artifact := localStorageArtifact{
+		MainPath: localPath,
+		URL:      record.Path,
+		FileName: record.FileName,
+		MimeType: record.MimeType,
+		FileSize: info.Size(),
+	}
+	if err := checkStorageCapacity(bucket, artifact.FileSize); err != nil {
+		return err
+	}
+
+	var metadata map[string]any
+	switch bucket.Type {
+	case "default":
+	case "s3", "r2":
+		err = uploadArtifactToS3(ctx, bucket, artifact)
+	case "webdav":
+		err = uploadArtifactToWebDAV(ctx, bucket, artifact)
+	case "ftp":
+		err = uploadArtifactToFTP(bucket, artifact)
+	case "telegram":
+		metadata, err = uploadArtifactToTelegram(bucket, artifact)
+	default:
+		err = fmt.Errorf("unsupported storage type %q", bucket.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	metadataValue, err := storageMetadataValue(metadata)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if bucket.Type != "default" && artifact.FileSize > 0 {
+			size := uint64(artifact.FileSize)
+			usageUpdate := tx.Model(&models.Buckets{}).
+				Where("id = ? AND (capacity = 0 OR type IN ('telegram','default') OR usage + ? <= capacity)", bucket.Id, size).
+				UpdateColumn("usage", gorm.Expr("usage + ?", size))
+			if usageUpdate.Error != nil {
+				return usageUpdate.Error
+			}
+			if usageUpdate.RowsAffected == 0 {
+				return fmt.Errorf("bucket %d has insufficient capacity", bucket.Id)
+			}
+		}
+		result := tx.Model(&models.ImageOriginal{}).
+			Where("id = ? AND status = ? AND lease_owner = ?", record.ID, models.ImageStorageStatusUploading, StorageWorkerID()).
+			Updates(map[string]any{
+				"storage":          bucket.Type,
+				"status":           models.ImageStorageStatusSuccess,
+				"file_size":        artifact.FileSize,
+				"error":            "",
+				"metadata":         metadataValue,
+				"next_retry_at":    nil,
+				"archived_at":      &now,
+				"lease_owner":      "",
+				"lease_expires_at": nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("task %d was modified before completion", record.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		record.Metadata = metadata
+		cleanupContext, cancelCleanup := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancelCleanup()
+		if cleanupErr := deleteArchivedOriginal(cleanupContext, bucket, record); cleanupErr != nil {
+			log.Printf("[original-archive] cleanup after failed completion also failed (task=%d): %v", record.ID, cleanupErr)
+		}
+	}
+	return err
+}
+
+func markOriginalArchiveFailed(recordID int, archiveErr error) error {
+	db := database.GetDB().DB
+	return db.Transaction(func(tx *gorm.DB) error {
+		var current models.ImageOriginal
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, recordID).Error; err != nil {
+			return err
+		}
+		if current.Status != models.ImageStorageStatusUploading || current.LeaseOwner != StorageWorkerID() {
+			return nil
+		}
+
+		attempts := current.RetryCount + 1
+		status, nextRetryAt := loadStorageRetryPolicy().next(attempts)
+		return tx.Model(&models.ImageOriginal{}).
+			Where("id = ? AND status = ? AND lease_owner = ?", recordID, models.ImageStorageStatusUploading, StorageWorkerID()).
+			Updates(map[string]any{
+				"status":           status,
+				"error":            archiveErr.Error(),
+				"retry_count":      attempts,
+				"next_retry_at":    nextRetryAt,
+				"lease_owner":      "",
+				"lease_expires_at": nil,
+			}).Error
+	})
+}
+
+// DeleteImageOriginal removes the archived original of an image, including a
+// staging copy that was never uploaded, and releases the bucket usage.
+func DeleteImageOriginal(ctx context.Context, imageID int) error {
+	unlock := lockImageOperations(imageID)
+	defer unlock()
+	return deleteImageOriginalLocked(ctx, database.GetDB().DB, imageID)
+}
+
+func deleteImageOriginalLocked(ctx context.Context, db *gorm.DB, imageID int) error {
+	var record models.ImageOriginal
+	if err := db.Where("image_id = ?", imageID).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	var bucket models.Buckets
+	if err := db.First(&bucket, record.BucketID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if record.Status == models.ImageStorageStatusSuccess && bucket.Id != 0 {
+		if err := deleteArchivedOriginal(ctx, bucket, record); err != nil {
+			return fmt.Errorf("delete archived original of image %d: %w", imageID, err)
+		}
+	}
+	if localPath, err := canonicalLocalPath(record.Path); err == nil {
+		if err := os.Remove(localPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if record.Status == models.ImageStorageStatusSuccess && bucket.Id != 0 && bucket.Type != "default" {
+			if err := ReleaseBucketUsage(tx, bucket.Id, record.FileSize); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&models.ImageOriginal{}, record.ID).Error
+	})
+}
+
+// QueueImageOriginalDeletion removes the archived original of an image like
+// DeleteImageOriginal, but hands an uploaded remote copy to the replica
+// deletion queue instead of deleting it inline.
+func QueueImageOriginalDeletion(imageID int) error {
+	unlock := lockImageOperations(imageID)
+	defer unlock()
+
+	db := database.GetDB().DB
+	var record models.ImageOriginal
+	if err := db.Where("image_id = ?", imageID).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	var bucket models.Buckets
+	if err := db.First(&bucket, record.BucketID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	remote := record.Status == models.ImageStorageStatusSuccess && bucket.Id != 0 && bucket.Type != "default"
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if remote {
+			task := models.ReplicaDeletion{
+				ImageID:  imageID,
+				BucketID: bucket.Id,
+				Storage:  bucket.Type,
+				Kind:     models.ReplicaDeletionKindOriginal,
+				Status:   models.ImageStorageStatusPending,
+				URL:      record.Path,
+				FileName: record.FileName,
+				Metadata: record.Metadata,
+			}
+			if err := tx.Create(&task).Error; err != nil {
+				return err
+			}
+			if err := ReleaseBucketUsage(tx, bucket.Id, record.FileSize); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&models.ImageOriginal{}, record.ID).Error
+	})
+	if err != nil {
+		return err
+	}
+	if remote {
+		WakeReplicaDeletionWorker()
+	}
+	if localPath, err := canonicalLocalPath(record.Path); err == nil {
+		if err := os.Remove(localPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[original-archive] failed to remove local copy of original %d: %v", record.ID, err)
+		}
+	}
+	return nil
+}
+
+func deleteArchivedOriginal(ctx context.Context, bucket models.Buckets, record models.ImageOriginal) error {
+	// Reuse the replica deletion helpers with an empty thumbnail path. The
+	// image name is the archive file so legacy Telegram lookups never match.
+	replica := models.ImageStorage{
+		ImageID:  record.ImageID,
+		BucketID: bucket.Id,
+		Storage:  bucket.Type,
+		URL:      record.Path,
+		Metadata: record.Metadata,
+	}
+	image := models.Image{Id: record.ImageID, Url: record.Path, FileName: record.FileName}
+	if bucket.Type == "default" {
+		return nil
+	}
+	return deleteRemoteReplica(ctx, image, bucket, replica)
+}
+
+// originalArchivePath returns <image dir>/originals/<image name><original ext>.
+func originalArchivePath(imageURL, mimeType string) string {
+	dir, fileName := path.Split(strings.TrimSpace(imageURL))
+	baseName := strings.TrimSuffix(fileName, path.Ext(fileName))
+	ext, ok := originalArchiveExtensions[mimeType]
+	if !ok {
+		ext = path.Ext(fileName)
+	}
+	return "/" + strings.TrimLeft(dir+"originals/"+baseName+ext, "/")
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestStageOriginalArchiveKeepsAndDeletesOriginal(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+
+	buckets := []models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{"storagePath": "/uploads"}},
+		{Id: 2, Name: "archive", Type: "s3", Capacity: 1024, Config: map[string]any{}},
+	}
+	if err := db.Create(&buckets).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	localImage := models.Image{Id: 1, Url: "/uploads/2026/photo.webp", FileName: "photo.webp", Storage: "default", BucketId: 1, UserId: 1}
+	remoteImage := models.Image{Id: 2, Url: "/uploads/2026/other.webp", FileName: "other.webp", Storage: "default", BucketId: 1, UserId: 1}
+	if err := db.Create(&[]models.Image{localImage, remoteImage}).Error; err != nil {
+		t.Fatalf("create images: %v", err)
+	}
+
+	original := []byte("original jpeg bytes")
+	if err := StageOriginalArchive(localImage, original, "image/jpeg", 8000, 6000, 1, false); err != nil {
+		t.Fatalf("stage local original: %v", err)
+	}
+	var record models.ImageOriginal
+	if err := db.Where("image_id = ?", localImage.Id).First(&record).Error; err != nil {
+		t.Fatalf("query original: %v", err)
+	}
+	if record.Path != "/uploads/2026/originals/photo.jpg" || record.Status != models.ImageStorageStatusSuccess ||
+		record.Width != 8000 || record.Height != 6000 || record.FileSize != int64(len(original)) || record.ArchivedAt == nil {
+		t.Fatalf("unexpected local original record: %+v", record)
+	}
+	stored, err := os.ReadFile(filepath.Join("uploads", "2026", "originals", "photo.jpg"))
+	if err != nil || string(stored) != string(original) {
+		t.Fatalf("original file not kept: %q, %v", stored, err)
+	}
+
+	if err := StageOriginalArchive(remoteImage, original, "image/png", 5000, 5000, 2, false); err != nil {
+		t.Fatalf("stage remote original: %v", err)
+	}
+	var pending models.ImageOriginal
+	if err := db.Where("image_id = ?", remoteImage.Id).First(&pending).Error; err != nil {
+		t.Fatalf("query pending original: %v", err)
+	}
+	if pending.Status != models.ImageStorageStatusPending || pending.Path != "/uploads/2026/originals/other.png" {
+		t.Fatalf("remote archive should wait for the worker: %+v", pending)
+	}
+
+	if err := DeleteImageOriginal(context.Background(), localImage.Id); err != nil {
+		t.Fatalf("delete local original: %v", err)
+	}
+	if err := DeleteImageOriginal(context.Background(), remoteImage.Id); err != nil {
+		t.Fatalf("delete pending original: %v", err)
+	}
+	var remaining int64
+	if err := db.Model(&models.ImageOriginal{}).Count(&remaining).Error; err != nil {
+		t.Fatalf("count originals: %v", err)
+	}
+	if remaining != 0 {
+		t.Fatalf("original records should be removed, got %d", remaining)
+	}
+	for _, name := range []string{"photo.jpg", "other.png"} {
+		if _, err := os.Stat(filepath.Join("uploads", "2026", "originals", name)); !os.IsNotExist(err) {
+			t.Fatalf("staged original %s should be removed, stat err=%v", name, err)
+		}
+	}
+}
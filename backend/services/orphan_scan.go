@@ -0,0 +1,378 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/utils/buckets"
+	"oneimg/backend/utils/ftp"
+	storageS3 "oneimg/backend/utils/s3"
+	storageSettings "oneimg/backend/utils/settings"
+	"oneimg/backend/utils/webdav"
+
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultOrphanGracePeriod is how old an unreferenced file must be before
+	// it counts as an orphan. Younger files may belong to an upload whose
+	// database commit has not happened yet.
+	DefaultOrphanGracePeriod = 24 * time.Hour
+	MinOrphanGracePeriod     = time.Hour
+
+	maxReportedOrphans = 1000
+)
+
+var ErrOrphanScanUnsupported = errors.New("objects of this storage type cannot be listed")
+
+// OrphanObject is a stored file that no database row references.
+type OrphanObject struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// OrphanScanResult summarizes the unreferenced files under a prefix of one
+// bucket. Recent counts unreferenced files still inside the grace period;
+// they are never reported or deleted.
+type OrphanScanResult struct {
+	BucketID    int            `json:"bucket_id"`
+	Prefix      string         `json:"prefix"`
+	GraceHours  float64        `json:"grace_hours"`
+	Scanned     int64          `json:"scanned"`
+	Recent      int64          `json:"recent"`
+	OrphanCount int64          `json:"orphan_count"`
+	OrphanBytes int64          `json:"orphan_bytes"`
+	Orphans     []OrphanObject `json:"orphans"`
+	Truncated   bool           `json:"truncated"`
+}
+
+// OrphanDeleteResult reports what a confirmed cleanup did with each path.
+// Skipped paths were no longer orphans when the bucket was scanned again.
+type OrphanDeleteResult struct {
+	Deleted []string          `json:"deleted"`
+	Skipped []string          `json:"skipped"`
+	Failed  map[string]string `json:"failed"`
+}
+
+// OrphanScanPrefix normalizes the prefix to scan. An empty prefix falls back
+// to the fixed leading directories of the configured upload path, so other
+// data sharing the bucket is not scanned.
+func OrphanScanPrefix(prefix string) (string, error) {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		uploadPath := "uploads/{year}/{month}"
+		if setting, err := storageSettings.GetSettings(); err == nil && strings.TrimSpace(setting.DefaultPath) != "" {
+			uploadPath = setting.DefaultPath
+		}
+		if index := strings.IndexByte(uploadPath, '{'); index >= 0 {
+			uploadPath = uploadPath[:index]
+			if slash := strings.LastIndexByte(uploadPath, '/'); slash >= 0 {
+				uploadPath = uploadPath[:slash]
+			} else {
+				uploadPath = ""
+			}
+		}
+		prefix = uploadPath
+	}
+	prefix = normalizeStoragePath(prefix)
+	if prefix == "" || prefix == "." || prefix == ".." || strings.HasPrefix(prefix, "../") {
+		return "", errors.New("a non-empty upload prefix is required")
+	}
+	return prefix, nil
+}
+
+// ScanBucketOrphans lists the files under prefix in bucket and reports those
+// that no image, replica, profile thumbnail or archived original references
+// and that are older than the grace period.
+func ScanBucketOrphans(ctx context.Context, bucket models.Buckets, prefix string, grace time.Duration) (OrphanScanResult, error) {
+	result, orphans, err := scanBucketOrphans(ctx, database.GetDB().DB, bucket, prefix, grace)
+	if err != nil {
+		return result, err
+	}
+	if len(orphans) > maxReportedOrphans {
+		orphans = orphans[:maxReportedOrphans]
+		result.Truncated = true
+	}
+	result.Orphans = orphans
+	return result, nil
+}
+
+// DeleteBucketOrphans deletes the given paths from bucket. The bucket is
+// scanned again first and only paths that are still orphans outside the grace
+// period are deleted.
+func DeleteBucketOrphans(ctx context.Context, bucket models.Buckets, prefix string, paths []string, grace time.Duration) (OrphanDeleteResult, error) {
+	result := OrphanDeleteResult{Deleted: []string{}, Skipped: []string{}, Failed: map[string]string{}}
+	_, orphans, err := scanBucketOrphans(ctx, database.GetDB().DB, bucket, prefix, grace)
+	if err != nil {
+		return result, err
+	}
+	current := make(map[string]bool, len(orphans))
+	for _, orphan := range orphans {
+		current[normalizeStoragePath(orphan.Path)] = true
+	}
+
+	for _, requested := range paths {
+		key := normalizeStoragePath(requested)
+		if !current[key] {
+			result.Skipped = append(result.Skipped, requested)
+			continue
+		}
+		if err := deleteBucketObject(ctx, bucket, "/"+key); err != nil {
+			result.Failed[requested] = err.Error()
+			continue
+		}
+		delete(current, key)
+		result.Deleted = append(result.Deleted, requested)
+		log.Printf("[orphan-scan] deleted orphan /%s from bucket %d", key, bucket.Id)
+	}
+	return result, nil
+}
+
+func scanBucketOrphans(ctx context.Context, db *gorm.DB, bucket models.Buckets, prefix string, grace time.Duration) (OrphanScanResult, []OrphanObject, error) {
+	if grace < MinOrphanGracePeriod {
+		grace = MinOrphanGracePeriod
+	}
+	result := OrphanScanResult{BucketID: bucket.Id, Prefix: prefix, GraceHours: grace.Hours()}
+
+	objects, err := listBucketObjects(ctx, bucket, prefix)
+	if err != nil {
+		return result, nil, err
+	}
+	// Load references after listing so a file committed in between is
+	// referenced rather than reported.
+	referenced, err := referencedBucketPaths(db, bucket)
+	if err != nil {
+		return result, nil, err
+	}
+
+	cutoff := time.Now().Add(-grace)
+	var orphans []OrphanObject
+	for _, object := range objects {
+		result.Scanned++
+		key := normalizeStoragePath(object.Path)
+		if referenced[key] {
+			continue
+		}
+		// Files of unknown age are treated as recent.
+		if object.ModifiedAt.IsZero() || object.ModifiedAt.After(cutoff) {
+			result.Recent++
+			continue
+		}
+		object.Path = "/" + key
+		orphans = append(orphans, object)
+		result.OrphanCount++
+		result.OrphanBytes += object.Size
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].Path < orphans[j].Path })
+	return result, orphans, nil
+}
+
+// pathReference names the path columns of a query over referencing rows.
+type pathReference struct {
+	query   *gorm.DB
+	columns []string
+}
+
+// referencedBucketPaths collects every path the database expects in bucket.
+// It errs on the side of keeping files: the local bucket also protects every
+// image path, profile thumbnail and staged original.
+func referencedBucketPaths(db *gorm.DB, bucket models.Buckets) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	queries := []pathReference{
+		{db.Model(&models.ImageStorage{}).Where("bucket_id = ?", bucket.Id), []string{"url", "thumbnail"}},
+		// Files queued for deletion belong to the deletion worker.
+		{db.Model(&models.ReplicaDeletion{}).Where("bucket_id = ?", bucket.Id), []string{"url", "thumbnail"}},
+	}
+	if bucket.Type == "default" {
+		queries = append(queries,
+			pathReference{db.Unscoped().Model(&models.Image{}), []string{"url", "thumbnail"}},
+			pathReference{db.Model(&models.ImageThumbnail{}), []string{"path"}},
+			pathReference{db.Model(&models.ImageOriginal{}), []string{"path"}},
+		)
+	} else {
+		queries = append(queries,
+			pathReference{db.Unscoped().Model(&models.Image{}).Where("bucket_id = ?", bucket.Id), []string{"url", "thumbnail"}},
+			pathReference{db.Model(&models.ImageOriginal{}).Where("bucket_id = ?", bucket.Id), []string{"path"}},
+		)
+	}
+
+	for _, source := range queries {
+		for _, column := range source.columns {
+			var values []string
+			if err := source.query.Session(&gorm.Session{}).Where(column+" <> ''").Pluck(column, &values).Error; err != nil {
+				return nil, err
+			}
+			for _, value := range values {
+				referenced[normalizeStoragePath(value)] = true
+			}
+		}
+	}
+	return referenced, nil
+}
+
+// listBucketObjects lists every file under prefix. A missing prefix yields
+// an empty list.
+func listBucketObjects(ctx context.Context, bucket models.Buckets, prefix string) ([]OrphanObject, error) {
+	switch bucket.Type {
+	case "default":
+		root, err := canonicalLocalPath(prefix)
+		if err != nil {
+			return nil, err
+		}
+		base, err := filepath.Abs(".")
+		if err != nil {
+			return nil, err
+		}
+		var objects []OrphanObject
+		err = filepath.WalkDir(root, func(current string, entry fs.DirEntry, walkErr error) error {
+			if walkErr != nil {
+				if errors.Is(walkErr, fs.ErrNotExist) && current == root {
+					return fs.SkipAll
+				}
+				return walkErr
+			}
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			relative, err := filepath.Rel(base, current)
+			if err != nil {
+				return err
+			}
+			objects = append(objects, OrphanObject{Path: filepath.ToSlash(relative), Size: info.Size(), ModifiedAt: info.ModTime()})
+			return nil
+		})
+		return objects, err
+	case "s3", "r2":
+		setting, err := storageSettings.GetSettings()
+		if err != nil {
+			return nil, err
+		}
+		client, err := storageS3.NewS3Client(setting, bucket)
+		if err != nil {
+			return nil, err
+		}
+		remoteBucket := buckets.ConvertToS3Bucket(bucket.Config).S3Bucket
+		if bucket.Type == "r2" {
+			remoteBucket = buckets.ConvertToR2Bucket(bucket.Config).R2Bucket
+		}
+		var objects []OrphanObject
+		for object := range client.ListObjects(ctx, remoteBucket, minio.ListObjectsOptions{Prefix: prefix + "/", Recursive: true}) {
+			if object.Err != nil {
+				return nil, fmt.Errorf("list objects: %w", object.Err)
+			}
+			if strings.HasSuffix(object.Key, "/") {
+				continue
+			}
+			objects = append(objects, OrphanObject{Path: object.Key, Size: object.Size, ModifiedAt: object.LastModified})
+		}
+		return objects, nil
+	case "webdav":
+		config := buckets.ConvertToWebDavBucket(bucket.Config)
+		client := webdav.Client(webdav.Config{
+			BaseURL:  config.WebdavURL,
+			Username: config.WebdavUser,
+			Password: config.WebdavPass,
+			Timeout:  time.Minute,
+		})
+		var objects []OrphanObject
+		pending := []string{prefix}
+		seen := map[string]bool{prefix: true}
+		for len(pending) > 0 {
+			dir := pending[0]
+			pending = pending[1:]
+			entries, err := client.WebDAVList(ctx, dir)
+			if err != nil {
+				return nil, fmt.Errorf("list %s: %w", dir, err)
+			}
+			for _, entry := range entries {
+				if entry.IsDir {
+					// Guard against servers returning parents or loops.
+					if !seen[entry.Path] && strings.HasPrefix(entry.Path, prefix+"/") {
+						seen[entry.Path] = true
+						pending = append(pending, entry.Path)
+					}
+					continue
+				}
+				objects = append(objects, OrphanObject{Path: entry.Path, Size: entry.Size, ModifiedAt: entry.ModifiedAt})
+			}
+		}
+		return objects, nil
+	case "ftp":
+		config := buckets.ConvertToFTPBucket(bucket.Config)
+		client := ftp.NewFTPUtil(ftp.FTPConfig{
+			Host:     config.FTPHost,
+			Port:     config.FTPPort,
+			User:     config.FTPUser,
+			Password: config.FTPPass,
+			Timeout:  30,
+		})
+		defer client.Close()
+		files, err := client.ListFiles("/" + prefix)
+		if err != nil {
+			if isMissingRemoteFileError(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		objects := make([]OrphanObject, 0, len(files))
+		for _, file := range files {
+			objects = append(objects, OrphanObject{Path: file.Path, Size: file.Size, ModifiedAt: file.ModifiedAt})
+		}
+		return objects, nil
+	default:
+		return nil, ErrOrphanScanUnsupported
+	}
+}
+
+func deleteBucketObject(ctx context.Context, bucket models.Buckets, publicPath string) error {
+	switch bucket.Type {
+	case "default":
+		localPath, err := canonicalLocalPath(publicPath)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(localPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	case "s3", "r2":
+		return deleteS3Replica(ctx, bucket, publicPath, "")
+	case "webdav":
+		return deleteWebDAVReplica(ctx, bucket, publicPath, "")
+	case "ftp":
+		return deleteFTPReplica(bucket, publicPath, "")
+	default:
+		return ErrOrphanScanUnsupported
+	}
+}
+
+// normalizeStoragePath turns stored URLs and listed keys into one comparable
+// form: slash separated, without query string or leading slash.
+func normalizeStoragePath(value string) string {
+	value = strings.TrimSpace(strings.ReplaceAll(value, "\\", "/"))
+	if index := strings.IndexByte(value, '?'); index >= 0 {
+		value = value[:index]
+	}
+	value = strings.TrimPrefix(value, "/")
+	if value == "" {
+		return ""
+	}
+	return path.Clean(value)
+}
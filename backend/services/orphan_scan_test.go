@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestLocalOrphanScanAndConfirmedDelete(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+
+	local := models.Buckets{Id: 1, Name: "local", Type: "default", Config: map[string]any{}}
+	if err := db.Create(&local).Error; err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	files := map[string]time.Time{
+		"uploads/2026/01/kept.webp":            old,
+		"uploads/2026/01/thumbnails/kept.webp": old,
+		"uploads/2026/01/small/kept.webp":      old,
+		"uploads/2026/01/orphan.webp":          old,
+		"uploads/2026/01/fresh.webp":           time.Now(),
+		"other/unrelated.webp":                 old,
+	}
+	for name, modified := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	image := models.Image{Url: "/uploads/2026/01/kept.webp", Thumbnail: "/uploads/2026/01/thumbnails/kept.webp", FileName: "kept.webp"}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	if err := db.Create(&models.ImageThumbnail{ImageId: image.Id, Profile: "small", Path: "/uploads/2026/01/small/kept.webp"}).Error; err != nil {
+		t.Fatalf("create profile thumbnail: %v", err)
+	}
+
+	prefix, err := OrphanScanPrefix("")
+	if err != nil || prefix != "uploads" {
+		t.Fatalf("expected default prefix uploads, got %q (%v)", prefix, err)
+	}
+	scan, err := ScanBucketOrphans(context.Background(), local, prefix, DefaultOrphanGracePeriod)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if scan.Scanned != 5 || scan.Recent != 1 || scan.OrphanCount != 1 || len(scan.Orphans) != 1 || scan.Orphans[0].Path != "/uploads/2026/01/orphan.webp" {
+		t.Fatalf("unexpected scan result: %+v", scan)
+	}
+
+	deleted, err := DeleteBucketOrphans(context.Background(), local, prefix,
+		[]string{"/uploads/2026/01/orphan.webp", "/uploads/2026/01/kept.webp", "/uploads/2026/01/fresh.webp"}, DefaultOrphanGracePeriod)
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if len(deleted.Deleted) != 1 || len(deleted.Skipped) != 2 || len(deleted.Failed) != 0 {
+		t.Fatalf("unexpected delete result: %+v", deleted)
+	}
+	if _, err := os.Stat("uploads/2026/01/orphan.webp"); !os.IsNotExist(err) {
+		t.Fatalf("expected orphan to be removed, got %v", err)
+	}
+	for _, kept := range []string{"uploads/2026/01/kept.webp", "uploads/2026/01/fresh.webp", "other/unrelated.webp"} {
+		if _, err := os.Stat(kept); err != nil {
+			t.Fatalf("expected %s to be kept: %v", kept, err)
+		}
+	}
+
+	if _, err := OrphanScanPrefix("../etc"); err == nil {
+		t.Fatal("expected prefix outside the upload root to be rejected")
+	}
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	replicaDeletionStartOnce sync.Once
+	replicaDeletionWake      = make(chan struct{}, 1)
+)
+
+// queueReplicaDeletion records a deletion task for the files of a remote
+// replica. It must run in the transaction that removes the replica row.
+func queueReplicaDeletion(tx *gorm.DB, image models.Image, bucket models.Buckets, replica models.ImageStorage) error {
+	task := newReplicaDeletion(image, bucket, replica)
+	return tx.Create(&task).Error
+}
+
+func newReplicaDeletion(image models.Image, bucket models.Buckets, replica models.ImageStorage) models.ReplicaDeletion {
+	task := models.ReplicaDeletion{
+		ImageID:   image.Id,
+		BucketID:  bucket.Id,
+		Storage:   bucket.Type,
+		Kind:      models.ReplicaDeletionKindReplica,
+		Status:    models.ImageStorageStatusPending,
+		URL:       replica.URL,
+		Thumbnail: replica.Thumbnail,
+		FileName:  image.FileName,
+		Metadata:  replica.Metadata,
+	}
+	if task.URL == "" {
+		task.URL = image.Url
+	}
+	if task.Thumbnail == "" {
+		task.Thumbnail = image.Thumbnail
+	}
+	return task
+}
+
+// StartReplicaDeletionWorker starts the background worker that deletes
+// queued remote files. Tasks whose lease has expired are returned to pending
+// first.
+func StartReplicaDeletionWorker() {
+	replicaDeletionStartOnce.Do(func() {
+		db := database.GetDB()
+		if db == nil || db.DB == nil {
+			log.Printf("[replica-deletion] database is not initialized; worker not started")
+			return
+		}
+		reclaimExpiredReplicaDeletionLeases(db.DB)
+		go runReplicaDeletionWorker()
+	})
+	WakeReplicaDeletionWorker()
+}
+
+func reclaimExpiredReplicaDeletionLeases(db *gorm.DB) {
+	reclaimed, err := reclaimExpiredStorageLeases(db, &models.ReplicaDeletion{}, nil)
+	if err != nil {
+		log.Printf("[replica-deletion] failed to recover interrupted tasks: %v", err)
+	} else if reclaimed > 0 {
+		log.Printf("[replica-deletion] recovered %d interrupted task(s)", reclaimed)
+		WakeReplicaDeletionWorker()
+	}
+}
+
+// WakeReplicaDeletionWorker asks the deletion worker to poll immediately.
+func WakeReplicaDeletionWorker() {
+	select {
+	case replicaDeletionWake <- struct{}{}:
+	default:
+	}
+}
+
+func runReplicaDeletionWorker() {
+	ticker := time.NewTicker(storageSyncPollInterval)
+	defer ticker.Stop()
+	lastReclaim := time.Now()
+
+	for {
+		if time.Since(lastReclaim) >= storageLeaseHeartbeat {
+			lastReclaim = time.Now()
+			if db := database.GetDB(); db != nil && db.DB != nil {
+				reclaimExpiredReplicaDeletionLeases(db.DB)
+			}
+		}
+		for processNextReplicaDeletion() {
+		}
+
+		select {
+		case <-replicaDeletionWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// processNextReplicaDeletion claims and runs one due task. It reports whether
+// the queue may hold more work.
+func processNextReplicaDeletion() bool {
+	db := database.GetDB()
+	if db == nil || db.DB == nil {
+		return false
+	}
+
+	var task models.ReplicaDeletion
+	lookup := db.DB.Where(
+		"status = ? AND (next_retry_at IS NULL OR next_retry_at <= ?) AND "+
+			"NOT EXISTS (SELECT 1 FROM buckets WHERE buckets.id = replica_deletions.bucket_id AND (buckets.disabled = ? OR buckets.sync_paused = ?))",
+		models.ImageStorageStatusPending, time.Now(), true, true,
+	).
+		Order("id ASC").
+		Limit(1).
+		Find(&task)
+	if lookup.Error != nil {
+		log.Printf("[replica-deletion] failed to find pending task: %v", lookup.Error)
+		return false
+	}
+	if lookup.RowsAffected == 0 {
+		return false
+	}
+
+	claim := db.DB.Model(&models.ReplicaDeletion{}).
+		Where("id = ? AND status = ?", task.ID, models.ImageStorageStatusPending).
+		Updates(storageLeaseClaim(time.Now()))
+	if claim.Error != nil {
+		log.Printf("[replica-deletion] failed to claim task %d: %v", task.ID, claim.Error)
+		return false
+	}
+	if claim.RowsAffected == 0 {
+		return true
+	}
+
+	leaseContext, releaseLease := holdStorageLease(db.DB, &models.ReplicaDeletion{}, task.ID, "replica-deletion")
+	defer releaseLease()
+
+	taskContext, cancelTask := context.WithTimeout(leaseContext, 5*time.Minute)
+	deleteErr := runReplicaDeletion(taskContext, db.DB, task)
+	cancelTask()
+	if deleteErr != nil {
+		if err := markReplicaDeletionFailed(task.ID, deleteErr); err != nil {
+			log.Printf("[replica-deletion] task %d failed and status update failed: %v (delete error: %v)", task.ID, err, deleteErr)
+		} else {
+			log.Printf("[replica-deletion] task %d failed: %v", task.ID, deleteErr)
+		}
+		return true
+	}
+	if err := db.DB.Where("id = ? AND lease_owner = ?", task.ID, StorageWorkerID()).Delete(&models.ReplicaDeletion{}).Error; err != nil {
+		log.Printf("[replica-deletion] task %d finished but could not be removed: %v", task.ID, err)
+	}
+	return true
+}
+
+// runReplicaDeletion deletes the files of one task. A bucket that no longer
+// exists took its files with it, so there is nothing left to do.
+func runReplicaDeletion(ctx context.Context, db *gorm.DB, task models.ReplicaDeletion) error {
+	var bucket models.Buckets
+	if err := db.First(&bucket, task.BucketID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("load bucket %d: %w", task.BucketID, err)
+	}
+	image := models.Image{Id: task.ImageID, Url: task.URL, Thumbnail: task.Thumbnail, FileName: task.FileName}
+	replica := models.ImageStorage{
+		ImageID:   task.ImageID,
+		BucketID:  bucket.Id,
+		Storage:   bucket.Type,
+		URL:       task.URL,
+		Thumbnail: task.Thumbnail,
+		Metadata:  task.Metadata,
+	}
+	return deleteRemoteReplica(ctx, image, bucket, replica)
+}
+
+func markReplicaDeletionFailed(taskID int, deleteErr error) error {
+	db := database.GetDB().DB
+	return db.Transaction(func(tx *gorm.DB) error {
+		var current models.ReplicaDeletion
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, taskID).Error; err != nil {
+			return err
+		}
+		if current.Status != models.ImageStorageStatusUploading || current.LeaseOwner != StorageWorkerID() {
+			return nil
+		}
+
+		attempts := current.RetryCount + 1
+		status, nextRetryAt := loadStorageRetryPolicy().next(attempts)
+		return tx.Model(&models.ReplicaDeletion{}).
+			Where("id = ? AND status = ? AND lease_owner = ?", taskID, models.ImageStorageStatusUploading, StorageWorkerID()).
+			Updates(map[string]any{
+				"status":           status,
+				"error":            deleteErr.Error(),
+				"retry_count":      attempts,
+				"next_retry_at":    nextRetryAt,
+				"lease_owner":      "",
+				"lease_expires_at": nil,
+			}).Error
+	})
+}
+
+// RetryReplicaDeletions sends dead-lettered deletion tasks back to the
+// queue, all of them when taskID is zero.
+func RetryReplicaDeletions(taskID int) (int64, error) {
+	query := database.GetDB().DB.Model(&models.ReplicaDeletion{}).
+		Where("status = ?", models.ImageStorageStatusDeadLetter)
+	if taskID > 0 {
+		query = query.Where("id = ?", taskID)
+	}
+	result := query.Updates(map[string]any{
+		"status":        models.ImageStorageStatusPending,
+		"retry_count":   0,
+		"next_retry_at": nil,
+	})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected > 0 {
+		WakeReplicaDeletionWorker()
+	}
+	return result.RowsAffected, nil
+}
+
+// DrainBucketDeletions runs every queued deletion of one bucket right away,
+// regardless of retry delays. It is used before the bucket itself is
+// removed, after which its tasks could no longer reach the files.
+func DrainBucketDeletions(ctx context.Context, bucket models.Buckets) error {
+	db := database.GetDB().DB
+	var tasks []models.ReplicaDeletion
+	if err := db.Where("bucket_id = ? AND status <> ?", bucket.Id, models.ImageStorageStatusUploading).
+		Order("id ASC").Find(&tasks).Error; err != nil {
+		return err
+	}
+	var deleteErrors []error
+	for _, task := range tasks {
+		if err := runReplicaDeletion(ctx, db, task); err != nil {
+			deleteErrors = append(deleteErrors, fmt.Errorf("deletion task %d: %w", task.ID, err))
+			continue
+		}
+		if err := db.Delete(&models.ReplicaDeletion{}, task.ID).Error; err != nil {
+			deleteErrors = append(deleteErrors, err)
+		}
+	}
+	return errors.Join(deleteErrors...)
+}
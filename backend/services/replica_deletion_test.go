@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestDeleteImageQueuesRemoteReplicaDeletion(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	var failing atomic.Bool
+	failing.Store(true)
+	var deletes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		deletes.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{StorageSyncMaxAttempts: 1}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	if err := db.Create(&[]models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}},
+		{Id: 2, Name: "dav", Type: "webdav", Usage: 150, Config: map[string]any{"webdav_url": server.URL}},
+	}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	image := models.Image{Url: "/uploads/a.webp", FileName: "a.webp", FileSize: 100, Storage: "webdav", BucketId: 2, AccessBucketId: 2}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	if err := db.Create(&models.ImageStorage{ImageID: image.Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: 100}).Error; err != nil {
+		t.Fatalf("create replica: %v", err)
+	}
+
+	// Deleting the image succeeds even though the remote is failing.
+	if err := DeleteImage(image); err != nil {
+		t.Fatalf("delete image: %v", err)
+	}
+	var replicas int64
+	db.Model(&models.ImageStorage{}).Count(&replicas)
+	if replicas != 0 {
+		t.Fatalf("expected replica rows to be removed, got %d", replicas)
+	}
+	var bucket models.Buckets
+	db.First(&bucket, 2)
+	if bucket.Usage != 50 {
+		t.Fatalf("expected usage released at enqueue time, got %d", bucket.Usage)
+	}
+	var task models.ReplicaDeletion
+	if err := db.First(&task).Error; err != nil {
+		t.Fatalf("expected a deletion task: %v", err)
+	}
+	if task.BucketID != 2 || task.URL != image.Url || task.Status != models.ImageStorageStatusPending {
+		t.Fatalf("unexpected task: %+v", task)
+	}
+
+	if !processNextReplicaDeletion() {
+		t.Fatal("expected the task to be processed")
+	}
+	db.First(&task, task.ID)
+	if task.Status != models.ImageStorageStatusDeadLetter || task.RetryCount != 1 || task.Error == "" {
+		t.Fatalf("expected dead-lettered task, got %+v", task)
+	}
+	if processNextReplicaDeletion() {
+		t.Fatal("dead-lettered tasks must not be picked up")
+	}
+
+	failing.Store(false)
+	retried, err := RetryReplicaDeletions(0)
+	if err != nil || retried != 1 {
+		t.Fatalf("retry: %d, %v", retried, err)
+	}
+	if !processNextReplicaDeletion() {
+		t.Fatal("expected the retried task to be processed")
+	}
+	var remaining int64
+	db.Model(&models.ReplicaDeletion{}).Count(&remaining)
+	if remaining != 0 || deletes.Load() == 0 {
+		t.Fatalf("expected task to finish after deleting the file, remaining %d, deletes %d", remaining, deletes.Load())
+	}
+}
+
+func TestReplicaDeletionForRemovedBucketCompletes(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.ReplicaDeletion{ImageID: 1, BucketID: 9, Storage: "webdav", Status: models.ImageStorageStatusPending, URL: "/uploads/gone.webp"}).Error; err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	if !processNextReplicaDeletion() {
+		t.Fatal("expected the task to be processed")
+	}
+	var remaining int64
+	db.Model(&models.ReplicaDeletion{}).Count(&remaining)
+	if remaining != 0 {
+		t.Fatalf("expected task for a removed bucket to complete, got %d left", remaining)
+	}
+}
+
+func TestFailedBucketReplicaDeleteIsNotRetriedAsUpload(t *testing.T) {
+	initStorageSyncTestDB(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	bucket := models.Buckets{Id: 2, Name: "dav", Type: "webdav", Usage: 100, Config: map[string]any{"webdav_url": server.URL}}
+	if err := db.Create(&bucket).Error; err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+	image := models.Image{Url: "/uploads/a.webp", FileName: "a.webp", FileSize: 100, Storage: "default", BucketId: 1}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	if err := db.Create(&models.ImageStorage{ImageID: image.Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: 100}).Error; err != nil {
+		t.Fatalf("create replica: %v", err)
+	}
+
+	if err := DeleteBucketReplicas(context.Background(), bucket); err == nil {
+		t.Fatal("expected the failing remote delete to be reported")
+	}
+	var replicas int64
+	db.Model(&models.ImageStorage{}).Where("bucket_id = ?", 2).Count(&replicas)
+	if replicas != 0 {
+		t.Fatalf("expected the replica row to move to the deletion queue, got %d rows", replicas)
+	}
+	var task models.ReplicaDeletion
+	if err := db.First(&task).Error; err != nil {
+		t.Fatalf("expected a deletion task: %v", err)
+	}
+	if task.BucketID != 2 || task.URL != image.Url || task.Error == "" {
+		t.Fatalf("unexpected task: %+v", task)
+	}
+	retried, err := RetryBucketReplicas(2)
+	if err != nil || retried != 0 {
+		t.Fatalf("upload retry picked up a deleted replica: %d, %v", retried, err)
+	}
+}
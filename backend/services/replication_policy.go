@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"path"
+	"slices"
+	"strings"
+
+	"oneimg/backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReplicationSubject describes an image for replication policy matching.
+type ReplicationSubject struct {
+	UserID   int
+	Role     int
+	Tags     []string
+	MimeType string
+	FileSize int64
+}
+
+// LoadReplicationPolicies returns the enabled policies in evaluation order.
+func LoadReplicationPolicies(db *gorm.DB) ([]models.ReplicationPolicy, error) {
+	var policies []models.ReplicationPolicy
+	err := db.Where("enabled = ?", true).Order("priority ASC, id ASC").Find(&policies).Error
+	return policies, err
+}
+
+// MatchReplicationPolicy returns the first policy whose conditions all match
+// the subject, or nil when none does.
+func MatchReplicationPolicy(policies []models.ReplicationPolicy, subject ReplicationSubject) *models.ReplicationPolicy {
+	for i := range policies {
+		if replicationPolicyMatches(policies[i], subject) {
+			return &policies[i]
+		}
+	}
+	return nil
+}
+
+func replicationPolicyMatches(policy models.ReplicationPolicy, subject ReplicationSubject) bool {
+	if len(policy.Tags) > 0 && !slices.ContainsFunc(subject.Tags, func(tag string) bool {
+		return slices.ContainsFunc(policy.Tags, func(want string) bool { return strings.EqualFold(want, tag) })
+	}) {
+		return false
+	}
+	if len(policy.UserIDs) > 0 && !slices.Contains(policy.UserIDs, subject.UserID) {
+		return false
+	}
+	if len(policy.Roles) > 0 && !slices.Contains(policy.Roles, subject.Role) {
+		return false
+	}
+	if len(policy.MimeTypes) > 0 && !slices.ContainsFunc(policy.MimeTypes, func(pattern string) bool {
+		matched, err := path.Match(strings.ToLower(strings.TrimSpace(pattern)), strings.ToLower(subject.MimeType))
+		return err == nil && matched
+	}) {
+		return false
+	}
+	if policy.MinSize > 0 && subject.FileSize < policy.MinSize {
+		return false
+	}
+	if policy.MaxSize > 0 && subject.FileSize > policy.MaxSize {
+		return false
+	}
+	return true
+}
+
+// ResolveReplicationTargets returns the remote buckets that should receive
+// copies of an upload together with the policy that chose them. Without a
+// matching policy the fallback targets are returned unchanged and the policy
+// is nil.
+func ResolveReplicationTargets(db *gorm.DB, subject ReplicationSubject, fallback []models.Buckets) ([]models.Buckets, *models.ReplicationPolicy, error) {
+	policies, err := LoadReplicationPolicies(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	policy := MatchReplicationPolicy(policies, subject)
+	if policy == nil {
+		return fallback, nil, nil
+	}
+	candidates, err := replicationPolicyBuckets(db, *policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	want := len(candidates)
+	if policy.Replicas > 0 {
+		want = policy.Replicas
+	}
+	if len(candidates) < want {
+		log.Printf("[replication-policy] policy %d wants %d replica(s) but only %d target bucket(s) are available", policy.ID, want, len(candidates))
+		want = len(candidates)
+	}
+	return candidates[:want], policy, nil
+}
+
+// replicationPolicyBuckets loads the enabled remote buckets of a policy in
+// the order the policy lists them.
+func replicationPolicyBuckets(db *gorm.DB, policy models.ReplicationPolicy) ([]models.Buckets, error) {
+	if len(policy.BucketIDs) == 0 {
+		return nil, nil
+	}
+	var found []models.Buckets
+	if err := db.Where("id IN ? AND disabled = ? AND type <> ?", policy.BucketIDs, false, "default").
+		Find(&found).Error; err != nil {
+		return nil, err
+	}
+	buckets := make([]models.Buckets, 0, len(found))
+	for _, id := range policy.BucketIDs {
+		index := slices.IndexFunc(found, func(bucket models.Buckets) bool { return bucket.Id == id })
+		if index >= 0 && !slices.ContainsFunc(buckets, func(bucket models.Buckets) bool { return bucket.Id == id }) {
+			buckets = append(buckets, found[index])
+		}
+	}
+	return buckets, nil
+}
+
+// ApplyReplicationPolicies re-evaluates the policies for existing images,
+// for example after they were tagged, and queues the replicas the matching
+// policy requires but the image does not have yet. Existing replicas are
+// never removed. It returns the number of queued replicas.
+func ApplyReplicationPolicies(db *gorm.DB, imageIDs []int) (int, error) {
+	policies, err := LoadReplicationPolicies(db)
+	if err != nil || len(policies) == 0 {
+		return 0, err
+	}
+	queued := 0
+	for _, imageID := range imageIDs {
+		count, err := applyReplicationPolicy(db, policies, imageID)
+		if err != nil {
+			return queued, err
+		}
+		queued += count
+	}
+	if queued > 0 {
+		WakeStorageSyncWorker()
+	}
+	return queued, nil
+}
+
+func applyReplicationPolicy(db *gorm.DB, policies []models.ReplicationPolicy, imageID int) (int, error) {
+	var image models.Image
+	if err := db.First(&image, imageID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	subject, err := imageReplicationSubject(db, image)
+	if err != nil {
+		return 0, err
+	}
+	policy := MatchReplicationPolicy(policies, subject)
+	if policy == nil {
+		return 0, nil
+	}
+	candidates, err := replicationPolicyBuckets(db, *policy)
+	if err != nil || len(candidates) == 0 {
+		return 0, err
+	}
+
+	unlock := lockImageOperations(imageID)
+	defer unlock()
+	var replicas []models.ImageStorage
+	if err := db.Where("image_id = ?", imageID).Find(&replicas).Error; err != nil {
+		return 0, err
+	}
+	// The sync worker copies new replicas from the local file or from another
+	// verified replica; without either it could only dead-letter them.
+	condition, args := storageSyncSourceCondition()
+	var sources int64
+	if err := db.Model(&models.Image{}).Where("images.id = ?", imageID).Where(condition, args...).Count(&sources).Error; err != nil {
+		return 0, err
+	}
+	if sources == 0 {
+		return 0, nil
+	}
+
+	want := len(candidates)
+	if policy.Replicas > 0 {
+		want = min(policy.Replicas, len(candidates))
+	}
+	have := 0
+	missing := make([]models.Buckets, 0, len(candidates))
+	for _, bucket := range candidates {
+		if slices.ContainsFunc(replicas, func(replica models.ImageStorage) bool { return replica.BucketID == bucket.Id }) {
+			have++
+		} else {
+			missing = append(missing, bucket)
+		}
+	}
+	if have >= want {
+		return 0, nil
+	}
+	missing = missing[:min(want-have, len(missing))]
+
+	rows := make([]models.ImageStorage, 0, len(missing))
+	for _, bucket := range missing {
+		rows = append(rows, models.ImageStorage{
+			ImageID:   imageID,
+			BucketID:  bucket.Id,
+			Storage:   bucket.Type,
+			Status:    models.ImageStorageStatusPending,
+			URL:       image.Url,
+			Thumbnail: image.Thumbnail,
+			FileSize:  image.FileSize,
+		})
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return int(result.RowsAffected), nil
+}
+
+// imageReplicationSubject builds the matching subject of a stored image.
+// Images whose uploader no longer exists are treated as guest uploads.
+func imageReplicationSubject(db *gorm.DB, image models.Image) (ReplicationSubject, error) {
+	subject := ReplicationSubject{UserID: image.UserId, Role: models.RoleGuest, MimeType: image.MimeType, FileSize: image.FileSize}
+	var user models.User
+	err := db.Select("id", "role").First(&user, image.UserId).Error
+	switch {
+	case err == nil:
+		subject.Role = user.Role
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return subject, err
+	}
+	if err := db.Model(&models.Tags{}).
+		Joins("JOIN image_to_tags ON image_to_tags.tag_id = tags.id").
+		Where("image_to_tags.image_id = ?", image.Id).
+		Pluck("tags.name", &subject.Tags).Error; err != nil {
+		return subject, err
+	}
+	return subject, nil
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"testing"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestReplicationPolicyMatching(t *testing.T) {
+	policies := []models.ReplicationPolicy{
+		{ID: 1, Name: "guests", Roles: []int{models.RoleGuest}},
+		{ID: 2, Name: "archive", Tags: []string{"Archive"}, BucketIDs: []int{3, 2}},
+		{ID: 3, Name: "large gifs", MimeTypes: []string{"image/gif"}, MinSize: 1024},
+		{ID: 4, Name: "pngs", MimeTypes: []string{"image/*"}, MaxSize: 10},
+	}
+	cases := []struct {
+		subject ReplicationSubject
+		want    int
+	}{
+		{ReplicationSubject{Role: models.RoleGuest, Tags: []string{"archive"}}, 1},
+		{ReplicationSubject{Role: models.RoleUser, Tags: []string{"misc", "archive"}}, 2},
+		{ReplicationSubject{Role: models.RoleUser, MimeType: "image/gif", FileSize: 2048}, 3},
+		{ReplicationSubject{Role: models.RoleUser, MimeType: "image/png", FileSize: 5}, 4},
+		{ReplicationSubject{Role: models.RoleUser, MimeType: "image/png", FileSize: 50}, 0},
+	}
+	for _, tc := range cases {
+		got := MatchReplicationPolicy(policies, tc.subject)
+		if (got == nil && tc.want != 0) || (got != nil && got.ID != tc.want) {
+			t.Fatalf("subject %+v matched %+v, want policy %d", tc.subject, got, tc.want)
+		}
+	}
+}
+
+func TestReplicationPolicyTargetsAndTagging(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+
+	buckets := []models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}},
+		{Id: 2, Name: "s3", Type: "s3", Config: map[string]any{}},
+		{Id: 3, Name: "telegram", Type: "telegram", Config: map[string]any{}},
+		{Id: 4, Name: "webdav", Type: "webdav", Config: map[string]any{}, Disabled: true},
+	}
+	if err := db.Create(&buckets).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	policies := []models.ReplicationPolicy{
+		{Name: "guests local only", Priority: 1, Enabled: true, Roles: []int{models.RoleGuest}},
+		{Name: "archive", Priority: 2, Enabled: true, Tags: []string{"archive"}, BucketIDs: []int{4, 3, 2}},
+		{Name: "disabled", Priority: 0, Enabled: false},
+	}
+	if err := db.Create(&policies).Error; err != nil {
+		t.Fatalf("create policies: %v", err)
+	}
+	fallback := []models.Buckets{buckets[1]}
+
+	targets, policy, err := ResolveReplicationTargets(db, ReplicationSubject{Role: models.RoleGuest}, fallback)
+	if err != nil || policy == nil || policy.Name != "guests local only" || len(targets) != 0 {
+		t.Fatalf("guest upload should stay local: %v %+v %v", targets, policy, err)
+	}
+	targets, policy, err = ResolveReplicationTargets(db, ReplicationSubject{Role: models.RoleUser, Tags: []string{"archive"}}, fallback)
+	if err != nil || policy == nil || len(targets) != 2 || targets[0].Id != 3 || targets[1].Id != 2 {
+		t.Fatalf("archive upload should go to telegram and s3 in order: %v %+v %v", targets, policy, err)
+	}
+	targets, policy, err = ResolveReplicationTargets(db, ReplicationSubject{Role: models.RoleUser}, fallback)
+	if err != nil || policy != nil || len(targets) != 1 || targets[0].Id != 2 {
+		t.Fatalf("unmatched upload should keep the fallback: %v %+v %v", targets, policy, err)
+	}
+
+	// Tagging an existing image queues the replicas its new policy requires.
+	user := models.User{ID: 2, Role: models.RoleUser, Username: "alice", Password: "x"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	image := models.Image{Url: "/uploads/a.webp", FileName: "a.webp", FileSize: 10, UserId: user.ID}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	replicas := []models.ImageStorage{
+		{ImageID: image.Id, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: 10},
+		{ImageID: image.Id, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: 10},
+	}
+	if err := db.Create(&replicas).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+	tag := models.Tags{Name: "archive"}
+	if err := db.Create(&tag).Error; err != nil {
+		t.Fatalf("create tag: %v", err)
+	}
+	if err := db.Create(&models.ImageToTags{ImageId: image.Id, TagId: tag.Id}).Error; err != nil {
+		t.Fatalf("tag image: %v", err)
+	}
+
+	for range 2 {
+		queued, err := ApplyReplicationPolicies(db, []int{image.Id})
+		if err != nil {
+			t.Fatalf("apply policies: %v", err)
+		}
+		var pending []models.ImageStorage
+		if err := db.Where("image_id = ? AND status = ?", image.Id, models.ImageStorageStatusPending).Find(&pending).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 1 || pending[0].BucketID != 3 || pending[0].URL != image.Url {
+			t.Fatalf("expected one pending telegram replica, got %+v (queued %d)", pending, queued)
+		}
+	}
+}
+
+func TestReplicationPolicyQueuesFromVerifiedRemoteReplicas(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+
+	buckets := []models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}},
+		{Id: 2, Name: "s3", Type: "s3", Config: map[string]any{}},
+		{Id: 3, Name: "telegram", Type: "telegram", Config: map[string]any{}},
+	}
+	if err := db.Create(&buckets).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	if err := db.Create(&models.ReplicationPolicy{Name: "all", Priority: 1, Enabled: true, BucketIDs: []int{2, 3}}).Error; err != nil {
+		t.Fatalf("create policy: %v", err)
+	}
+
+	// Both images were tiered off the local disk; only the first has a
+	// verified replica of the original the worker can copy from.
+	imageList := []models.Image{
+		{Url: "/uploads/a.webp", FileName: "a.webp", FileSize: 10},
+		{Url: "/uploads/b.webp", FileName: "b.webp", FileSize: 11},
+	}
+	if err := db.Create(&imageList).Error; err != nil {
+		t.Fatalf("create images: %v", err)
+	}
+	replicas := []models.ImageStorage{
+		{ImageID: imageList[0].Id, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusSuccess, URL: imageList[0].Url, Checksum: "a-sum"},
+		{ImageID: imageList[1].Id, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusSuccess, URL: imageList[1].Url},
+	}
+	if err := db.Create(&replicas).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+
+	queued, err := ApplyReplicationPolicies(db, []int{imageList[0].Id, imageList[1].Id})
+	if err != nil {
+		t.Fatalf("apply policies: %v", err)
+	}
+	var pending []models.ImageStorage
+	if err := db.Where("status = ?", models.ImageStorageStatusPending).Find(&pending).Error; err != nil {
+		t.Fatal(err)
+	}
+	if queued != 1 || len(pending) != 1 || pending[0].ImageID != imageList[0].Id || pending[0].BucketID != 3 ||
+		pending[0].URL != imageList[0].Url || pending[0].FileSize != 10 {
+		t.Fatalf("expected one pending telegram replica of the verified image, got %+v (queued %d)", pending, queued)
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"oneimg/backend/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// storageLeaseDuration is how long a claim stays valid without a
+	// heartbeat. It must comfortably exceed storageLeaseHeartbeat.
+	storageLeaseDuration  = 2 * time.Minute
+	storageLeaseHeartbeat = 30 * time.Second
+)
+
+var (
+	storageWorkerIDOnce sync.Once
+	storageWorkerIDVal  string
+)
+
+// StorageWorkerID identifies this process in upload leases. It combines the
+// host name, the process ID and a random suffix so restarted or cloned
+// instances never share an ID.
+func StorageWorkerID() string {
+	storageWorkerIDOnce.Do(func() {
+		host, err := os.Hostname()
+		if err != nil || host == "" {
+			host = "oneimg"
+		}
+		storageWorkerIDVal = fmt.Sprintf("%s-%d-%s", host, os.Getpid(), rand.Text()[:8])
+	})
+	return storageWorkerIDVal
+}
+
+// storageLeaseClaim returns the column updates that claim a task for this
+// worker.
+func storageLeaseClaim(now time.Time) map[string]any {
+	expires := now.Add(storageLeaseDuration)
+	return map[string]any{
+		"status":           models.ImageStorageStatusUploading,
+		"lease_owner":      StorageWorkerID(),
+		"lease_expires_at": &expires,
+		"next_retry_at":    nil,
+	}
+}
+
+// holdStorageLease renews the lease on one uploading task until the returned
+// stop function is called. If the lease is lost, because it expired and
+// another worker reclaimed the task or the task was reset, the returned
+// context is canceled so the upload stops early.
+func holdStorageLease(db *gorm.DB, model any, id int, prefix string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(storageLeaseHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			expires := time.Now().Add(storageLeaseDuration)
+			result := db.Model(model).
+				Where("id = ? AND status = ? AND lease_owner = ?", id, models.ImageStorageStatusUploading, StorageWorkerID()).
+				Update("lease_expires_at", &expires)
+			if result.Error != nil {
+				// Keep the upload running; the next heartbeat retries before
+				// the lease can expire.
+				log.Printf("[%s] failed to renew lease of task %d: %v", prefix, id, result.Error)
+				continue
+			}
+			if result.RowsAffected == 0 {
+				log.Printf("[%s] lost lease of task %d; abandoning upload", prefix, id)
+				cancel()
+				return
+			}
+		}
+	}()
+	var stopOnce sync.Once
+	return ctx, func() {
+		stopOnce.Do(func() {
+			close(done)
+			cancel()
+		})
+	}
+}
+
+// reclaimExpiredStorageLeases returns uploading tasks whose lease has lapsed
+// to pending. Rows without a lease were claimed before leases existed and are
+// treated as expired.
+func reclaimExpiredStorageLeases(db *gorm.DB, model any, updates map[string]any) (int64, error) {
+	values := map[string]any{
+		"status":           models.ImageStorageStatusPending,
+		"lease_owner":      "",
+		"lease_expires_at": nil,
+		"next_retry_at":    nil,
+	}
+	for key, value := range updates {
+		values[key] = value
+	}
+	result := db.Model(model).
+		Where("status = ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)", models.ImageStorageStatusUploading, time.Now()).
+		Updates(values)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestReclaimOnlyExpiredStorageLeases(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+
+	expired := time.Now().Add(-time.Minute)
+	live := time.Now().Add(storageLeaseDuration)
+	replicas := []models.ImageStorage{
+		{ImageID: 1, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusUploading, LeaseOwner: "other-instance", LeaseExpiresAt: &live},
+		{ImageID: 2, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusUploading, LeaseOwner: "crashed-instance", LeaseExpiresAt: &expired},
+		{ImageID: 3, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusUploading},
+	}
+	if err := db.Create(&replicas).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+
+	reclaimed, err := reclaimExpiredStorageLeases(db, &models.ImageStorage{}, nil)
+	if err != nil || reclaimed != 2 {
+		t.Fatalf("expected 2 reclaimed leases, got %d (%v)", reclaimed, err)
+	}
+	var stored []models.ImageStorage
+	if err := db.Order("id ASC").Find(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored[0].Status != models.ImageStorageStatusUploading || stored[0].LeaseOwner != "other-instance" {
+		t.Fatalf("live lease must be kept: %+v", stored[0])
+	}
+	for _, replica := range stored[1:] {
+		if replica.Status != models.ImageStorageStatusPending || replica.LeaseOwner != "" || replica.LeaseExpiresAt != nil {
+			t.Fatalf("expired lease should be returned to pending: %+v", replica)
+		}
+	}
+
+	// A worker whose lease was taken over must not complete or fail the task.
+	bucket := models.Buckets{Id: 2, Name: "s3", Type: "s3", Config: map[string]any{}}
+	if err := db.Create(&bucket).Error; err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+	if err := completeStorageSync(stored[0].ID, bucket, localStorageArtifact{URL: "/uploads/a.webp", FileSize: 10}, nil); err == nil {
+		t.Fatal("completion under another worker's lease should fail")
+	}
+	if err := markStorageSyncFailed(stored[0].ID, errors.New("upload failed"), nil); err != nil {
+		t.Fatalf("mark failed: %v", err)
+	}
+	var current models.ImageStorage
+	if err := db.First(&current, stored[0].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if current.Status != models.ImageStorageStatusUploading || current.RetryCount != 0 || current.LeaseOwner != "other-instance" {
+		t.Fatalf("task leased by another worker was modified: %+v", current)
+	}
+}
@@ -0,0 +1,562 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/utils/buckets"
+	"oneimg/backend/utils/ftp"
+	storageS3 "oneimg/backend/utils/s3"
+	storageSettings "oneimg/backend/utils/settings"
+	"oneimg/backend/utils/webdav"
+
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+const (
+	// StorageScrubModeHead checks that remote objects exist with the recorded
+	// size. StorageScrubModeRead downloads them and compares SHA-256 digests.
+	StorageScrubModeHead = "head"
+	StorageScrubModeRead = "read"
+	// StorageScrubMaxRate caps the replicas checked per minute.
+	StorageScrubMaxRate = 600
+
+	defaultStorageScrubInterval = 7 * 24 * time.Hour
+	storageScrubTick            = time.Minute
+)
+
+// Outcomes of a failed scrub.
+const (
+	StorageScrubRepairRequeued = "requeued"
+	StorageScrubRepairRestored = "restored"
+	StorageScrubRepairFailed   = "failed"
+)
+
+var (
+	ErrReplicaNotScrubbable = errors.New("only synchronized replicas can be scrubbed")
+
+	storageScrubStartOnce sync.Once
+)
+
+// StorageScrubResult reports the outcome of checking one replica.
+type StorageScrubResult struct {
+	ReplicaID int    `json:"replica_id"`
+	Healthy   bool   `json:"healthy"`
+	Problem   string `json:"problem,omitempty"`
+	Repair    string `json:"repair,omitempty"`
+}
+
+// StartStorageScrubWorker starts the background integrity scrubber. It checks
+// up to StorageScrubRate replicas per minute, oldest scrub first.
+func StartStorageScrubWorker() {
+	storageScrubStartOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(storageScrubTick)
+			defer ticker.Stop()
+			for range ticker.C {
+				scrubDueReplicas()
+			}
+		}()
+	})
+}
+
+// scrubDueReplicas checks one tick's worth of replicas whose last scrub is
+// older than the configured interval and returns how many it checked.
+func scrubDueReplicas() int {
+	db := database.GetDB()
+	if db == nil || db.DB == nil {
+		return 0
+	}
+	setting, err := storageSettings.GetSettings()
+	if err != nil {
+		log.Printf("[storage-scrub] failed to load settings: %v", err)
+		return 0
+	}
+	rate := min(setting.StorageScrubRate, StorageScrubMaxRate)
+	if rate <= 0 {
+		return 0
+	}
+	interval := defaultStorageScrubInterval
+	if setting.StorageScrubInterval > 0 {
+		interval = time.Duration(setting.StorageScrubInterval) * time.Hour
+	}
+
+	var due []models.ImageStorage
+	if err := db.DB.Select("image_storages.id").
+		Joins("JOIN buckets ON buckets.id = image_storages.bucket_id").
+		Where("image_storages.status = ? AND buckets.disabled = ? AND (image_storages.scrubbed_at IS NULL OR image_storages.scrubbed_at < ?)",
+			models.ImageStorageStatusSuccess, false, time.Now().Add(-interval)).
+		Order("CASE WHEN image_storages.scrubbed_at IS NULL THEN 0 ELSE 1 END, image_storages.scrubbed_at ASC, image_storages.id ASC").
+		Limit(rate).
+		Find(&due).Error; err != nil {
+		log.Printf("[storage-scrub] failed to find due replicas: %v", err)
+		return 0
+	}
+	for _, replica := range due {
+		if _, err := ScrubReplica(replica.ID); err != nil && !errors.Is(err, ErrReplicaNotScrubbable) {
+			log.Printf("[storage-scrub] replica %d: %v", replica.ID, err)
+		}
+	}
+	return len(due)
+}
+
+// ScrubReplica checks one synchronized replica. A damaged remote replica is
+// queued for another upload from the local file, or from another verified
+// remote replica when the image has no local copy; a damaged local file is
+// restored from a remote replica whose checksum proves it intact. Errors that
+// do not prove damage, such as an unreachable backend, leave the replica
+// untouched so the next scrub tries again.
+func ScrubReplica(replicaID int) (StorageScrubResult, error) {
+	db := database.GetDB().DB
+	result := StorageScrubResult{ReplicaID: replicaID}
+
+	var replica models.ImageStorage
+	if err := db.Select("id", "image_id").First(&replica, replicaID).Error; err != nil {
+		return result, err
+	}
+	unlock := lockImageOperations(replica.ImageID)
+	defer unlock()
+	if err := db.First(&replica, replicaID).Error; err != nil {
+		return result, err
+	}
+	if replica.Status != models.ImageStorageStatusSuccess {
+		return result, ErrReplicaNotScrubbable
+	}
+	var bucket models.Buckets
+	if err := db.First(&bucket, replica.BucketID).Error; err != nil {
+		return result, fmt.Errorf("load bucket %d: %w", replica.BucketID, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	checksum, thumbnailChecksum, checkErr := checkReplicaIntegrity(ctx, bucket, replica, loadStorageScrubMode())
+	now := time.Now()
+	if checkErr == nil {
+		updates := map[string]any{"scrubbed_at": &now}
+		// The first read of a replica without a digest records it.
+		if replica.Checksum == "" && checksum != "" {
+			updates["checksum"] = checksum
+		}
+		if replica.ThumbnailChecksum == "" && thumbnailChecksum != "" {
+			updates["thumbnail_checksum"] = thumbnailChecksum
+		}
+		result.Healthy = true
+		return result, db.Model(&models.ImageStorage{}).Where("id = ?", replica.ID).Updates(updates).Error
+	}
+	if !errors.Is(checkErr, errReplicaDamaged) {
+		return result, checkErr
+	}
+
+	result.Problem = checkErr.Error()
+	log.Printf("[storage-scrub] replica %d of image %d in bucket %d is damaged: %v", replica.ID, replica.ImageID, bucket.Id, checkErr)
+
+	if bucket.Type == "default" {
+		if err := restoreLocalReplica(ctx, db, replica); err != nil {
+			log.Printf("[storage-scrub] restoring local files of image %d failed: %v", replica.ImageID, err)
+			result.Repair = StorageScrubRepairFailed
+			return result, markReplicaDamaged(db, bucket, replica, models.ImageStorageStatusFailed,
+				fmt.Sprintf("scrub: %v; restore failed: %v", checkErr, err))
+		}
+		result.Repair = StorageScrubRepairRestored
+		return result, nil
+	}
+
+	if err := ensureRepairSource(ctx, db, replica); err != nil {
+		log.Printf("[storage-scrub] no healthy copy of image %d to repair from: %v", replica.ImageID, err)
+		result.Repair = StorageScrubRepairFailed
+		return result, markReplicaDamaged(db, bucket, replica, models.ImageStorageStatusFailed,
+			fmt.Sprintf("scrub: %v; no healthy copy to repair from", checkErr))
+	}
+	result.Repair = StorageScrubRepairRequeued
+	if err := markReplicaDamaged(db, bucket, replica, models.ImageStorageStatusPending, "scrub: "+checkErr.Error()); err != nil {
+		return result, err
+	}
+	WakeStorageSyncWorker()
+	return result, nil
+}
+
+func loadStorageScrubMode() string {
+	setting, err := storageSettings.GetSettings()
+	if err != nil || setting.StorageScrubMode != StorageScrubModeRead {
+		return StorageScrubModeHead
+	}
+	return StorageScrubModeRead
+}
+
+// checkReplicaIntegrity verifies one replica and returns the digests it
+// computed. Local replicas are always read in full; remote replicas only in
+// read mode. Telegram replicas cannot be compared byte for byte and always
+// get the metadata check.
+func checkReplicaIntegrity(ctx context.Context, bucket models.Buckets, replica models.ImageStorage, mode string) (string, string, error) {
+	if bucket.Type != "default" && (mode != StorageScrubModeRead || bucket.Type == "telegram") {
+		return "", "", verifyRemoteReplica(ctx, bucket, replica)
+	}
+	checksum, err := readReplicaChecksum(ctx, bucket, replica.URL, replica.FileSize, replica.Checksum)
+	if err != nil {
+		return "", "", err
+	}
+	if replica.Thumbnail == "" {
+		return checksum, "", nil
+	}
+	thumbnailChecksum, err := readReplicaChecksum(ctx, bucket, replica.Thumbnail, replica.ThumbnailSize, replica.ThumbnailChecksum)
+	if err != nil {
+		return "", "", err
+	}
+	return checksum, thumbnailChecksum, nil
+}
+
+func readReplicaChecksum(ctx context.Context, bucket models.Buckets, path string, size int64, expected string) (string, error) {
+	reader, err := openReplicaObject(ctx, bucket, path)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	read, err := io.Copy(hash, reader)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", path, err)
+	}
+	if err := checkVerifiedSize(path, read, size); err != nil {
+		return "", err
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if expected != "" && checksum != expected {
+		return "", fmt.Errorf("%w: %s has checksum %s, expected %s", errReplicaDamaged, path, checksum, expected)
+	}
+	return checksum, nil
+}
+
+// openReplicaObject streams one stored object. Objects that do not exist are
+// reported as damage.
+func openReplicaObject(ctx context.Context, bucket models.Buckets, path string) (io.ReadCloser, error) {
+	switch bucket.Type {
+	case "default":
+		localPath, err := canonicalLocalPath(path)
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.Open(localPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s is missing", errReplicaDamaged, path)
+		}
+		return file, err
+	case "s3", "r2":
+		setting, err := storageSettings.GetSettings()
+		if err != nil {
+			return nil, err
+		}
+		client, err := storageS3.NewS3Client(setting, bucket)
+		if err != nil {
+			return nil, err
+		}
+		remoteBucket := buckets.ConvertToS3Bucket(bucket.Config).S3Bucket
+		if bucket.Type == "r2" {
+			remoteBucket = buckets.ConvertToR2Bucket(bucket.Config).R2Bucket
+		}
+		object, err := client.GetObject(ctx, remoteBucket, remoteObjectKey(path), minio.GetObjectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", path, err)
+		}
+		if _, err := object.Stat(); err != nil {
+			object.Close()
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return nil, fmt.Errorf("%w: %s is missing", errReplicaDamaged, path)
+			}
+			return nil, fmt.Errorf("open %s: %w", path, err)
+		}
+		return object, nil
+	case "webdav":
+		config := buckets.ConvertToWebDavBucket(bucket.Config)
+		client := webdav.Client(webdav.Config{
+			BaseURL:  config.WebdavURL,
+			Username: config.WebdavUser,
+			Password: config.WebdavPass,
+			Timeout:  5 * time.Minute,
+		})
+		resp, err := client.WebDAVGetFile(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", path, err)
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return resp.Body, nil
+		case http.StatusNotFound:
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: %s is missing", errReplicaDamaged, path)
+		default:
+			resp.Body.Close()
+			return nil, fmt.Errorf("open %s: status code %d", path, resp.StatusCode)
+		}
+	case "ftp":
+		config := buckets.ConvertToFTPBucket(bucket.Config)
+		client := ftp.NewFTPUtil(ftp.FTPConfig{
+			Host:     config.FTPHost,
+			Port:     config.FTPPort,
+			User:     config.FTPUser,
+			Password: config.FTPPass,
+			Timeout:  30,
+		})
+		reader, _, err := client.GetFileStreamReader(path)
+		if err != nil {
+			client.Close()
+			if isMissingRemoteFileError(err) {
+				return nil, fmt.Errorf("%w: %s is missing", errReplicaDamaged, path)
+			}
+			return nil, fmt.Errorf("open %s: %w", path, err)
+		}
+		return ftpObjectReader{ReadCloser: reader, client: client}, nil
+	default:
+		return nil, fmt.Errorf("reading %q storage is not supported", bucket.Type)
+	}
+}
+
+// ftpObjectReader closes the FTP connection together with the transfer.
+type ftpObjectReader struct {
+	io.ReadCloser
+	client *ftp.FTPUtil
+}
+
+func (r ftpObjectReader) Close() error {
+	err := r.ReadCloser.Close()
+	if closeErr := r.client.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ensureRepairSource makes sure a damaged remote replica can be uploaded
+// again. The local canonical files are checked and restored from another
+// replica when damaged. Images without a local replica, such as tiered ones,
+// are repaired from another remote replica whose bytes still match its
+// recorded checksum; the sync worker downloads from it.
+func ensureRepairSource(ctx context.Context, db *gorm.DB, damaged models.ImageStorage) error {
+	var local models.ImageStorage
+	lookup := db.Joins("JOIN buckets ON buckets.id = image_storages.bucket_id").
+		Where("image_storages.image_id = ? AND buckets.type = ?", damaged.ImageID, "default").
+		Limit(1).Find(&local)
+	if lookup.Error != nil {
+		return lookup.Error
+	}
+	if lookup.RowsAffected == 0 {
+		return verifyRemoteRepairSource(ctx, db, damaged)
+	}
+
+	var localBucket models.Buckets
+	if err := db.First(&localBucket, local.BucketID).Error; err != nil {
+		return err
+	}
+	if local.Status == models.ImageStorageStatusSuccess {
+		_, _, err := checkReplicaIntegrity(ctx, localBucket, local, StorageScrubModeRead)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errReplicaDamaged) {
+			return err
+		}
+	}
+	if err := restoreLocalReplica(ctx, db, local, damaged.ID); err != nil {
+		markErr := markReplicaDamaged(db, localBucket, local, models.ImageStorageStatusFailed, "scrub: local files are damaged; restore failed: "+err.Error())
+		return errors.Join(err, markErr)
+	}
+	return nil
+}
+
+// verifyRemoteRepairSource reads the other checksummed remote replicas of an
+// image that hold the original bytes until one matches its recorded digest.
+func verifyRemoteRepairSource(ctx context.Context, db *gorm.DB, damaged models.ImageStorage) error {
+	var sources []models.ImageStorage
+	if err := db.Where("image_id = ? AND id <> ? AND status = ? AND checksum <> '' AND processed = ?", damaged.ImageID, damaged.ID, models.ImageStorageStatusSuccess, false).
+		Order("id ASC").Find(&sources).Error; err != nil {
+		return err
+	}
+	var errs []error
+	for _, source := range sources {
+		if source.Thumbnail != "" && source.ThumbnailChecksum == "" {
+			continue
+		}
+		var bucket models.Buckets
+		if err := db.First(&bucket, source.BucketID).Error; err != nil || bucket.Type == "default" || bucket.Type == "telegram" || bucket.Disabled {
+			continue
+		}
+		if _, _, err := checkReplicaIntegrity(ctx, bucket, source, StorageScrubModeRead); err != nil {
+			errs = append(errs, fmt.Errorf("bucket %d: %w", bucket.Id, err))
+			continue
+		}
+		return nil
+	}
+	if len(errs) == 0 {
+		return errors.New("image has no local replica and no other replica with recorded checksums")
+	}
+	return errors.Join(errs...)
+}
+
+// restoreLocalReplica rewrites the local files of an image from the first
+// remote replica whose recorded checksums match the downloaded bytes and marks
+// the local replica healthy again. Replicas listed in skip and replicas derived
+// with a processing profile are not used.
+func restoreLocalReplica(ctx context.Context, db *gorm.DB, local models.ImageStorage, skip ...int) error {
+	var image models.Image
+	if err := db.Unscoped().First(&image, local.ImageID).Error; err != nil {
+		return fmt.Errorf("load image %d: %w", local.ImageID, err)
+	}
+	query := db.Where("image_id = ? AND id <> ? AND status = ? AND checksum <> '' AND processed = ?", local.ImageID, local.ID, models.ImageStorageStatusSuccess, false)
+	if len(skip) > 0 {
+		query = query.Where("id NOT IN ?", skip)
+	}
+	var sources []models.ImageStorage
+	if err := query.Order("id ASC").Find(&sources).Error; err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, source := range sources {
+		if image.Thumbnail != "" && source.ThumbnailChecksum == "" {
+			continue
+		}
+		var bucket models.Buckets
+		if err := db.First(&bucket, source.BucketID).Error; err != nil || bucket.Type == "default" || bucket.Disabled {
+			continue
+		}
+		if err := restoreLocalObject(ctx, bucket, source.URL, image.Url, source.Checksum); err != nil {
+			errs = append(errs, fmt.Errorf("bucket %d: %w", bucket.Id, err))
+			continue
+		}
+		if image.Thumbnail != "" {
+			if err := restoreLocalObject(ctx, bucket, source.Thumbnail, image.Thumbnail, source.ThumbnailChecksum); err != nil {
+				errs = append(errs, fmt.Errorf("bucket %d: %w", bucket.Id, err))
+				continue
+			}
+		}
+
+		now := time.Now()
+		if err := db.Model(&models.ImageStorage{}).Where("id = ?", local.ID).Updates(map[string]any{
+			"status":             models.ImageStorageStatusSuccess,
+			"url":                image.Url,
+			"thumbnail":          image.Thumbnail,
+			"file_size":          source.FileSize,
+			"thumbnail_size":     source.ThumbnailSize,
+			"checksum":           source.Checksum,
+			"thumbnail_checksum": source.ThumbnailChecksum,
+			"error":              "",
+			"scrubbed_at":        &now,
+		}).Error; err != nil {
+			return err
+		}
+		log.Printf("[storage-scrub] restored local files of image %d from bucket %d", image.Id, bucket.Id)
+		return nil
+	}
+	if len(errs) == 0 {
+		return errors.New("no remote replica with recorded checksums")
+	}
+	return errors.Join(errs...)
+}
+
+// restoreLocalObject downloads one object next to its local path and renames
+// it into place only after its digest matched.
+func restoreLocalObject(ctx context.Context, bucket models.Buckets, remotePath, localPublicPath, expected string) error {
+	localPath, err := canonicalLocalPath(localPublicPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return err
+	}
+	tempPath, _, err := downloadReplicaObject(ctx, bucket, remotePath, filepath.Dir(localPath), expected)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, localPath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// downloadReplicaObject copies one stored object into a new temporary file in
+// dir and returns its path and size. When expected is set the SHA-256 digest
+// of the downloaded bytes must match it; the file is removed otherwise.
+func downloadReplicaObject(ctx context.Context, bucket models.Buckets, remotePath, dir, expected string) (string, int64, error) {
+	reader, err := openReplicaObject(ctx, bucket, remotePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer reader.Close()
+
+	temp, err := os.CreateTemp(dir, ".restore-*")
+	if err != nil {
+		return "", 0, err
+	}
+	hash := sha256.New()
+	size, copyErr := io.Copy(io.MultiWriter(temp, hash), reader)
+	closeErr := temp.Close()
+	switch {
+	case copyErr != nil:
+		err = fmt.Errorf("download %s: %w", remotePath, copyErr)
+	case closeErr != nil:
+		err = closeErr
+	case expected != "":
+		if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != expected {
+			err = fmt.Errorf("%s has checksum %s, expected %s", remotePath, checksum, expected)
+		}
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", 0, err
+	}
+	return temp.Name(), size, nil
+}
+
+// markReplicaDamaged moves a successful replica to status and releases the
+// usage it accounted for. Pending replicas are uploaded again by the sync
+// worker; failed ones wait for an administrator.
+func markReplicaDamaged(db *gorm.DB, bucket models.Buckets, replica models.ImageStorage, status, message string) error {
+	now := time.Now()
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.ImageStorage{}).
+			Where("id = ? AND status = ?", replica.ID, models.ImageStorageStatusSuccess).
+			Updates(map[string]any{
+				"status":             status,
+				"error":              message,
+				"retry_count":        0,
+				"next_retry_at":      nil,
+				"synced_at":          nil,
+				"checksum":           "",
+				"thumbnail_checksum": "",
+				"scrubbed_at":        &now,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if bucket.Type == "default" {
+			return nil
+		}
+		return ReleaseBucketUsage(tx, bucket.Id, replica.FileSize+replica.ThumbnailSize)
+	})
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestScrubReplicaRestoresDamagedLocalFile(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+
+	content := []byte("original image bytes")
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/uploads/a.webp" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	if err := db.Create(&[]models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}},
+		{Id: 2, Name: "dav", Type: "webdav", Config: map[string]any{"webdav_url": server.URL}},
+	}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	if err := os.MkdirAll("uploads", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("uploads", "a.webp"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	image := models.Image{Url: "/uploads/a.webp", FileName: "a.webp", FileSize: int64(len(content))}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	local := models.ImageStorage{ImageID: image.Id, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: image.FileSize}
+	remote := models.ImageStorage{ImageID: image.Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: image.FileSize, Checksum: checksum}
+	if err := db.Create(&[]*models.ImageStorage{&local, &remote}).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+
+	// The first scrub records the digest of the local file.
+	scrub, err := ScrubReplica(local.ID)
+	if err != nil || !scrub.Healthy {
+		t.Fatalf("expected healthy local replica, got %+v (%v)", scrub, err)
+	}
+	db.First(&local, local.ID)
+	if local.Checksum != checksum || local.ScrubbedAt == nil {
+		t.Fatalf("expected checksum to be recorded, got %+v", local)
+	}
+
+	// Same size, different bytes: only the digest can tell.
+	if err := os.WriteFile(filepath.Join("uploads", "a.webp"), []byte("tampered image bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	scrub, err = ScrubReplica(local.ID)
+	if err != nil || scrub.Healthy || scrub.Repair != StorageScrubRepairRestored {
+		t.Fatalf("expected local file to be restored, got %+v (%v)", scrub, err)
+	}
+	restored, err := os.ReadFile(filepath.Join("uploads", "a.webp"))
+	if err != nil || string(restored) != string(content) {
+		t.Fatalf("unexpected restored content %q (%v)", restored, err)
+	}
+	db.First(&local, local.ID)
+	if local.Status != models.ImageStorageStatusSuccess || local.Checksum != checksum {
+		t.Fatalf("expected local replica to be healthy again, got %+v", local)
+	}
+}
+
+func TestScrubReplicaRequeuesMissingRemoteCopy(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	if err := db.Create(&[]models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}},
+		{Id: 2, Name: "dav", Type: "webdav", Usage: 100, Config: map[string]any{"webdav_url": server.URL}},
+	}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	if err := os.MkdirAll("uploads", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("uploads", "b.webp"), []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	image := models.Image{Url: "/uploads/b.webp", FileName: "b.webp", FileSize: 5}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	local := models.ImageStorage{ImageID: image.Id, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: 5}
+	remote := models.ImageStorage{ImageID: image.Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: 5, Checksum: "stale", RetryCount: 2}
+	if err := db.Create(&[]*models.ImageStorage{&local, &remote}).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+
+	scrub, err := ScrubReplica(remote.ID)
+	if err != nil || scrub.Healthy || scrub.Repair != StorageScrubRepairRequeued {
+		t.Fatalf("expected missing copy to be requeued, got %+v (%v)", scrub, err)
+	}
+	db.First(&remote, remote.ID)
+	if remote.Status != models.ImageStorageStatusPending || remote.RetryCount != 0 || remote.Checksum != "" || remote.Error == "" {
+		t.Fatalf("unexpected requeued replica: %+v", remote)
+	}
+	var bucket models.Buckets
+	db.First(&bucket, 2)
+	if bucket.Usage != 95 {
+		t.Fatalf("expected usage to be released, got %d", bucket.Usage)
+	}
+	if _, err := ScrubReplica(remote.ID); err != ErrReplicaNotScrubbable {
+		t.Fatalf("expected pending replica to be skipped, got %v", err)
+	}
+}
+
+func TestScrubReplicaRepairsTieredImageFromAnotherRemote(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{MultiStorageSync: true}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+
+	content := []byte("cold image bytes")
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	damagedDAV, damagedServer := newMemoryWebDAV(t, map[string][]byte{})
+	_, healthyServer := newMemoryWebDAV(t, map[string][]byte{"/uploads/c.webp": content})
+	if err := db.Create(&[]models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}},
+		{Id: 2, Name: "cold", Type: "webdav", Usage: 100, Config: map[string]any{"webdav_url": damagedServer.URL}},
+		{Id: 3, Name: "offsite", Type: "webdav", Config: map[string]any{"webdav_url": healthyServer.URL}},
+	}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	// Tiered: no local replica and no local files.
+	image := models.Image{Url: "/uploads/c.webp", FileName: "c.webp", FileSize: int64(len(content)), AccessBucketId: 2}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	damaged := models.ImageStorage{ImageID: image.Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: image.FileSize, Checksum: checksum}
+	healthy := models.ImageStorage{ImageID: image.Id, BucketID: 3, Storage: "webdav", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: image.FileSize, Checksum: checksum}
+	if err := db.Create(&[]*models.ImageStorage{&damaged, &healthy}).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+
+	scrub, err := ScrubReplica(damaged.ID)
+	if err != nil || scrub.Repair != StorageScrubRepairRequeued {
+		t.Fatalf("expected repair from the offsite replica to be queued, got %+v (%v)", scrub, err)
+	}
+	if !processNextStorageSyncTask() {
+		t.Fatal("expected the repair upload to be processed")
+	}
+	db.First(&damaged, damaged.ID)
+	if damaged.Status != models.ImageStorageStatusSuccess || string(damagedDAV.file(image.Url)) != string(content) {
+		t.Fatalf("expected the cold replica to be rewritten, got %+v", damaged)
+	}
+}
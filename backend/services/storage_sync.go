@@ -1,6 +1,7 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -17,10 +18,12 @@ import (
 	"oneimg/backend/models"
 	"oneimg/backend/utils/buckets"
 	"oneimg/backend/utils/ftp"
+	"oneimg/backend/utils/images"
 	storageS3 "oneimg/backend/utils/s3"
 	"oneimg/backend/utils/securestorage"
 	storageSettings "oneimg/backend/utils/settings"
 	"oneimg/backend/utils/telegram"
+	"oneimg/backend/utils/uploads"
 	"oneimg/backend/utils/webdav"
 
 	"github.com/minio/minio-go/v7"
@@ -29,14 +32,10 @@ import (
 )
 
 const storageSyncPollInterval = 3 * time.Second
-const storageSyncMaxAttempts = 3
 
 var (
 	storageSyncStartOnce sync.Once
 	storageSyncWake      = make(chan struct{}, 1)
-	// A single process only runs one upload/delete operation at a time. Database
-	// compare-and-swap still protects task claiming and persisted state.
-	storageReplicaOperationMu sync.Mutex
 )
 
 type localStorageArtifact struct {
@@ -48,11 +47,19 @@ type localStorageArtifact struct {
 	MimeType      string
 	FileSize      int64
 	ThumbnailSize int64
+	// SHA-256 of the files written to the bucket, recorded on replicas that
+	// store the bytes unchanged.
+	Checksum          string
+	ThumbnailChecksum string
+	// Processed is set when the main file was derived with the bucket's
+	// processing profile; MimeType then describes the derived bytes.
+	Processed bool
 }
 
-// StartStorageSyncWorker starts the durable, single-worker storage queue.
-// Calling it more than once is safe. Tasks which were interrupted while in
-// uploading state are returned to pending before the worker starts.
+// StartStorageSyncWorker starts the durable storage queue and its worker pool.
+// Calling it more than once is safe. Uploading tasks whose lease has expired,
+// because the instance that claimed them stopped, are returned to pending;
+// tasks leased by other live instances are left alone.
 func StartStorageSyncWorker() {
 	storageSyncStartOnce.Do(func() {
 		db := database.GetDB()
@@ -61,26 +68,23 @@ func StartStorageSyncWorker() {
 			return
 		}
 
-		result := db.DB.Model(&models.ImageStorage{}).
-			Where("status = ?", models.ImageStorageStatusUploading).
-			Updates(map[string]any{
-				"status":        models.ImageStorageStatusPending,
-				"error":         "",
-				"started_at":    nil,
-				"next_retry_at": nil,
-			})
-		if result.Error != nil {
-			log.Printf("[storage-sync] failed to recover interrupted tasks: %v", result.Error)
-		} else if result.RowsAffected > 0 {
-			log.Printf("[storage-sync] recovered %d interrupted task(s)", result.RowsAffected)
-		}
-
+		reclaimExpiredStorageSyncLeases(db.DB)
 		go runStorageSyncWorker()
 	})
 	WakeStorageSyncWorker()
 }
 
-// WakeStorageSyncWorker asks the worker to poll immediately. The signal is
+func reclaimExpiredStorageSyncLeases(db *gorm.DB) {
+	reclaimed, err := reclaimExpiredStorageLeases(db, &models.ImageStorage{}, map[string]any{"error": "", "started_at": nil})
+	if err != nil {
+		log.Printf("[storage-sync] failed to recover interrupted tasks: %v", err)
+	} else if reclaimed > 0 {
+		log.Printf("[storage-sync] recovered %d interrupted task(s)", reclaimed)
+		WakeStorageSyncWorker()
+	}
+}
+
+// WakeStorageSyncWorker asks the worker pool to poll immediately. The signal is
 // deliberately lossy because pending work is durable in the database.
 func WakeStorageSyncWorker() {
 	select {
@@ -92,10 +96,16 @@ func WakeStorageSyncWorker() {
 func runStorageSyncWorker() {
 	ticker := time.NewTicker(storageSyncPollInterval)
 	defer ticker.Stop()
+	lastReclaim := time.Now()
 
 	for {
-		for processNextStorageSyncTask() {
+		if time.Since(lastReclaim) >= storageLeaseHeartbeat {
+			lastReclaim = time.Now()
+			if db := database.GetDB(); db != nil && db.DB != nil {
+				reclaimExpiredStorageSyncLeases(db.DB)
+			}
 		}
+		fillStorageSyncPool()
 
 		select {
 		case <-storageSyncWake:
@@ -104,10 +114,10 @@ func runStorageSyncWorker() {
 	}
 }
 
+// processNextStorageSyncTask reserves a slot on the next bucket in round-robin
+// order, claims that bucket's oldest runnable task by compare-and-swap and runs
+// it. It reports whether the caller should keep polling.
 func processNextStorageSyncTask() bool {
-	storageReplicaOperationMu.Lock()
-	defer storageReplicaOperationMu.Unlock()
-
 	db := database.GetDB()
 	if db == nil || db.DB == nil {
 		return false
@@ -121,11 +131,29 @@ func processNextStorageSyncTask() bool {
 		return false
 	}
 
+	var candidates []storageSyncCandidate
+	if err := db.DB.Model(&models.ImageStorage{}).
+		Select("image_storages.bucket_id AS bucket_id, buckets.sync_concurrency AS sync_concurrency").
+		Joins("JOIN buckets ON buckets.id = image_storages.bucket_id").
+		Where("image_storages.status = ? AND (image_storages.next_retry_at IS NULL OR image_storages.next_retry_at <= ?) AND buckets.disabled = ? AND buckets.sync_paused = ?",
+			models.ImageStorageStatusPending, time.Now(), false, false).
+		Group("image_storages.bucket_id, buckets.sync_concurrency").
+		Order("image_storages.bucket_id ASC").
+		Scan(&candidates).Error; err != nil {
+		log.Printf("[storage-sync] failed to find pending buckets: %v", err)
+		return false
+	}
+	bucketLimit := normalizeStorageSyncConcurrency(setting.StorageSyncBucketConcurrency, defaultStorageSyncBucketConcurrency)
+	bucketID, ok := storageSyncSlots.reserve(candidates, bucketLimit)
+	if !ok {
+		return false
+	}
+	defer storageSyncSlots.release(bucketID)
+
 	var replica models.ImageStorage
 	lookup := db.DB.Where(
-		"status = ? AND (next_retry_at IS NULL OR next_retry_at <= ?) AND "+
-			"EXISTS (SELECT 1 FROM buckets WHERE buckets.id = image_storages.bucket_id AND buckets.disabled = ?)",
-		models.ImageStorageStatusPending, time.Now(), false,
+		"bucket_id = ? AND status = ? AND (next_retry_at IS NULL OR next_retry_at <= ?)",
+		bucketID, models.ImageStorageStatusPending, time.Now(),
 	).
 		Order("id ASC").
 		Limit(1).
@@ -135,18 +163,16 @@ func processNextStorageSyncTask() bool {
 		return false
 	}
 	if lookup.RowsAffected == 0 {
-		return false
+		// Another worker drained the bucket in the meantime.
+		return true
 	}
 
 	now := time.Now()
+	updates := storageLeaseClaim(now)
+	updates["started_at"] = &now
 	claim := db.DB.Model(&models.ImageStorage{}).
 		Where("id = ? AND status = ?", replica.ID, models.ImageStorageStatusPending).
-		Updates(map[string]any{
-			"status":        models.ImageStorageStatusUploading,
-			"error":         "",
-			"started_at":    &now,
-			"next_retry_at": nil,
-		})
+		Updates(updates)
 	if claim.Error != nil {
 		log.Printf("[storage-sync] failed to claim task %d: %v", replica.ID, claim.Error)
 		return false
@@ -156,8 +182,20 @@ func processNextStorageSyncTask() bool {
 	}
 	replica.Status = models.ImageStorageStatusUploading
 	replica.StartedAt = &now
+	leaseContext, releaseLease := holdStorageLease(db.DB, &models.ImageStorage{}, replica.ID, "storage-sync")
+	defer releaseLease()
+
+	unlock := lockImageOperations(replica.ImageID)
+	defer unlock()
+	// A delete or file replacement may have removed or reset the task while
+	// this worker waited for the image lock.
+	var current models.ImageStorage
+	if err := db.DB.Select("id", "status", "lease_owner").First(&current, replica.ID).Error; err != nil ||
+		current.Status != models.ImageStorageStatusUploading || current.LeaseOwner != StorageWorkerID() {
+		return true
+	}
 
-	taskContext, cancelTask := context.WithTimeout(context.Background(), 5*time.Minute)
+	taskContext, cancelTask := context.WithTimeout(leaseContext, 5*time.Minute)
 	metadata, syncErr := synchronizeReplica(taskContext, &replica)
 	cancelTask()
 	if syncErr != nil {
@@ -174,8 +212,9 @@ func processNextStorageSyncTask() bool {
 func synchronizeReplica(ctx context.Context, replica *models.ImageStorage) (map[string]any, error) {
 	db := database.GetDB().DB
 
+	// Trashed images keep synchronizing so a restore finds every replica.
 	var image models.Image
-	if err := db.First(&image, replica.ImageID).Error; err != nil {
+	if err := db.Unscoped().First(&image, replica.ImageID).Error; err != nil {
 		return nil, fmt.Errorf("load image %d: %w", replica.ImageID, err)
 	}
 
@@ -188,9 +227,23 @@ func synchronizeReplica(ctx context.Context, replica *models.ImageStorage) (map[
 	}
 
 	artifact, err := buildLocalStorageArtifact(image)
+	if errors.Is(err, os.ErrNotExist) && bucket.Type != "default" {
+		// Tiered images and images migrated between remote buckets have no
+		// local files; copy them from a verified remote replica instead.
+		var cleanup func()
+		artifact, cleanup, err = buildRemoteStorageArtifact(ctx, db, image, bucket.Id)
+		if err == nil {
+			defer cleanup()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	artifact, cleanupProcessed, err := processArtifactForBucket(image, bucket, artifact)
 	if err != nil {
 		return nil, err
 	}
+	defer cleanupProcessed()
 
 	if err := checkStorageCapacity(bucket, artifact.FileSize+artifact.ThumbnailSize); err != nil {
 		return nil, err
@@ -263,6 +316,9 @@ func buildLocalStorageArtifact(image models.Image) (localStorageArtifact, error)
 		MimeType: image.MimeType,
 		FileSize: mainInfo.Size(),
 	}
+	if artifact.Checksum, err = fileSHA256(mainPath); err != nil {
+		return localStorageArtifact{}, fmt.Errorf("hash local image %q: %w", mainPath, err)
+	}
 	if artifact.FileName == "" {
 		artifact.FileName = filepath.Base(mainPath)
 	}
@@ -285,11 +341,153 @@ func buildLocalStorageArtifact(image models.Image) (localStorageArtifact, error)
 		artifact.ThumbnailPath = thumbnailPath
 		artifact.Thumbnail = image.Thumbnail
 		artifact.ThumbnailSize = thumbnailInfo.Size()
+		if artifact.ThumbnailChecksum, err = fileSHA256(thumbnailPath); err != nil {
+			return localStorageArtifact{}, fmt.Errorf("hash local thumbnail %q: %w", thumbnailPath, err)
+		}
+	}
+
+	return artifact, nil
+}
+
+// storageSyncSourceCondition is a condition on images that holds when the
+// sync worker has a source to build a new replica from: a successful local
+// replica, or a successful replica in an enabled remote bucket with recorded
+// checksums of the original files.
+func storageSyncSourceCondition() (string, []any) {
+	return "EXISTS (SELECT 1 FROM image_storages source JOIN buckets ON buckets.id = source.bucket_id " +
+			"WHERE source.image_id = images.id AND source.status = ? AND (buckets.type = ? OR " +
+			"(buckets.disabled = ? AND source.checksum <> '' AND source.processed = ? AND " +
+			"(COALESCE(images.thumbnail, '') = '' OR source.thumbnail_checksum <> ''))))",
+		[]any{models.ImageStorageStatusSuccess, "default", false, false}
+}
+
+// buildRemoteStorageArtifact downloads the files of an image from the first
+// successful replica outside targetID whose recorded checksums match, into a
+// temporary directory. The returned cleanup function removes the directory.
+// Replicas derived with a processing profile are not used as sources.
+func buildRemoteStorageArtifact(ctx context.Context, db *gorm.DB, image models.Image, targetID int) (localStorageArtifact, func(), error) {
+	var sources []models.ImageStorage
+	if err := db.Where("image_id = ? AND bucket_id <> ? AND status = ? AND checksum <> '' AND processed = ?", image.Id, targetID, models.ImageStorageStatusSuccess, false).
+		Order("id ASC").Find(&sources).Error; err != nil {
+		return localStorageArtifact{}, nil, err
+	}
+
+	var errs []error
+	for _, source := range sources {
+		if image.Thumbnail != "" && source.ThumbnailChecksum == "" {
+			continue
+		}
+		var bucket models.Buckets
+		if err := db.First(&bucket, source.BucketID).Error; err != nil || bucket.Type == "default" || bucket.Disabled {
+			continue
+		}
+		dir, err := os.MkdirTemp("", "oneimg-sync-*")
+		if err != nil {
+			return localStorageArtifact{}, nil, err
+		}
+		artifact, err := downloadStorageArtifact(ctx, bucket, source, image, dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			errs = append(errs, fmt.Errorf("bucket %d: %w", bucket.Id, err))
+			continue
+		}
+		return artifact, func() { os.RemoveAll(dir) }, nil
+	}
+	if len(errs) == 0 {
+		return localStorageArtifact{}, nil, errors.New("local files are missing and no remote replica has recorded checksums")
 	}
+	return localStorageArtifact{}, nil, errors.Join(errs...)
+}
 
+func downloadStorageArtifact(ctx context.Context, bucket models.Buckets, source models.ImageStorage, image models.Image, dir string) (localStorageArtifact, error) {
+	artifact := localStorageArtifact{
+		URL:               image.Url,
+		FileName:          image.FileName,
+		MimeType:          image.MimeType,
+		Checksum:          source.Checksum,
+		ThumbnailChecksum: source.ThumbnailChecksum,
+	}
+	mainPath, thumbnailPath := source.URL, source.Thumbnail
+	if mainPath == "" {
+		mainPath = image.Url
+	}
+	if thumbnailPath == "" {
+		thumbnailPath = image.Thumbnail
+	}
+	var err error
+	if artifact.MainPath, artifact.FileSize, err = downloadReplicaObject(ctx, bucket, mainPath, dir, source.Checksum); err != nil {
+		return localStorageArtifact{}, err
+	}
+	if artifact.FileName == "" {
+		artifact.FileName = filepath.Base(image.Url)
+	}
+	if artifact.MimeType == "" {
+		artifact.MimeType = "application/octet-stream"
+	}
+	if image.Thumbnail != "" {
+		if artifact.ThumbnailPath, artifact.ThumbnailSize, err = downloadReplicaObject(ctx, bucket, thumbnailPath, dir, source.ThumbnailChecksum); err != nil {
+			return localStorageArtifact{}, err
+		}
+		artifact.Thumbnail = image.Thumbnail
+	}
 	return artifact, nil
 }
 
+// processArtifactForBucket derives the main file a bucket stores from the
+// source artifact using the bucket's processing profile. The source is left
+// untouched; the derived file lives in a temporary directory removed by the
+// returned cleanup function. Local buckets, profiles that keep the original
+// and the bucket that received the upload, whose bytes were already processed
+// with its profile, store the source as is.
+func processArtifactForBucket(image models.Image, bucket models.Buckets, artifact localStorageArtifact) (localStorageArtifact, func(), error) {
+	noop := func() {}
+	if bucket.Type == "default" || bucket.Processing == nil || bucket.Processing.KeepOriginal || bucket.Id == image.BucketId {
+		return artifact, noop, nil
+	}
+	setting, err := storageSettings.GetSettings()
+	if err != nil {
+		return localStorageArtifact{}, noop, fmt.Errorf("load settings: %w", err)
+	}
+	processing := uploads.ProcessingSettings(&setting, &bucket)
+	// The source already carries the watermark chosen at upload.
+	processing.WatermarkEnable = false
+
+	data, encrypted, err := securestorage.ReadFile(artifact.MainPath)
+	if err != nil {
+		return localStorageArtifact{}, noop, fmt.Errorf("read source image: %w", err)
+	}
+	processed, mimeType, err := images.ImageSvc.ProcessStoredImage(data, artifact.MimeType, processing)
+	if err != nil {
+		return localStorageArtifact{}, noop, fmt.Errorf("process image for bucket %d: %w", bucket.Id, err)
+	}
+	if bytes.Equal(processed, data) {
+		return artifact, noop, nil
+	}
+	stored, err := securestorage.Encode(processed, encrypted)
+	if err != nil {
+		return localStorageArtifact{}, noop, err
+	}
+
+	dir, err := os.MkdirTemp("", "oneimg-process-*")
+	if err != nil {
+		return localStorageArtifact{}, noop, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	artifact.MainPath = filepath.Join(dir, filepath.Base(artifact.MainPath))
+	if err := os.WriteFile(artifact.MainPath, stored, 0o600); err != nil {
+		cleanup()
+		return localStorageArtifact{}, noop, err
+	}
+	if artifact.Checksum, err = fileSHA256(artifact.MainPath); err != nil {
+		cleanup()
+		return localStorageArtifact{}, noop, err
+	}
+	artifact.FileSize = int64(len(stored))
+	artifact.MimeType = mimeType
+	artifact.Processed = true
+	return artifact, cleanup, nil
+}
+
 func canonicalLocalPath(publicPath string) (string, error) {
 	path := strings.TrimSpace(publicPath)
 	if path == "" {
@@ -542,6 +740,15 @@ func completeStorageSync(replicaID int, bucket models.Buckets, artifact localSto
 	if err != nil {
 		return err
 	}
+	checksum, thumbnailChecksum := artifact.Checksum, artifact.ThumbnailChecksum
+	mimeType := ""
+	if artifact.Processed {
+		mimeType = artifact.MimeType
+	}
+	if bucket.Type == "telegram" {
+		// Telegram re-encodes photos, so the stored bytes are unknown.
+		checksum, thumbnailChecksum = "", ""
+	}
 
 	return db.Transaction(func(tx *gorm.DB) error {
 		var current models.ImageStorage
@@ -554,6 +761,9 @@ func completeStorageSync(replicaID int, bucket models.Buckets, artifact localSto
 		if current.Status != models.ImageStorageStatusUploading {
 			return fmt.Errorf("task %d is no longer uploading (status=%s)", replicaID, current.Status)
 		}
+		if current.LeaseOwner != StorageWorkerID() {
+			return fmt.Errorf("task %d is leased by another worker (%s)", replicaID, current.LeaseOwner)
+		}
 
 		if bucket.Type != "default" && totalSize > 0 {
 			totalSizeUint := uint64(totalSize)
@@ -569,20 +779,27 @@ func completeStorageSync(replicaID int, bucket models.Buckets, artifact localSto
 		}
 
 		updates := map[string]any{
-			"storage":        bucket.Type,
-			"status":         models.ImageStorageStatusSuccess,
-			"url":            artifact.URL,
-			"thumbnail":      artifact.Thumbnail,
-			"file_size":      artifact.FileSize,
-			"thumbnail_size": artifact.ThumbnailSize,
-			"error":          "",
-			"metadata":       metadataValue,
-			"started_at":     nil,
-			"next_retry_at":  nil,
-			"synced_at":      &now,
+			"storage":            bucket.Type,
+			"status":             models.ImageStorageStatusSuccess,
+			"url":                artifact.URL,
+			"thumbnail":          artifact.Thumbnail,
+			"file_size":          artifact.FileSize,
+			"thumbnail_size":     artifact.ThumbnailSize,
+			"checksum":           checksum,
+			"thumbnail_checksum": thumbnailChecksum,
+			"processed":          artifact.Processed,
+			"mime_type":          mimeType,
+			"error":              "",
+			"metadata":           metadataValue,
+			"started_at":         nil,
+			"next_retry_at":      nil,
+			"synced_at":          &now,
+			"scrubbed_at":        &now,
+			"lease_owner":        "",
+			"lease_expires_at":   nil,
 		}
 		result := tx.Model(&models.ImageStorage{}).
-			Where("id = ? AND status = ?", replicaID, models.ImageStorageStatusUploading).
+			Where("id = ? AND status = ? AND lease_owner = ?", replicaID, models.ImageStorageStatusUploading, StorageWorkerID()).
 			Updates(updates)
 		if result.Error != nil {
 			return result.Error
@@ -601,24 +818,20 @@ func markStorageSyncFailed(replicaID int, syncErr error, metadata map[string]any
 		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, replicaID).Error; err != nil {
 			return err
 		}
-		if current.Status != models.ImageStorageStatusUploading {
+		if current.Status != models.ImageStorageStatusUploading || current.LeaseOwner != StorageWorkerID() {
 			return nil
 		}
 
 		attempts := current.RetryCount + 1
-		status := models.ImageStorageStatusFailed
-		var nextRetryAt *time.Time
-		if attempts < storageSyncMaxAttempts {
-			status = models.ImageStorageStatusPending
-			retryTime := time.Now().Add(time.Duration(1<<(attempts-1)) * 5 * time.Second)
-			nextRetryAt = &retryTime
-		}
+		status, nextRetryAt := loadStorageRetryPolicy().next(attempts)
 		updates := map[string]any{
-			"status":        status,
-			"error":         syncErr.Error(),
-			"retry_count":   attempts,
-			"started_at":    nil,
-			"next_retry_at": nextRetryAt,
+			"status":           status,
+			"error":            syncErr.Error(),
+			"retry_count":      attempts,
+			"started_at":       nil,
+			"next_retry_at":    nextRetryAt,
+			"lease_owner":      "",
+			"lease_expires_at": nil,
 		}
 		if metadata != nil {
 			metadataValue, err := storageMetadataValue(metadata)
@@ -628,7 +841,7 @@ func markStorageSyncFailed(replicaID int, syncErr error, metadata map[string]any
 			updates["metadata"] = metadataValue
 		}
 		return tx.Model(&models.ImageStorage{}).
-			Where("id = ? AND status = ?", replicaID, models.ImageStorageStatusUploading).
+			Where("id = ? AND status = ? AND lease_owner = ?", replicaID, models.ImageStorageStatusUploading, StorageWorkerID()).
 			Updates(updates).Error
 	})
 }
@@ -744,12 +957,13 @@ func timePointer(value time.Time) *time.Time {
 	return &copy
 }
 
-// DeleteImageReplicas deletes every remote replica first and the local
-// canonical files last. Successfully deleted replicas have their bucket usage
-// released. On a remote error, the local source is retained for a safe retry.
-func DeleteImageReplicas(ctx context.Context, image models.Image) error {
-	storageReplicaOperationMu.Lock()
-	defer storageReplicaOperationMu.Unlock()
+// DeleteImageReplicas removes every replica row of an image. Remote copies
+// become durable deletion tasks in the same transaction, so a backend that is
+// down never blocks the caller; pending uploads are simply dropped. Local
+// files are deleted last, once the remote tasks are safely queued.
+func DeleteImageReplicas(image models.Image) error {
+	unlock := lockImageOperations(image.Id)
+	defer unlock()
 
 	db := database.GetDB()
 	if db == nil || db.DB == nil {
@@ -781,39 +995,43 @@ func DeleteImageReplicas(ctx context.Context, image models.Image) error {
 	}
 
 	var localReplicas []models.ImageStorage
-	var deleteErrors []error
-	for _, replica := range replicas {
-		var bucket models.Buckets
-		if err := db.DB.First(&bucket, replica.BucketID).Error; err != nil {
-			deleteErrors = append(deleteErrors, fmt.Errorf("load bucket %d: %w", replica.BucketID, err))
-			continue
-		}
-		if bucket.Type == "default" || replica.Storage == "default" {
-			localReplicas = append(localReplicas, replica)
-			continue
-		}
-		if replica.Status == models.ImageStorageStatusPending {
-			if err := removeReplicaRecord(db.DB, bucket, replica); err != nil {
-				deleteErrors = append(deleteErrors, err)
+	queued := false
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, replica := range replicas {
+			var bucket models.Buckets
+			if err := tx.First(&bucket, replica.BucketID).Error; err != nil {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					return fmt.Errorf("load bucket %d: %w", replica.BucketID, err)
+				}
+				// The bucket and its files are already gone.
+				if replica.ID != 0 {
+					if err := tx.Delete(&models.ImageStorage{}, replica.ID).Error; err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if bucket.Type == "default" || replica.Storage == "default" {
+				localReplicas = append(localReplicas, replica)
+				continue
+			}
+			if replica.Status != models.ImageStorageStatusPending {
+				if err := queueReplicaDeletion(tx, image, bucket, replica); err != nil {
+					return err
+				}
+				queued = true
+			}
+			if err := removeReplicaRecord(tx, bucket, replica); err != nil {
+				return err
 			}
-			continue
-		}
-
-		if err := deleteRemoteReplica(ctx, image, bucket, replica); err != nil {
-			_ = db.DB.Model(&models.ImageStorage{}).Where("id = ?", replica.ID).Updates(map[string]any{
-				"status": models.ImageStorageStatusFailed,
-				"error":  err.Error(),
-			}).Error
-			deleteErrors = append(deleteErrors, fmt.Errorf("delete image %d from bucket %d: %w", image.Id, bucket.Id, err))
-			continue
-		}
-		if err := removeReplicaRecord(db.DB, bucket, replica); err != nil {
-			deleteErrors = append(deleteErrors, err)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-
-	if len(deleteErrors) > 0 {
-		return errors.Join(deleteErrors...)
+	if queued {
+		WakeReplicaDeletionWorker()
 	}
 
 	if image.Storage == "default" || len(localReplicas) > 0 {
@@ -821,6 +1039,7 @@ func DeleteImageReplicas(ctx context.Context, image models.Image) error {
 			return err
 		}
 	}
+	var deleteErrors []error
 	for _, replica := range localReplicas {
 		if replica.ID != 0 {
 			if err := db.DB.Delete(&models.ImageStorage{}, replica.ID).Error; err != nil {
@@ -834,9 +1053,6 @@ func DeleteImageReplicas(ctx context.Context, image models.Image) error {
 // DeleteBucketReplicas removes all replicas from one remote bucket without
 // deleting their canonical Image rows. The default local bucket is protected.
 func DeleteBucketReplicas(ctx context.Context, bucket models.Buckets) error {
-	storageReplicaOperationMu.Lock()
-	defer storageReplicaOperationMu.Unlock()
-
 	if bucket.Type == "default" {
 		return errors.New("the default local bucket cannot be deleted")
 	}
@@ -852,39 +1068,69 @@ func DeleteBucketReplicas(ctx context.Context, bucket models.Buckets) error {
 
 	var deleteErrors []error
 	for _, replica := range replicas {
-		var image models.Image
-		if err := db.DB.First(&image, replica.ImageID).Error; err != nil {
-			if !errors.Is(err, gorm.ErrRecordNotFound) {
-				deleteErrors = append(deleteErrors, err)
-				continue
-			}
-			image = models.Image{
-				Id:        replica.ImageID,
-				Url:       replica.URL,
-				Thumbnail: replica.Thumbnail,
-				FileName:  filepath.Base(replica.URL),
-			}
+		if err := deleteBucketReplica(ctx, db.DB, bucket, replica); err != nil {
+			deleteErrors = append(deleteErrors, err)
 		}
-		if replica.Status == models.ImageStorageStatusPending {
-			if err := removeReplicaRecord(db.DB, bucket, replica); err != nil {
-				deleteErrors = append(deleteErrors, err)
-			}
-			continue
+	}
+
+	var originals []models.ImageOriginal
+	if err := db.DB.Where("bucket_id = ?", bucket.Id).Order("id ASC").Find(&originals).Error; err != nil {
+		deleteErrors = append(deleteErrors, err)
+	}
+	for _, original := range originals {
+		if err := DeleteImageOriginal(ctx, original.ImageID); err != nil {
+			deleteErrors = append(deleteErrors, fmt.Errorf("delete archived original %d: %w", original.ID, err))
 		}
+	}
+	return errors.Join(deleteErrors...)
+}
 
-		if err := deleteRemoteReplica(ctx, image, bucket, replica); err != nil {
-			_ = db.DB.Model(&models.ImageStorage{}).Where("id = ?", replica.ID).Updates(map[string]any{
-				"status": models.ImageStorageStatusFailed,
-				"error":  err.Error(),
-			}).Error
-			deleteErrors = append(deleteErrors, fmt.Errorf("delete replica %d: %w", replica.ID, err))
-			continue
+// deleteBucketReplica removes one replica under its image lock. The row is
+// reloaded after locking because an upload may have finished in the meantime.
+// Files that cannot be deleted now are left to the replica deletion queue.
+func deleteBucketReplica(ctx context.Context, db *gorm.DB, bucket models.Buckets, replica models.ImageStorage) error {
+	unlock := lockImageOperations(replica.ImageID)
+	defer unlock()
+
+	if err := db.First(&replica, replica.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
 		}
-		if err := removeReplicaRecord(db.DB, bucket, replica); err != nil {
-			deleteErrors = append(deleteErrors, err)
+		return err
+	}
+	var image models.Image
+	if err := db.Unscoped().First(&image, replica.ImageID).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		image = models.Image{
+			Id:        replica.ImageID,
+			Url:       replica.URL,
+			Thumbnail: replica.Thumbnail,
+			FileName:  filepath.Base(replica.URL),
 		}
 	}
-	return errors.Join(deleteErrors...)
+	if replica.Status == models.ImageStorageStatusPending {
+		return removeReplicaRecord(db, bucket, replica)
+	}
+
+	if err := deleteRemoteReplica(ctx, image, bucket, replica); err != nil {
+		// Hand the files to the deletion queue instead of marking the replica
+		// failed, which would make upload retries copy it back.
+		task := newReplicaDeletion(image, bucket, replica)
+		task.Error = err.Error()
+		queueErr := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&task).Error; err != nil {
+				return err
+			}
+			return removeReplicaRecord(tx, bucket, replica)
+		})
+		if queueErr == nil {
+			WakeReplicaDeletionWorker()
+		}
+		return errors.Join(fmt.Errorf("delete replica %d: %w", replica.ID, err), queueErr)
+	}
+	return removeReplicaRecord(db, bucket, replica)
 }
 
 func deleteRemoteReplica(ctx context.Context, image models.Image, bucket models.Buckets, replica models.ImageStorage) error {
@@ -1072,17 +1318,12 @@ func isMissingRemoteFileError(err error) bool {
 func removeReplicaRecord(db *gorm.DB, bucket models.Buckets, replica models.ImageStorage) error {
 	return db.Transaction(func(tx *gorm.DB) error {
 		if replica.Status == models.ImageStorageStatusSuccess && bucket.Type != "default" {
-			totalSize := replica.FileSize + replica.ThumbnailSize
-			if totalSize > 0 {
-				totalSizeUint := uint64(totalSize)
-				if err := tx.Model(&models.Buckets{}).Where("id = ?", bucket.Id).
-					UpdateColumn("usage", gorm.Expr("CASE WHEN usage >= ? THEN usage - ? ELSE 0 END", totalSizeUint, totalSizeUint)).Error; err != nil {
-					return err
-				}
+			if err := ReleaseBucketUsage(tx, bucket.Id, replica.FileSize+replica.ThumbnailSize); err != nil {
+				return err
 			}
 		}
 		if replica.ID != 0 {
-			if err := tx.Model(&models.Image{}).
+			if err := tx.Unscoped().Model(&models.Image{}).
 				Where("id = ? AND access_bucket_id = ?", replica.ImageID, bucket.Id).
 				Update("access_bucket_id", 0).Error; err != nil {
 				return err
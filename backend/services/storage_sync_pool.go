@@ -0,0 +1,165 @@
+package services
+
+import (
+	"log"
+	"sync"
+
+	storageSettings "oneimg/backend/utils/settings"
+)
+
+// StorageSyncMaxConcurrency caps both the worker pool size and the per-bucket
+// concurrency limit.
+const StorageSyncMaxConcurrency = 32
+
+const (
+	defaultStorageSyncWorkers           = 4
+	defaultStorageSyncBucketConcurrency = 2
+)
+
+var (
+	storageSyncPoolMu        sync.Mutex
+	storageSyncActiveWorkers int
+	storageSyncSlots         = &storageSyncScheduler{inFlight: make(map[int]int)}
+	storageImageLocks        = &imageOperationLocks{locks: make(map[int]*imageOperationLock)}
+)
+
+// fillStorageSyncPool starts pool workers until the configured worker count is
+// reached. Workers exit as soon as no task can be scheduled, so the pool only
+// holds goroutines while there is work.
+func fillStorageSyncPool() {
+	setting, err := storageSettings.GetSettings()
+	if err != nil {
+		log.Printf("[storage-sync] failed to load pool settings: %v", err)
+		return
+	}
+	if !setting.MultiStorageSync {
+		return
+	}
+	workers := normalizeStorageSyncConcurrency(setting.StorageSyncWorkers, defaultStorageSyncWorkers)
+
+	storageSyncPoolMu.Lock()
+	defer storageSyncPoolMu.Unlock()
+	for storageSyncActiveWorkers < workers {
+		storageSyncActiveWorkers++
+		go runStorageSyncPoolWorker()
+	}
+}
+
+func runStorageSyncPoolWorker() {
+	processed := false
+	for processNextStorageSyncTask() {
+		processed = true
+	}
+
+	storageSyncPoolMu.Lock()
+	storageSyncActiveWorkers--
+	storageSyncPoolMu.Unlock()
+	if processed {
+		// Slots released by this worker may unblock tasks that idle workers
+		// skipped because their bucket was at its limit.
+		WakeStorageSyncWorker()
+	}
+}
+
+func normalizeStorageSyncConcurrency(value, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	if value > StorageSyncMaxConcurrency {
+		return StorageSyncMaxConcurrency
+	}
+	return value
+}
+
+// storageSyncCandidate is a bucket with at least one runnable pending task.
+type storageSyncCandidate struct {
+	BucketID        int
+	SyncConcurrency int
+}
+
+// storageSyncScheduler tracks in-flight tasks per bucket inside this process
+// and hands out bucket slots round-robin, so a slow bucket only ever occupies
+// its own slots and cannot starve replication to the others.
+type storageSyncScheduler struct {
+	mu         sync.Mutex
+	inFlight   map[int]int
+	lastBucket int
+}
+
+// reserve picks the first bucket after the one served last that still has a
+// free slot. candidates must be ordered by bucket id.
+func (s *storageSyncScheduler) reserve(candidates []storageSyncCandidate, defaultLimit int) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(candidates) == 0 {
+		return 0, false
+	}
+	start := 0
+	for i, candidate := range candidates {
+		if candidate.BucketID > s.lastBucket {
+			start = i
+			break
+		}
+	}
+	for i := range candidates {
+		candidate := candidates[(start+i)%len(candidates)]
+		limit := normalizeStorageSyncConcurrency(candidate.SyncConcurrency, defaultLimit)
+		if s.inFlight[candidate.BucketID] >= limit {
+			continue
+		}
+		s.inFlight[candidate.BucketID]++
+		s.lastBucket = candidate.BucketID
+		return candidate.BucketID, true
+	}
+	return 0, false
+}
+
+func (s *storageSyncScheduler) release(bucketID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inFlight[bucketID] <= 1 {
+		delete(s.inFlight, bucketID)
+		return
+	}
+	s.inFlight[bucketID]--
+}
+
+// imageOperationLocks serializes file operations (upload, delete, replace,
+// original archive) on one image inside this process. It only avoids wasted
+// work; persisted state is still guarded by database compare-and-swap.
+type imageOperationLocks struct {
+	mu    sync.Mutex
+	locks map[int]*imageOperationLock
+}
+
+type imageOperationLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func (l *imageOperationLocks) lock(imageID int) func() {
+	l.mu.Lock()
+	entry := l.locks[imageID]
+	if entry == nil {
+		entry = &imageOperationLock{}
+		l.locks[imageID] = entry
+	}
+	entry.refs++
+	l.mu.Unlock()
+
+	entry.mu.Lock()
+	return func() {
+		entry.mu.Unlock()
+		l.mu.Lock()
+		entry.refs--
+		if entry.refs == 0 {
+			delete(l.locks, imageID)
+		}
+		l.mu.Unlock()
+	}
+}
+
+func lockImageOperations(imageID int) func() {
+	return storageImageLocks.lock(imageID)
+}
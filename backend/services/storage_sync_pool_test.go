@@ -0,0 +1,78 @@
+package services
+
+import (
+	"testing"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestStorageSyncSchedulerRoundRobinsAndHonorsLimits(t *testing.T) {
+	scheduler := &storageSyncScheduler{inFlight: make(map[int]int)}
+	candidates := []storageSyncCandidate{
+		{BucketID: 2, SyncConcurrency: 1},
+		{BucketID: 3},
+		{BucketID: 5, SyncConcurrency: 3},
+	}
+
+	var order []int
+	for {
+		bucketID, ok := scheduler.reserve(candidates, 2)
+		if !ok {
+			break
+		}
+		order = append(order, bucketID)
+	}
+	want := []int{2, 3, 5, 3, 5, 5}
+	if len(order) != len(want) {
+		t.Fatalf("expected reservations %v, got %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected reservations %v, got %v", want, order)
+		}
+	}
+
+	scheduler.release(2)
+	if bucketID, ok := scheduler.reserve(candidates, 2); !ok || bucketID != 2 {
+		t.Fatalf("released slot should be reusable, got %d %v", bucketID, ok)
+	}
+	scheduler.release(5)
+	scheduler.release(3)
+	// Round-robin continues after the bucket served last.
+	if bucketID, ok := scheduler.reserve(candidates, 2); !ok || bucketID != 3 {
+		t.Fatalf("expected bucket 3 after bucket 2, got %d %v", bucketID, ok)
+	}
+}
+
+func TestStorageWorkerSkipsBucketAtConcurrencyLimit(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{MultiStorageSync: true, StorageSyncWorkers: 4, StorageSyncBucketConcurrency: 1}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	if err := db.Create(&models.Buckets{Id: 2, Name: "slow-ftp", Type: "ftp", Config: map[string]any{}}).Error; err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+	replica := models.ImageStorage{ImageID: 1, BucketID: 2, Storage: "ftp", Status: models.ImageStorageStatusPending}
+	if err := db.Create(&replica).Error; err != nil {
+		t.Fatalf("create pending replica: %v", err)
+	}
+
+	// Simulate a long-running upload that already occupies the bucket's slot.
+	if _, ok := storageSyncSlots.reserve([]storageSyncCandidate{{BucketID: 2}}, 1); !ok {
+		t.Fatal("reserve slot")
+	}
+	defer storageSyncSlots.release(2)
+
+	if processNextStorageSyncTask() {
+		t.Fatal("worker claimed a task although the bucket was at its concurrency limit")
+	}
+	var stored models.ImageStorage
+	if err := db.First(&stored, replica.ID).Error; err != nil {
+		t.Fatalf("reload replica: %v", err)
+	}
+	if stored.Status != models.ImageStorageStatusPending {
+		t.Fatalf("task should stay pending, got %q", stored.Status)
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	storageSettings "oneimg/backend/utils/settings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultStorageSyncMaxAttempts = 3
+	defaultStorageSyncBackoffBase = 5 * time.Second
+	defaultStorageSyncBackoffMax  = time.Hour
+)
+
+// storageRetryPolicy decides what happens to a task after a failed attempt.
+type storageRetryPolicy struct {
+	maxAttempts int
+	base        time.Duration
+	max         time.Duration
+}
+
+// loadStorageRetryPolicy reads the retry settings and falls back to the
+// defaults when they are missing or out of range.
+func loadStorageRetryPolicy() storageRetryPolicy {
+	policy := storageRetryPolicy{
+		maxAttempts: defaultStorageSyncMaxAttempts,
+		base:        defaultStorageSyncBackoffBase,
+		max:         defaultStorageSyncBackoffMax,
+	}
+	setting, err := storageSettings.GetSettings()
+	if err != nil {
+		return policy
+	}
+	if setting.StorageSyncMaxAttempts > 0 {
+		policy.maxAttempts = setting.StorageSyncMaxAttempts
+	}
+	if setting.StorageSyncBackoffBase > 0 {
+		policy.base = time.Duration(setting.StorageSyncBackoffBase) * time.Second
+	}
+	if setting.StorageSyncBackoffMax > 0 {
+		policy.max = time.Duration(setting.StorageSyncBackoffMax) * time.Second
+	}
+	if policy.max < policy.base {
+		policy.max = policy.base
+	}
+	return policy
+}
+
+// delay returns the wait before the given retry. The exponential part is
+// capped at max and only its upper half is randomized, so tasks that failed
+// together spread out without retrying earlier than half the nominal delay.
+func (p storageRetryPolicy) delay(attempts int) time.Duration {
+	delay := p.max
+	if shift := attempts - 1; shift < 32 {
+		if exponential := p.base << shift; exponential > 0 && exponential < p.max {
+			delay = exponential
+		}
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// next returns the status and retry time of a task after it failed for the
+// given number of attempts.
+func (p storageRetryPolicy) next(attempts int) (string, *time.Time) {
+	if attempts >= p.maxAttempts {
+		return models.ImageStorageStatusDeadLetter, nil
+	}
+	retryAt := time.Now().Add(p.delay(attempts))
+	return models.ImageStorageStatusPending, &retryAt
+}
+
+// retryableStorageStatuses are the replica states an administrator can send
+// back to the queue.
+var retryableStorageStatuses = []string{models.ImageStorageStatusFailed, models.ImageStorageStatusDeadLetter}
+
+// RetryReplica queues one failed or dead-lettered replica again. The last
+// error is kept until the next attempt finishes.
+func RetryReplica(replicaID int) (int64, error) {
+	return retryReplicas(func(db *gorm.DB) *gorm.DB {
+		return db.Where("id = ?", replicaID)
+	})
+}
+
+// RetryBucketReplicas queues every failed or dead-lettered replica of a bucket.
+func RetryBucketReplicas(bucketID int) (int64, error) {
+	return retryReplicas(func(db *gorm.DB) *gorm.DB {
+		return db.Where("bucket_id = ?", bucketID)
+	})
+}
+
+// RetryFailedReplicas queues every failed or dead-lettered replica.
+func RetryFailedReplicas() (int64, error) {
+	return retryReplicas(func(db *gorm.DB) *gorm.DB { return db })
+}
+
+func retryReplicas(scope func(*gorm.DB) *gorm.DB) (int64, error) {
+	db := database.GetDB().DB
+	result := scope(db.Model(&models.ImageStorage{})).
+		Where("status IN ?", retryableStorageStatuses).
+		Updates(map[string]any{
+			"status":        models.ImageStorageStatusPending,
+			"retry_count":   0,
+			"started_at":    nil,
+			"next_retry_at": nil,
+		})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected > 0 {
+		WakeStorageSyncWorker()
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestStorageRetryPolicyBacksOffWithJitter(t *testing.T) {
+	policy := storageRetryPolicy{maxAttempts: 4, base: 10 * time.Second, max: 30 * time.Second}
+	for attempts, nominal := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 30 * time.Second, 40: 30 * time.Second} {
+		for range 50 {
+			delay := policy.delay(attempts)
+			if delay < nominal/2 || delay > nominal {
+				t.Fatalf("attempt %d delay %s outside [%s, %s]", attempts, delay, nominal/2, nominal)
+			}
+		}
+	}
+
+	if status, retryAt := policy.next(3); status != models.ImageStorageStatusPending || retryAt == nil {
+		t.Fatalf("attempt 3 should be retried, got %s %v", status, retryAt)
+	}
+	if status, retryAt := policy.next(4); status != models.ImageStorageStatusDeadLetter || retryAt != nil {
+		t.Fatalf("attempt 4 should be dead-lettered, got %s %v", status, retryAt)
+	}
+}
+
+func TestRetryReplicasRequeuesFailedAndDeadLetter(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+	replicas := []models.ImageStorage{
+		{ImageID: 1, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusDeadLetter, RetryCount: 3, Error: "timeout"},
+		{ImageID: 2, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusFailed, RetryCount: 1, Error: "denied"},
+		{ImageID: 3, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusSuccess},
+		{ImageID: 1, BucketID: 3, Storage: "ftp", Status: models.ImageStorageStatusDeadLetter, RetryCount: 3},
+	}
+	if err := db.Create(&replicas).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+
+	if retried, err := RetryReplica(replicas[2].ID); err != nil || retried != 0 {
+		t.Fatalf("successful replica must not be retried: %d %v", retried, err)
+	}
+	if retried, err := RetryBucketReplicas(2); err != nil || retried != 2 {
+		t.Fatalf("expected 2 retried replicas in bucket 2, got %d %v", retried, err)
+	}
+	var stored models.ImageStorage
+	if err := db.First(&stored, replicas[0].ID).Error; err != nil {
+		t.Fatalf("reload replica: %v", err)
+	}
+	if stored.Status != models.ImageStorageStatusPending || stored.RetryCount != 0 || stored.Error != "timeout" {
+		t.Fatalf("retried replica should be pending with its last error kept: %+v", stored)
+	}
+	if retried, err := RetryFailedReplicas(); err != nil || retried != 1 {
+		t.Fatalf("expected the remaining dead letter to be retried, got %d %v", retried, err)
+	}
+}
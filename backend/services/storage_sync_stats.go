@@ -0,0 +1,196 @@
+package services
+
+import (
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+// StorageQueueBucketStats summarizes the replication queue of one bucket.
+type StorageQueueBucketStats struct {
+	BucketID                int              `json:"bucket_id"`
+	Name                    string           `json:"name"`
+	Type                    string           `json:"type"`
+	Disabled                bool             `json:"disabled"`
+	SyncPaused              bool             `json:"sync_paused"`
+	Counts                  map[string]int64 `json:"counts"`
+	OldestPendingAt         *time.Time       `json:"oldest_pending_at"`
+	OldestPendingAgeSeconds int64            `json:"oldest_pending_age_seconds"`
+}
+
+// StorageThroughputPoint counts replicas completed in one time slot.
+type StorageThroughputPoint struct {
+	BucketID  int       `json:"bucket_id"`
+	Start     time.Time `json:"start"`
+	Completed int64     `json:"completed"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// StorageInFlightTask is a replica currently claimed by a worker.
+type StorageInFlightTask struct {
+	ID             int        `json:"id"`
+	ImageID        int        `json:"image_id"`
+	BucketID       int        `json:"bucket_id"`
+	RetryCount     int        `json:"retry_count"`
+	StartedAt      *time.Time `json:"started_at"`
+	RunningSeconds int64      `json:"running_seconds"`
+	WorkerID       string     `json:"worker_id"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at"`
+}
+
+// StorageQueueOverview returns per-bucket status counts and the age of the
+// oldest pending task for every remote bucket. The local bucket is omitted
+// because its replicas never queue.
+func StorageQueueOverview() ([]StorageQueueBucketStats, error) {
+	db := database.GetDB().DB
+	var bucketList []models.Buckets
+	if err := db.Select("id", "name", "type", "disabled", "sync_paused").
+		Where("type <> ?", "default").Order("id ASC").Find(&bucketList).Error; err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		BucketID int
+		Status   string
+		Count    int64
+	}
+	if err := db.Model(&models.ImageStorage{}).
+		Select("bucket_id, status, COUNT(*) AS count").
+		Group("bucket_id, status").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+
+	stats := make([]StorageQueueBucketStats, 0, len(bucketList))
+	index := make(map[int]int, len(bucketList))
+	for _, bucket := range bucketList {
+		index[bucket.Id] = len(stats)
+		stats = append(stats, StorageQueueBucketStats{
+			BucketID:   bucket.Id,
+			Name:       bucket.Name,
+			Type:       bucket.Type,
+			Disabled:   bucket.Disabled,
+			SyncPaused: bucket.SyncPaused,
+			Counts: map[string]int64{
+				models.ImageStorageStatusPending:    0,
+				models.ImageStorageStatusUploading:  0,
+				models.ImageStorageStatusSuccess:    0,
+				models.ImageStorageStatusFailed:     0,
+				models.ImageStorageStatusDeadLetter: 0,
+			},
+		})
+	}
+	for _, row := range counts {
+		if i, ok := index[row.BucketID]; ok {
+			stats[i].Counts[row.Status] = row.Count
+		}
+	}
+
+	now := time.Now()
+	for i := range stats {
+		if stats[i].Counts[models.ImageStorageStatusPending] == 0 {
+			continue
+		}
+		var oldest models.ImageStorage
+		lookup := db.Select("id", "created_at").
+			Where("bucket_id = ? AND status = ?", stats[i].BucketID, models.ImageStorageStatusPending).
+			Order("created_at ASC").Limit(1).Find(&oldest)
+		if lookup.Error != nil {
+			return nil, lookup.Error
+		}
+		if lookup.RowsAffected > 0 {
+			createdAt := oldest.CreatedAt
+			stats[i].OldestPendingAt = &createdAt
+			stats[i].OldestPendingAgeSeconds = int64(now.Sub(createdAt).Seconds())
+		}
+	}
+	return stats, nil
+}
+
+// StorageQueueThroughput counts remote replicas completed since the given
+// time, grouped per bucket into slots of the given width. Aggregation happens
+// in the database. The series is derived from each replica's latest
+// synced_at rather than an event log: a replica that is uploaded again
+// (manual retry, scrub repair, migration) moves to its newest slot and drops
+// out of the earlier one, and deleted replicas are no longer counted.
+func StorageQueueThroughput(since time.Time, slot time.Duration) ([]StorageThroughputPoint, error) {
+	db := database.GetDB().DB
+	seconds := int64(slot / time.Second)
+	if seconds <= 0 {
+		seconds = 1
+	}
+	slotStart := storageSlotStartExpr(db.Dialector.Name(), "image_storages.synced_at")
+
+	var rows []struct {
+		BucketID  int
+		SlotStart int64
+		Completed int64
+		Bytes     int64
+	}
+	if err := db.Model(&models.ImageStorage{}).
+		Select("image_storages.bucket_id AS bucket_id, "+slotStart+" AS slot_start, COUNT(*) AS completed, "+
+			"COALESCE(SUM(image_storages.file_size + image_storages.thumbnail_size), 0) AS bytes", seconds, seconds).
+		Joins("JOIN buckets ON buckets.id = image_storages.bucket_id").
+		Where("image_storages.status = ? AND image_storages.synced_at >= ? AND buckets.type <> ?", models.ImageStorageStatusSuccess, since, "default").
+		Group("image_storages.bucket_id, slot_start").
+		Order("slot_start ASC, image_storages.bucket_id ASC").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	series := make([]StorageThroughputPoint, 0, len(rows))
+	for _, row := range rows {
+		series = append(series, StorageThroughputPoint{
+			BucketID:  row.BucketID,
+			Start:     time.Unix(row.SlotStart, 0),
+			Completed: row.Completed,
+			Bytes:     row.Bytes,
+		})
+	}
+	return series, nil
+}
+
+// storageSlotStartExpr returns a SQL expression that rounds a timestamp
+// column down to a multiple of a slot width in Unix seconds. The expression
+// takes the width twice as bind parameters.
+func storageSlotStartExpr(dialect, column string) string {
+	switch dialect {
+	case "mysql":
+		return "(UNIX_TIMESTAMP(" + column + ") DIV ?) * ?"
+	case "postgres":
+		return "(CAST(EXTRACT(EPOCH FROM " + column + ") AS BIGINT) / ?) * ?"
+	default:
+		return "(CAST(strftime('%s', " + column + ") AS INTEGER) / ?) * ?"
+	}
+}
+
+// StorageQueueInFlight lists replicas that workers are uploading right now,
+// longest running first.
+func StorageQueueInFlight() ([]StorageInFlightTask, error) {
+	db := database.GetDB().DB
+	var replicas []models.ImageStorage
+	if err := db.Where("status = ?", models.ImageStorageStatusUploading).
+		Order("started_at ASC").Find(&replicas).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tasks := make([]StorageInFlightTask, 0, len(replicas))
+	for _, replica := range replicas {
+		task := StorageInFlightTask{
+			ID:             replica.ID,
+			ImageID:        replica.ImageID,
+			BucketID:       replica.BucketID,
+			RetryCount:     replica.RetryCount,
+			StartedAt:      replica.StartedAt,
+			WorkerID:       replica.LeaseOwner,
+			LeaseExpiresAt: replica.LeaseExpiresAt,
+		}
+		if replica.StartedAt != nil {
+			task.RunningSeconds = int64(now.Sub(*replica.StartedAt).Seconds())
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestStorageQueueOverviewAndThroughput(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+	buckets := []models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}},
+		{Id: 2, Name: "remote", Type: "s3", Config: map[string]any{}},
+		{Id: 3, Name: "archive", Type: "ftp", SyncPaused: true, Config: map[string]any{}},
+	}
+	if err := db.Create(&buckets).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+
+	now := time.Now()
+	hourAgo := now.Add(-time.Hour)
+	replicas := []models.ImageStorage{
+		{ImageID: 1, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess, SyncedAt: &now},
+		{ImageID: 1, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusSuccess, FileSize: 10, ThumbnailSize: 2, SyncedAt: &now},
+		{ImageID: 2, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusSuccess, FileSize: 5, SyncedAt: &hourAgo},
+		{ImageID: 3, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusDeadLetter, Error: "timeout"},
+		{ImageID: 1, BucketID: 3, Storage: "ftp", Status: models.ImageStorageStatusPending, CreatedAt: now.Add(-48 * time.Hour)},
+		{ImageID: 2, BucketID: 3, Storage: "ftp", Status: models.ImageStorageStatusPending},
+	}
+	if err := db.Create(&replicas).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+
+	stats, err := StorageQueueOverview()
+	if err != nil {
+		t.Fatalf("overview: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("local bucket should be omitted, got %+v", stats)
+	}
+	if stats[0].Counts[models.ImageStorageStatusSuccess] != 2 || stats[0].Counts[models.ImageStorageStatusDeadLetter] != 1 || stats[0].OldestPendingAt != nil {
+		t.Fatalf("unexpected remote stats: %+v", stats[0])
+	}
+	if !stats[1].SyncPaused || stats[1].Counts[models.ImageStorageStatusPending] != 2 || stats[1].OldestPendingAgeSeconds < 47*3600 {
+		t.Fatalf("unexpected paused bucket stats: %+v", stats[1])
+	}
+
+	series, err := StorageQueueThroughput(now.Add(-2*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatalf("throughput: %v", err)
+	}
+	var completed, bytes int64
+	for _, point := range series {
+		if point.BucketID != 2 {
+			t.Fatalf("only remote completions should be counted: %+v", point)
+		}
+		completed += point.Completed
+		bytes += point.Bytes
+	}
+	if completed != 2 || bytes != 17 {
+		t.Fatalf("expected 2 completions and 17 bytes, got %d and %d", completed, bytes)
+	}
+	if len(series) != 2 {
+		t.Fatalf("expected one point per hour slot, got %+v", series)
+	}
+	if want := time.Unix(now.Unix()/3600*3600, 0); !series[1].Start.Equal(want) || series[1].Bytes != 12 {
+		t.Fatalf("latest point = %+v, want slot %v with 12 bytes", series[1], want)
+	}
+}
+
+func TestStorageWorkerSkipsPausedBucket(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{MultiStorageSync: true}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	if err := db.Create(&models.Buckets{Id: 2, Name: "remote", Type: "s3", SyncPaused: true, Config: map[string]any{}}).Error; err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+	if err := db.Create(&models.ImageStorage{ImageID: 1, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusPending}).Error; err != nil {
+		t.Fatalf("create replica: %v", err)
+	}
+	if processNextStorageSyncTask() {
+		t.Fatal("worker picked up a task from a paused bucket")
+	}
+}
@@ -1,13 +1,21 @@
 package services
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
 	"path/filepath"
 	"testing"
 
 	"oneimg/backend/config"
 	"oneimg/backend/database"
 	"oneimg/backend/models"
+	"oneimg/backend/utils/images"
 )
 
 func TestBackfillImageStoragesIsIdempotent(t *testing.T) {
@@ -97,16 +105,17 @@ func TestStorageSyncFailureRetriesBeforeTerminalFailure(t *testing.T) {
 	initStorageSyncTestDB(t)
 	db := database.GetDB().DB
 	replica := models.ImageStorage{
-		ImageID:  1,
-		BucketID: 2,
-		Storage:  "s3",
-		Status:   models.ImageStorageStatusUploading,
+		ImageID:    1,
+		BucketID:   2,
+		Storage:    "s3",
+		Status:     models.ImageStorageStatusUploading,
+		LeaseOwner: StorageWorkerID(),
 	}
 	if err := db.Create(&replica).Error; err != nil {
 		t.Fatalf("create uploading replica: %v", err)
 	}
 
-	for attempt := 1; attempt <= storageSyncMaxAttempts; attempt++ {
+	for attempt := 1; attempt <= defaultStorageSyncMaxAttempts; attempt++ {
 		if err := markStorageSyncFailed(replica.ID, errors.New("temporary failure"), nil); err != nil {
 			t.Fatalf("mark attempt %d failed: %v", attempt, err)
 		}
@@ -117,18 +126,19 @@ func TestStorageSyncFailureRetriesBeforeTerminalFailure(t *testing.T) {
 		if stored.RetryCount != attempt {
 			t.Fatalf("attempt %d stored retry_count=%d", attempt, stored.RetryCount)
 		}
-		if attempt < storageSyncMaxAttempts {
+		if attempt < defaultStorageSyncMaxAttempts {
 			if stored.Status != models.ImageStorageStatusPending || stored.NextRetryAt == nil {
 				t.Fatalf("attempt %d should be scheduled for retry: %+v", attempt, stored)
 			}
 			if err := db.Model(&stored).Updates(map[string]any{
 				"status":        models.ImageStorageStatusUploading,
+				"lease_owner":   StorageWorkerID(),
 				"next_retry_at": nil,
 			}).Error; err != nil {
 				t.Fatalf("prepare attempt %d: %v", attempt+1, err)
 			}
-		} else if stored.Status != models.ImageStorageStatusFailed || stored.NextRetryAt != nil {
-			t.Fatalf("final attempt should be terminal failure: %+v", stored)
+		} else if stored.Status != models.ImageStorageStatusDeadLetter || stored.NextRetryAt != nil {
+			t.Fatalf("final attempt should be dead-lettered: %+v", stored)
 		}
 	}
 }
@@ -147,10 +157,11 @@ func TestCompleteStorageSyncPersistsMetadataAndUsageOnce(t *testing.T) {
 		t.Fatalf("create bucket: %v", err)
 	}
 	replica := models.ImageStorage{
-		ImageID:  1,
-		BucketID: bucket.Id,
-		Storage:  bucket.Type,
-		Status:   models.ImageStorageStatusUploading,
+		ImageID:    1,
+		BucketID:   bucket.Id,
+		Storage:    bucket.Type,
+		Status:     models.ImageStorageStatusUploading,
+		LeaseOwner: StorageWorkerID(),
 	}
 	if err := db.Create(&replica).Error; err != nil {
 		t.Fatalf("create replica: %v", err)
@@ -193,3 +204,85 @@ func initStorageSyncTestDB(t *testing.T) {
 		SqlitePath: filepath.Join(t.TempDir(), "storage-sync.db"),
 	})
 }
+
+func TestStorageSyncAppliesBucketProcessingToReplicas(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	images.InitImageService()
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{MultiStorageSync: true, CompressQuality: 80}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+
+	picture := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for x := range 32 {
+		for y := range 32 {
+			picture.Set(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y * 8), B: 128, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, picture); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	content := encoded.Bytes()
+	if err := os.MkdirAll("uploads", 0o755); err != nil {
+		t.Fatalf("create uploads: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join("uploads", "a.png"), content, 0o644); err != nil {
+		t.Fatalf("write local file: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	cdnDAV, cdnServer := newMemoryWebDAV(t, map[string][]byte{})
+	archiveDAV, archiveServer := newMemoryWebDAV(t, map[string][]byte{})
+	if err := db.Create(&[]models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}},
+		{Id: 2, Name: "cdn", Type: "webdav", Config: map[string]any{"webdav_url": cdnServer.URL},
+			Processing: &models.BucketProcessing{OutputFormat: models.BucketOutputFormatWebP}},
+		{Id: 3, Name: "archive", Type: "webdav", Config: map[string]any{"webdav_url": archiveServer.URL},
+			Processing: &models.BucketProcessing{KeepOriginal: true}},
+	}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	img := models.Image{Url: "/uploads/a.png", FileName: "a.png", FileSize: int64(len(content)), MimeType: "image/png", Storage: "default", BucketId: 1}
+	if err := db.Create(&img).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	if err := db.Create(&[]models.ImageStorage{
+		{ImageID: img.Id, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess, URL: img.Url, FileSize: img.FileSize, Checksum: checksum},
+		{ImageID: img.Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusPending},
+		{ImageID: img.Id, BucketID: 3, Storage: "webdav", Status: models.ImageStorageStatusPending},
+	}).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+
+	for range 2 {
+		if !processNextStorageSyncTask() {
+			t.Fatal("expected a replica to be processed")
+		}
+	}
+
+	var cdn, archive models.ImageStorage
+	db.Where("image_id = ? AND bucket_id = ?", img.Id, 2).First(&cdn)
+	db.Where("image_id = ? AND bucket_id = ?", img.Id, 3).First(&archive)
+
+	derived := cdnDAV.file("/uploads/a.png")
+	derivedSum := sha256.Sum256(derived)
+	if len(derived) < 12 || string(derived[8:12]) != "WEBP" {
+		t.Fatalf("cdn bucket should receive WebP bytes, got %d bytes", len(derived))
+	}
+	if cdn.Status != models.ImageStorageStatusSuccess || !cdn.Processed || cdn.MimeType != "image/webp" ||
+		cdn.FileSize != int64(len(derived)) || cdn.Checksum != hex.EncodeToString(derivedSum[:]) {
+		t.Fatalf("unexpected cdn replica %+v", cdn)
+	}
+	if !bytes.Equal(archiveDAV.file("/uploads/a.png"), content) {
+		t.Fatal("archive bucket should keep the original bytes")
+	}
+	if archive.Status != models.ImageStorageStatusSuccess || archive.Processed || archive.MimeType != "" || archive.Checksum != checksum {
+		t.Fatalf("unexpected archive replica %+v", archive)
+	}
+	if local, err := os.ReadFile(filepath.Join("uploads", "a.png")); err != nil || !bytes.Equal(local, content) {
+		t.Fatalf("local original must stay untouched: %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	storageSettings "oneimg/backend/utils/settings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	storageTieringTick      = time.Hour
+	storageTieringBatchSize = 100
+	// imageAccessRecordInterval limits how often serving an image writes its
+	// last access time.
+	imageAccessRecordInterval = 24 * time.Hour
+)
+
+var (
+	ErrStorageTieringRunning = errors.New("a tiering run is already in progress")
+
+	storageTieringStartOnce sync.Once
+	storageTieringRunning   sync.Mutex
+)
+
+// StorageTieringResult summarizes one tiering run.
+type StorageTieringResult struct {
+	Rules  int `json:"rules"`
+	Moved  int `json:"moved"`
+	Queued int `json:"queued"`
+	Failed int `json:"failed"`
+}
+
+// StartStorageTieringWorker runs the enabled tiering rules every hour.
+func StartStorageTieringWorker() {
+	storageTieringStartOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(storageTieringTick)
+			defer ticker.Stop()
+			for range ticker.C {
+				if _, err := RunStorageTiering(context.Background()); err != nil && !errors.Is(err, ErrStorageTieringRunning) {
+					log.Printf("[storage-tiering] run failed: %v", err)
+				}
+			}
+		}()
+	})
+}
+
+// RecordImageAccess stores the time an image was served, at most once per
+// imageAccessRecordInterval.
+func RecordImageAccess(db *gorm.DB, image models.Image) {
+	now := time.Now()
+	if image.LastAccessedAt != nil && now.Sub(*image.LastAccessedAt) < imageAccessRecordInterval {
+		return
+	}
+	if err := db.Model(&models.Image{}).
+		Where("id = ? AND (last_accessed_at IS NULL OR last_accessed_at < ?)", image.Id, now.Add(-imageAccessRecordInterval)).
+		UpdateColumn("last_accessed_at", &now).Error; err != nil {
+		log.Printf("[storage-tiering] failed to record access of image %d: %v", image.Id, err)
+	}
+}
+
+// RunStorageTiering applies every enabled tiering rule once. Qualifying
+// images without a replica in the rule's bucket get one queued and are moved
+// on a later run, after the sync worker has uploaded it.
+func RunStorageTiering(ctx context.Context) (StorageTieringResult, error) {
+	var result StorageTieringResult
+	if !storageTieringRunning.TryLock() {
+		return result, ErrStorageTieringRunning
+	}
+	defer storageTieringRunning.Unlock()
+
+	db := database.GetDB()
+	if db == nil || db.DB == nil {
+		return result, errors.New("database is not initialized")
+	}
+	setting, err := storageSettings.GetSettings()
+	if err != nil {
+		return result, err
+	}
+	if !setting.MultiStorageSync {
+		return result, nil
+	}
+	var rules []models.TieringRule
+	if err := db.DB.Where("enabled = ?", true).Order("id ASC").Find(&rules).Error; err != nil {
+		return result, err
+	}
+	for _, rule := range rules {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if err := applyTieringRule(ctx, db.DB, rule, &result); err != nil {
+			log.Printf("[storage-tiering] rule %d: %v", rule.ID, err)
+			continue
+		}
+		result.Rules++
+	}
+	if result.Queued > 0 {
+		WakeStorageSyncWorker()
+	}
+	if result.Moved > 0 || result.Queued > 0 || result.Failed > 0 {
+		log.Printf("[storage-tiering] moved %d image(s), queued %d replica(s), %d failure(s)", result.Moved, result.Queued, result.Failed)
+	}
+	return result, nil
+}
+
+func applyTieringRule(ctx context.Context, db *gorm.DB, rule models.TieringRule, result *StorageTieringResult) error {
+	var target models.Buckets
+	if err := db.First(&target, rule.TargetBucketID).Error; err != nil {
+		return fmt.Errorf("load bucket %d: %w", rule.TargetBucketID, err)
+	}
+	if target.Type == "default" {
+		return errors.New("the local bucket cannot be a tiering target")
+	}
+	if target.Disabled {
+		return nil
+	}
+
+	now := time.Now()
+	lastID := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		query := db.Model(&models.Image{}).
+			Where("images.id > ?", lastID).
+			Where("EXISTS (SELECT 1 FROM image_storages JOIN buckets ON buckets.id = image_storages.bucket_id "+
+				"WHERE image_storages.image_id = images.id AND buckets.type = ? AND image_storages.status = ?)",
+				"default", models.ImageStorageStatusSuccess)
+		if rule.MinAgeDays > 0 {
+			query = query.Where("images.created_at < ?", now.AddDate(0, 0, -rule.MinAgeDays))
+		}
+		if rule.IdleDays > 0 {
+			idleSince := now.AddDate(0, 0, -rule.IdleDays)
+			query = query.Where("((images.last_accessed_at IS NULL AND images.created_at < ?) OR images.last_accessed_at < ?)", idleSince, idleSince)
+		}
+		var images []models.Image
+		if err := query.Order("images.id ASC").Limit(storageTieringBatchSize).Find(&images).Error; err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			return nil
+		}
+		for _, image := range images {
+			lastID = image.Id
+			moved, queued, err := tierImage(ctx, db, image.Id, target)
+			switch {
+			case err != nil:
+				result.Failed++
+				log.Printf("[storage-tiering] image %d to bucket %d: %v", image.Id, target.Id, err)
+			case moved:
+				result.Moved++
+			case queued:
+				result.Queued++
+			}
+		}
+	}
+}
+
+// tierImage moves one image to the cold bucket. Without a replica there it
+// queues one; with a verified replica, and once every other replica of the
+// image is synchronized, it makes that bucket the access source and deletes
+// the local copy. Profile thumbnails stay on the local disk.
+func tierImage(ctx context.Context, db *gorm.DB, imageID int, target models.Buckets) (moved, queued bool, err error) {
+	unlock := lockImageOperations(imageID)
+	defer unlock()
+
+	var image models.Image
+	if err := db.First(&image, imageID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	var replicas []models.ImageStorage
+	if err := db.Joins("JOIN buckets ON buckets.id = image_storages.bucket_id").
+		Where("image_storages.image_id = ? AND (buckets.type = ? OR buckets.id = ?)", imageID, "default", target.Id).
+		Find(&replicas).Error; err != nil {
+		return false, false, err
+	}
+	var local, cold *models.ImageStorage
+	for i := range replicas {
+		if replicas[i].BucketID == target.Id {
+			cold = &replicas[i]
+		} else if replicas[i].Status == models.ImageStorageStatusSuccess {
+			local = &replicas[i]
+		}
+	}
+	if local == nil {
+		return false, false, nil
+	}
+	if cold == nil {
+		row := models.ImageStorage{
+			ImageID:       imageID,
+			BucketID:      target.Id,
+			Storage:       target.Type,
+			Status:        models.ImageStorageStatusPending,
+			URL:           local.URL,
+			Thumbnail:     local.Thumbnail,
+			FileSize:      local.FileSize,
+			ThumbnailSize: local.ThumbnailSize,
+		}
+		created := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+		return false, created.RowsAffected > 0, created.Error
+	}
+	if cold.Status != models.ImageStorageStatusSuccess {
+		// Still uploading or waiting for a retry.
+		return false, false, nil
+	}
+	// Other replicas that are still queued or failed may need the local files
+	// for their upload; keep them until every copy is in place.
+	var unfinished int64
+	if err := db.Model(&models.ImageStorage{}).
+		Where("image_id = ? AND id NOT IN ? AND status <> ?", imageID, []int{local.ID, cold.ID}, models.ImageStorageStatusSuccess).
+		Count(&unfinished).Error; err != nil {
+		return false, false, err
+	}
+	if unfinished > 0 {
+		return false, false, nil
+	}
+
+	verifyContext, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if err := verifyRemoteReplica(verifyContext, target, *cold); err != nil {
+		return false, false, fmt.Errorf("verify cold replica: %w", err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if image.AccessBucketId == 0 || image.AccessBucketId == local.BucketID {
+			if err := tx.Model(&models.Image{}).Where("id = ?", imageID).
+				Update("access_bucket_id", target.Id).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&models.ImageStorage{}, local.ID).Error; err != nil {
+			return err
+		}
+		return ReleaseBucketUsage(tx, local.BucketID, local.FileSize+local.ThumbnailSize)
+	})
+	if err != nil {
+		return false, false, err
+	}
+	// The database no longer references the files, so a failed removal only
+	// leaves orphans for the orphan scanner.
+	if err := deleteLocalImageFiles(models.Image{Url: local.URL, Thumbnail: local.Thumbnail}); err != nil {
+		log.Printf("[storage-tiering] image %d moved but local files were kept: %v", imageID, err)
+	}
+	log.Printf("[storage-tiering] image %d moved to bucket %d (%s)", imageID, target.Id, target.Type)
+	return true, false, nil
+}
+
+// CountSoleReplicas returns how many images whose canonical bucket is
+// another one keep their only successful copy in the given bucket, which is
+// the case once tiering removed the local copy.
+func CountSoleReplicas(db *gorm.DB, bucketID int) (int64, error) {
+	var count int64
+	err := db.Model(&models.ImageStorage{}).
+		Joins("JOIN images ON images.id = image_storages.image_id").
+		Where("image_storages.bucket_id = ? AND image_storages.status = ? AND images.bucket_id <> ?", bucketID, models.ImageStorageStatusSuccess, bucketID).
+		Where("NOT EXISTS (SELECT 1 FROM image_storages other WHERE other.image_id = image_storages.image_id AND other.id <> image_storages.id AND other.status = ?)",
+			models.ImageStorageStatusSuccess).
+		Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestStorageTieringMovesIdleImages(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+	if err := db.Create(&models.Settings{MultiStorageSync: true}).Error; err != nil {
+		t.Fatalf("create settings: %v", err)
+	}
+	buckets := []models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}, Usage: 100},
+		{Id: 2, Name: "telegram", Type: "telegram", Config: map[string]any{}},
+	}
+	if err := db.Create(&buckets).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+
+	old := time.Now().AddDate(0, 0, -60)
+	recent := time.Now().Add(-time.Hour)
+	imageList := []models.Image{
+		{Url: "/uploads/idle.webp", FileName: "idle.webp", Storage: "default", BucketId: 1, AccessBucketId: 1, CreatedAt: old},
+		{Url: "/uploads/viewed.webp", FileName: "viewed.webp", Storage: "default", BucketId: 1, AccessBucketId: 1, CreatedAt: old, LastAccessedAt: &recent},
+		{Url: "/uploads/new.webp", FileName: "new.webp", Storage: "default", BucketId: 1, AccessBucketId: 1},
+	}
+	if err := db.Create(&imageList).Error; err != nil {
+		t.Fatalf("create images: %v", err)
+	}
+	for _, image := range imageList {
+		if err := os.MkdirAll("uploads", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join("uploads", image.FileName), []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&models.ImageStorage{ImageID: image.Id, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: 40}).Error; err != nil {
+			t.Fatalf("create local replica: %v", err)
+		}
+	}
+	if err := db.Create(&models.TieringRule{Name: "idle", Enabled: true, TargetBucketID: 2, IdleDays: 30}).Error; err != nil {
+		t.Fatalf("create rule: %v", err)
+	}
+
+	result, err := RunStorageTiering(context.Background())
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if result.Rules != 1 || result.Queued != 1 || result.Moved != 0 {
+		t.Fatalf("expected one queued replica, got %+v", result)
+	}
+	var cold models.ImageStorage
+	if err := db.Where("image_id = ? AND bucket_id = ?", imageList[0].Id, 2).First(&cold).Error; err != nil {
+		t.Fatalf("cold replica not queued: %v", err)
+	}
+	if cold.Status != models.ImageStorageStatusPending {
+		t.Fatalf("expected pending cold replica, got %s", cold.Status)
+	}
+
+	// Pretend the sync worker uploaded it.
+	cold.Status = models.ImageStorageStatusSuccess
+	cold.Metadata = map[string]any{"tg_message_id": 42}
+	if err := db.Save(&cold).Error; err != nil {
+		t.Fatalf("mark cold replica synced: %v", err)
+	}
+	result, err = RunStorageTiering(context.Background())
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if result.Moved != 1 || result.Queued != 0 || result.Failed != 0 {
+		t.Fatalf("expected one moved image, got %+v", result)
+	}
+
+	var moved models.Image
+	if err := db.First(&moved, imageList[0].Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if moved.AccessBucketId != 2 {
+		t.Fatalf("expected access bucket 2, got %d", moved.AccessBucketId)
+	}
+	var localCount int64
+	db.Model(&models.ImageStorage{}).Where("image_id = ? AND bucket_id = ?", moved.Id, 1).Count(&localCount)
+	if localCount != 0 {
+		t.Fatal("local replica should be removed")
+	}
+	if _, err := os.Stat(filepath.Join("uploads", "idle.webp")); !os.IsNotExist(err) {
+		t.Fatalf("local file should be deleted, stat err: %v", err)
+	}
+	for _, kept := range []string{"viewed.webp", "new.webp"} {
+		if _, err := os.Stat(filepath.Join("uploads", kept)); err != nil {
+			t.Fatalf("%s should stay local: %v", kept, err)
+		}
+	}
+	var local models.Buckets
+	if err := db.First(&local, 1).Error; err != nil || local.Usage != 60 {
+		t.Fatalf("expected local usage 60, got %d (%v)", local.Usage, err)
+	}
+
+	count, err := CountSoleReplicas(db, 2)
+	if err != nil || count != 1 {
+		t.Fatalf("expected one sole replica in bucket 2, got %d (%v)", count, err)
+	}
+}
+
+func TestRecordImageAccessThrottled(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+	image := models.Image{Url: "/uploads/a.webp", FileName: "a.webp"}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+
+	RecordImageAccess(db, image)
+	if err := db.First(&image, image.Id).Error; err != nil || image.LastAccessedAt == nil {
+		t.Fatalf("access time not recorded: %v", err)
+	}
+	first := *image.LastAccessedAt
+
+	RecordImageAccess(db, image)
+	if err := db.First(&image, image.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !image.LastAccessedAt.Equal(first) {
+		t.Fatalf("access time rewritten within interval: %v -> %v", first, *image.LastAccessedAt)
+	}
+}
+
+func TestTierImageWaitsForOtherReplicas(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+	if err := db.Create(&[]models.Buckets{
+		{Id: 1, Name: "local", Type: "default", Config: map[string]any{}, Usage: 100},
+		{Id: 2, Name: "telegram", Type: "telegram", Config: map[string]any{}},
+		{Id: 3, Name: "offsite", Type: "s3", Config: map[string]any{}},
+	}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	image := models.Image{Url: "/uploads/idle.webp", FileName: "idle.webp", Storage: "default", BucketId: 1, AccessBucketId: 1}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	if err := os.MkdirAll("uploads", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("uploads", "idle.webp"), []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offsite := models.ImageStorage{ImageID: image.Id, BucketID: 3, Storage: "s3", Status: models.ImageStorageStatusPending, URL: image.Url}
+	if err := db.Create(&[]*models.ImageStorage{
+		{ImageID: image.Id, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess, URL: image.Url, FileSize: 40},
+		{ImageID: image.Id, BucketID: 2, Storage: "telegram", Status: models.ImageStorageStatusSuccess, URL: image.Url, Metadata: map[string]any{"tg_message_id": 42}},
+		&offsite,
+	}).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+	cold := models.Buckets{Id: 2, Type: "telegram"}
+
+	moved, _, err := tierImage(context.Background(), db, image.Id, cold)
+	if err != nil || moved {
+		t.Fatalf("expected tiering to wait for the offsite upload, moved=%v err=%v", moved, err)
+	}
+	if _, err := os.Stat(filepath.Join("uploads", "idle.webp")); err != nil {
+		t.Fatalf("local file must stay while another replica needs it: %v", err)
+	}
+
+	db.Model(&offsite).Update("status", models.ImageStorageStatusSuccess)
+	moved, _, err = tierImage(context.Background(), db, image.Id, cold)
+	if err != nil || !moved {
+		t.Fatalf("expected the image to be tiered, moved=%v err=%v", moved, err)
+	}
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	storageSettings "oneimg/backend/utils/settings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Usage sources reported by a reconciliation.
+	BucketUsageSourceRecords = "records"
+	BucketUsageSourceListing = "listing"
+
+	bucketUsageReconcileTick = time.Minute
+)
+
+var (
+	bucketUsageReconcileStartOnce sync.Once
+	bucketUsageReconcileMu        sync.Mutex
+)
+
+// BucketUsageReport describes the usage recomputed for one bucket.
+type BucketUsageReport struct {
+	BucketID int    `json:"bucket_id"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Previous uint64 `json:"previous"`
+	// Recorded sums the sizes of every file OneImg tracks in the bucket.
+	Recorded uint64 `json:"recorded"`
+	// Listed is the total size of the objects found under the upload prefix
+	// when the backend listing was requested and supported.
+	Listed  *uint64 `json:"listed,omitempty"`
+	Objects int     `json:"objects,omitempty"`
+	Usage   uint64  `json:"usage"`
+	Source  string  `json:"source"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// ReleaseBucketUsage subtracts size bytes from a bucket's usage without
+// going below zero.
+func ReleaseBucketUsage(tx *gorm.DB, bucketID int, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	n := uint64(size)
+	return tx.Model(&models.Buckets{}).Where("id = ?", bucketID).
+		UpdateColumn("usage", gorm.Expr("CASE WHEN usage >= ? THEN usage - ? ELSE 0 END", n, n)).Error
+}
+
+// StartBucketUsageReconciler periodically recomputes the usage of every
+// bucket from its records so incremental accounting cannot drift for long.
+func StartBucketUsageReconciler() {
+	bucketUsageReconcileStartOnce.Do(func() {
+		go func() {
+			var lastRun time.Time
+			ticker := time.NewTicker(bucketUsageReconcileTick)
+			defer ticker.Stop()
+			for range ticker.C {
+				interval, ok := loadBucketUsageReconcileInterval()
+				if !ok || time.Since(lastRun) < interval {
+					continue
+				}
+				lastRun = time.Now()
+				if _, err := ReconcileAllBucketUsage(context.Background(), false); err != nil {
+					log.Printf("[storage-usage] reconciliation failed: %v", err)
+				}
+			}
+		}()
+	})
+}
+
+func loadBucketUsageReconcileInterval() (time.Duration, bool) {
+	setting, err := storageSettings.GetSettings()
+	if err != nil {
+		log.Printf("[storage-usage] failed to load settings: %v", err)
+		return 0, false
+	}
+	if setting.StorageReconcileInterval <= 0 {
+		return 0, false
+	}
+	return time.Duration(setting.StorageReconcileInterval) * time.Hour, true
+}
+
+// ReconcileAllBucketUsage recomputes the usage of every bucket. A bucket that
+// fails keeps its previous usage and reports the error; the others are still
+// reconciled.
+func ReconcileAllBucketUsage(ctx context.Context, fromListing bool) ([]BucketUsageReport, error) {
+	db := database.GetDB().DB
+	var buckets []models.Buckets
+	if err := db.Order("id ASC").Find(&buckets).Error; err != nil {
+		return nil, err
+	}
+	reports := make([]BucketUsageReport, 0, len(buckets))
+	for _, bucket := range buckets {
+		if err := ctx.Err(); err != nil {
+			return reports, err
+		}
+		report, err := ReconcileBucketUsage(ctx, bucket, fromListing)
+		if err != nil {
+			report.Error = err.Error()
+			log.Printf("[storage-usage] bucket %d: %v", bucket.Id, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// ReconcileBucketUsage recomputes one bucket's usage from the successful
+// replicas, thumbnails and archived originals it holds. With fromListing the
+// objects actually stored under the upload prefix are summed instead, which
+// also counts files OneImg no longer references; backends that cannot be
+// listed fall back to the records.
+func ReconcileBucketUsage(ctx context.Context, bucket models.Buckets, fromListing bool) (BucketUsageReport, error) {
+	bucketUsageReconcileMu.Lock()
+	defer bucketUsageReconcileMu.Unlock()
+
+	db := database.GetDB().DB
+	report := BucketUsageReport{
+		BucketID: bucket.Id,
+		Name:     bucket.Name,
+		Type:     bucket.Type,
+		Previous: bucket.Usage,
+		Source:   BucketUsageSourceRecords,
+	}
+	recorded, err := recordedBucketUsage(db, bucket)
+	if err != nil {
+		return report, fmt.Errorf("sum recorded usage: %w", err)
+	}
+	report.Recorded = recorded
+	report.Usage = recorded
+
+	if fromListing {
+		listed, objects, err := listedBucketUsage(ctx, bucket)
+		switch {
+		case errors.Is(err, ErrOrphanScanUnsupported):
+		case err != nil:
+			return report, fmt.Errorf("list objects: %w", err)
+		default:
+			report.Listed = &listed
+			report.Objects = objects
+			report.Usage = listed
+			report.Source = BucketUsageSourceListing
+		}
+	}
+
+	if err := db.Model(&models.Buckets{}).Where("id = ?", bucket.Id).
+		UpdateColumn("usage", report.Usage).Error; err != nil {
+		return report, err
+	}
+	if report.Usage != report.Previous {
+		log.Printf("[storage-usage] bucket %d usage %d -> %d (%s)", bucket.Id, report.Previous, report.Usage, report.Source)
+	}
+	return report, nil
+}
+
+// recordedBucketUsage sums the files OneImg tracks in a bucket: successful
+// replicas with their thumbnails, archived originals and, for the local
+// bucket, profile thumbnails.
+func recordedBucketUsage(db *gorm.DB, bucket models.Buckets) (uint64, error) {
+	var replicas, originals, thumbnails int64
+	if err := db.Model(&models.ImageStorage{}).
+		Where("bucket_id = ? AND status = ?", bucket.Id, models.ImageStorageStatusSuccess).
+		Select("COALESCE(SUM(file_size + thumbnail_size), 0)").
+		Scan(&replicas).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Model(&models.ImageOriginal{}).
+		Where("bucket_id = ? AND status = ?", bucket.Id, models.ImageStorageStatusSuccess).
+		Select("COALESCE(SUM(file_size), 0)").
+		Scan(&originals).Error; err != nil {
+		return 0, err
+	}
+	if bucket.Type == "default" {
+		if err := db.Model(&models.ImageThumbnail{}).
+			Select("COALESCE(SUM(file_size), 0)").
+			Scan(&thumbnails).Error; err != nil {
+			return 0, err
+		}
+	}
+	return uint64(max(replicas, 0) + max(originals, 0) + max(thumbnails, 0)), nil
+}
+
+func listedBucketUsage(ctx context.Context, bucket models.Buckets) (uint64, int, error) {
+	prefix, err := OrphanScanPrefix("")
+	if err != nil {
+		return 0, 0, err
+	}
+	objects, err := listBucketObjects(ctx, bucket, prefix)
+	if err != nil {
+		return 0, 0, err
+	}
+	var total uint64
+	for _, object := range objects {
+		if object.Size > 0 {
+			total += uint64(object.Size)
+		}
+	}
+	return total, len(objects), nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestReconcileBucketUsageFromRecords(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+
+	local := models.Buckets{Id: 1, Name: "local", Type: "default", Config: map[string]any{}, Usage: 1 << 40}
+	remote := models.Buckets{Id: 2, Name: "remote", Type: "webdav", Config: map[string]any{}, Usage: 7}
+	if err := db.Create(&[]models.Buckets{local, remote}).Error; err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+	image := models.Image{Url: "/uploads/a.webp", FileName: "a.webp"}
+	if err := db.Create(&image).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	replicas := []models.ImageStorage{
+		{ImageID: image.Id, BucketID: 1, Storage: "default", Status: models.ImageStorageStatusSuccess, FileSize: 100, ThumbnailSize: 10},
+		{ImageID: image.Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusSuccess, FileSize: 100, ThumbnailSize: 10},
+		{ImageID: image.Id + 1, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusFailed, FileSize: 500},
+	}
+	if err := db.Create(&replicas).Error; err != nil {
+		t.Fatalf("create replicas: %v", err)
+	}
+	if err := db.Create(&models.ImageThumbnail{ImageId: image.Id, Profile: "small", Path: "/uploads/small/a.webp", FileSize: 5}).Error; err != nil {
+		t.Fatalf("create profile thumbnail: %v", err)
+	}
+	if err := db.Create(&models.ImageOriginal{ImageID: image.Id, BucketID: 2, Storage: "webdav", Status: models.ImageStorageStatusSuccess, Path: "/originals/a.png", FileSize: 1000}).Error; err != nil {
+		t.Fatalf("create original: %v", err)
+	}
+
+	reports, err := ReconcileAllBucketUsage(context.Background(), false)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if len(reports) != 2 || reports[0].Usage != 115 || reports[0].Previous != 1<<40 || reports[1].Usage != 1110 {
+		t.Fatalf("unexpected reports: %+v", reports)
+	}
+	var stored []models.Buckets
+	if err := db.Order("id ASC").Find(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored[0].Usage != 115 || stored[1].Usage != 1110 {
+		t.Fatalf("usage not written: %d, %d", stored[0].Usage, stored[1].Usage)
+	}
+
+	if err := ReleaseBucketUsage(db, 2, 5000); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if err := db.First(&stored[1], 2).Error; err != nil || stored[1].Usage != 0 {
+		t.Fatalf("expected usage clamped to zero, got %d (%v)", stored[1].Usage, err)
+	}
+}
+
+func TestReconcileLocalBucketUsageFromListing(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+
+	local := models.Buckets{Id: 1, Name: "local", Type: "default", Config: map[string]any{}}
+	if err := db.Create(&local).Error; err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+	files := map[string]int{
+		"uploads/2026/01/a.webp":      300,
+		"uploads/2026/01/orphan.webp": 20,
+		"other/unrelated.bin":         4096,
+	}
+	for name, size := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := ReconcileBucketUsage(context.Background(), local, true)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if report.Source != BucketUsageSourceListing || report.Listed == nil || *report.Listed != 320 || report.Objects != 2 || report.Usage != 320 || report.Recorded != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
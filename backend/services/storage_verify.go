@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"oneimg/backend/models"
+	"oneimg/backend/utils/buckets"
+	"oneimg/backend/utils/ftp"
+	storageS3 "oneimg/backend/utils/s3"
+	storageSettings "oneimg/backend/utils/settings"
+	"oneimg/backend/utils/webdav"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// errReplicaDamaged marks verification failures that prove a stored copy is
+// missing or differs from what was uploaded, as opposed to errors reaching
+// the backend.
+var errReplicaDamaged = errors.New("replica is damaged")
+
+// verifyRemoteReplica checks that the objects of a successful replica exist in
+// its bucket. Where the backend reports object sizes, they must match the
+// sizes recorded when the replica was synchronized. Telegram offers no stat
+// call, so only the recorded message metadata is checked there.
+func verifyRemoteReplica(ctx context.Context, bucket models.Buckets, replica models.ImageStorage) error {
+	objects := []struct {
+		path string
+		size int64
+	}{{replica.URL, replica.FileSize}}
+	if replica.Thumbnail != "" {
+		objects = append(objects, struct {
+			path string
+			size int64
+		}{replica.Thumbnail, replica.ThumbnailSize})
+	}
+
+	switch bucket.Type {
+	case "default":
+		return nil
+	case "s3", "r2":
+		setting, err := storageSettings.GetSettings()
+		if err != nil {
+			return err
+		}
+		client, err := storageS3.NewS3Client(setting, bucket)
+		if err != nil {
+			return err
+		}
+		remoteBucket := buckets.ConvertToS3Bucket(bucket.Config).S3Bucket
+		if bucket.Type == "r2" {
+			remoteBucket = buckets.ConvertToR2Bucket(bucket.Config).R2Bucket
+		}
+		for _, object := range objects {
+			info, err := client.StatObject(ctx, remoteBucket, remoteObjectKey(object.path), minio.StatObjectOptions{})
+			if err != nil {
+				if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+					return fmt.Errorf("%w: %s is missing", errReplicaDamaged, object.path)
+				}
+				return fmt.Errorf("stat %s: %w", object.path, err)
+			}
+			if err := checkVerifiedSize(object.path, info.Size, object.size); err != nil {
+				return err
+			}
+		}
+	case "webdav":
+		config := buckets.ConvertToWebDavBucket(bucket.Config)
+		client := webdav.Client(webdav.Config{
+			BaseURL:  config.WebdavURL,
+			Username: config.WebdavUser,
+			Password: config.WebdavPass,
+			Timeout:  30 * time.Second,
+		})
+		for _, object := range objects {
+			exists, err := client.WebDAVStat(ctx, object.path)
+			if err != nil {
+				return fmt.Errorf("stat %s: %w", object.path, err)
+			}
+			if !exists {
+				return fmt.Errorf("%w: %s is missing", errReplicaDamaged, object.path)
+			}
+		}
+	case "ftp":
+		config := buckets.ConvertToFTPBucket(bucket.Config)
+		client := ftp.NewFTPUtil(ftp.FTPConfig{
+			Host:     config.FTPHost,
+			Port:     config.FTPPort,
+			User:     config.FTPUser,
+			Password: config.FTPPass,
+			Timeout:  30,
+		})
+		defer client.Close()
+		for _, object := range objects {
+			size, err := client.FileSize(object.path)
+			if err != nil {
+				if isMissingRemoteFileError(err) {
+					return fmt.Errorf("%w: %s is missing", errReplicaDamaged, object.path)
+				}
+				return fmt.Errorf("stat %s: %w", object.path, err)
+			}
+			if err := checkVerifiedSize(object.path, size, object.size); err != nil {
+				return err
+			}
+		}
+	case "telegram":
+		if metadataInt(replica.Metadata, "tg_message_id") == 0 {
+			return fmt.Errorf("%w: telegram message id is missing", errReplicaDamaged)
+		}
+		if replica.Thumbnail != "" && metadataInt(replica.Metadata, "tg_thumbnail_message_id") == 0 {
+			return fmt.Errorf("%w: telegram thumbnail message id is missing", errReplicaDamaged)
+		}
+	default:
+		return fmt.Errorf("unsupported storage type %q", bucket.Type)
+	}
+	return nil
+}
+
+func checkVerifiedSize(path string, actual, expected int64) error {
+	if expected > 0 && actual != expected {
+		return fmt.Errorf("%w: %s has %d bytes, expected %d", errReplicaDamaged, path, actual, expected)
+	}
+	return nil
+}
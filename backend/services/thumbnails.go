@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/utils/images"
+	"oneimg/backend/utils/securestorage"
+	"oneimg/backend/utils/settings"
+
+	"gorm.io/gorm"
+)
+
+const thumbnailRegenerationBatchSize = 100
+
+var (
+	ErrThumbnailRegenerationRunning = errors.New("thumbnail regeneration is already running")
+	errThumbnailSourceUnavailable   = errors.New("original image is not stored locally")
+
+	thumbnailRegenerationMu     sync.Mutex
+	thumbnailRegenerationState  ThumbnailRegenerationStatus
+	thumbnailRegenerationCancel context.CancelFunc
+)
+
+// ThumbnailRegenerationStatus reports progress of the background job that
+// rebuilds profile thumbnails after the configured profiles change.
+type ThumbnailRegenerationStatus struct {
+	Running    bool       `json:"running"`
+	Canceled   bool       `json:"canceled"`
+	Total      int64      `json:"total"`
+	Processed  int64      `json:"processed"`
+	Succeeded  int64      `json:"succeeded"`
+	Skipped    int64      `json:"skipped"`
+	Failed     int64      `json:"failed"`
+	LastError  string     `json:"last_error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// StartThumbnailRegeneration rebuilds profile thumbnails for every image from
+// its local original using the currently saved settings. Only one job may run
+// at a time.
+func StartThumbnailRegeneration() (ThumbnailRegenerationStatus, error) {
+	setting, err := settings.GetSettings()
+	if err != nil {
+		return ThumbnailRegenerationStatus{}, err
+	}
+	db := database.GetDB().DB
+	var total int64
+	if err := db.Model(&models.Image{}).Count(&total).Error; err != nil {
+		return ThumbnailRegenerationStatus{}, err
+	}
+
+	thumbnailRegenerationMu.Lock()
+	defer thumbnailRegenerationMu.Unlock()
+	if thumbnailRegenerationState.Running {
+		return thumbnailRegenerationState, ErrThumbnailRegenerationRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	thumbnailRegenerationCancel = cancel
+	thumbnailRegenerationState = ThumbnailRegenerationStatus{
+		Running:   true,
+		Total:     total,
+		StartedAt: &now,
+	}
+	go runThumbnailRegeneration(ctx, setting.GetThumbnailProfiles(), setting.EncryptedStorage)
+	return thumbnailRegenerationState, nil
+}
+
+// CancelThumbnailRegeneration stops the running job after the current image.
+func CancelThumbnailRegeneration() bool {
+	thumbnailRegenerationMu.Lock()
+	defer thumbnailRegenerationMu.Unlock()
+	if !thumbnailRegenerationState.Running || thumbnailRegenerationCancel == nil {
+		return false
+	}
+	thumbnailRegenerationCancel()
+	return true
+}
+
+// GetThumbnailRegenerationStatus returns a snapshot of the latest job.
+func GetThumbnailRegenerationStatus() ThumbnailRegenerationStatus {
+	thumbnailRegenerationMu.Lock()
+	defer thumbnailRegenerationMu.Unlock()
+	return thumbnailRegenerationState
+}
+
+func runThumbnailRegeneration(ctx context.Context, profiles []models.ThumbnailProfile, encrypted bool) {
+	defer func() {
+		thumbnailRegenerationMu.Lock()
+		now := time.Now()
+		thumbnailRegenerationState.Running = false
+		thumbnailRegenerationState.Canceled = ctx.Err() != nil
+		thumbnailRegenerationState.FinishedAt = &now
+		if thumbnailRegenerationCancel != nil {
+			thumbnailRegenerationCancel()
+			thumbnailRegenerationCancel = nil
+		}
+		thumbnailRegenerationMu.Unlock()
+	}()
+
+	db := database.GetDB().DB
+	lastID := 0
+	for ctx.Err() == nil {
+		var batch []models.Image
+		if err := db.Where("id > ?", lastID).Order("id ASC").Limit(thumbnailRegenerationBatchSize).Find(&batch).Error; err != nil {
+			log.Printf("[thumbnails] load images after %d: %v", lastID, err)
+			recordThumbnailRegenerationResult(err)
+			return
+		}
+		if len(batch) == 0 {
+			return
+		}
+		for _, image := range batch {
+			if ctx.Err() != nil {
+				return
+			}
+			lastID = image.Id
+			err := RegenerateImageThumbnails(db, image, profiles, encrypted)
+			if err != nil && !errors.Is(err, errThumbnailSourceUnavailable) {
+				log.Printf("[thumbnails] regenerate image %d: %v", image.Id, err)
+			}
+			recordThumbnailRegenerationResult(err)
+		}
+	}
+}
+
+func recordThumbnailRegenerationResult(err error) {
+	thumbnailRegenerationMu.Lock()
+	defer thumbnailRegenerationMu.Unlock()
+	thumbnailRegenerationState.Processed++
+	switch {
+	case err == nil:
+		thumbnailRegenerationState.Succeeded++
+	case errors.Is(err, errThumbnailSourceUnavailable):
+		thumbnailRegenerationState.Skipped++
+	default:
+		thumbnailRegenerationState.Failed++
+		thumbnailRegenerationState.LastError = err.Error()
+	}
+}
+
+// RegenerateImageThumbnails rebuilds all profile thumbnails of one image from
+// its local original and replaces the stored records. Profiles that are no
+// longer configured are removed.
+func RegenerateImageThumbnails(db *gorm.DB, image models.Image, profiles []models.ThumbnailProfile, encrypted bool) error {
+	var existing []models.ImageThumbnail
+	if err := db.Where("image_id = ?", image.Id).Find(&existing).Error; err != nil {
+		return err
+	}
+
+	var records []models.ImageThumbnail
+	if len(profiles) > 0 && image.MimeType != "image/svg+xml" {
+		sourcePath, err := localOriginalPath(db, image)
+		if err != nil {
+			return err
+		}
+		data, _, err := securestorage.ReadFile(sourcePath)
+		if err != nil {
+			return fmt.Errorf("read local original: %w", err)
+		}
+		decoded, format, err := images.ImageSvc.DecodeImageBytes(data, image.MimeType)
+		if err != nil {
+			return fmt.Errorf("decode local original: %w", err)
+		}
+		if format != "svg" {
+			generated, profileErrs := images.ImageSvc.GenerateProfileThumbnails(decoded, profiles)
+			if len(profileErrs) > 0 {
+				return errors.Join(profileErrs...)
+			}
+			records, err = writeProfileThumbnails(image, generated, encrypted)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return ReplaceImageThumbnails(tx, image.Id, records)
+	}); err != nil {
+		return err
+	}
+
+	keep := make(map[string]struct{}, len(records))
+	for _, record := range records {
+		keep[record.Path] = struct{}{}
+	}
+	for _, record := range existing {
+		if _, ok := keep[record.Path]; ok {
+			continue
+		}
+		if err := removeLocalThumbnailFile(record.Path); err != nil {
+			log.Printf("[thumbnails] remove stale thumbnail %s: %v", record.Path, err)
+		}
+	}
+	return nil
+}
+
+// ReplaceImageThumbnails swaps the profile thumbnail records of one image.
+func ReplaceImageThumbnails(tx *gorm.DB, imageID int, records []models.ImageThumbnail) error {
+	if err := tx.Where("image_id = ?", imageID).Delete(&models.ImageThumbnail{}).Error; err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	for i := range records {
+		records[i].Id = 0
+		records[i].ImageId = imageID
+	}
+	return tx.Create(&records).Error
+}
+
+// DeleteImageThumbnailFiles removes the local files of the given profile
+// thumbnails; missing files are ignored.
+func DeleteImageThumbnailFiles(records []models.ImageThumbnail) error {
+	var deleteErrors []error
+	for _, record := range records {
+		if err := removeLocalThumbnailFile(record.Path); err != nil {
+			deleteErrors = append(deleteErrors, err)
+		}
+	}
+	return errors.Join(deleteErrors...)
+}
+
+func writeProfileThumbnails(image models.Image, generated []images.ProfileThumbnail, encrypted bool) ([]models.ImageThumbnail, error) {
+	records := make([]models.ImageThumbnail, 0, len(generated))
+	for _, thumbnail := range generated {
+		publicPath := "/" + strings.TrimLeft(images.ProfileThumbnailPath(image.Url, thumbnail.Profile, thumbnail.Ext), "/")
+		localPath, err := canonicalLocalPath(publicPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return nil, fmt.Errorf("create thumbnail directory: %w", err)
+		}
+		if err := securestorage.WriteFile(localPath, thumbnail.Bytes, encrypted); err != nil {
+			return nil, fmt.Errorf("write thumbnail %s: %w", thumbnail.Profile, err)
+		}
+		records = append(records, models.ImageThumbnail{
+			ImageId:  image.Id,
+			Profile:  thumbnail.Profile,
+			Path:     publicPath,
+			Width:    thumbnail.Width,
+			Height:   thumbnail.Height,
+			FileSize: int64(len(thumbnail.Bytes)),
+			MimeType: thumbnail.MimeType,
+		})
+	}
+	return records, nil
+}
+
+// localOriginalPath prefers the successful local replica and falls back to
+// the canonical path for legacy local images without replica records. Images
+// whose local copy was tiered away have no source.
+func localOriginalPath(db *gorm.DB, image models.Image) (string, error) {
+	var replica models.ImageStorage
+	err := db.Where("image_id = ? AND storage = ? AND status = ?", image.Id, "default", models.ImageStorageStatusSuccess).
+		Order("id ASC").
+		First(&replica).Error
+	switch {
+	case err == nil && replica.URL != "":
+		return canonicalLocalPath(replica.URL)
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		return "", err
+	}
+	if image.Storage != "default" {
+		return "", errThumbnailSourceUnavailable
+	}
+	var replicas int64
+	if err := db.Model(&models.ImageStorage{}).Where("image_id = ?", image.Id).Count(&replicas).Error; err != nil {
+		return "", err
+	}
+	if replicas > 0 {
+		return "", errThumbnailSourceUnavailable
+	}
+	return canonicalLocalPath(image.Url)
+}
+
+func removeLocalThumbnailFile(publicPath string) error {
+	localPath, err := canonicalLocalPath(publicPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(localPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+	"oneimg/backend/utils/images"
+)
+
+func TestRegenerateImageThumbnailsReplacesProfiles(t *testing.T) {
+	initStorageSyncTestDB(t)
+	images.InitImageService()
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+
+	source := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	for x := 300; x < 400; x++ {
+		for y := 0; y < 200; y++ {
+			source.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 255, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, source); err != nil {
+		t.Fatalf("encode source: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join("uploads", "2026"), 0755); err != nil {
+		t.Fatalf("create upload dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join("uploads", "2026", "photo.png"), encoded.Bytes(), 0644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+
+	imageModel := models.Image{
+		Url:      "/uploads/2026/photo.png",
+		FileName: "photo.png",
+		FileSize: int64(encoded.Len()),
+		MimeType: "image/png",
+		Storage:  "default",
+		BucketId: 1,
+		UserId:   1,
+	}
+	if err := db.Create(&imageModel).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+
+	profiles, err := models.ParseThumbnailProfiles(`[
+		{"name":"small","width":100,"height":100},
+		{"name":"square","width":50,"height":50,"fit":"smart","format":"jpg","quality":70}
+	]`)
+	if err != nil {
+		t.Fatalf("parse profiles: %v", err)
+	}
+	if err := RegenerateImageThumbnails(db, imageModel, profiles, false); err != nil {
+		t.Fatalf("regenerate thumbnails: %v", err)
+	}
+
+	var records []models.ImageThumbnail
+	if err := db.Where("image_id = ?", imageModel.Id).Order("profile ASC").Find(&records).Error; err != nil {
+		t.Fatalf("query thumbnails: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected two profile thumbnails, got %d", len(records))
+	}
+	if records[0].Profile != "small" || records[0].Path != "/uploads/2026/thumbnails/small/photo.webp" ||
+		records[0].Width != 100 || records[0].Height != 50 || records[0].MimeType != "image/webp" {
+		t.Fatalf("unexpected contain thumbnail: %+v", records[0])
+	}
+	if records[1].Profile != "square" || records[1].Path != "/uploads/2026/thumbnails/square/photo.jpg" ||
+		records[1].Width != 50 || records[1].Height != 50 || records[1].MimeType != "image/jpeg" {
+		t.Fatalf("unexpected smart thumbnail: %+v", records[1])
+	}
+	for _, record := range records {
+		if _, err := os.Stat(filepath.FromSlash(record.Path[1:])); err != nil {
+			t.Fatalf("thumbnail file %s missing: %v", record.Path, err)
+		}
+	}
+
+	if err := RegenerateImageThumbnails(db, imageModel, profiles[:1], false); err != nil {
+		t.Fatalf("regenerate with fewer profiles: %v", err)
+	}
+	var remaining int64
+	if err := db.Model(&models.ImageThumbnail{}).Where("image_id = ?", imageModel.Id).Count(&remaining).Error; err != nil {
+		t.Fatalf("count thumbnails: %v", err)
+	}
+	if remaining != 1 {
+		t.Fatalf("stale profile record should be removed, got %d records", remaining)
+	}
+	if _, err := os.Stat(filepath.Join("uploads", "2026", "thumbnails", "square", "photo.jpg")); !os.IsNotExist(err) {
+		t.Fatalf("stale profile file should be removed, stat err=%v", err)
+	}
+}
+
+func TestRegenerateImageThumbnailsSkipsTieredImages(t *testing.T) {
+	initStorageSyncTestDB(t)
+	t.Chdir(t.TempDir())
+	db := database.GetDB().DB
+
+	// Uploaded locally, then tiered: only the cold replica is left.
+	tiered := models.Image{Url: "/uploads/cold.png", FileName: "cold.png", MimeType: "image/png", Storage: "default", BucketId: 1, AccessBucketId: 2}
+	if err := db.Create(&tiered).Error; err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	if err := db.Create(&models.ImageStorage{ImageID: tiered.Id, BucketID: 2, Storage: "s3", Status: models.ImageStorageStatusSuccess, URL: tiered.Url}).Error; err != nil {
+		t.Fatalf("create cold replica: %v", err)
+	}
+	profiles, err := models.ParseThumbnailProfiles(`[{"name":"small","width":100,"height":100}]`)
+	if err != nil {
+		t.Fatalf("parse profiles: %v", err)
+	}
+
+	err = RegenerateImageThumbnails(db, tiered, profiles, false)
+	if !errors.Is(err, errThumbnailSourceUnavailable) {
+		t.Fatalf("expected tiered image to be skipped, got %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"oneimg/backend/models"
+	"oneimg/backend/utils/secureconfig"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// TOTPIssuer is shown by authenticator apps next to the account name.
+	TOTPIssuer = "OneImg"
+	// RecoveryCodeCount is how many one-time recovery codes are issued at once.
+	RecoveryCodeCount = 10
+
+	totpPeriod      = 30
+	totpDigits      = 6
+	totpSkew        = 1 // accept codes from one step before or after now
+	totpSecretBytes = 20
+
+	// totpMaxFailures consecutive wrong codes lock the second factor for
+	// totpLockDuration, no matter how many login sessions they were spread over.
+	totpMaxFailures  = 10
+	totpLockDuration = 15 * time.Minute
+)
+
+var (
+	ErrTOTPNotEnrolled    = errors.New("two-factor authentication is not set up")
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPInvalidCode    = errors.New("two-factor code is invalid")
+	ErrTOTPLocked         = errors.New("two-factor authentication is temporarily locked")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPRequiredForRole reports whether settings force local accounts with
+// the given role to use two-factor authentication.
+func TOTPRequiredForRole(setting models.Settings, role int) bool {
+	switch role {
+	case models.RoleAdmin:
+		return setting.TOTPRequireAdmin
+	case models.RoleUser:
+		return setting.TOTPRequireUser
+	}
+	return false
+}
+
+// LoadUserTOTP returns the user's TOTP record, or ok=false if there is none.
+func LoadUserTOTP(db *gorm.DB, userID int) (models.UserTOTP, bool, error) {
+	var record models.UserTOTP
+	found := db.Where("user_id = ?", userID).Limit(1).Find(&record)
+	return record, found.Error == nil && found.RowsAffected > 0, found.Error
+}
+
+// UserTOTPEnabled reports whether the user has completed TOTP enrollment.
+func UserTOTPEnabled(db *gorm.DB, userID int) (bool, error) {
+	record, ok, err := LoadUserTOTP(db, userID)
+	return ok && record.Enabled, err
+}
+
+// BeginTOTPEnrollment stores a fresh, not yet enabled secret for the user and
+// returns it together with an otpauth:// provisioning URI for QR codes.
+// Starting again before confirming replaces the pending secret.
+func BeginTOTPEnrollment(db *gorm.DB, user models.User) (string, string, error) {
+	if enabled, err := UserTOTPEnabled(db, user.ID); err != nil {
+		return "", "", err
+	} else if enabled {
+		return "", "", ErrTOTPAlreadyEnabled
+	}
+
+	raw := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	secret := totpEncoding.EncodeToString(raw)
+	encrypted, err := secureconfig.EncryptSecret(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	record := models.UserTOTP{UserID: user.ID, Secret: encrypted, RecoveryCodes: []string{}}
+	if err := db.Save(&record).Error; err != nil {
+		return "", "", err
+	}
+	return secret, TOTPProvisioningURI(user.Username, secret), nil
+}
+
+// ConfirmTOTPEnrollment enables TOTP once the user proves the authenticator
+// works, and returns the plaintext recovery codes, which are shown only once.
+func ConfirmTOTPEnrollment(db *gorm.DB, userID int, code string) ([]string, error) {
+	record, ok, err := LoadUserTOTP(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if record.Enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	step, err := matchUserTOTP(record, code, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	plain, hashed, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := db.Model(&record).Select("enabled", "enabled_at", "last_used_step", "recovery_codes").Updates(&models.UserTOTP{
+		Enabled:       true,
+		EnabledAt:     &now,
+		LastUsedStep:  step,
+		RecoveryCodes: hashed,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// VerifySecondFactor checks a TOTP code or, failing that, a recovery code for
+// a user with TOTP enabled. TOTP codes cannot be replayed and recovery codes
+// are consumed on use; usedRecovery reports which kind matched. Wrong codes
+// are counted per user and lock verification with ErrTOTPLocked once
+// totpMaxFailures is reached, so re-entering the password does not grant
+// fresh guesses.
+func VerifySecondFactor(db *gorm.DB, userID int, code string) (usedRecovery bool, err error) {
+	code = strings.TrimSpace(code)
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		record, ok, err := LoadUserTOTP(tx, userID)
+		if err != nil {
+			return err
+		}
+		if !ok || !record.Enabled {
+			return ErrTOTPNotEnrolled
+		}
+		if record.LockedUntil != nil && now.Before(*record.LockedUntil) {
+			return ErrTOTPLocked
+		}
+
+		if isTOTPCode(code) {
+			step, err := matchUserTOTP(record, code, now)
+			if err != nil {
+				return err
+			}
+			updated := tx.Model(&models.UserTOTP{}).
+				Where("user_id = ? AND last_used_step < ?", userID, step).
+				Updates(map[string]any{"last_used_step": step, "failed_count": 0, "locked_until": nil})
+			if updated.Error != nil {
+				return updated.Error
+			}
+			if updated.RowsAffected == 0 {
+				return ErrTOTPInvalidCode
+			}
+			return nil
+		}
+
+		hashed := hashRecoveryCode(code)
+		index := slices.IndexFunc(record.RecoveryCodes, func(stored string) bool {
+			return subtle.ConstantTimeCompare([]byte(stored), []byte(hashed)) == 1
+		})
+		if index < 0 {
+			return ErrTOTPInvalidCode
+		}
+		remaining := slices.Delete(slices.Clone(record.RecoveryCodes), index, index+1)
+		usedRecovery = true
+		return tx.Model(&record).Select("recovery_codes", "failed_count", "locked_until").
+			Updates(&models.UserTOTP{RecoveryCodes: remaining}).Error
+	})
+	if errors.Is(err, ErrTOTPInvalidCode) {
+		if recordErr := recordTOTPFailure(db, userID, now); recordErr != nil {
+			return false, recordErr
+		}
+	}
+	return usedRecovery, err
+}
+
+// recordTOTPFailure counts a wrong code and locks the second factor once the
+// user reaches totpMaxFailures. The counter restarts after the lock.
+func recordTOTPFailure(db *gorm.DB, userID int, now time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserTOTP{}).Where("user_id = ?", userID).
+			Update("failed_count", gorm.Expr("failed_count + 1")).Error; err != nil {
+			return err
+		}
+		lockedUntil := now.Add(totpLockDuration)
+		return tx.Model(&models.UserTOTP{}).
+			Where("user_id = ? AND failed_count >= ?", userID, totpMaxFailures).
+			Updates(map[string]any{"failed_count": 0, "locked_until": lockedUntil}).Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of an enabled user.
+func RegenerateRecoveryCodes(db *gorm.DB, userID int) ([]string, error) {
+	record, ok, err := LoadUserTOTP(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || !record.Enabled {
+		return nil, ErrTOTPNotEnrolled
+	}
+	plain, hashed, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Model(&record).Select("recovery_codes").Updates(&models.UserTOTP{RecoveryCodes: hashed}).Error; err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// DisableTOTP removes the user's TOTP configuration, pending or enabled.
+func DisableTOTP(db *gorm.DB, userID int) error {
+	return db.Where("user_id = ?", userID).Delete(&models.UserTOTP{}).Error
+}
+
+// TOTPProvisioningURI builds the otpauth:// URI understood by authenticator
+// apps (Key Uri Format).
+func TOTPProvisioningURI(account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", TOTPIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(TOTPIssuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TOTPCode returns the code a base32 secret produces at the given time.
+func TOTPCode(secret string, at time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, at.Unix()/totpPeriod), nil
+}
+
+// matchUserTOTP decrypts the stored secret and returns the matching time
+// step for code, rejecting steps that were already used.
+func matchUserTOTP(record models.UserTOTP, code string, now time.Time) (int64, error) {
+	if !isTOTPCode(code) {
+		return 0, ErrTOTPInvalidCode
+	}
+	secret, err := secureconfig.DecryptSecret(record.Secret)
+	if err != nil {
+		return 0, err
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, err
+	}
+	current := now.Unix() / totpPeriod
+	for offset := -totpSkew; offset <= totpSkew; offset++ {
+		step := current + int64(offset)
+		if step <= record.LastUsedStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+	return 0, ErrTOTPInvalidCode
+}
+
+// totpCode computes the RFC 6238 code (HMAC-SHA1, dynamic truncation).
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRecoveryCodes returns recovery codes formatted as xxxxx-xxxxx together
+// with their stored hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	plain := make([]string, 0, RecoveryCodeCount)
+	hashed := make([]string, 0, RecoveryCodeCount)
+	for range RecoveryCodeCount {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		encoded := strings.ToLower(totpEncoding.EncodeToString(raw))[:10]
+		code := encoded[:5] + "-" + encoded[5:]
+		plain = append(plain, code)
+		hashed = append(hashed, hashRecoveryCode(code))
+	}
+	return plain, hashed, nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes so codes can be typed loosely.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
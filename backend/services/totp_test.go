@@ -0,0 +1,148 @@
+package services
+
+import (
+	"encoding/base32"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"oneimg/backend/config"
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+)
+
+func TestTOTPCodeMatchesRFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range cases {
+		got, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d): %v", unix, err)
+		}
+		if got != want {
+			t.Fatalf("TOTPCode(%d) = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestTOTPEnrollmentReplayAndRecoveryCodes(t *testing.T) {
+	initStorageSyncTestDB(t)
+	oldConfig := config.App
+	config.App = &config.Config{ConfigSecret: "totp-test-config-secret"}
+	t.Cleanup(func() { config.App = oldConfig })
+	db := database.GetDB().DB
+	user := models.User{ID: 2, Username: "alice", Role: models.RoleUser}
+
+	secret, uri, err := BeginTOTPEnrollment(db, user)
+	if err != nil {
+		t.Fatalf("begin enrollment: %v", err)
+	}
+	if !strings.HasPrefix(uri, "otpauth://totp/OneImg:alice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("unexpected provisioning uri %q", uri)
+	}
+	record, _, _ := LoadUserTOTP(db, user.ID)
+	if record.Enabled || strings.Contains(record.Secret, secret) {
+		t.Fatalf("pending secret must be stored encrypted and disabled: %+v", record)
+	}
+	if _, err := ConfirmTOTPEnrollment(db, user.ID, "000000"); !errors.Is(err, ErrTOTPInvalidCode) && err != nil {
+		t.Fatalf("wrong code: %v", err)
+	}
+
+	now := time.Now()
+	code, _ := TOTPCode(secret, now)
+	recovery, err := ConfirmTOTPEnrollment(db, user.ID, code)
+	if err != nil {
+		t.Fatalf("confirm enrollment: %v", err)
+	}
+	if len(recovery) != RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes", len(recovery))
+	}
+	if _, _, err := BeginTOTPEnrollment(db, user); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Fatalf("re-enrolling an enabled user: %v", err)
+	}
+
+	// The code used to enroll cannot be replayed, the next one is accepted once.
+	if _, err := VerifySecondFactor(db, user.ID, code); !errors.Is(err, ErrTOTPInvalidCode) {
+		t.Fatalf("replayed code: %v", err)
+	}
+	next, _ := TOTPCode(secret, now.Add(30*time.Second))
+	if usedRecovery, err := VerifySecondFactor(db, user.ID, next); err != nil || usedRecovery {
+		t.Fatalf("next code: recovery=%v err=%v", usedRecovery, err)
+	}
+	if _, err := VerifySecondFactor(db, user.ID, next); !errors.Is(err, ErrTOTPInvalidCode) {
+		t.Fatalf("replayed next code: %v", err)
+	}
+
+	typed := strings.ToUpper(strings.ReplaceAll(recovery[0], "-", " "))
+	if usedRecovery, err := VerifySecondFactor(db, user.ID, typed); err != nil || !usedRecovery {
+		t.Fatalf("recovery code: recovery=%v err=%v", usedRecovery, err)
+	}
+	if _, err := VerifySecondFactor(db, user.ID, recovery[0]); !errors.Is(err, ErrTOTPInvalidCode) {
+		t.Fatalf("recovery code must be single use: %v", err)
+	}
+	record, _, _ = LoadUserTOTP(db, user.ID)
+	if len(record.RecoveryCodes) != RecoveryCodeCount-1 {
+		t.Fatalf("remaining recovery codes = %d", len(record.RecoveryCodes))
+	}
+
+	if err := DisableTOTP(db, user.ID); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	if enabled, _ := UserTOTPEnabled(db, user.ID); enabled {
+		t.Fatalf("totp should be disabled")
+	}
+}
+
+func TestVerifySecondFactorLocksAfterRepeatedFailures(t *testing.T) {
+	initStorageSyncTestDB(t)
+	oldConfig := config.App
+	config.App = &config.Config{ConfigSecret: "totp-test-config-secret"}
+	t.Cleanup(func() { config.App = oldConfig })
+	db := database.GetDB().DB
+	user := models.User{ID: 2, Username: "alice", Role: models.RoleUser}
+
+	secret, _, _ := BeginTOTPEnrollment(db, user)
+	now := time.Now()
+	code, _ := TOTPCode(secret, now.Add(-30*time.Second))
+	if _, err := ConfirmTOTPEnrollment(db, user.ID, code); err != nil {
+		t.Fatalf("confirm enrollment: %v", err)
+	}
+
+	// A correct code resets the counter.
+	for range totpMaxFailures - 1 {
+		if _, err := VerifySecondFactor(db, user.ID, "abcdef"); !errors.Is(err, ErrTOTPInvalidCode) {
+			t.Fatalf("wrong code: %v", err)
+		}
+	}
+	current, _ := TOTPCode(secret, now)
+	if _, err := VerifySecondFactor(db, user.ID, current); err != nil {
+		t.Fatalf("correct code: %v", err)
+	}
+	if record, _, _ := LoadUserTOTP(db, user.ID); record.FailedCount != 0 {
+		t.Fatalf("failed count after success = %d", record.FailedCount)
+	}
+
+	for range totpMaxFailures {
+		if _, err := VerifySecondFactor(db, user.ID, "abcdef"); !errors.Is(err, ErrTOTPInvalidCode) {
+			t.Fatalf("wrong code: %v", err)
+		}
+	}
+	next, _ := TOTPCode(secret, now.Add(30*time.Second))
+	if _, err := VerifySecondFactor(db, user.ID, next); !errors.Is(err, ErrTOTPLocked) {
+		t.Fatalf("correct code while locked: %v", err)
+	}
+
+	expired := now.Add(-time.Second)
+	if err := db.Model(&models.UserTOTP{}).Where("user_id = ?", user.ID).Update("locked_until", expired).Error; err != nil {
+		t.Fatalf("expire lock: %v", err)
+	}
+	if _, err := VerifySecondFactor(db, user.ID, next); err != nil {
+		t.Fatalf("correct code after the lock expired: %v", err)
+	}
+}
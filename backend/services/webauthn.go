@@ -0,0 +1,313 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"oneimg/backend/models"
+
+	"github.com/ugorji/go/codec"
+	"gorm.io/gorm"
+)
+
+// COSE algorithm identifiers accepted for passkeys.
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgRS256 = -257
+)
+
+const (
+	// WebAuthnTimeout is how long a started ceremony stays valid.
+	WebAuthnTimeout = 5 * time.Minute
+	// MaxWebAuthnCredentialsPerUser caps how many passkeys one user may register.
+	MaxWebAuthnCredentialsPerUser = 20
+
+	authDataFlagUserPresent  = 0x01
+	authDataFlagUserVerified = 0x04
+	authDataFlagAttested     = 0x40
+	authDataMinLength        = 37
+)
+
+var (
+	ErrWebAuthnInvalid     = errors.New("webauthn response is invalid")
+	ErrWebAuthnSignCounter = errors.New("webauthn sign counter did not increase")
+)
+
+// WebAuthnAlgorithms lists the accepted algorithms in order of preference.
+var WebAuthnAlgorithms = []int{COSEAlgES256, COSEAlgEdDSA, COSEAlgRS256}
+
+var cborHandle = &codec.CborHandle{}
+
+// WebAuthnRelyingParty identifies this site to authenticators.
+type WebAuthnRelyingParty struct {
+	ID     string // effective domain, e.g. img.example.com
+	Name   string
+	Origin string // scheme://host[:port] the browser reports in clientDataJSON
+}
+
+// NewWebAuthnRelyingParty derives the relying party from the public site URL.
+func NewWebAuthnRelyingParty(siteURL, name string) (WebAuthnRelyingParty, error) {
+	parsed, err := url.Parse(strings.TrimSpace(siteURL))
+	if err != nil || parsed.Hostname() == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return WebAuthnRelyingParty{}, fmt.Errorf("invalid site url %q", siteURL)
+	}
+	return WebAuthnRelyingParty{
+		ID:     parsed.Hostname(),
+		Name:   name,
+		Origin: parsed.Scheme + "://" + parsed.Host,
+	}, nil
+}
+
+// WebAuthnRegistration is the credential extracted from a verified
+// registration ceremony.
+type WebAuthnRegistration struct {
+	CredentialID []byte
+	PublicKey    []byte // COSE_Key as sent by the authenticator
+	Algorithm    int
+	SignCount    uint32
+	AAGUID       []byte
+}
+
+// NewWebAuthnChallenge returns a random base64url challenge.
+func NewWebAuthnChallenge() (string, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(challenge), nil
+}
+
+// WebAuthnUserHandle is the opaque user.id given to authenticators.
+func WebAuthnUserHandle(userID int) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// DecodeWebAuthnBase64 accepts base64url with or without padding, which is
+// what PublicKeyCredential.toJSON() and most client libraries produce.
+func DecodeWebAuthnBase64(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// VerifyWebAuthnRegistration checks an attestation response against the
+// challenge issued for it. Attestation statements are not verified: the
+// ceremony requests attestation "none" and only the key is trusted.
+func VerifyWebAuthnRegistration(rp WebAuthnRelyingParty, challenge string, clientDataJSON, attestationObject []byte) (WebAuthnRegistration, error) {
+	var registration WebAuthnRegistration
+	if err := verifyClientData(rp, "webauthn.create", challenge, clientDataJSON); err != nil {
+		return registration, err
+	}
+
+	var attestation struct {
+		Fmt      string         `codec:"fmt"`
+		AttStmt  map[string]any `codec:"attStmt"`
+		AuthData []byte         `codec:"authData"`
+	}
+	if err := codec.NewDecoderBytes(attestationObject, cborHandle).Decode(&attestation); err != nil {
+		return registration, fmt.Errorf("%w: attestation object: %v", ErrWebAuthnInvalid, err)
+	}
+	authData := attestation.AuthData
+	flags, signCount, err := parseAuthenticatorData(rp, authData)
+	if err != nil {
+		return registration, err
+	}
+	if flags&authDataFlagAttested == 0 || len(authData) < authDataMinLength+18 {
+		return registration, fmt.Errorf("%w: missing attested credential data", ErrWebAuthnInvalid)
+	}
+
+	rest := authData[authDataMinLength:]
+	aaguid := rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) <= idLength {
+		return registration, fmt.Errorf("%w: credential id length", ErrWebAuthnInvalid)
+	}
+	credentialID := rest[:idLength]
+	rest = rest[idLength:]
+
+	decoder := codec.NewDecoderBytes(rest, cborHandle)
+	var coseKey map[int64]any
+	if err := decoder.Decode(&coseKey); err != nil {
+		return registration, fmt.Errorf("%w: credential public key: %v", ErrWebAuthnInvalid, err)
+	}
+	publicKey := bytes.Clone(rest[:decoder.NumBytesRead()])
+	algorithm, _, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return registration, err
+	}
+
+	registration = WebAuthnRegistration{
+		CredentialID: bytes.Clone(credentialID),
+		PublicKey:    publicKey,
+		Algorithm:    algorithm,
+		SignCount:    signCount,
+		AAGUID:       bytes.Clone(aaguid),
+	}
+	return registration, nil
+}
+
+// VerifyWebAuthnAssertion checks an assertion made with credential and
+// returns the authenticator's new sign counter.
+func VerifyWebAuthnAssertion(rp WebAuthnRelyingParty, challenge string, credential models.WebAuthnCredential, clientDataJSON, authenticatorData, signature []byte) (uint32, error) {
+	if err := verifyClientData(rp, "webauthn.get", challenge, clientDataJSON); err != nil {
+		return 0, err
+	}
+	_, signCount, err := parseAuthenticatorData(rp, authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	_, publicKey, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(bytes.Clone(authenticatorData), clientDataHash[:]...)
+	digest := sha256.Sum256(signed)
+	valid := false
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, signed, signature)
+	}
+	if !valid {
+		return 0, fmt.Errorf("%w: signature", ErrWebAuthnInvalid)
+	}
+	return signCount, nil
+}
+
+// RecordWebAuthnUse stores the new sign counter and last-used time. A counter
+// that does not increase suggests a cloned authenticator and is rejected;
+// authenticators that always report zero are allowed.
+func RecordWebAuthnUse(db *gorm.DB, credential models.WebAuthnCredential, signCount uint32) error {
+	if (signCount != 0 || credential.SignCount != 0) && signCount <= credential.SignCount {
+		return ErrWebAuthnSignCounter
+	}
+	updated := db.Model(&models.WebAuthnCredential{}).
+		Where("id = ? AND sign_count = ?", credential.ID, credential.SignCount).
+		Updates(map[string]any{
+			"sign_count":   signCount,
+			"last_used_at": time.Now(),
+		})
+	if updated.Error != nil {
+		return updated.Error
+	}
+	if updated.RowsAffected == 0 {
+		return ErrWebAuthnSignCounter
+	}
+	return nil
+}
+
+// verifyClientData checks the ceremony type, challenge and origin.
+func verifyClientData(rp WebAuthnRelyingParty, ceremony, challenge string, clientDataJSON []byte) error {
+	var clientData struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return fmt.Errorf("%w: client data: %v", ErrWebAuthnInvalid, err)
+	}
+	if clientData.Type != ceremony {
+		return fmt.Errorf("%w: unexpected ceremony %q", ErrWebAuthnInvalid, clientData.Type)
+	}
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(strings.TrimRight(clientData.Challenge, "=")), []byte(challenge)) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrWebAuthnInvalid)
+	}
+	if clientData.Origin != rp.Origin || clientData.CrossOrigin {
+		return fmt.Errorf("%w: unexpected origin %q", ErrWebAuthnInvalid, clientData.Origin)
+	}
+	return nil
+}
+
+// parseAuthenticatorData checks the RP ID hash and user verification and
+// returns the flags and sign counter. Passkeys replace both the password and
+// the second factor, so a bare presence check is not enough.
+func parseAuthenticatorData(rp WebAuthnRelyingParty, authData []byte) (byte, uint32, error) {
+	if len(authData) < authDataMinLength {
+		return 0, 0, fmt.Errorf("%w: authenticator data too short", ErrWebAuthnInvalid)
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(authData[:32], rpIDHash[:]) != 1 {
+		return 0, 0, fmt.Errorf("%w: rp id mismatch", ErrWebAuthnInvalid)
+	}
+	flags := authData[32]
+	if flags&authDataFlagUserPresent == 0 {
+		return 0, 0, fmt.Errorf("%w: user not present", ErrWebAuthnInvalid)
+	}
+	if flags&authDataFlagUserVerified == 0 {
+		return 0, 0, fmt.Errorf("%w: user not verified", ErrWebAuthnInvalid)
+	}
+	return flags, binary.BigEndian.Uint32(authData[33:37]), nil
+}
+
+// parseCOSEKey decodes a COSE_Key into a Go public key.
+func parseCOSEKey(raw []byte) (int, any, error) {
+	var key map[int64]any
+	if err := codec.NewDecoderBytes(raw, cborHandle).Decode(&key); err != nil {
+		return 0, nil, fmt.Errorf("%w: public key: %v", ErrWebAuthnInvalid, err)
+	}
+	kty, _ := coseInt(key[1])
+	alg, _ := coseInt(key[3])
+	switch {
+	case kty == 2 && alg == COSEAlgES256:
+		crv, _ := coseInt(key[-1])
+		x, _ := key[-2].([]byte)
+		y, _ := key[-3].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, fmt.Errorf("%w: EC2 key", ErrWebAuthnInvalid)
+		}
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return 0, nil, fmt.Errorf("%w: EC2 point", ErrWebAuthnInvalid)
+		}
+		return COSEAlgES256, publicKey, nil
+	case kty == 1 && alg == COSEAlgEdDSA:
+		crv, _ := coseInt(key[-1])
+		x, _ := key[-2].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, fmt.Errorf("%w: OKP key", ErrWebAuthnInvalid)
+		}
+		return COSEAlgEdDSA, ed25519.PublicKey(bytes.Clone(x)), nil
+	case kty == 3 && alg == COSEAlgRS256:
+		n, _ := key[-1].([]byte)
+		e, _ := key[-2].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, fmt.Errorf("%w: RSA key", ErrWebAuthnInvalid)
+		}
+		return COSEAlgRS256, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return 0, nil, fmt.Errorf("%w: unsupported key type %d / algorithm %d", ErrWebAuthnInvalid, kty, alg)
+}
+
+func coseInt(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), true
+	}
+	return 0, false
+}
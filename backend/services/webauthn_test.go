@@ -0,0 +1,186 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"oneimg/backend/database"
+	"oneimg/backend/models"
+
+	"github.com/ugorji/go/codec"
+)
+
+// softAuthenticator is a minimal in-memory WebAuthn authenticator.
+type softAuthenticator struct {
+	t            *testing.T
+	credentialID []byte
+	ecKey        *ecdsa.PrivateKey
+	edKey        ed25519.PrivateKey
+	signCount    uint32
+	presenceOnly bool // assert without user verification
+}
+
+func newSoftAuthenticator(t *testing.T, algorithm int) *softAuthenticator {
+	t.Helper()
+	authenticator := &softAuthenticator{t: t, credentialID: []byte("soft-credential-" + t.Name())}
+	switch algorithm {
+	case COSEAlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		authenticator.ecKey = key
+	case COSEAlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		authenticator.edKey = key
+	}
+	return authenticator
+}
+
+func (a *softAuthenticator) cbor(value any) []byte {
+	var out []byte
+	codec.NewEncoderBytes(&out, &codec.CborHandle{}).MustEncode(value)
+	return out
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge, origin string) []byte {
+	data, _ := json.Marshal(map[string]any{"type": ceremony, "challenge": challenge, "origin": origin})
+	return data
+}
+
+func (a *softAuthenticator) authData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func (a *softAuthenticator) register(rp WebAuthnRelyingParty, challenge string) ([]byte, []byte) {
+	var coseKey map[int64]any
+	if a.ecKey != nil {
+		coseKey = map[int64]any{1: 2, 3: COSEAlgES256, -1: 1, -2: a.ecKey.X.FillBytes(make([]byte, 32)), -3: a.ecKey.Y.FillBytes(make([]byte, 32))}
+	} else {
+		coseKey = map[int64]any{1: 1, 3: COSEAlgEdDSA, -1: 6, -2: []byte(a.edKey.Public().(ed25519.PublicKey))}
+	}
+	authData := a.authData(rp.ID, authDataFlagUserPresent|authDataFlagUserVerified|authDataFlagAttested)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, a.cbor(coseKey)...)
+	attestation := a.cbor(map[string]any{"fmt": "none", "attStmt": map[string]any{}, "authData": authData})
+	return a.clientData("webauthn.create", challenge, rp.Origin), attestation
+}
+
+func (a *softAuthenticator) assert(rp WebAuthnRelyingParty, challenge, origin string) ([]byte, []byte, []byte) {
+	a.signCount++
+	clientData := a.clientData("webauthn.get", challenge, origin)
+	flags := byte(authDataFlagUserPresent | authDataFlagUserVerified)
+	if a.presenceOnly {
+		flags = authDataFlagUserPresent
+	}
+	authData := a.authData(rp.ID, flags)
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+	if a.ecKey != nil {
+		digest := sha256.Sum256(signed)
+		signature, err := ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+		if err != nil {
+			a.t.Fatalf("sign: %v", err)
+		}
+		return clientData, authData, signature
+	}
+	return clientData, authData, ed25519.Sign(a.edKey, signed)
+}
+
+func TestWebAuthnRegistrationAndAssertion(t *testing.T) {
+	rp, err := NewWebAuthnRelyingParty("https://img.example.com/", "OneImg")
+	if err != nil {
+		t.Fatalf("relying party: %v", err)
+	}
+	for name, algorithm := range map[string]int{"es256": COSEAlgES256, "eddsa": COSEAlgEdDSA} {
+		t.Run(name, func(t *testing.T) {
+			authenticator := newSoftAuthenticator(t, algorithm)
+			clientData, attestation := authenticator.register(rp, "register-challenge")
+			if _, err := VerifyWebAuthnRegistration(rp, "other-challenge", clientData, attestation); !errors.Is(err, ErrWebAuthnInvalid) {
+				t.Fatalf("wrong challenge should fail, got %v", err)
+			}
+			registration, err := VerifyWebAuthnRegistration(rp, "register-challenge", clientData, attestation)
+			if err != nil {
+				t.Fatalf("verify registration: %v", err)
+			}
+			if registration.Algorithm != algorithm || string(registration.CredentialID) != string(authenticator.credentialID) {
+				t.Fatalf("unexpected registration %+v", registration)
+			}
+
+			credential := models.WebAuthnCredential{PublicKey: registration.PublicKey, SignCount: registration.SignCount}
+			clientData, authData, signature := authenticator.assert(rp, "login-challenge", rp.Origin)
+			signCount, err := VerifyWebAuthnAssertion(rp, "login-challenge", credential, clientData, authData, signature)
+			if err != nil || signCount != 1 {
+				t.Fatalf("verify assertion: count=%d err=%v", signCount, err)
+			}
+			signature[len(signature)-1] ^= 0xff
+			if _, err := VerifyWebAuthnAssertion(rp, "login-challenge", credential, clientData, authData, signature); !errors.Is(err, ErrWebAuthnInvalid) {
+				t.Fatalf("tampered signature should fail, got %v", err)
+			}
+
+			clientData, authData, signature = authenticator.assert(rp, "login-challenge", "https://evil.example")
+			if _, err := VerifyWebAuthnAssertion(rp, "login-challenge", credential, clientData, authData, signature); !errors.Is(err, ErrWebAuthnInvalid) {
+				t.Fatalf("foreign origin should fail, got %v", err)
+			}
+			other, _ := NewWebAuthnRelyingParty("https://other.example.com", "OneImg")
+			other.Origin = rp.Origin
+			clientData, authData, signature = authenticator.assert(other, "login-challenge", rp.Origin)
+			if _, err := VerifyWebAuthnAssertion(rp, "login-challenge", credential, clientData, authData, signature); !errors.Is(err, ErrWebAuthnInvalid) {
+				t.Fatalf("foreign rp id should fail, got %v", err)
+			}
+
+			authenticator.presenceOnly = true
+			clientData, authData, signature = authenticator.assert(rp, "login-challenge", rp.Origin)
+			if _, err := VerifyWebAuthnAssertion(rp, "login-challenge", credential, clientData, authData, signature); !errors.Is(err, ErrWebAuthnInvalid) {
+				t.Fatalf("assertion without user verification should fail, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRecordWebAuthnUseRejectsNonIncreasingCounter(t *testing.T) {
+	initStorageSyncTestDB(t)
+	db := database.GetDB().DB
+	credential := models.WebAuthnCredential{UserID: 2, Name: "key", CredentialID: "abc", PublicKey: []byte{1}, SignCount: 5, Transports: []string{}}
+	if err := db.Create(&credential).Error; err != nil {
+		t.Fatalf("create credential: %v", err)
+	}
+
+	if err := RecordWebAuthnUse(db, credential, 5); !errors.Is(err, ErrWebAuthnSignCounter) {
+		t.Fatalf("repeated counter: %v", err)
+	}
+	if err := RecordWebAuthnUse(db, credential, 6); err != nil {
+		t.Fatalf("increasing counter: %v", err)
+	}
+	// A concurrent login that read the old counter loses the race.
+	if err := RecordWebAuthnUse(db, credential, 7); !errors.Is(err, ErrWebAuthnSignCounter) {
+		t.Fatalf("stale credential: %v", err)
+	}
+	var stored models.WebAuthnCredential
+	db.First(&stored, credential.ID)
+	if stored.SignCount != 6 || stored.LastUsedAt == nil {
+		t.Fatalf("unexpected stored credential %+v", stored)
+	}
+
+	zero := models.WebAuthnCredential{UserID: 2, Name: "no counter", CredentialID: "def", PublicKey: []byte{1}, Transports: []string{}}
+	if err := db.Create(&zero).Error; err != nil {
+		t.Fatalf("create credential: %v", err)
+	}
+	if err := RecordWebAuthnUse(db, zero, 0); err != nil {
+		t.Fatalf("authenticators without counters are allowed: %v", err)
+	}
+}
@@ -161,6 +161,57 @@ func (f *FTPUtil) DeleteImage(remotePath string) error {
 	return nil
 }
 
+// FileSize 获取FTP服务器上文件的大小
+// remotePath: 远程文件路径
+func (f *FTPUtil) FileSize(remotePath string) (int64, error) {
+	client, err := f.GetClient()
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := client.FileSize(remotePath)
+	if err != nil {
+		if strings.Contains(err.Error(), "550") || strings.Contains(err.Error(), "No such file") {
+			return 0, errors.New("文件不存在")
+		}
+		return 0, fmt.Errorf("获取文件大小失败: %w", err)
+	}
+	return size, nil
+}
+
+// RemoteFile FTP服务器上的文件信息
+type RemoteFile struct {
+	Path       string
+	Size       int64
+	ModifiedAt time.Time
+}
+
+// ListFiles 递归列出目录下的全部文件（不含目录）
+func (f *FTPUtil) ListFiles(root string) ([]RemoteFile, error) {
+	client, err := f.GetClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []RemoteFile
+	walker := client.Walk(strings.ReplaceAll(root, "\\", "/"))
+	for walker.Next() {
+		entry := walker.Stat()
+		if entry == nil || entry.Type != ftp.EntryTypeFile {
+			continue
+		}
+		size := int64(0)
+		if entry.Size <= uint64(math.MaxInt64) {
+			size = int64(entry.Size)
+		}
+		files = append(files, RemoteFile{Path: walker.Path(), Size: size, ModifiedAt: entry.Time})
+	}
+	if err := walker.Err(); err != nil {
+		return files, fmt.Errorf("列出目录失败: %w", err)
+	}
+	return files, nil
+}
+
 // Close 关闭FTP连接
 func (f *FTPUtil) Close() error {
 	if f.conn != nil {
@@ -32,6 +32,7 @@ const (
 	ThumbnailMaxHeight     = 300
 	ThumbnailQuality       = 80
 	CompressSizeThreshold  = 1024 * 1024 // 1MB
+	DownscaleJPEGQuality   = 92          // 超尺寸缩小后重新编码JPEG的质量
 )
 
 // 特殊格式常量
@@ -63,12 +64,17 @@ type ProcessedImage struct {
 	OriginalBytes   []byte // 原始文件字节
 	CompressedBytes []byte // 处理后的字节
 	ThumbnailBytes  []byte // 缩略图字节
-	Width           int    // 图片宽度
-	Height          int    // 图片高度
-	Format          string // 最终格式
-	MimeType        string // 最终MIME类型
-	OutputExt       string // 输出文件扩展名
-	UniqueFileName  string // 唯一文件名
+	// ProfileThumbnails 按设置中的缩略图规格生成的衍生图
+	ProfileThumbnails []ProfileThumbnail
+	Width             int    // 图片宽度
+	Height            int    // 图片高度
+	Downscaled        bool   // 是否因超过最大边长被缩小
+	OriginalWidth     int    // 缩小前宽度
+	OriginalHeight    int    // 缩小前高度
+	Format            string // 最终格式
+	MimeType          string // 最终MIME类型
+	OutputExt         string // 输出文件扩展名
+	UniqueFileName    string // 唯一文件名
 }
 
 // ProcessImage 处理图片（压缩、获取尺寸等）
@@ -98,10 +104,23 @@ func (s *ImageService) ProcessImage(
 	}
 	mimeType := header.Header.Get("Content-Type")
 	originalFileName := header.Filename
+	originalWidth, originalHeight := width, height
+
+	// 3.1 超过最大边长时等比缩小，后续水印/压缩/缩略图都基于缩小后的图片
+	sourceBytes, sourceSize := fileBytes, header.Size
+	img, downscaledBytes, format, mimeType, err := s.downscale(img, format, mimeType, setting.MaxImageDimension)
+	if err != nil {
+		return nil, err
+	}
+	downscaled := downscaledBytes != nil
+	if downscaled {
+		sourceBytes, sourceSize = downscaledBytes, int64(len(downscaledBytes))
+		width, height = img.Bounds().Dx(), img.Bounds().Dy()
+	}
 
 	// 4. 处理主图片（压缩/格式转换）
 	processedBytes, finalFormat, finalMimeType, err := s.processMainImage(
-		fileBytes, img, format, mimeType, header.Size, setting,
+		sourceBytes, img, format, mimeType, sourceSize, setting,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("process main image failed: %w", err)
@@ -128,6 +147,16 @@ func (s *ImageService) ProcessImage(
 		thumbnailBytes = fileBytes // SVG用原文件作为缩略图
 	}
 
+	// 6.1 按配置的缩略图规格生成衍生图（失败的规格跳过，不中断上传）
+	var profileThumbnails []ProfileThumbnail
+	if format != "svg" {
+		var profileErrs []error
+		profileThumbnails, profileErrs = s.GenerateProfileThumbnails(img, setting.GetThumbnailProfiles())
+		for _, profileErr := range profileErrs {
+			log.Printf("generate profile thumbnail failed: %v", profileErr)
+		}
+	}
+
 	// 7. 处理文件名
 	fileName := ""
 	if setting.SaveOriginalName {
@@ -142,18 +171,61 @@ func (s *ImageService) ProcessImage(
 
 	// 8. 组装返回结果
 	return &ProcessedImage{
-		OriginalBytes:   fileBytes,
-		CompressedBytes: processedBytes,
-		ThumbnailBytes:  thumbnailBytes,
-		Width:           width,
-		Height:          height,
-		Format:          finalFormat,
-		MimeType:        finalMimeType,
-		OutputExt:       outputExt[finalMimeType],
-		UniqueFileName:  fileName,
+		OriginalBytes:     fileBytes,
+		CompressedBytes:   processedBytes,
+		ThumbnailBytes:    thumbnailBytes,
+		ProfileThumbnails: profileThumbnails,
+		Width:             width,
+		Height:            height,
+		Downscaled:        downscaled,
+		OriginalWidth:     originalWidth,
+		OriginalHeight:    originalHeight,
+		Format:            finalFormat,
+		MimeType:          finalMimeType,
+		OutputExt:         outputExt[finalMimeType],
+		UniqueFileName:    fileName,
 	}, nil
 }
 
+// ProcessStoredImage 按处理配置重新处理已保存的图片，返回处理后的字节与MIME类型。
+// 用于同步副本时按目标存储桶的处理规格生成衍生文件，不生成缩略图。
+func (s *ImageService) ProcessStoredImage(data []byte, mimeType string, setting models.Settings) ([]byte, string, error) {
+	img, format, err := s.decodeImage(bytes.NewReader(data), mimeType)
+	if err != nil {
+		return nil, "", fmt.Errorf("decode image failed: %w", err)
+	}
+	img, downscaledBytes, format, mimeType, err := s.downscale(img, format, mimeType, setting.MaxImageDimension)
+	if err != nil {
+		return nil, "", err
+	}
+	if downscaledBytes != nil {
+		data = downscaledBytes
+	}
+	processed, _, processedMimeType, err := s.processMainImage(data, img, format, mimeType, int64(len(data)), setting)
+	if err != nil {
+		return nil, "", fmt.Errorf("process main image failed: %w", err)
+	}
+	return processed, processedMimeType, nil
+}
+
+// downscale 超过最大边长时等比缩小并重新编码，未缩小时返回的字节为 nil
+func (s *ImageService) downscale(img image.Image, format, mimeType string, limit int) (image.Image, []byte, string, string, error) {
+	bounds := img.Bounds()
+	if limit <= 0 || s.isSpecialFormat(format, mimeType) || max(bounds.Dx(), bounds.Dy()) <= limit {
+		return img, nil, format, mimeType, nil
+	}
+	img = imaging.Fit(img, limit, limit, imaging.Lanczos)
+	data, err := s.encodeSameFormat(img, format)
+	if err != nil {
+		return nil, nil, "", "", fmt.Errorf("downscale image failed: %w", err)
+	}
+	// 除 WebP/PNG 外的格式统一重新编码为 JPEG，格式与MIME需同步
+	if lower := strings.ToLower(format); lower != "webp" && lower != "png" {
+		format, mimeType = "jpeg", "image/jpeg"
+	}
+	return img, data, format, mimeType, nil
+}
+
 // processMainImage 处理主图片（拆分逻辑，提高可读性）
 func (s *ImageService) processMainImage(
 	fileBytes []byte,
@@ -182,16 +254,23 @@ func (s *ImageService) processMainImage(
 		if err != nil {
 			return nil, "", "", fmt.Errorf("读取水印后图片数据失败：%w", err)
 		}
-		img, _, err = image.Decode(bytes.NewReader(fileBytes))
+		var watermarkedFormat string
+		img, watermarkedFormat, err = image.Decode(bytes.NewReader(fileBytes))
 		if err != nil {
 			return nil, "", "", fmt.Errorf("解码水印后图片失败：%w", err)
 		}
+		// 水印处理会把非 PNG 格式重新编码为 JPEG，格式与MIME需同步
+		if watermarkedFormat != strings.ToLower(format) {
+			format, mimeType = watermarkedFormat, "image/"+watermarkedFormat
+		}
 	}
 
+	compressQuality, compressThreshold := compressionParams(setting)
+
 	// WebP格式处理
 	if strings.ToLower(format) == "webp" {
-		if setting.CompressImage && fileSize > CompressSizeThreshold {
-			compressed, err := s.compressWebP(img, DefaultCompressQuality)
+		if setting.CompressImage && fileSize > compressThreshold {
+			compressed, err := s.compressWebP(img, compressQuality)
 			if err != nil {
 				return nil, "", "", fmt.Errorf("compress webp: %w", err)
 			}
@@ -200,10 +279,25 @@ func (s *ImageService) processMainImage(
 		return fileBytes, "webp", "image/webp", nil
 	}
 
+	// PNG 无损模式：保持 PNG，或转换为无损 WebP
+	if setting.LosslessPNG && strings.ToLower(format) == "png" {
+		if setting.SaveWebp {
+			webpData, err := webp.EncodeLosslessRGBA(img)
+			if err != nil {
+				return nil, "", "", fmt.Errorf("encode lossless webp: %w", err)
+			}
+			return webpData, "webp", "image/webp", nil
+		}
+		if setting.CompressImage {
+			return OptimizePNG(fileBytes, img), "png", "image/png", nil
+		}
+		return fileBytes, format, mimeType, nil
+	}
+
 	// 其他格式处理
 	quality := OriginalQuality
-	if setting.CompressImage && fileSize > CompressSizeThreshold {
-		quality = DefaultCompressQuality
+	if setting.CompressImage && fileSize > compressThreshold {
+		quality = compressQuality
 	}
 
 	// 需要转换为WebP
@@ -216,16 +310,57 @@ func (s *ImageService) processMainImage(
 		return webpData, "webp", "image/webp", nil
 	}
 
-	// 压缩图片
+	// 压缩图片（保持原格式，存储内容与MIME一致）
 	if setting.CompressImage {
-		compressed, err := s.compressWebP(img, DefaultCompressQuality)
+		return s.optimizeSameFormat(fileBytes, img, format, fileSize > compressThreshold, compressQuality)
+	}
+
+	return fileBytes, format, mimeType, nil
+}
+
+// optimizeSameFormat 不转换格式的压缩：PNG 无损优化；JPEG 超过阈值时按压缩质量重新编码，
+// 再去除非必要元数据并重建最优 Huffman 表；其他格式无法保持原格式压缩，转换为 WebP 并返回对应MIME。
+func (s *ImageService) optimizeSameFormat(fileBytes []byte, img image.Image, format string, overThreshold bool, quality int) ([]byte, string, string, error) {
+	switch strings.ToLower(format) {
+	case "png":
+		return OptimizePNG(fileBytes, img), "png", "image/png", nil
+	case "jpeg", "jpg":
+		source := fileBytes
+		if overThreshold {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, "", "", fmt.Errorf("compress jpeg: %w", err)
+			}
+			if buf.Len() < len(source) {
+				source = buf.Bytes()
+			}
+		}
+		optimized, err := OptimizeJPEG(source)
+		if err != nil {
+			log.Printf("optimize jpeg failed: %v", err)
+			return source, "jpeg", "image/jpeg", nil
+		}
+		return optimized, "jpeg", "image/jpeg", nil
+	default:
+		compressed, err := s.compressWebP(img, quality)
 		if err != nil {
 			return nil, "", "", fmt.Errorf("compress webp: %w", err)
 		}
-		return compressed, format, mimeType, nil
+		return compressed, "webp", "image/webp", nil
 	}
+}
 
-	return fileBytes, format, mimeType, nil
+// compressionParams 返回压缩质量与压缩阈值，未配置时使用默认值
+func compressionParams(setting models.Settings) (int, int64) {
+	quality := setting.CompressQuality
+	if quality <= 0 || quality > 100 {
+		quality = DefaultCompressQuality
+	}
+	threshold := setting.CompressThreshold
+	if threshold <= 0 {
+		threshold = CompressSizeThreshold
+	}
+	return quality, threshold
 }
 
 // generateThumbnail 生成缩略图（新增SVG处理）
@@ -322,6 +457,24 @@ func (s *ImageService) convertToWebP(img image.Image, quality int) ([]byte, erro
 	return data, nil
 }
 
+// encodeSameFormat 按原格式重新编码（用于超尺寸缩小后保持原格式）
+func (s *ImageService) encodeSameFormat(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch strings.ToLower(format) {
+	case "webp":
+		return s.convertToWebP(img, OriginalQuality)
+	case "png":
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("encode png: %w", err)
+		}
+	default:
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: DownscaleJPEGQuality}); err != nil {
+			return nil, fmt.Errorf("encode jpeg: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
 // compressWebP 压缩webp图片
 func (s *ImageService) compressWebP(img image.Image, quality int) ([]byte, error) {
 	return s.convertToWebP(img, quality)
@@ -0,0 +1,70 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"oneimg/backend/models"
+)
+
+func TestProcessMainImageLosslessPNG(t *testing.T) {
+	source := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for x := 0; x < 32; x++ {
+		for y := 0; y < 32; y++ {
+			source.Set(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 8), B: 128, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, source); err != nil {
+		t.Fatalf("encode source: %v", err)
+	}
+	service := &ImageService{}
+
+	setting := models.Settings{CompressImage: true, LosslessPNG: true}
+	data, format, mimeType, err := service.processMainImage(encoded.Bytes(), source, "png", "image/png", int64(encoded.Len()), setting)
+	if err != nil {
+		t.Fatalf("process png: %v", err)
+	}
+	if format != "png" || mimeType != "image/png" {
+		t.Fatalf("lossless png should stay png, got format=%s mime=%s", format, mimeType)
+	}
+	optimized, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode optimized png: %v", err)
+	}
+	if color.NRGBAModel.Convert(optimized.At(31, 17)) != source.NRGBAAt(31, 17) {
+		t.Fatalf("lossless png changed pixel values")
+	}
+
+	setting.SaveWebp = true
+	data, format, mimeType, err = service.processMainImage(encoded.Bytes(), source, "png", "image/png", int64(encoded.Len()), setting)
+	if err != nil {
+		t.Fatalf("process png to webp: %v", err)
+	}
+	if format != "webp" || mimeType != "image/webp" {
+		t.Fatalf("expected lossless webp, got format=%s mime=%s", format, mimeType)
+	}
+	decoded, _, err := service.DecodeImageBytes(data, mimeType)
+	if err != nil {
+		t.Fatalf("decode webp: %v", err)
+	}
+	r, g, b, _ := decoded.At(31, 17).RGBA()
+	wantR, wantG, wantB, _ := source.At(31, 17).RGBA()
+	if r != wantR || g != wantG || b != wantB {
+		t.Fatalf("lossless webp changed pixel values")
+	}
+}
+
+func TestCompressionParamsFallBackToDefaults(t *testing.T) {
+	quality, threshold := compressionParams(models.Settings{})
+	if quality != DefaultCompressQuality || threshold != CompressSizeThreshold {
+		t.Fatalf("unexpected defaults: quality=%d threshold=%d", quality, threshold)
+	}
+	quality, threshold = compressionParams(models.Settings{CompressQuality: 60, CompressThreshold: 2048})
+	if quality != 60 || threshold != 2048 {
+		t.Fatalf("unexpected configured params: quality=%d threshold=%d", quality, threshold)
+	}
+}
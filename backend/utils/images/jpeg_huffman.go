@@ -0,0 +1,673 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"slices"
+)
+
+var errJPEGNotSequential = errors.New("jpeg: only 8-bit huffman sequential images can be optimized")
+
+// OptimizeJPEG 无损优化 JPEG：去除非必要元数据，并按扫描中实际出现的符号频率重建最优 Huffman 表。
+// 熵编码数据先按原 Huffman 表解码为符号与附加位，再用新表逐个重新编码，DCT 系数逐位不变。
+// 只重建 8 位 Huffman 顺序式（基线/扩展）JPEG；渐进式、算术编码等帧类型、
+// 重新编码后未变小或解码像素不一致时，返回只去除元数据的结果。
+func OptimizeJPEG(data []byte) ([]byte, error) {
+	stripped, err := StripJPEGMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	optimized, err := rebuildJPEGHuffmanTables(stripped)
+	if err != nil || len(optimized) >= len(stripped) || !sameJPEGPixels(stripped, optimized) {
+		return stripped, nil
+	}
+	return optimized, nil
+}
+
+// jpegHuffmanTables 按 class*4+id 索引 Huffman 表，class 0 为 DC、1 为 AC
+type jpegHuffmanTables [8]*jpegHuffmanTable
+
+// jpegHuffmanTable 规范 Huffman 表（ITU T.81 附录 C、F.2.2.3）
+type jpegHuffmanTable struct {
+	bits    [17]uint8 // bits[l] 为长度 l 的码字个数
+	values  []uint8
+	codes   [256]uint16
+	sizes   [256]uint8
+	maxCode [17]int32
+	minCode [17]int32
+	valPtr  [17]int32
+}
+
+type jpegComponent struct {
+	id   uint8
+	h, v int
+}
+
+type jpegScan struct {
+	components []int // 在帧组件中的下标
+	dc, ac     []int // jpegHuffmanTables 下标
+	restart    int
+	data       []byte // 熵编码数据（含填充字节与 RST 标记）
+}
+
+type jpegFrame struct {
+	width, height int
+	hMax, vMax    int
+	components    []jpegComponent
+}
+
+// rebuildJPEGHuffmanTables 用最优 Huffman 表重新编码所有扫描；
+// 原有 DHT 段被移除，合并后的新表写在首个扫描之前。
+func rebuildJPEGHuffmanTables(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("jpeg: missing SOI marker")
+	}
+	var (
+		frame   *jpegFrame
+		tables  jpegHuffmanTables
+		restart int
+		scans   []jpegScan
+		// parts 为输出顺序，nil 表示在该位置写入下一个重新编码的扫描
+		parts     [][]byte
+		firstScan = -1
+	)
+	offset := 2
+	for {
+		if offset+2 > len(data) || data[offset] != 0xFF {
+			return nil, errors.New("jpeg: invalid segment marker")
+		}
+		marker := data[offset+1]
+		if marker == 0xFF {
+			offset++
+			continue
+		}
+		if marker == 0xD9 {
+			break
+		}
+		if offset+4 > len(data) {
+			return nil, errors.New("jpeg: truncated segment")
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("jpeg: invalid segment length")
+		}
+		segment := data[offset+4 : end]
+
+		switch {
+		case marker == 0xC0 || marker == 0xC1:
+			if frame != nil {
+				return nil, errors.New("jpeg: multiple frames")
+			}
+			parsed, err := parseJPEGFrame(segment)
+			if err != nil {
+				return nil, err
+			}
+			frame = parsed
+		case marker >= 0xC2 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			return nil, errJPEGNotSequential
+		case marker == 0xCC || marker == 0xDC:
+			// 算术编码条件表、DNL 段
+			return nil, errJPEGNotSequential
+		case marker == 0xC4:
+			if len(scans) > 0 {
+				return nil, errors.New("jpeg: huffman tables redefined between scans")
+			}
+			if err := parseJPEGHuffmanTables(segment, &tables); err != nil {
+				return nil, err
+			}
+			offset = end
+			continue
+		case marker == 0xDD:
+			if len(segment) < 2 {
+				return nil, errors.New("jpeg: invalid DRI segment")
+			}
+			restart = int(binary.BigEndian.Uint16(segment))
+		case marker == 0xDA:
+			if frame == nil {
+				return nil, errors.New("jpeg: scan before frame header")
+			}
+			scan, err := parseJPEGScan(segment, frame, &tables)
+			if err != nil {
+				return nil, err
+			}
+			scan.restart = restart
+			dataEnd := jpegEntropyEnd(data, end)
+			scan.data = data[end:dataEnd]
+			if firstScan < 0 {
+				firstScan = len(parts)
+			}
+			parts = append(parts, data[offset:end], nil)
+			scans = append(scans, scan)
+			offset = dataEnd
+			continue
+		}
+		parts = append(parts, data[offset:end])
+		offset = end
+	}
+	if firstScan < 0 {
+		return nil, errors.New("jpeg: no scan")
+	}
+
+	var freqs [len(tables)]*[257]int
+	for i := range scans {
+		for _, slot := range slices.Concat(scans[i].dc, scans[i].ac) {
+			if freqs[slot] == nil {
+				freqs[slot] = new([257]int)
+			}
+		}
+		err := frame.walkScan(&scans[i], &tables, func(slot int, symbol uint8, _ uint16, _ uint8) {
+			freqs[slot][symbol]++
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var optimal jpegHuffmanTables
+	for slot, freq := range freqs {
+		if freq != nil {
+			optimal[slot] = buildOptimalHuffmanTable(freq)
+		}
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	out.Write(data[:2])
+	scanIndex := 0
+	for i, part := range parts {
+		if i == firstScan {
+			writeJPEGHuffmanTables(&out, &optimal)
+		}
+		if part != nil {
+			out.Write(part)
+			continue
+		}
+		if err := frame.encodeScan(&out, &scans[scanIndex], &tables, &optimal); err != nil {
+			return nil, err
+		}
+		scanIndex++
+	}
+	out.Write([]byte{0xFF, 0xD9})
+	return out.Bytes(), nil
+}
+
+func parseJPEGFrame(segment []byte) (*jpegFrame, error) {
+	if len(segment) < 6 || segment[0] != 8 {
+		return nil, errJPEGNotSequential
+	}
+	frame := &jpegFrame{
+		height: int(binary.BigEndian.Uint16(segment[1:3])),
+		width:  int(binary.BigEndian.Uint16(segment[3:5])),
+		hMax:   1,
+		vMax:   1,
+	}
+	count := int(segment[5])
+	if frame.width == 0 || frame.height == 0 || count == 0 || count > 4 || len(segment) < 6+count*3 {
+		return nil, errors.New("jpeg: unsupported frame header")
+	}
+	for i := range count {
+		sampling := segment[7+i*3]
+		component := jpegComponent{id: segment[6+i*3], h: int(sampling >> 4), v: int(sampling & 0x0F)}
+		if component.h < 1 || component.h > 4 || component.v < 1 || component.v > 4 {
+			return nil, errors.New("jpeg: invalid sampling factor")
+		}
+		frame.hMax = max(frame.hMax, component.h)
+		frame.vMax = max(frame.vMax, component.v)
+		frame.components = append(frame.components, component)
+	}
+	return frame, nil
+}
+
+func parseJPEGScan(segment []byte, frame *jpegFrame, tables *jpegHuffmanTables) (jpegScan, error) {
+	var scan jpegScan
+	if len(segment) < 1 {
+		return scan, errors.New("jpeg: invalid SOS segment")
+	}
+	count := int(segment[0])
+	if count == 0 || count > 4 || len(segment) != 1+count*2+3 {
+		return scan, errors.New("jpeg: invalid SOS segment")
+	}
+	// 顺序式扫描的频谱选择必须为 0..63，逐次逼近为 0
+	if tail := segment[1+count*2:]; tail[0] != 0 || tail[1] != 63 || tail[2] != 0 {
+		return scan, errJPEGNotSequential
+	}
+	for i := range count {
+		id, selector := segment[1+i*2], segment[2+i*2]
+		index := slices.IndexFunc(frame.components, func(c jpegComponent) bool { return c.id == id })
+		if index < 0 {
+			return scan, fmt.Errorf("jpeg: unknown scan component %d", id)
+		}
+		dc, ac := int(selector>>4), 4+int(selector&0x0F)
+		if selector>>4 > 3 || selector&0x0F > 3 || tables[dc] == nil || tables[ac] == nil {
+			return scan, errors.New("jpeg: missing huffman table")
+		}
+		scan.components = append(scan.components, index)
+		scan.dc = append(scan.dc, dc)
+		scan.ac = append(scan.ac, ac)
+	}
+	return scan, nil
+}
+
+func parseJPEGHuffmanTables(segment []byte, tables *jpegHuffmanTables) error {
+	for len(segment) > 0 {
+		if len(segment) < 17 {
+			return errors.New("jpeg: invalid DHT segment")
+		}
+		class, id := segment[0]>>4, segment[0]&0x0F
+		if class > 1 || id > 3 {
+			return errors.New("jpeg: invalid huffman table selector")
+		}
+		table := &jpegHuffmanTable{}
+		total := 0
+		for l := 1; l <= 16; l++ {
+			table.bits[l] = segment[l]
+			total += int(segment[l])
+		}
+		if total == 0 || total > 256 || len(segment) < 17+total {
+			return errors.New("jpeg: invalid DHT segment")
+		}
+		table.values = slices.Clone(segment[17 : 17+total])
+		if err := table.generate(); err != nil {
+			return err
+		}
+		tables[class*4+id] = table
+		segment = segment[17+total:]
+	}
+	return nil
+}
+
+// generate 按 BITS/HUFFVAL 生成码字与解码用的 minCode/maxCode/valPtr。
+func (t *jpegHuffmanTable) generate() error {
+	code := int32(0)
+	k := 0
+	for l := 1; l <= 16; l++ {
+		t.valPtr[l] = int32(k)
+		t.minCode[l] = code
+		t.maxCode[l] = -1
+		for range int(t.bits[l]) {
+			t.codes[t.values[k]] = uint16(code)
+			t.sizes[t.values[k]] = uint8(l)
+			code++
+			k++
+		}
+		if t.bits[l] > 0 {
+			t.maxCode[l] = code - 1
+		}
+		if code > 1<<l {
+			return errors.New("jpeg: invalid huffman code lengths")
+		}
+		code <<= 1
+	}
+	return nil
+}
+
+// buildOptimalHuffmanTable 按符号频率生成码长不超过 16 的最优表（ITU T.81 附录 K.2）。
+// freq[256] 为保留的哑符号，保证不会生成全 1 码字。
+func buildOptimalHuffmanTable(counts *[257]int) *jpegHuffmanTable {
+	var freq [257]int
+	copy(freq[:], counts[:])
+	freq[256] = 1
+	var codeSize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		// c1 为频率最小的符号，c2 为次小；频率相同时取较大的符号
+		c1, c2 := -1, -1
+		for i := range freq {
+			if freq[i] > 0 && (c1 < 0 || freq[i] <= freq[c1]) {
+				c1 = i
+			}
+		}
+		for i := range freq {
+			if freq[i] > 0 && i != c1 && (c2 < 0 || freq[i] <= freq[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+		codeSize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codeSize[c1]++
+		}
+		others[c1] = c2
+		codeSize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codeSize[c2]++
+		}
+	}
+
+	var bits [33]int
+	for _, size := range codeSize {
+		if size > 0 {
+			bits[size]++
+		}
+	}
+	// 码长超过 16 时按附录 K.3 调整
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	// 去掉哑符号占用的最长码字
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	table := &jpegHuffmanTable{}
+	for l := 1; l <= 16; l++ {
+		table.bits[l] = uint8(bits[l])
+	}
+	for size := 1; size <= 32; size++ {
+		for symbol := range 256 {
+			if codeSize[symbol] == size {
+				table.values = append(table.values, uint8(symbol))
+			}
+		}
+	}
+	// 码长调整后 generate 只会按 bits 分配，不会失败
+	_ = table.generate()
+	return table
+}
+
+func writeJPEGHuffmanTables(out *bytes.Buffer, tables *jpegHuffmanTables) {
+	length := 2
+	for _, table := range tables {
+		if table != nil {
+			length += 17 + len(table.values)
+		}
+	}
+	out.Write([]byte{0xFF, 0xC4, byte(length >> 8), byte(length)})
+	for slot, table := range tables {
+		if table == nil {
+			continue
+		}
+		out.WriteByte(byte(slot/4)<<4 | byte(slot%4))
+		out.Write(table.bits[1:])
+		out.Write(table.values)
+	}
+}
+
+// jpegEntropyEnd 返回从 start 开始的熵编码数据结束位置，即首个非填充、非 RST 标记。
+func jpegEntropyEnd(data []byte, start int) int {
+	i := start
+	for i+1 < len(data) {
+		if data[i] != 0xFF {
+			i++
+			continue
+		}
+		next := data[i+1]
+		if next == 0x00 || (next >= 0xD0 && next <= 0xD7) {
+			i += 2
+			continue
+		}
+		if next == 0xFF {
+			i++
+			continue
+		}
+		return i
+	}
+	return len(data)
+}
+
+// jpegBitReader 读取去除 0xFF00 填充后的熵编码位流，遇到标记时停止预读。
+type jpegBitReader struct {
+	data []byte
+	pos  int
+	acc  uint64
+	n    uint
+}
+
+var (
+	errJPEGScanEnd    = errors.New("jpeg: unexpected end of scan")
+	errJPEGScanMarker = errors.New("jpeg: unexpected marker in scan")
+)
+
+// fill 预读字节直到累加器中至少有 need 位。
+func (r *jpegBitReader) fill(need uint) error {
+	for r.n < need {
+		if r.pos >= len(r.data) {
+			return errJPEGScanEnd
+		}
+		b := r.data[r.pos]
+		if b == 0xFF {
+			if r.pos+1 >= len(r.data) || r.data[r.pos+1] != 0x00 {
+				return errJPEGScanMarker
+			}
+			r.pos++
+		}
+		r.pos++
+		r.acc = r.acc<<8 | uint64(b)
+		r.n += 8
+	}
+	return nil
+}
+
+func (r *jpegBitReader) bits(n uint8) (uint16, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	if err := r.fill(uint(n)); err != nil {
+		return 0, err
+	}
+	r.n -= uint(n)
+	return uint16(r.acc>>r.n) & (1<<n - 1), nil
+}
+
+func (r *jpegBitReader) decode(table *jpegHuffmanTable) (uint8, error) {
+	// 扫描末尾或标记前可能不足 16 位，只要剩余位足以构成码字即可
+	err := r.fill(16)
+	code := int32(0)
+	for l := 1; l <= 16; l++ {
+		if r.n == 0 {
+			return 0, err
+		}
+		r.n--
+		code = code<<1 | int32(r.acc>>r.n&1)
+		if code <= table.maxCode[l] {
+			return table.values[table.valPtr[l]+code-table.minCode[l]], nil
+		}
+	}
+	return 0, errors.New("jpeg: invalid huffman code")
+}
+
+// restart 丢弃当前字节剩余的填充位并读取期望的 RST 标记。
+func (r *jpegBitReader) restart(index int) error {
+	// 预读在标记前停止，剩余满 8 位说明标记前还有未解码的数据
+	if r.n >= 8 {
+		return errors.New("jpeg: unexpected data before restart marker")
+	}
+	r.n = 0
+	for r.pos+1 < len(r.data) && r.data[r.pos] == 0xFF && r.data[r.pos+1] == 0xFF {
+		r.pos++
+	}
+	if r.pos+1 >= len(r.data) || r.data[r.pos] != 0xFF || r.data[r.pos+1] != 0xD0+byte(index%8) {
+		return errors.New("jpeg: missing restart marker")
+	}
+	r.pos += 2
+	return nil
+}
+
+// walkScan 按扫描的 MCU 顺序解码每个块的 Huffman 符号及其附加位，依次交给 emit；
+// 每个重启间隔结束时调用 restart（可为 nil）。
+func (f *jpegFrame) walkScan(
+	scan *jpegScan,
+	tables *jpegHuffmanTables,
+	emit func(slot int, symbol uint8, bits uint16, size uint8),
+	restart func(index int),
+) error {
+	reader := &jpegBitReader{data: scan.data}
+	var mcus int
+	blocksPerMCU := make([]int, len(scan.components))
+	if len(scan.components) == 1 {
+		// 非交错扫描：每个 MCU 为该组件的一个块
+		component := f.components[scan.components[0]]
+		width := (f.width*component.h + f.hMax - 1) / f.hMax
+		height := (f.height*component.v + f.vMax - 1) / f.vMax
+		mcus = ((width + 7) / 8) * ((height + 7) / 8)
+		blocksPerMCU[0] = 1
+	} else {
+		mcusWide := (f.width + 8*f.hMax - 1) / (8 * f.hMax)
+		mcus = mcusWide * ((f.height + 8*f.vMax - 1) / (8 * f.vMax))
+		for i, index := range scan.components {
+			blocksPerMCU[i] = f.components[index].h * f.components[index].v
+		}
+	}
+
+	for mcu := range mcus {
+		if scan.restart > 0 && mcu > 0 && mcu%scan.restart == 0 {
+			index := mcu/scan.restart - 1
+			if err := reader.restart(index); err != nil {
+				return err
+			}
+			if restart != nil {
+				restart(index)
+			}
+		}
+		for i := range scan.components {
+			dcTable, acTable := tables[scan.dc[i]], tables[scan.ac[i]]
+			for range blocksPerMCU[i] {
+				size, err := reader.decode(dcTable)
+				if err != nil {
+					return err
+				}
+				if size > 11 {
+					return errors.New("jpeg: invalid DC coefficient size")
+				}
+				value, err := reader.bits(size)
+				if err != nil {
+					return err
+				}
+				emit(scan.dc[i], size, value, size)
+				for k := 1; k < 64; {
+					symbol, err := reader.decode(acTable)
+					if err != nil {
+						return err
+					}
+					run, size := int(symbol>>4), symbol&0x0F
+					if size == 0 {
+						emit(scan.ac[i], symbol, 0, 0)
+						if run != 15 {
+							break // EOB
+						}
+						k += 16
+						continue
+					}
+					if size > 10 {
+						return errors.New("jpeg: invalid AC coefficient size")
+					}
+					k += run
+					if k > 63 {
+						return errors.New("jpeg: AC coefficients exceed block")
+					}
+					value, err := reader.bits(size)
+					if err != nil {
+						return err
+					}
+					emit(scan.ac[i], symbol, value, size)
+					k++
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// encodeScan 用新表重新编码扫描，重启标记保留在原位置。
+func (f *jpegFrame) encodeScan(out *bytes.Buffer, scan *jpegScan, tables, optimal *jpegHuffmanTables) error {
+	writer := jpegBitWriter{out: out}
+	err := f.walkScan(scan, tables, func(slot int, symbol uint8, bits uint16, size uint8) {
+		table := optimal[slot]
+		writer.write(uint32(table.codes[symbol]), table.sizes[symbol])
+		writer.write(uint32(bits), size)
+	}, func(index int) {
+		writer.flush()
+		out.Write([]byte{0xFF, 0xD0 + byte(index%8)})
+	})
+	if err != nil {
+		return err
+	}
+	writer.flush()
+	return nil
+}
+
+// jpegBitWriter 写入熵编码位流，0xFF 字节后补 0x00，结尾以 1 填充到整字节。
+type jpegBitWriter struct {
+	out *bytes.Buffer
+	acc uint64
+	n   uint
+}
+
+func (w *jpegBitWriter) write(bits uint32, size uint8) {
+	w.acc = w.acc<<size | uint64(bits)&(1<<size-1)
+	w.n += uint(size)
+	for w.n >= 8 {
+		w.n -= 8
+		b := byte(w.acc >> w.n)
+		w.out.WriteByte(b)
+		if b == 0xFF {
+			w.out.WriteByte(0x00)
+		}
+	}
+}
+
+func (w *jpegBitWriter) flush() {
+	if w.n > 0 {
+		w.write(0xFF, uint8(8-w.n))
+	}
+}
+
+// sameJPEGPixels 解码两份 JPEG 并逐像素比较，任一无法解码时视为不一致。
+func sameJPEGPixels(a, b []byte) bool {
+	want, err := jpeg.Decode(bytes.NewReader(a))
+	if err != nil {
+		return false
+	}
+	got, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil || got.Bounds() != want.Bounds() {
+		return false
+	}
+	switch w := want.(type) {
+	case *image.YCbCr:
+		g, ok := got.(*image.YCbCr)
+		return ok && w.SubsampleRatio == g.SubsampleRatio &&
+			bytes.Equal(w.Y, g.Y) && bytes.Equal(w.Cb, g.Cb) && bytes.Equal(w.Cr, g.Cr)
+	case *image.Gray:
+		g, ok := got.(*image.Gray)
+		return ok && bytes.Equal(w.Pix, g.Pix)
+	case *image.CMYK:
+		g, ok := got.(*image.CMYK)
+		return ok && bytes.Equal(w.Pix, g.Pix)
+	case *image.RGBA:
+		g, ok := got.(*image.RGBA)
+		return ok && bytes.Equal(w.Pix, g.Pix)
+	}
+	bounds := want.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if want.At(x, y) != got.At(x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
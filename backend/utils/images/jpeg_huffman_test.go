@@ -0,0 +1,300 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+)
+
+func TestOptimizeJPEGShrinksStandardEncoderOutput(t *testing.T) {
+	colorful := image.NewNRGBA(image.Rect(0, 0, 157, 93))
+	gray := image.NewGray(image.Rect(0, 0, 61, 45))
+	random := rand.New(rand.NewSource(1))
+	for x := 0; x < 157; x++ {
+		for y := 0; y < 93; y++ {
+			noise := uint8(random.Intn(24))
+			colorful.Set(x, y, color.NRGBA{R: uint8(x) + noise, G: uint8(y * 2), B: uint8((x * y) % 256), A: 255})
+			gray.Set(x%61, y%45, color.Gray{Y: uint8((x+y*7)%256) + noise})
+		}
+	}
+
+	for name, source := range map[string]image.Image{"ycbcr": colorful, "gray": gray} {
+		var encoded bytes.Buffer
+		if err := jpeg.Encode(&encoded, source, &jpeg.Options{Quality: 90}); err != nil {
+			t.Fatalf("%s: encode source: %v", name, err)
+		}
+		optimized, err := OptimizeJPEG(encoded.Bytes())
+		if err != nil {
+			t.Fatalf("%s: optimize: %v", name, err)
+		}
+		// 标准库使用附录 K 的通用表，按实际频率重建后应变小
+		if len(optimized) >= encoded.Len() {
+			t.Fatalf("%s: expected smaller jpeg, got %d >= %d", name, len(optimized), encoded.Len())
+		}
+		assertSameJPEGPixels(t, name, encoded.Bytes(), optimized)
+	}
+}
+
+func TestRebuildJPEGHuffmanTablesHandlesSamplingAndRestarts(t *testing.T) {
+	cases := map[string]struct {
+		sampling    []byte
+		restart     int
+		interleaved bool
+	}{
+		"gray":                  {sampling: []byte{0x11}, restart: 0, interleaved: true},
+		"4:4:4":                 {sampling: []byte{0x11, 0x11, 0x11}, restart: 0, interleaved: true},
+		"4:2:2":                 {sampling: []byte{0x21, 0x11, 0x11}, restart: 0, interleaved: true},
+		"4:4:0":                 {sampling: []byte{0x12, 0x11, 0x11}, restart: 0, interleaved: true},
+		"4:2:0 restart":         {sampling: []byte{0x22, 0x11, 0x11}, restart: 3, interleaved: true},
+		"4:1:1 restart":         {sampling: []byte{0x41, 0x11, 0x11}, restart: 1, interleaved: true},
+		"cmyk":                  {sampling: []byte{0x11, 0x11, 0x11, 0x11}, restart: 0, interleaved: true},
+		"4:2:2 non-interleaved": {sampling: []byte{0x21, 0x11, 0x11}, restart: 0, interleaved: false},
+		// 标准库解码非交错扫描时按交错 MCU 计数重启间隔，仅在各分量 1x1 采样时与规范一致
+		"4:4:4 non-interleaved restart": {sampling: []byte{0x11, 0x11, 0x11}, restart: 5, interleaved: false},
+	}
+	for name, tc := range cases {
+		source := syntheticJPEG(t, 37, 23, tc.sampling, tc.restart, tc.interleaved)
+		if _, err := jpeg.Decode(bytes.NewReader(source)); err != nil {
+			t.Fatalf("%s: fixture is not decodable: %v", name, err)
+		}
+		rebuilt, err := rebuildJPEGHuffmanTables(source)
+		if err != nil {
+			t.Fatalf("%s: rebuild tables: %v", name, err)
+		}
+		if len(rebuilt) >= len(source) {
+			t.Fatalf("%s: expected smaller jpeg, got %d >= %d", name, len(rebuilt), len(source))
+		}
+		assertSameJPEGPixels(t, name, source, rebuilt)
+
+		optimized, err := OptimizeJPEG(source)
+		if err != nil || !bytes.Equal(optimized, rebuilt) {
+			t.Fatalf("%s: OptimizeJPEG should return the rebuilt jpeg (err %v)", name, err)
+		}
+	}
+}
+
+func TestOptimizeJPEGFallsBackToStrippedData(t *testing.T) {
+	source := syntheticJPEG(t, 37, 23, []byte{0x21, 0x11, 0x11}, 2, true)
+	scan := jpegMarkerOffset(t, source, 0xDA)
+
+	progressive := append([]byte(nil), source...)
+	progressive[jpegMarkerOffset(t, progressive, 0xC0)+1] = 0xC2
+	truncated := append(append([]byte(nil), source[:scan+40]...), 0xFF, 0xD9)
+	garbled := append([]byte(nil), source...)
+	for i := scan + 20; i < scan+60; i++ {
+		garbled[i] = 0x00
+	}
+	missingRestart := bytes.Replace(append([]byte(nil), source...), []byte{0xFF, 0xD1}, []byte{0xFF, 0xD5}, 1)
+
+	for name, data := range map[string][]byte{
+		"progressive":     progressive,
+		"truncated scan":  truncated,
+		"garbled scan":    garbled,
+		"missing restart": missingRestart,
+	} {
+		optimized, err := OptimizeJPEG(data)
+		if err != nil {
+			t.Fatalf("%s: optimize: %v", name, err)
+		}
+		if !bytes.Equal(optimized, data) {
+			t.Fatalf("%s: expected the input back unchanged", name)
+		}
+	}
+	if _, err := rebuildJPEGHuffmanTables(progressive); err != errJPEGNotSequential {
+		t.Fatalf("progressive: expected errJPEGNotSequential, got %v", err)
+	}
+	for name, data := range map[string][]byte{"truncated scan": truncated, "garbled scan": garbled, "missing restart": missingRestart} {
+		if _, err := rebuildJPEGHuffmanTables(data); err == nil {
+			t.Fatalf("%s: expected the entropy data to be rejected", name)
+		}
+	}
+	if _, err := OptimizeJPEG([]byte("not a jpeg")); err == nil {
+		t.Fatal("expected error for data without SOI")
+	}
+}
+
+func TestBuildOptimalHuffmanTableLimitsCodeLength(t *testing.T) {
+	// 斐波那契频率会产生超过 16 位的码长，需要按附录 K.3 调整
+	var freq [257]int
+	a, b := 1, 1
+	for symbol := range 30 {
+		freq[symbol] = a
+		a, b = b, a+b
+	}
+	table := buildOptimalHuffmanTable(&freq)
+	total := 0
+	for l := 1; l <= 16; l++ {
+		total += int(table.bits[l])
+	}
+	if total != 30 || len(table.values) != 30 {
+		t.Fatalf("expected 30 symbols, got %d/%d", total, len(table.values))
+	}
+	for symbol := range 30 {
+		if size := table.sizes[symbol]; size == 0 || size > 16 {
+			t.Fatalf("symbol %d has code length %d", symbol, size)
+		}
+		// 全 1 码字保留不用
+		if table.codes[symbol] == 1<<table.sizes[symbol]-1 {
+			t.Fatalf("symbol %d uses the all-ones code", symbol)
+		}
+	}
+}
+
+func assertSameJPEGPixels(t *testing.T, name string, want, got []byte) {
+	t.Helper()
+	wantImage, err := jpeg.Decode(bytes.NewReader(want))
+	if err != nil {
+		t.Fatalf("%s: decode source: %v", name, err)
+	}
+	gotImage, err := jpeg.Decode(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("%s: decode optimized: %v", name, err)
+	}
+	bounds := wantImage.Bounds()
+	if gotImage.Bounds() != bounds {
+		t.Fatalf("%s: bounds changed", name)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if wantImage.At(x, y) != gotImage.At(x, y) {
+				t.Fatalf("%s: pixel (%d,%d) changed", name, x, y)
+			}
+		}
+	}
+}
+
+// syntheticJPEG 生成指定采样因子的基线 JPEG：系数随机，Huffman 表为等长码（故意不是最优），
+// 可带重启间隔或按分量拆成多个非交错扫描，覆盖标准库编码器不会产生的结构。
+func syntheticJPEG(t *testing.T, width, height int, sampling []byte, restart int, interleaved bool) []byte {
+	t.Helper()
+	random := rand.New(rand.NewSource(int64(len(sampling)*100 + restart)))
+	var out bytes.Buffer
+	segment := func(marker byte, body []byte) {
+		out.Write([]byte{0xFF, marker})
+		out.Write(binary.BigEndian.AppendUint16(nil, uint16(len(body)+2)))
+		out.Write(body)
+	}
+	out.Write([]byte{0xFF, 0xD8})
+	segment(0xDB, append([]byte{0x00}, bytes.Repeat([]byte{2}, 64)...))
+	if len(sampling) == 4 {
+		// 四分量需要 Adobe 段说明颜色变换（0 为 CMYK）
+		segment(0xEE, []byte("Adobe\x00\x64\x00\x00\x00\x00\x00"))
+	}
+
+	frameHeader := []byte{8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(len(sampling))}
+	frame := &jpegFrame{width: width, height: height, hMax: 1, vMax: 1}
+	for i, factors := range sampling {
+		frameHeader = append(frameHeader, byte(i+1), factors, 0)
+		component := jpegComponent{id: byte(i + 1), h: int(factors >> 4), v: int(factors & 0x0F)}
+		frame.hMax, frame.vMax = max(frame.hMax, component.h), max(frame.vMax, component.v)
+		frame.components = append(frame.components, component)
+	}
+	segment(0xC0, frameHeader)
+
+	// DC：类别 0..11 均为 4 位码；AC：162 个基线符号均为 8 位码
+	dc := &jpegHuffmanTable{values: []uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}}
+	dc.bits[4] = 12
+	ac := &jpegHuffmanTable{values: []uint8{0x00, 0xF0}}
+	for run := range 16 {
+		for size := 1; size <= 10; size++ {
+			ac.values = append(ac.values, uint8(run<<4|size))
+		}
+	}
+	ac.bits[8] = uint8(len(ac.values))
+	if err := dc.generate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ac.generate(); err != nil {
+		t.Fatal(err)
+	}
+	dht := append([]byte{0x00}, dc.bits[1:]...)
+	dht = append(dht, dc.values...)
+	dht = append(dht, 0x10)
+	dht = append(dht, ac.bits[1:]...)
+	dht = append(dht, ac.values...)
+	segment(0xC4, dht)
+	if restart > 0 {
+		segment(0xDD, binary.BigEndian.AppendUint16(nil, uint16(restart)))
+	}
+
+	scans := [][]int{}
+	if interleaved {
+		all := make([]int, len(sampling))
+		for i := range all {
+			all[i] = i
+		}
+		scans = append(scans, all)
+	} else {
+		for i := range sampling {
+			scans = append(scans, []int{i})
+		}
+	}
+	for _, components := range scans {
+		header := []byte{byte(len(components))}
+		for _, index := range components {
+			header = append(header, byte(index+1), 0x00)
+		}
+		segment(0xDA, append(header, 0, 63, 0))
+
+		// 每个块写入随机的 DC 差值与 AC 游程/幅值，附加位随机
+		writer := jpegBitWriter{out: &out}
+		put := func(table *jpegHuffmanTable, symbol uint8, size uint8) {
+			writer.write(uint32(table.codes[symbol]), table.sizes[symbol])
+			writer.write(uint32(random.Intn(1<<size)), size)
+		}
+		mcus, blocks := syntheticScanLayout(frame, components)
+		for mcu := range mcus {
+			if restart > 0 && mcu > 0 && mcu%restart == 0 {
+				writer.flush()
+				out.Write([]byte{0xFF, 0xD0 + byte((mcu/restart-1)%8)})
+			}
+			for _, count := range blocks {
+				for range count {
+					size := uint8(random.Intn(5))
+					put(dc, size, size)
+					for k := 1; k < 64; {
+						if random.Intn(4) == 0 {
+							put(ac, 0x00, 0)
+							break
+						}
+						run, size := random.Intn(4), 1+random.Intn(3)
+						if random.Intn(20) == 0 && k+16 < 64 {
+							put(ac, 0xF0, 0)
+							k += 16
+							continue
+						}
+						if k+run > 63 {
+							put(ac, 0x00, 0)
+							break
+						}
+						put(ac, uint8(run<<4|size), uint8(size))
+						k += run + 1
+					}
+				}
+			}
+		}
+		writer.flush()
+	}
+	out.Write([]byte{0xFF, 0xD9})
+	return out.Bytes()
+}
+
+// syntheticScanLayout 返回扫描的 MCU 数及每个 MCU 中各分量的块数。
+func syntheticScanLayout(frame *jpegFrame, components []int) (int, []int) {
+	if len(components) == 1 {
+		component := frame.components[components[0]]
+		width := (frame.width*component.h + frame.hMax - 1) / frame.hMax
+		height := (frame.height*component.v + frame.vMax - 1) / frame.vMax
+		return ((width + 7) / 8) * ((height + 7) / 8), []int{1}
+	}
+	blocks := make([]int, len(components))
+	for i, index := range components {
+		blocks[i] = frame.components[index].h * frame.components[index].v
+	}
+	mcusWide := (frame.width + 8*frame.hMax - 1) / (8 * frame.hMax)
+	return mcusWide * ((frame.height + 8*frame.vMax - 1) / (8 * frame.vMax)), blocks
+}
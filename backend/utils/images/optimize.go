@@ -0,0 +1,197 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// OptimizePNG 无损优化 PNG：颜色数不超过 256 时转换为调色板图，并以最高压缩级别重新编码。
+// 结果不比原文件小、或原文件带 ICC 配置时返回原字节。
+func OptimizePNG(original []byte, img image.Image) []byte {
+	if pngHasChunk(original, "iCCP") {
+		return original
+	}
+	candidate := img
+	if paletted, ok := reducePalette(img); ok {
+		candidate = paletted
+	}
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, candidate); err != nil || buf.Len() >= len(original) {
+		return original
+	}
+	return buf.Bytes()
+}
+
+// reducePalette 颜色数不超过 256 时返回等价的调色板图（16 位图不处理，避免精度损失）。
+func reducePalette(img image.Image) (*image.Paletted, bool) {
+	switch img.(type) {
+	case *image.Paletted, *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return nil, false
+	}
+	bounds := img.Bounds()
+	index := make(map[color.NRGBA]uint8, 256)
+	palette := make(color.Palette, 0, 256)
+	pixels := make([]uint8, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			i, ok := index[c]
+			if !ok {
+				if len(palette) == 256 {
+					return nil, false
+				}
+				i = uint8(len(palette))
+				index[c] = i
+				palette = append(palette, c)
+			}
+			pixels = append(pixels, i)
+		}
+	}
+	paletted := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette)
+	copy(paletted.Pix, pixels)
+	return paletted, true
+}
+
+// pngHasChunk 判断 PNG 是否包含指定类型的数据块。
+func pngHasChunk(data []byte, chunkType string) bool {
+	offset := 8
+	for offset+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		if string(data[offset+4:offset+8]) == chunkType {
+			return true
+		}
+		if length < 0 || offset+12+length > len(data) {
+			return false
+		}
+		offset += 12 + length
+	}
+	return false
+}
+
+// StripJPEGMetadata 去除 JPEG 的 XMP/Photoshop/注释等非必要元数据段，Exif 只保留方向。
+// 只按段标记遍历到首个扫描，之后的熵编码数据与后续段原样复制，
+// 因此与编码方式（基线、渐进式、算术编码）及采样因子无关，像素数据保持不变。
+func StripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("jpeg: missing SOI marker")
+	}
+	var out bytes.Buffer
+	out.Grow(len(data))
+	out.Write(data[:2])
+	offset := 2
+	for {
+		if offset+4 > len(data) || data[offset] != 0xFF {
+			return nil, errors.New("jpeg: invalid segment marker")
+		}
+		marker := data[offset+1]
+		if marker == 0xFF {
+			offset++
+			continue
+		}
+		if marker == 0xD8 || marker == 0xD9 || (marker >= 0xD0 && marker <= 0xD7) {
+			return nil, fmt.Errorf("jpeg: unexpected marker 0x%02X before scan", marker)
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		if length < 2 || offset+2+length > len(data) {
+			return nil, errors.New("jpeg: invalid segment length")
+		}
+		end := offset + 2 + length
+		if marker == 0xDA {
+			out.Write(data[offset:])
+			return out.Bytes(), nil
+		}
+		segment := data[offset+4 : end]
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00")):
+			if orientation, ok := exifOrientationSegment(segment); ok {
+				out.Write(orientation)
+			}
+		case keepJPEGSegment(marker, segment):
+			out.Write(data[offset:end])
+		}
+		offset = end
+	}
+}
+
+// keepJPEGSegment 保留解码与显示需要的段：JFIF、Exif、ICC 配置和 Adobe 颜色变换标记。
+func keepJPEGSegment(marker byte, segment []byte) bool {
+	switch {
+	case marker == 0xFE:
+		return false
+	case marker == 0xE0:
+		return bytes.HasPrefix(segment, []byte("JFIF\x00")) || bytes.HasPrefix(segment, []byte("JFXX\x00"))
+	case marker == 0xE1:
+		return bytes.HasPrefix(segment, []byte("Exif\x00"))
+	case marker == 0xE2:
+		return bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00"))
+	case marker == 0xEE:
+		return bytes.HasPrefix(segment, []byte("Adobe"))
+	case marker > 0xE0 && marker <= 0xEF:
+		return false
+	}
+	return true
+}
+
+// exifOrientationSegment 从 Exif 段中取出方向标签，生成只含该标签的完整 APP1 段。
+// 方向为默认值或无法解析时返回 false，整个 Exif 段可以去除。
+func exifOrientationSegment(segment []byte) ([]byte, bool) {
+	if len(segment) < 6+8 {
+		return nil, false
+	}
+	tiff := segment[6:]
+	var order interface {
+		binary.ByteOrder
+		binary.AppendByteOrder
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, false
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return nil, false
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	orientation := uint16(0)
+	for i := range count {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return nil, false
+		}
+		// 方向标签 0x0112，类型 SHORT，数量 1
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 && order.Uint32(tiff[entry+4:]) == 1 {
+			orientation = order.Uint16(tiff[entry+8:])
+			break
+		}
+	}
+	if orientation < 2 || orientation > 8 {
+		return nil, false
+	}
+
+	// TIFF 头 8 字节 + IFD0（1 个条目）18 字节
+	body := make([]byte, 0, 6+26)
+	body = append(body, "Exif\x00\x00"...)
+	body = append(body, tiff[:2]...)
+	body = order.AppendUint16(body, 42)
+	body = order.AppendUint32(body, 8)
+	body = order.AppendUint16(body, 1)
+	body = order.AppendUint16(body, 0x0112)
+	body = order.AppendUint16(body, 3)
+	body = order.AppendUint32(body, 1)
+	body = order.AppendUint16(body, orientation)
+	body = order.AppendUint16(body, 0)
+	body = order.AppendUint32(body, 0)
+	out := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(out[2:], uint16(len(body)+2))
+	return append(out, body...), true
+}
@@ -0,0 +1,241 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestStripJPEGMetadataRemovesCommentAndKeepsPixels(t *testing.T) {
+	colorful := image.NewNRGBA(image.Rect(0, 0, 123, 77))
+	gray := image.NewGray(image.Rect(0, 0, 61, 45))
+	for x := 0; x < 123; x++ {
+		for y := 0; y < 77; y++ {
+			colorful.Set(x, y, color.NRGBA{R: uint8(x * 2), G: uint8(y * 3), B: uint8((x * y) % 256), A: 255})
+			gray.Set(x%61, y%45, color.Gray{Y: uint8((x + y*7) % 256)})
+		}
+	}
+
+	for name, source := range map[string]image.Image{"ycbcr": colorful, "gray": gray} {
+		var encoded bytes.Buffer
+		if err := jpeg.Encode(&encoded, source, &jpeg.Options{Quality: 90}); err != nil {
+			t.Fatalf("%s: encode source: %v", name, err)
+		}
+		// 插入一段注释，应被移除
+		withComment := append([]byte{0xFF, 0xD8, 0xFF, 0xFE, 0x00, 0x07, 'h', 'e', 'l', 'l', 'o'}, encoded.Bytes()[2:]...)
+
+		optimized, err := StripJPEGMetadata(withComment)
+		if err != nil {
+			t.Fatalf("%s: strip metadata: %v", name, err)
+		}
+		if !bytes.Equal(optimized, encoded.Bytes()) {
+			t.Fatalf("%s: expected only the comment segment to be removed", name)
+		}
+
+		want, err := jpeg.Decode(bytes.NewReader(encoded.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: decode source: %v", name, err)
+		}
+		got, err := jpeg.Decode(bytes.NewReader(optimized))
+		if err != nil {
+			t.Fatalf("%s: decode optimized: %v", name, err)
+		}
+		bounds := want.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if want.At(x, y) != got.At(x, y) {
+					t.Fatalf("%s: pixel (%d,%d) changed", name, x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestStripJPEGMetadataLeavesScanDataUntouched(t *testing.T) {
+	source := image.NewNRGBA(image.Rect(0, 0, 33, 17))
+	for x := 0; x < 33; x++ {
+		for y := 0; y < 17; y++ {
+			source.Set(x, y, color.NRGBA{R: uint8(x * 7), G: uint8(y * 13), B: 90, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, source, &jpeg.Options{Quality: 80}); err != nil {
+		t.Fatalf("encode source: %v", err)
+	}
+	payload := []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")
+	xmp := append([]byte{0xFF, 0xE1, 0x00, byte(len(payload) + 2)}, payload...)
+
+	cases := map[string]func([]byte){
+		// 4:2:2 与 4:4:0 采样、渐进式帧：熵数据不被解析，必须逐字节保留
+		"4:2:2":       func(data []byte) { setJPEGSampling(t, data, 0x21) },
+		"4:4:0":       func(data []byte) { setJPEGSampling(t, data, 0x12) },
+		"progressive": func(data []byte) { data[jpegMarkerOffset(t, data, 0xC0)+1] = 0xC2 },
+	}
+	for name, mutate := range cases {
+		want := append([]byte(nil), encoded.Bytes()...)
+		mutate(want)
+		input := append(append(append([]byte(nil), want[:2]...), xmp...), want[2:]...)
+
+		got, err := StripJPEGMetadata(input)
+		if err != nil {
+			t.Fatalf("%s: strip metadata: %v", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: expected XMP segment removed and everything else unchanged", name)
+		}
+	}
+}
+
+func TestStripJPEGMetadataRejectsCorruptData(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("encode source: %v", err)
+	}
+	valid := encoded.Bytes()
+	oversized := append([]byte(nil), valid...)
+	oversized[4], oversized[5] = 0xFF, 0xFF
+
+	cases := map[string][]byte{
+		"empty":            nil,
+		"not a jpeg":       []byte("\x89PNG\r\n\x1a\n"),
+		"truncated header": valid[:20],
+		"oversized length": oversized,
+		"garbage marker":   append([]byte{0xFF, 0xD8, 0x00, 0x01, 0x02, 0x03}, valid[2:]...),
+		"eoi before scan":  []byte{0xFF, 0xD8, 0xFF, 0xD9, 0x00, 0x00},
+	}
+	for name, data := range cases {
+		if _, err := StripJPEGMetadata(data); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestStripJPEGMetadataKeepsOnlyExifOrientation(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 16, 16)), nil); err != nil {
+		t.Fatalf("encode source: %v", err)
+	}
+
+	cases := []struct {
+		name        string
+		exif        []byte
+		orientation uint16
+	}{
+		{name: "big endian rotated", exif: exifSegment(binary.BigEndian, "MM", 6), orientation: 6},
+		{name: "little endian mirrored", exif: exifSegment(binary.LittleEndian, "II", 2), orientation: 2},
+		{name: "default orientation", exif: exifSegment(binary.BigEndian, "MM", 1)},
+		{name: "truncated", exif: []byte{0xFF, 0xE1, 0x00, 0x07, 'E', 'x', 'i', 'f', 0x00}},
+	}
+	for _, tc := range cases {
+		input := append(append(append([]byte(nil), encoded.Bytes()[:2]...), tc.exif...), encoded.Bytes()[2:]...)
+		got, err := StripJPEGMetadata(input)
+		if err != nil {
+			t.Fatalf("%s: strip metadata: %v", tc.name, err)
+		}
+		if bytes.Contains(got, []byte("OneImg Camera")) {
+			t.Fatalf("%s: expected other exif tags removed", tc.name)
+		}
+		index := bytes.Index(got, []byte("Exif\x00\x00"))
+		if tc.orientation == 0 {
+			if index >= 0 || !bytes.Equal(got, encoded.Bytes()) {
+				t.Fatalf("%s: expected exif segment removed", tc.name)
+			}
+			continue
+		}
+		if index < 0 {
+			t.Fatalf("%s: expected orientation kept", tc.name)
+		}
+		length := int(binary.BigEndian.Uint16(got[index-2:]))
+		orientation, ok := exifOrientationSegment(got[index : index-2+length])
+		if !ok || !bytes.Equal(orientation[4:], got[index:index-2+length]) {
+			t.Fatalf("%s: expected minimal orientation segment", tc.name)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+			t.Fatalf("%s: decode stripped: %v", tc.name, err)
+		}
+		var order binary.ByteOrder = binary.BigEndian
+		if tc.exif[10] == 'I' {
+			order = binary.LittleEndian
+		}
+		if value := order.Uint16(orientation[len(orientation)-8:]); value != tc.orientation {
+			t.Fatalf("%s: expected orientation %d, got %d", tc.name, tc.orientation, value)
+		}
+	}
+}
+
+// exifSegment 生成含相机型号与方向两个标签的 Exif APP1 段。
+func exifSegment(order binary.AppendByteOrder, header string, orientation uint16) []byte {
+	model := []byte("OneImg Camera\x00")
+	body := []byte("Exif\x00\x00" + header)
+	body = order.AppendUint16(body, 42)
+	body = order.AppendUint32(body, 8)
+	body = order.AppendUint16(body, 2)
+	// 型号：ASCII，数据位于 IFD 之后
+	body = order.AppendUint16(body, 0x0110)
+	body = order.AppendUint16(body, 2)
+	body = order.AppendUint32(body, uint32(len(model)))
+	body = order.AppendUint32(body, 8+2+2*12+4)
+	body = order.AppendUint16(body, 0x0112)
+	body = order.AppendUint16(body, 3)
+	body = order.AppendUint32(body, 1)
+	body = order.AppendUint16(body, orientation)
+	body = order.AppendUint16(body, 0)
+	body = order.AppendUint32(body, 0)
+	body = append(body, model...)
+	return append([]byte{0xFF, 0xE1, byte((len(body) + 2) >> 8), byte(len(body) + 2)}, body...)
+}
+
+// jpegMarkerOffset 返回首个指定段标记（0xFF 之后的字节）前 0xFF 的位置。
+func jpegMarkerOffset(t *testing.T, data []byte, marker byte) int {
+	t.Helper()
+	index := bytes.Index(data, []byte{0xFF, marker})
+	if index < 0 {
+		t.Fatalf("marker 0x%02X not found", marker)
+	}
+	return index
+}
+
+// setJPEGSampling 修改帧头中第一个分量的采样因子。
+func setJPEGSampling(t *testing.T, data []byte, factors byte) {
+	t.Helper()
+	offset := jpegMarkerOffset(t, data, 0xC0)
+	// FF C0 | 长度(2) | 精度(1) | 高(2) | 宽(2) | 分量数(1) | 分量ID(1) | 采样因子
+	data[offset+11] = factors
+}
+
+func TestOptimizePNGReducesPalette(t *testing.T) {
+	source := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	palette := []color.NRGBA{{R: 255, A: 255}, {G: 255, A: 128}, {B: 255, A: 255}, {}}
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			source.Set(x, y, palette[(x/8+y/8)%len(palette)])
+		}
+	}
+	var encoded bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.NoCompression}).Encode(&encoded, source); err != nil {
+		t.Fatalf("encode source: %v", err)
+	}
+
+	optimized := OptimizePNG(encoded.Bytes(), source)
+	if len(optimized) >= encoded.Len() {
+		t.Fatalf("expected smaller png, got %d >= %d", len(optimized), encoded.Len())
+	}
+	decoded, err := png.Decode(bytes.NewReader(optimized))
+	if err != nil {
+		t.Fatalf("decode optimized: %v", err)
+	}
+	if _, ok := decoded.(*image.Paletted); !ok {
+		t.Fatalf("expected paletted png, got %T", decoded)
+	}
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			if color.NRGBAModel.Convert(decoded.At(x, y)) != source.NRGBAAt(x, y) {
+				t.Fatalf("pixel (%d,%d) changed", x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"path"
+	"strings"
+
+	"oneimg/backend/models"
+
+	"github.com/disintegration/imaging"
+)
+
+// smartCropAnalyzeSize 智能裁剪分析时的缩放边长，只影响选区精度不影响输出质量
+const smartCropAnalyzeSize = 256
+
+// ProfileThumbnail 按缩略图规格生成的衍生图
+type ProfileThumbnail struct {
+	Profile  string // 规格名
+	Bytes    []byte // 编码后的字节
+	Width    int    // 实际宽度
+	Height   int    // 实际高度
+	MimeType string // MIME类型
+	Ext      string // 文件扩展名
+}
+
+// GenerateProfileThumbnails 按规格批量生成缩略图，单个规格失败不影响其他规格。
+func (s *ImageService) GenerateProfileThumbnails(img image.Image, profiles []models.ThumbnailProfile) ([]ProfileThumbnail, []error) {
+	if len(profiles) == 0 || img == nil || img.Bounds().Empty() {
+		return nil, nil
+	}
+
+	thumbnails := make([]ProfileThumbnail, 0, len(profiles))
+	var errs []error
+	for _, profile := range profiles {
+		thumbnail, err := s.GenerateProfileThumbnail(img, profile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("profile %s: %w", profile.Name, err))
+			continue
+		}
+		thumbnails = append(thumbnails, thumbnail)
+	}
+	return thumbnails, errs
+}
+
+// GenerateProfileThumbnail 按单个规格缩放/裁剪并编码缩略图。
+func (s *ImageService) GenerateProfileThumbnail(img image.Image, profile models.ThumbnailProfile) (ProfileThumbnail, error) {
+	if img == nil || img.Bounds().Empty() {
+		return ProfileThumbnail{}, ErrSVGThumbnail
+	}
+
+	var resized *image.NRGBA
+	switch profile.Fit {
+	case models.ThumbnailFitCover:
+		resized = imaging.Fill(img, profile.Width, profile.Height, imaging.Center, imaging.Lanczos)
+	case models.ThumbnailFitSmart:
+		resized = imaging.Resize(imaging.Crop(img, smartCropRect(img, profile.Width, profile.Height)), profile.Width, profile.Height, imaging.Lanczos)
+	default:
+		resized = imaging.Fit(img, profile.Width, profile.Height, imaging.Lanczos)
+	}
+
+	quality := profile.Quality
+	if quality <= 0 {
+		quality = models.DefaultThumbnailQuality
+	}
+
+	var (
+		data     []byte
+		mimeType string
+		ext      string
+	)
+	switch profile.Format {
+	case models.ThumbnailFormatJPEG:
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resized, &jpeg.Options{Quality: quality}); err != nil {
+			return ProfileThumbnail{}, fmt.Errorf("encode jpeg: %w", err)
+		}
+		data, mimeType, ext = buf.Bytes(), "image/jpeg", ".jpg"
+	case models.ThumbnailFormatPNG:
+		var buf bytes.Buffer
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		if err := encoder.Encode(&buf, resized); err != nil {
+			return ProfileThumbnail{}, fmt.Errorf("encode png: %w", err)
+		}
+		data, mimeType, ext = buf.Bytes(), "image/png", ".png"
+	default:
+		encoded, err := s.convertToWebP(resized, quality)
+		if err != nil {
+			return ProfileThumbnail{}, err
+		}
+		data, mimeType, ext = encoded, "image/webp", ".webp"
+	}
+
+	bounds := resized.Bounds()
+	return ProfileThumbnail{
+		Profile:  profile.Name,
+		Bytes:    data,
+		Width:    bounds.Dx(),
+		Height:   bounds.Dy(),
+		MimeType: mimeType,
+		Ext:      ext,
+	}, nil
+}
+
+// DecodeImageBytes 解码图片字节，供缩略图重建等非上传场景使用。
+func (s *ImageService) DecodeImageBytes(data []byte, mimeType string) (image.Image, string, error) {
+	return s.decodeImage(bytes.NewReader(data), mimeType)
+}
+
+// ProfileThumbnailPath 返回规格缩略图的访问路径：<原图目录>/thumbnails/<规格名>/<原文件名><扩展名>。
+func ProfileThumbnailPath(imageURL, profile, ext string) string {
+	dir, fileName := path.Split(strings.TrimSpace(imageURL))
+	baseName := strings.TrimSuffix(fileName, path.Ext(fileName))
+	return dir + "thumbnails/" + profile + "/" + baseName + ext
+}
+
+// smartCropRect 在原图中选出与目标宽高比一致、边缘细节最丰富的区域。
+// 先缩小到分析尺寸计算梯度能量，再用积分图滑窗求能量最大的窗口。
+func smartCropRect(img image.Image, width, height int) image.Rectangle {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	scale := math.Max(float64(width)/float64(srcW), float64(height)/float64(srcH))
+	cropW := min(srcW, max(1, int(math.Round(float64(width)/scale))))
+	cropH := min(srcH, max(1, int(math.Round(float64(height)/scale))))
+	if cropW == srcW && cropH == srcH {
+		return bounds
+	}
+
+	analyzeScale := math.Min(1, float64(smartCropAnalyzeSize)/float64(max(srcW, srcH)))
+	aw := max(1, int(math.Round(float64(srcW)*analyzeScale)))
+	ah := max(1, int(math.Round(float64(srcH)*analyzeScale)))
+	gray := imaging.Grayscale(imaging.Resize(img, aw, ah, imaging.Box))
+
+	// integral[(y)*(aw+1)+x] 为 [0,x)×[0,y) 区域的能量和
+	integral := make([]float64, (aw+1)*(ah+1))
+	for y := 0; y < ah; y++ {
+		rowSum := 0.0
+		for x := 0; x < aw; x++ {
+			center := float64(gray.Pix[y*gray.Stride+x*4])
+			energy := 0.0
+			if x+1 < aw {
+				energy += math.Abs(center - float64(gray.Pix[y*gray.Stride+(x+1)*4]))
+			}
+			if y+1 < ah {
+				energy += math.Abs(center - float64(gray.Pix[(y+1)*gray.Stride+x*4]))
+			}
+			rowSum += energy
+			integral[(y+1)*(aw+1)+x+1] = integral[y*(aw+1)+x+1] + rowSum
+		}
+	}
+
+	winW := min(aw, max(1, int(math.Round(float64(cropW)*analyzeScale))))
+	winH := min(ah, max(1, int(math.Round(float64(cropH)*analyzeScale))))
+	windowEnergy := func(x, y int) float64 {
+		return integral[(y+winH)*(aw+1)+x+winW] - integral[y*(aw+1)+x+winW] -
+			integral[(y+winH)*(aw+1)+x] + integral[y*(aw+1)+x]
+	}
+	// 以居中窗口为基准，能量相同时保持居中（纯色图等）
+	bestX, bestY := (aw-winW)/2, (ah-winH)/2
+	bestEnergy := windowEnergy(bestX, bestY)
+	for y := 0; y+winH <= ah; y++ {
+		for x := 0; x+winW <= aw; x++ {
+			if energy := windowEnergy(x, y); energy > bestEnergy {
+				bestX, bestY, bestEnergy = x, y, energy
+			}
+		}
+	}
+
+	offsetX := min(srcW-cropW, int(math.Round(float64(bestX)/analyzeScale)))
+	offsetY := min(srcH-cropH, int(math.Round(float64(bestY)/analyzeScale)))
+	origin := bounds.Min.Add(image.Pt(offsetX, offsetY))
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(cropW, cropH))}
+}
@@ -0,0 +1,51 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"oneimg/backend/models"
+)
+
+func TestSmartCropPrefersDetailedRegion(t *testing.T) {
+	source := image.NewNRGBA(image.Rect(0, 0, 400, 100))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 100; y++ {
+			source.Set(x, y, color.NRGBA{R: 200, G: 200, B: 200, A: 255})
+		}
+	}
+	// 右侧棋盘格区域细节最丰富
+	for x := 300; x < 400; x++ {
+		for y := 0; y < 100; y++ {
+			if (x/5+y/5)%2 == 0 {
+				source.Set(x, y, color.NRGBA{A: 255})
+			}
+		}
+	}
+
+	thumbnail, err := (&ImageService{}).GenerateProfileThumbnail(source, models.ThumbnailProfile{
+		Name: "avatar", Width: 100, Height: 100, Fit: models.ThumbnailFitSmart, Format: models.ThumbnailFormatPNG,
+	})
+	if err != nil {
+		t.Fatalf("generate smart thumbnail: %v", err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(thumbnail.Bytes))
+	if err != nil {
+		t.Fatalf("decode smart thumbnail: %v", err)
+	}
+	dark := 0
+	bounds := decoded.Bounds()
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			if r, _, _, _ := decoded.At(x, y).RGBA(); r < 0x4000 {
+				dark++
+			}
+		}
+	}
+	if dark < bounds.Dx()*bounds.Dy()/4 {
+		t.Fatalf("smart crop should keep the detailed region, dark pixels=%d", dark)
+	}
+}
@@ -80,62 +80,86 @@ func SanitizeSettingsForResponse(setting models.Settings) map[string]any {
 	}
 
 	return map[string]any{
-		"id":                            setting.ID,
-		"compress_image":                setting.CompressImage,
-		"save_webp":                     setting.SaveWebp,
-		"thumbnail":                     setting.Thumbnail,
-		"tourist":                       setting.Tourist,
-		"tg_notice":                     setting.TGNotice,
-		"pow_verify":                    setting.PowVerify,
-		"tg_bot_token":                  tgBotTokenStatus,
-		"tg_bot_token_configured":       strings.TrimSpace(setting.TGBotToken) != "",
-		"tg_receivers":                  setting.TGReceivers,
-		"tg_notice_text":                setting.TGNoticeText,
-		"start_api":                     setting.StartAPI,
-		"random_graph":                  setting.RandomGraph,
-		"start_register":                setting.StartRegister,
-		"api_token":                     apiTokenStatus,
-		"api_token_configured":          strings.TrimSpace(setting.APITokenHash) != "",
-		"save_original_name":            setting.SaveOriginalName,
-		"default_storage":               setting.DefaultStorage,
-		"multi_storage_sync":            setting.MultiStorageSync,
-		"encrypted_storage":             setting.EncryptedStorage,
-		"oidc_enable":                   setting.OIDCEnable,
-		"oidc_issuer":                   setting.OIDCIssuer,
-		"oidc_client_id":                setting.OIDCClientID,
-		"oidc_client_secret":            oidcClientSecretStatus,
-		"oidc_client_secret_configured": strings.TrimSpace(setting.OIDCClientSecret) != "",
-		"oidc_redirect_url":             setting.OIDCRedirectURL,
-		"oidc_scopes":                   setting.OIDCScopes,
-		"oidc_username_claim":           setting.OIDCUsernameClaim,
-		"oidc_display_name":             setting.OIDCDisplayName,
-		"oidc_auto_provision":           setting.OIDCAutoProvision,
-		"oidc_super_admin_username":     setting.OIDCSuperAdminUsername,
-		"cas_enable":                    setting.CASEnable,
-		"cas_server_url":                setting.CASServerURL,
-		"cas_service_url":               setting.CASServiceURL,
-		"cas_display_name":              setting.CASDisplayName,
-		"cas_auto_provision":            setting.CASAutoProvision,
-		"cas_super_admin_username":      setting.CASSuperAdminUsername,
-		"max_file_size":                 setting.MaxFileSize,
-		"allowed_types":                 setting.AllowedTypes,
-		"public_image_domain":           setting.PublicImageDomain,
-		"watermark_enable":              setting.WatermarkEnable,
-		"watermark_text":                setting.WatermarkText,
-		"watermark_pos":                 setting.WatermarkPos,
-		"watermark_size":                setting.WatermarkSize,
-		"watermark_color":               setting.WatermarkColor,
-		"watermark_opac":                setting.WatermarkOpac,
-		"referer_white_enable":          setting.RefererWhiteEnable,
-		"referer_white_list":            setting.RefererWhiteList,
-		"seo_title":                     setting.SEOTitle,
-		"seo_description":               setting.SEODescription,
-		"seo_keywords":                  setting.SEOKeywords,
-		"seo_icp":                       setting.SEOICP,
-		"public_security":               setting.PublicSecurity,
-		"seo_icon":                      setting.SEOicon,
-		"default_path":                  setting.DefaultPath,
-		"file_name":                     setting.FileName,
+		"id":                              setting.ID,
+		"compress_image":                  setting.CompressImage,
+		"save_webp":                       setting.SaveWebp,
+		"thumbnail":                       setting.Thumbnail,
+		"thumbnail_profiles":              setting.ThumbnailProfiles,
+		"max_image_dimension":             setting.MaxImageDimension,
+		"archive_original_bucket":         setting.ArchiveOriginalBucket,
+		"compress_quality":                setting.CompressQuality,
+		"compress_threshold":              setting.CompressThreshold,
+		"lossless_png":                    setting.LosslessPNG,
+		"tourist":                         setting.Tourist,
+		"tg_notice":                       setting.TGNotice,
+		"pow_verify":                      setting.PowVerify,
+		"totp_require_admin":              setting.TOTPRequireAdmin,
+		"totp_require_user":               setting.TOTPRequireUser,
+		"tg_bot_token":                    tgBotTokenStatus,
+		"tg_bot_token_configured":         strings.TrimSpace(setting.TGBotToken) != "",
+		"tg_receivers":                    setting.TGReceivers,
+		"tg_notice_text":                  setting.TGNoticeText,
+		"start_api":                       setting.StartAPI,
+		"random_graph":                    setting.RandomGraph,
+		"start_register":                  setting.StartRegister,
+		"api_token":                       apiTokenStatus,
+		"api_token_configured":            strings.TrimSpace(setting.APITokenHash) != "",
+		"save_original_name":              setting.SaveOriginalName,
+		"default_storage":                 setting.DefaultStorage,
+		"multi_storage_sync":              setting.MultiStorageSync,
+		"encrypted_storage":               setting.EncryptedStorage,
+		"storage_sync_workers":            setting.StorageSyncWorkers,
+		"storage_sync_bucket_concurrency": setting.StorageSyncBucketConcurrency,
+		"storage_sync_max_attempts":       setting.StorageSyncMaxAttempts,
+		"storage_sync_backoff_base":       setting.StorageSyncBackoffBase,
+		"storage_sync_backoff_max":        setting.StorageSyncBackoffMax,
+		"storage_scrub_rate":              setting.StorageScrubRate,
+		"storage_scrub_interval":          setting.StorageScrubInterval,
+		"storage_scrub_mode":              setting.StorageScrubMode,
+		"storage_reconcile_interval":      setting.StorageReconcileInterval,
+		"delivery_policy":                 setting.DeliveryPolicy,
+		"bucket_health_interval":          setting.BucketHealthInterval,
+		"bucket_health_threshold":         setting.BucketHealthThreshold,
+		"admin_image_expiry":              setting.AdminImageExpiry,
+		"user_image_expiry":               setting.UserImageExpiry,
+		"guest_image_expiry":              setting.GuestImageExpiry,
+		"trash_retention_days":            setting.TrashRetentionDays,
+		"oidc_enable":                     setting.OIDCEnable,
+		"oidc_issuer":                     setting.OIDCIssuer,
+		"oidc_client_id":                  setting.OIDCClientID,
+		"oidc_client_secret":              oidcClientSecretStatus,
+		"oidc_client_secret_configured":   strings.TrimSpace(setting.OIDCClientSecret) != "",
+		"oidc_redirect_url":               setting.OIDCRedirectURL,
+		"oidc_scopes":                     setting.OIDCScopes,
+		"oidc_username_claim":             setting.OIDCUsernameClaim,
+		"oidc_display_name":               setting.OIDCDisplayName,
+		"oidc_auto_provision":             setting.OIDCAutoProvision,
+		"oidc_super_admin_username":       setting.OIDCSuperAdminUsername,
+		"cas_enable":                      setting.CASEnable,
+		"cas_server_url":                  setting.CASServerURL,
+		"cas_service_url":                 setting.CASServiceURL,
+		"cas_display_name":                setting.CASDisplayName,
+		"cas_auto_provision":              setting.CASAutoProvision,
+		"cas_super_admin_username":        setting.CASSuperAdminUsername,
+		"max_file_size":                   setting.MaxFileSize,
+		"allowed_types":                   setting.AllowedTypes,
+		"public_image_domain":             setting.PublicImageDomain,
+		"watermark_enable":                setting.WatermarkEnable,
+		"watermark_text":                  setting.WatermarkText,
+		"watermark_pos":                   setting.WatermarkPos,
+		"watermark_size":                  setting.WatermarkSize,
+		"watermark_color":                 setting.WatermarkColor,
+		"watermark_opac":                  setting.WatermarkOpac,
+		"referer_white_enable":            setting.RefererWhiteEnable,
+		"referer_white_list":              setting.RefererWhiteList,
+		"seo_title":                       setting.SEOTitle,
+		"seo_description":                 setting.SEODescription,
+		"seo_keywords":                    setting.SEOKeywords,
+		"seo_icp":                         setting.SEOICP,
+		"public_security":                 setting.PublicSecurity,
+		"seo_icon":                        setting.SEOicon,
+		"default_path":                    setting.DefaultPath,
+		"file_name":                       setting.FileName,
 	}
 }
 
@@ -320,6 +344,16 @@ func maskMapValues(configMap map[string]any, sensitiveKeys map[string]struct{})
 	return result
 }
 
+// EncryptSecret 使用配置密钥加密单个敏感值（如两步验证密钥）。
+func EncryptSecret(plainText string) (string, error) {
+	return encryptString(plainText)
+}
+
+// DecryptSecret 解密 EncryptSecret 的结果。
+func DecryptSecret(cipherText string) (string, error) {
+	return decryptString(cipherText)
+}
+
 func encryptString(plainText string) (string, error) {
 	secret := getSecretKey()
 	block, err := aes.NewCipher(secret)
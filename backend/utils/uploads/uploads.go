@@ -55,7 +55,7 @@ func (u *R2Uploader) Upload(c *gin.Context, setting *models.Settings, bucket *mo
 	userRole := c.GetInt("user_role")
 
 	// 处理图片
-	processedImage, err := images.ImageSvc.ProcessImage(file, fileHeader, getProcessingSettings(setting, bucket), userRole)
+	processedImage, err := images.ImageSvc.ProcessImage(file, fileHeader, ProcessingSettings(setting, bucket), userRole)
 	if err != nil {
 		return nil, fmt.Errorf("图片处理失败: %v", err)
 	}
@@ -78,11 +78,8 @@ func (u *R2Uploader) Upload(c *gin.Context, setting *models.Settings, bucket *mo
 		return nil, fmt.Errorf("创建R2客户端失败：%v", err)
 	}
 
-	// 上传文件到R2
-	contentType := "image/webp"
-	if !setting.SaveWebp {
-		contentType = fileHeader.Header.Get("Content-Type")
-	}
+	// 上传文件到R2（Content-Type 取处理后的实际格式，受存储源处理配置影响）
+	contentType := processedImage.MimeType
 	storedImageBytes, err := securestorage.Encode(processedImage.CompressedBytes, setting.EncryptedStorage)
 	if err != nil {
 		return nil, fmt.Errorf("加密图片失败：%v", err)
@@ -117,18 +114,22 @@ func (u *R2Uploader) Upload(c *gin.Context, setting *models.Settings, bucket *mo
 	url := "/" + PathJoin(subDir, uniqueFileName)
 
 	return &interfaces.ImageUploadResult{
-		Success:       true,
-		Message:       "上传成功",
-		FileName:      uniqueFileName,
-		FileSize:      int64(len(processedImage.CompressedBytes)),
-		ThumbnailSize: int64(len(processedImage.ThumbnailBytes)),
-		MimeType:      contentType,
-		URL:           url,
-		ThumbnailURL:  thumbnailURL,
-		Storage:       bucket.Type,
-		CreatedAt:     time.Now().Format("2006-01-02 15:04:05"),
-		Width:         processedImage.Width,
-		Height:        processedImage.Height,
+		Success:        true,
+		Message:        "上传成功",
+		FileName:       uniqueFileName,
+		FileSize:       int64(len(processedImage.CompressedBytes)),
+		ThumbnailSize:  int64(len(processedImage.ThumbnailBytes)),
+		MimeType:       contentType,
+		URL:            url,
+		ThumbnailURL:   thumbnailURL,
+		Thumbnails:     saveProfileThumbnails(url, processedImage, setting.EncryptedStorage),
+		Storage:        bucket.Type,
+		CreatedAt:      time.Now().Format("2006-01-02 15:04:05"),
+		Width:          processedImage.Width,
+		Height:         processedImage.Height,
+		Downscaled:     processedImage.Downscaled,
+		OriginalWidth:  processedImage.OriginalWidth,
+		OriginalHeight: processedImage.OriginalHeight,
 	}, nil
 }
 
@@ -150,7 +151,7 @@ func (u *S3Uploader) Upload(c *gin.Context, setting *models.Settings, bucket *mo
 	userRole := c.GetInt("user_role")
 
 	// 处理图片
-	processedImage, err := images.ImageSvc.ProcessImage(file, fileHeader, getProcessingSettings(setting, bucket), userRole)
+	processedImage, err := images.ImageSvc.ProcessImage(file, fileHeader, ProcessingSettings(setting, bucket), userRole)
 	if err != nil {
 		return nil, fmt.Errorf("图片处理失败: %v", err)
 	}
@@ -173,11 +174,8 @@ func (u *S3Uploader) Upload(c *gin.Context, setting *models.Settings, bucket *mo
 		return nil, fmt.Errorf("创建S3客户端失败：%v", err)
 	}
 
-	// 上传文件到S3
-	contentType := "image/webp"
-	if !setting.SaveWebp {
-		contentType = fileHeader.Header.Get("Content-Type")
-	}
+	// 上传文件到S3（Content-Type 取处理后的实际格式，受存储源处理配置影响）
+	contentType := processedImage.MimeType
 	storedImageBytes, err := securestorage.Encode(processedImage.CompressedBytes, setting.EncryptedStorage)
 	if err != nil {
 		return nil, fmt.Errorf("加密图片失败：%v", err)
@@ -211,18 +209,22 @@ func (u *S3Uploader) Upload(c *gin.Context, setting *models.Settings, bucket *mo
 	url := "/" + PathJoin(subDir, uniqueFileName)
 
 	return &interfaces.ImageUploadResult{
-		Success:       true,
-		Message:       "上传成功",
-		FileName:      uniqueFileName,
-		FileSize:      int64(len(processedImage.CompressedBytes)),
-		ThumbnailSize: int64(len(processedImage.ThumbnailBytes)),
-		MimeType:      contentType,
-		URL:           url,
-		ThumbnailURL:  thumbnailURL,
-		Storage:       bucket.Type,
-		CreatedAt:     time.Now().Format("2006-01-02 15:04:05"),
-		Width:         processedImage.Width,
-		Height:        processedImage.Height,
+		Success:        true,
+		Message:        "上传成功",
+		FileName:       uniqueFileName,
+		FileSize:       int64(len(processedImage.CompressedBytes)),
+		ThumbnailSize:  int64(len(processedImage.ThumbnailBytes)),
+		MimeType:       contentType,
+		URL:            url,
+		ThumbnailURL:   thumbnailURL,
+		Thumbnails:     saveProfileThumbnails(url, processedImage, setting.EncryptedStorage),
+		Storage:        bucket.Type,
+		CreatedAt:      time.Now().Format("2006-01-02 15:04:05"),
+		Width:          processedImage.Width,
+		Height:         processedImage.Height,
+		Downscaled:     processedImage.Downscaled,
+		OriginalWidth:  processedImage.OriginalWidth,
+		OriginalHeight: processedImage.OriginalHeight,
 	}, nil
 }
 
@@ -244,7 +246,7 @@ func (u *WebDAVUploader) Upload(c *gin.Context, setting *models.Settings, bucket
 	userRole := c.GetInt("user_role")
 
 	// 处理图片
-	processedImage, err := images.ImageSvc.ProcessImage(file, fileHeader, getProcessingSettings(setting, bucket), userRole)
+	processedImage, err := images.ImageSvc.ProcessImage(file, fileHeader, ProcessingSettings(setting, bucket), userRole)
 	if err != nil {
 		return nil, fmt.Errorf("图片处理失败: %v", err)
 	}
@@ -303,18 +305,22 @@ func (u *WebDAVUploader) Upload(c *gin.Context, setting *models.Settings, bucket
 	url := PathJoin(subDir, uniqueFileName)
 
 	return &interfaces.ImageUploadResult{
-		Success:       true,
-		Message:       "上传成功",
-		FileName:      uniqueFileName,
-		FileSize:      int64(len(processedImage.CompressedBytes)),
-		ThumbnailSize: int64(len(processedImage.ThumbnailBytes)),
-		MimeType:      processedImage.MimeType,
-		URL:           url,
-		ThumbnailURL:  thumbnailURL,
-		Storage:       bucket.Type,
-		Width:         processedImage.Width,
-		Height:        processedImage.Height,
-		CreatedAt:     time.Now().Format("2006-01-02 15:04:05"),
+		Success:        true,
+		Message:        "上传成功",
+		FileName:       uniqueFileName,
+		FileSize:       int64(len(processedImage.CompressedBytes)),
+		ThumbnailSize:  int64(len(processedImage.ThumbnailBytes)),
+		MimeType:       processedImage.MimeType,
+		URL:            url,
+		ThumbnailURL:   thumbnailURL,
+		Thumbnails:     saveProfileThumbnails(url, processedImage, setting.EncryptedStorage),
+		Storage:        bucket.Type,
+		Width:          processedImage.Width,
+		Height:         processedImage.Height,
+		Downscaled:     processedImage.Downscaled,
+		OriginalWidth:  processedImage.OriginalWidth,
+		OriginalHeight: processedImage.OriginalHeight,
+		CreatedAt:      time.Now().Format("2006-01-02 15:04:05"),
 	}, nil
 }
 
@@ -336,7 +342,7 @@ func (u *FTPUploader) Upload(c *gin.Context, setting *models.Settings, bucket *m
 	userRole := c.GetInt("user_role")
 
 	// 处理图片（压缩、生成缩略图等）
-	processedImage, err := images.ImageSvc.ProcessImage(file, fileHeader, getProcessingSettings(setting, bucket), userRole)
+	processedImage, err := images.ImageSvc.ProcessImage(file, fileHeader, ProcessingSettings(setting, bucket), userRole)
 	if err != nil {
 		return nil, fmt.Errorf("图片处理失败: %v", err)
 	}
@@ -397,18 +403,22 @@ func (u *FTPUploader) Upload(c *gin.Context, setting *models.Settings, bucket *m
 	url := "/" + PathJoin(subDir, uniqueFileName)
 
 	return &interfaces.ImageUploadResult{
-		Success:       true,
-		Message:       "上传成功",
-		FileName:      uniqueFileName,
-		FileSize:      int64(len(processedImage.CompressedBytes)),
-		ThumbnailSize: int64(len(processedImage.ThumbnailBytes)),
-		MimeType:      processedImage.MimeType,
-		URL:           url,
-		ThumbnailURL:  thumbnailURL,
-		Storage:       bucket.Type,
-		Width:         processedImage.Width,
-		Height:        processedImage.Height,
-		CreatedAt:     time.Now().Format("2006-01-02 15:04:05"),
+		Success:        true,
+		Message:        "上传成功",
+		FileName:       uniqueFileName,
+		FileSize:       int64(len(processedImage.CompressedBytes)),
+		ThumbnailSize:  int64(len(processedImage.ThumbnailBytes)),
+		MimeType:       processedImage.MimeType,
+		URL:            url,
+		ThumbnailURL:   thumbnailURL,
+		Thumbnails:     saveProfileThumbnails(url, processedImage, setting.EncryptedStorage),
+		Storage:        bucket.Type,
+		Width:          processedImage.Width,
+		Height:         processedImage.Height,
+		Downscaled:     processedImage.Downscaled,
+		OriginalWidth:  processedImage.OriginalWidth,
+		OriginalHeight: processedImage.OriginalHeight,
+		CreatedAt:      time.Now().Format("2006-01-02 15:04:05"),
 	}, nil
 }
 
@@ -430,7 +440,7 @@ func (u *DefaultUploader) Upload(c *gin.Context, setting *models.Settings, bucke
 	userRole := c.GetInt("user_role")
 
 	// 处理图片
-	processedImage, err := images.ImageSvc.ProcessImage(file, fileHeader, getProcessingSettings(setting, bucket), userRole)
+	processedImage, err := images.ImageSvc.ProcessImage(file, fileHeader, ProcessingSettings(setting, bucket), userRole)
 	if err != nil {
 		return nil, fmt.Errorf("图片处理失败: %v", err)
 	}
@@ -478,18 +488,22 @@ func (u *DefaultUploader) Upload(c *gin.Context, setting *models.Settings, bucke
 	fileURL := "/" + PathJoin(subDir, uniqueFileName)
 
 	return &interfaces.ImageUploadResult{
-		Success:       true,
-		Message:       "上传成功",
-		URL:           fileURL,
-		ThumbnailURL:  thumbnailURL,
-		Storage:       bucket.Type,
-		FileName:      uniqueFileName,
-		FileSize:      int64(len(processedImage.CompressedBytes)),
-		ThumbnailSize: int64(len(processedImage.ThumbnailBytes)),
-		MimeType:      processedImage.MimeType,
-		Width:         processedImage.Width,
-		Height:        processedImage.Height,
-		CreatedAt:     time.Now().Format("2006-01-02 15:04:05"),
+		Success:        true,
+		Message:        "上传成功",
+		URL:            fileURL,
+		ThumbnailURL:   thumbnailURL,
+		Thumbnails:     saveProfileThumbnails(fileURL, processedImage, setting.EncryptedStorage),
+		Storage:        bucket.Type,
+		FileName:       uniqueFileName,
+		FileSize:       int64(len(processedImage.CompressedBytes)),
+		ThumbnailSize:  int64(len(processedImage.ThumbnailBytes)),
+		MimeType:       processedImage.MimeType,
+		Width:          processedImage.Width,
+		Height:         processedImage.Height,
+		Downscaled:     processedImage.Downscaled,
+		OriginalWidth:  processedImage.OriginalWidth,
+		OriginalHeight: processedImage.OriginalHeight,
+		CreatedAt:      time.Now().Format("2006-01-02 15:04:05"),
 	}, nil
 }
 
@@ -511,7 +525,7 @@ func (u *TelegramUploader) Upload(c *gin.Context, setting *models.Settings, buck
 	userRole := c.GetInt("user_role")
 
 	// 处理图片
-	processedImage, err := images.ImageSvc.ProcessImage(file, fileHeader, getProcessingSettings(setting, bucket), userRole)
+	processedImage, err := images.ImageSvc.ProcessImage(file, fileHeader, ProcessingSettings(setting, bucket), userRole)
 	if err != nil {
 		return nil, fmt.Errorf("图片处理失败: %v", err)
 	}
@@ -602,18 +616,22 @@ func (u *TelegramUploader) Upload(c *gin.Context, setting *models.Settings, buck
 	}
 
 	return &interfaces.ImageUploadResult{
-		Success:       true,
-		Message:       "Telegram上传成功",
-		FileName:      processedImage.UniqueFileName,
-		FileSize:      int64(len(processedImage.CompressedBytes)),
-		ThumbnailSize: int64(len(processedImage.ThumbnailBytes)),
-		MimeType:      processedImage.MimeType,
-		URL:           url,
-		ThumbnailURL:  thumbnailURL,
-		Storage:       bucket.Type, // 存储类型标识
-		CreatedAt:     time.Now().Format("2006-01-02 15:04:05"),
-		Width:         processedImage.Width,
-		Height:        processedImage.Height,
+		Success:        true,
+		Message:        "Telegram上传成功",
+		FileName:       processedImage.UniqueFileName,
+		FileSize:       int64(len(processedImage.CompressedBytes)),
+		ThumbnailSize:  int64(len(processedImage.ThumbnailBytes)),
+		MimeType:       processedImage.MimeType,
+		URL:            url,
+		ThumbnailURL:   thumbnailURL,
+		Thumbnails:     saveProfileThumbnails(url, processedImage, setting.EncryptedStorage),
+		Storage:        bucket.Type, // 存储类型标识
+		CreatedAt:      time.Now().Format("2006-01-02 15:04:05"),
+		Width:          processedImage.Width,
+		Height:         processedImage.Height,
+		Downscaled:     processedImage.Downscaled,
+		OriginalWidth:  processedImage.OriginalWidth,
+		OriginalHeight: processedImage.OriginalHeight,
 	}, nil
 }
 
@@ -630,14 +648,77 @@ func saveFile(filePath string, data []byte, encrypted bool) error {
 	return securestorage.WriteFile(filePath, data, encrypted)
 }
 
-func getProcessingSettings(setting *models.Settings, bucket *models.Buckets) models.Settings {
+// saveProfileThumbnails 将规格缩略图写入本机 <原图目录>/thumbnails/<规格名>/ 下。
+// 规格缩略图可随时由原图重建，无论原图存到哪个存储源都只保存在本机，写入失败仅记录日志。
+func saveProfileThumbnails(imageURL string, processedImage *images.ProcessedImage, encrypted bool) []interfaces.ThumbnailVariant {
+	if len(processedImage.ProfileThumbnails) == 0 {
+		return nil
+	}
+
+	variants := make([]interfaces.ThumbnailVariant, 0, len(processedImage.ProfileThumbnails))
+	for _, thumbnail := range processedImage.ProfileThumbnails {
+		relativePath := strings.TrimLeft(images.ProfileThumbnailPath(imageURL, thumbnail.Profile, thumbnail.Ext), "/")
+		publicPath := "/" + relativePath
+		localPath := filepath.FromSlash(relativePath)
+		if err := ensureUploadDir(filepath.Dir(localPath)); err != nil {
+			log.Printf("创建缩略图规格目录失败 [%s]: %v", thumbnail.Profile, err)
+			continue
+		}
+		if err := saveFile(localPath, thumbnail.Bytes, encrypted); err != nil {
+			log.Printf("保存缩略图规格失败 [%s]: %v", thumbnail.Profile, err)
+			continue
+		}
+		variants = append(variants, interfaces.ThumbnailVariant{
+			Profile:  thumbnail.Profile,
+			URL:      publicPath,
+			Width:    thumbnail.Width,
+			Height:   thumbnail.Height,
+			FileSize: int64(len(thumbnail.Bytes)),
+			MimeType: thumbnail.MimeType,
+		})
+	}
+	return variants
+}
+
+// ProcessingSettings 返回上传到指定存储桶时实际使用的图片处理配置
+func ProcessingSettings(setting *models.Settings, bucket *models.Buckets) models.Settings {
 	processingSettings := *setting
 	if publicurl.HasDomain(*setting) && publicurl.SupportsStorage(bucket.Type) {
 		processingSettings.WatermarkEnable = false
 	}
+	applyBucketProcessing(&processingSettings, bucket.Processing)
 	return processingSettings
 }
 
+// applyBucketProcessing 用存储桶处理规格覆盖系统设置，零值字段沿用系统设置
+func applyBucketProcessing(processingSettings *models.Settings, profile *models.BucketProcessing) {
+	if profile == nil {
+		return
+	}
+	if profile.KeepOriginal {
+		processingSettings.CompressImage = false
+		processingSettings.SaveWebp = false
+		processingSettings.WatermarkEnable = false
+		processingSettings.MaxImageDimension = 0
+		return
+	}
+	if profile.Quality > 0 {
+		processingSettings.CompressQuality = profile.Quality
+	}
+	if profile.SizeThreshold > 0 {
+		processingSettings.CompressThreshold = profile.SizeThreshold
+	}
+	switch profile.OutputFormat {
+	case models.BucketOutputFormatOriginal:
+		processingSettings.SaveWebp = false
+	case models.BucketOutputFormatWebP:
+		processingSettings.SaveWebp = true
+	}
+	if profile.LosslessPNG {
+		processingSettings.LosslessPNG = true
+	}
+}
+
 func storageContentType(contentType string, encrypted bool) string {
 	if encrypted {
 		return "application/octet-stream"
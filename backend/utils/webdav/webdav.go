@@ -3,11 +3,13 @@ package webdav
 import (
 	"bytes"
 	"context"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -260,3 +262,98 @@ func (c *WebDAVClient) WebDAVGetFile(ctx context.Context, path string) (*http.Re
 	}
 	return resp, nil
 }
+
+// WebDAVEntry 目录列表中的一项，Path 为相对 BaseURL 的路径
+type WebDAVEntry struct {
+	Path       string
+	IsDir      bool
+	Size       int64
+	ModifiedAt time.Time
+}
+
+type webDAVMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// WebDAVList 列出目录的直接子项（PROPFIND Depth: 1），目录不存在时返回空列表
+func (c *WebDAVClient) WebDAVList(ctx context.Context, dirPath string) ([]WebDAVEntry, error) {
+	cleanDir := c.NormalizePath(dirPath)
+	fullURL := c.config.BaseURL + cleanDir
+	if cleanDir != "" {
+		fullURL += "/"
+	}
+
+	body := `<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+	req, err := http.NewRequestWithContext(ctx, "PROPFIND", fullURL, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("构建请求失败：%w", err)
+	}
+	if c.config.Username != "" && c.config.Password != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("User-Agent", "OneIMG/3.0")
+
+	client := &http.Client{Timeout: c.config.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求失败：%w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("列出目录失败，状态码：%d", resp.StatusCode)
+	}
+
+	var status webDAVMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("解析目录列表失败：%w", err)
+	}
+	basePath := "/"
+	if parsed, err := url.Parse(c.config.BaseURL); err == nil && parsed.Path != "" {
+		basePath = parsed.Path
+	}
+
+	entries := make([]WebDAVEntry, 0, len(status.Responses))
+	for _, response := range status.Responses {
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			continue
+		}
+		entryPath := c.NormalizePath(strings.TrimPrefix(href.Path, basePath))
+		if entryPath == cleanDir {
+			continue
+		}
+		entry := WebDAVEntry{Path: entryPath}
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200") {
+				continue
+			}
+			prop := propstat.Prop
+			entry.IsDir = prop.ResourceType.Collection != nil
+			if size, err := strconv.ParseInt(strings.TrimSpace(prop.ContentLength), 10, 64); err == nil {
+				entry.Size = size
+			}
+			if modified, err := http.ParseTime(strings.TrimSpace(prop.LastModified)); err == nil {
+				entry.ModifiedAt = modified
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -23,6 +23,11 @@ const STATUS_META = {
     icon: 'ri-error-warning-line',
     badgeClass: 'border-red-200 bg-red-50 text-red-700 dark:border-red-500/20 dark:bg-red-500/10 dark:text-red-300',
   },
+  dead_letter: {
+    label: '重试已用尽',
+    icon: 'ri-close-circle-line',
+    badgeClass: 'border-red-200 bg-red-50 text-red-700 dark:border-red-500/20 dark:bg-red-500/10 dark:text-red-300',
+  },
   unknown: {
     label: '状态未知',
     icon: 'ri-question-line',
@@ -63,6 +68,8 @@ export const getStorageDisplayName = (storage) => {
   return storage?.bucket_id ? `存储源 ${storage.bucket_id}` : '未知存储源'
 }
 
+export const isFailedStorageStatus = (status) => status === 'failed' || status === 'dead_letter'
+
 export const hasActiveStorageSync = (image) => {
   return getStorageStatuses(image).some(item => item.status === 'pending' || item.status === 'uploading')
 }
@@ -74,7 +81,7 @@ export const getStorageSyncSummary = (image) => {
   }
 
   const success = statuses.filter(item => item.status === 'success').length
-  const failed = statuses.filter(item => item.status === 'failed').length
+  const failed = statuses.filter(item => isFailedStorageStatus(item.status)).length
   const uploading = statuses.filter(item => item.status === 'uploading').length
   const pending = statuses.filter(item => item.status === 'pending').length
   const active = uploading + pending
@@ -122,7 +129,7 @@ export const renderStorageStatusesHtml = (image, emptyText = '未配置远程同
 
   return statuses.map((storage) => {
     const meta = getStorageStatusMeta(storage.status)
-    const errorHtml = storage.status === 'failed' && storage.error
+    const errorHtml = isFailedStorageStatus(storage.status) && storage.error
       ? `<p class="mt-1 break-words text-[11px] leading-4 text-red-600 dark:text-red-300" title="${escapeHtml(storage.error)}">${escapeHtml(storage.error)}</p>`
       : ''
 
@@ -21,6 +21,7 @@ require (
 	github.com/coreos/go-oidc/v3 v3.17.0
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
 	github.com/minio/minio-go/v7 v7.2.1
+	github.com/ugorji/go/codec v1.3.0
 	golang.org/x/oauth2 v0.35.0
 )
 
@@ -83,7 +84,6 @@ require (
 	github.com/quic-go/quic-go v0.54.0 // indirect
 	github.com/shirou/gopsutil/v3 v3.20.10
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
-	github.com/ugorji/go/codec v1.3.0 // indirect
 	go.uber.org/mock v0.5.0 // indirect
 	golang.org/x/arch v0.20.0 // indirect
 	golang.org/x/image v0.0.0-20211028202545-6944b10bf410